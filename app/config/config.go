@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zones are also available in containers without tzdata
 
 	"github.com/joho/godotenv"
 )
@@ -16,7 +20,10 @@ type config struct {
 	PaypalPercentageCosts             float64
 	TransactionCostsName              string
 	DonationName                      string
+	VoucherName                       string
 	IntervalToDeletePDFsInWeeks       int
+	PDFWatermark                      bool
+	PDFLinkValidityDays               int
 	VivaWalletVerificationKey         string
 	VivaWalletAPIURL                  string
 	VivaWalletAccountsURL             string
@@ -29,12 +36,15 @@ type config struct {
 	KeycloakRealm                     string
 	KeycloakClientID                  string
 	KeycloakClientSecret              string
+	IdentityProvider                  string // keycloak or memory
 	KeycloakVendorGroup               string
 	KeycloakCustomerGroup             string
 	KeycloakBackofficeGroup           string
 	SendCustomerEmail                 bool
+	SendOrderConfirmation             bool
 	OnlinePaperUrl                    string
 	FrontendURL                       string
+	TrustedProxies                    []netip.Prefix // Proxies whose X-Real-Ip and X-Forwarded-For headers are used as client address
 	Development                       bool
 	SMTPServer                        string
 	SMTPPort                          string
@@ -42,6 +52,35 @@ type config struct {
 	SMTPPassword                      string
 	SMTPSenderAddress                 string
 	SMTPSsl                           bool
+	SMTPSecurity                      string
+	SMTPTLSSkipVerify                 bool
+	SMTPTLSCAFile                     string
+	SMTPSenderName                    string
+	MailTransport                     string
+	MailDir                           string
+	EmailQueueIntervalSeconds         int
+	EmailMaxAttempts                  int
+	DefaultLanguage                   string
+	StorageBackend                    string
+	StorageDir                        string
+	StorageRedirect                   bool
+	S3Endpoint                        string
+	S3Bucket                          string
+	S3AccessKey                       string
+	S3SecretKey                       string
+	S3Region                          string
+	S3UseSSL                          bool
+	ImageMaxUploadMB                  int
+	VendorDocumentMaxUploadMB         int
+	VendorDocumentRetentionDays       int
+	VendorLicenseExpiryNoticeDays     int
+	VendorSyncIntervalHours           int
+	VendorAnonymizeAfterDays          int
+	BookkeepingRetentionYears         int
+	SubscriptionReminderDays          int
+	SubscriptionGraceDays             int
+	LicenseValidityDays               int
+	TimeZone                          *time.Location // Time zone of the time slots of vendor locations
 	SentryDSN                         string
 	FlourWebhookURL                   string
 }
@@ -69,7 +108,10 @@ func InitConfig() {
 		PaypalPercentageCosts:             getEnvFloat("PAYPAL_PERCENTAGE_COSTS", 0.00),
 		DonationName:                      getEnv("DONATION_NAME", "donation"),
 		TransactionCostsName:              getEnv("TRANSACTION_COSTS_NAME", "transactionCosts"),
+		VoucherName:                       getEnv("VOUCHER_NAME", "voucher"),
 		IntervalToDeletePDFsInWeeks:       getEnvInt("INTERVAL_TO_DELETE_PDFS_IN_WEEKS", 0),
+		PDFWatermark:                      (getEnv("PDF_WATERMARK", "true") == "true"),
+		PDFLinkValidityDays:               getEnvInt("PDF_LINK_VALIDITY_DAYS", 42),
 		VivaWalletVerificationKey:         getEnv("VIVA_WALLET_VERIFICATION_KEY", ""),
 		VivaWalletAPIURL:                  getEnv("VIVA_WALLET_API_URL", ""),
 		VivaWalletAccountsURL:             getEnv("VIVA_WALLET_ACCOUNTS_URL", ""),
@@ -85,7 +127,9 @@ func InitConfig() {
 		KeycloakRealm:                     getEnv("KEYCLOAK_REALM", ""),
 		KeycloakClientID:                  getEnv("KEYCLOAK_CLIENT_ID", ""),
 		KeycloakClientSecret:              getEnv("KEYCLOAK_CLIENT_SECRET", ""),
+		IdentityProvider:                  getEnv("IDENTITY_PROVIDER", "keycloak"),
 		SendCustomerEmail:                 (getEnv("SEND_CUSTOMER_EMAIL", "false") == "true"),
+		SendOrderConfirmation:             (getEnv("SEND_ORDER_CONFIRMATION", "false") == "true"),
 		OnlinePaperUrl:                    getEnv("ONLINE_PAPER_URL", ""),
 		Development:                       (getEnv("DEVELOPMENT", "false") == "true"),
 		SMTPServer:                        getEnv("SMTP_SERVER", ""),
@@ -94,10 +138,55 @@ func InitConfig() {
 		SMTPPassword:                      getEnv("SMTP_PASSWORD", ""),
 		SMTPSenderAddress:                 getEnv("SMTP_SENDER_ADDRESS", ""),
 		SMTPSsl:                           (getEnv("SMTP_SSL", "false") == "true"),
+		SMTPSecurity:                      getEnv("SMTP_SECURITY", ""),
+		SMTPTLSSkipVerify:                 (getEnv("SMTP_TLS_SKIP_VERIFY", "false") == "true"),
+		SMTPTLSCAFile:                     getEnv("SMTP_TLS_CA_FILE", ""),
+		SMTPSenderName:                    getEnv("SMTP_SENDER_NAME", ""),
+		MailTransport:                     getEnv("MAIL_TRANSPORT", ""),
+		MailDir:                           getEnv("MAIL_DIR", "maildir"),
+		EmailQueueIntervalSeconds:         getEnvInt("EMAIL_QUEUE_INTERVAL_SECONDS", 30),
+		EmailMaxAttempts:                  getEnvInt("EMAIL_MAX_ATTEMPTS", 5),
+		DefaultLanguage:                   getEnv("DEFAULT_LANGUAGE", "de"),
+		StorageBackend:                    getEnv("STORAGE_BACKEND", "local"),
+		StorageDir:                        getEnv("STORAGE_DIR", "."),
+		StorageRedirect:                   (getEnv("STORAGE_REDIRECT", "false") == "true"),
+		S3Endpoint:                        getEnv("S3_ENDPOINT", ""),
+		S3Bucket:                          getEnv("S3_BUCKET", ""),
+		S3AccessKey:                       getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:                       getEnv("S3_SECRET_KEY", ""),
+		S3Region:                          getEnv("S3_REGION", ""),
+		S3UseSSL:                          (getEnv("S3_USE_SSL", "true") == "true"),
+		ImageMaxUploadMB:                  getEnvInt("IMAGE_MAX_UPLOAD_MB", 10),
+		VendorDocumentMaxUploadMB:         getEnvInt("VENDOR_DOCUMENT_MAX_UPLOAD_MB", 20),
+		VendorDocumentRetentionDays:       getEnvInt("VENDOR_DOCUMENT_RETENTION_DAYS", 0),
+		VendorLicenseExpiryNoticeDays:     getEnvInt("VENDOR_LICENSE_EXPIRY_NOTICE_DAYS", 30),
+		VendorSyncIntervalHours:           getEnvInt("VENDOR_SYNC_INTERVAL_HOURS", 24),
+		VendorAnonymizeAfterDays:          getEnvInt("VENDOR_ANONYMIZE_AFTER_DAYS", 90),
+		BookkeepingRetentionYears:         getEnvInt("BOOKKEEPING_RETENTION_YEARS", 7),
+		SubscriptionReminderDays:          getEnvInt("SUBSCRIPTION_REMINDER_DAYS", 7),
+		SubscriptionGraceDays:             getEnvInt("SUBSCRIPTION_GRACE_DAYS", 7),
+		LicenseValidityDays:               getEnvInt("LICENSE_VALIDITY_DAYS", 31),
 		FrontendURL:                       getEnv("FRONTEND_URL", ""),
+		TrustedProxies:                    getEnvPrefixes("TRUSTED_PROXIES"),
 		SentryDSN:                         getEnv("SENTRY_DSN", ""),
 		FlourWebhookURL:                   getEnv("FLOUR_WEBHOOK_URL", ""),
 	}
+	// Emails are captured in memory in development mode unless a transport is set
+	if Config.MailTransport == "" {
+		Config.MailTransport = "smtp"
+		if Config.Development {
+			Config.MailTransport = "memory"
+		}
+	}
+	Config.TimeZone, err = time.LoadLocation(getEnv("TIME_ZONE", "Europe/Vienna"))
+	if err != nil {
+		fmt.Println("Invalid TIME_ZONE, using local time: ", err)
+		Config.TimeZone = time.Local
+	}
+	// SMTP_SSL is kept for backwards compatibility and means implicit TLS
+	if Config.SMTPSecurity == "" && Config.SMTPSsl {
+		Config.SMTPSecurity = "tls"
+	}
 }
 
 // Local copy of utils.GetEnv to avoid circular dependency
@@ -120,6 +209,27 @@ func getEnvFloat(key string, fallback float64) float64 {
 	return fallback
 }
 
+// getEnvPrefixes returns the comma separated IP addresses and networks (CIDR) of the environment variable key
+func getEnvPrefixes(key string) (prefixes []netip.Prefix) {
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				fmt.Println("Invalid address in ", key, ": ", value)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return
+}
+
 // getEnvInt returns the value of the environment variable key as an int or the fallback value if not set
 func getEnvInt(key string, fallback int) int {
 	if value, ok := os.LookupEnv(key); ok {
@@ -107,6 +107,18 @@ func initData(db *Database) (err error) {
 		log.Info("Database already initialized")
 	}
 
+	// Email templates are checked on every start, so that new templates are added to existing installations
+	err = db.InitiateEmailTemplates()
+	if err != nil {
+		log.Error("Email templates creation failed ", zap.Error(err))
+	}
+
+	// The voucher item is added to existing installations as well
+	err = db.InitiateVoucherItem()
+	if err != nil {
+		log.Error("Voucher item creation failed ", zap.Error(err))
+	}
+
 	return
 }
 
@@ -1,22 +1,63 @@
 package database
 
-import "gopkg.in/guregu/null.v4"
+import (
+	"augustin/mailer"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/guregu/null.v4"
+)
 
 // GetTotal returns the total amount of a payment order in cents
-// Only entries that are part of the sale are counted
+// Only entries that are part of the sale are counted, discounts of vouchers are subtracted
 func (order Order) GetTotal() (amount int) {
 	amount = 0
 	for _, entry := range order.Entries {
-		if entry.IsSale {
+		if entry.VoucherCode.Valid {
+			amount -= entry.Price * entry.Quantity
+		} else if entry.IsSale {
+			amount += entry.Price * entry.Quantity
+		}
+	}
+	return amount
+}
+
+// GetDiscount returns the discount of vouchers of a payment order in cents
+func (order Order) GetDiscount() (amount int) {
+	for _, entry := range order.Entries {
+		if entry.VoucherCode.Valid {
 			amount += entry.Price * entry.Quantity
 		}
 	}
 	return amount
 }
 
+// IsGift returns whether the order is delivered to a recipient instead of the buyer.
+// Orders stay gifts when the recipient is erased, so that the buyer doesn't get the items instead.
+func (order Order) IsGift() bool {
+	return order.RecipientEmail.Valid
+}
+
+// RecipientErased returns whether the recipient of a gift has been erased (GDPR), the gift is not delivered anymore
+func (order Order) RecipientErased() bool {
+	return order.IsGift() && order.RecipientEmail.String == ""
+}
+
+// LicenseHolder returns the email of the customer who gets the digital license items and PDFs of the order
+func (order Order) LicenseHolder() string {
+	if order.IsGift() {
+		return order.RecipientEmail.String
+	}
+	return order.CustomerEmail.String
+}
+
 type PDFDownloadLinks struct {
 	Link   string
 	ItemID null.Int
+	PDF    int // Edition of the item
 }
 
 func (order Order) GetPDFDownloadLinks() *[]PDFDownloadLinks {
@@ -31,6 +72,7 @@ func (order Order) GetPDFDownloadLinks() *[]PDFDownloadLinks {
 		links = append(links, PDFDownloadLinks{
 			Link:   download.LinkID,
 			ItemID: download.ItemID,
+			PDF:    download.PDF,
 		})
 	}
 	if len(links) > 0 {
@@ -38,3 +80,105 @@ func (order Order) GetPDFDownloadLinks() *[]PDFDownloadLinks {
 	}
 	return nil
 }
+
+// MailerTemplate converts the email template into a template that can be rendered by the mailer
+func (t EmailTemplate) MailerTemplate() mailer.Template {
+	return mailer.Template{
+		Subject:  t.Subject,
+		HTMLBody: t.HTMLBody,
+		TextBody: t.TextBody,
+	}
+}
+
+// Errors returned if a download link may not be used
+var (
+	ErrPDFDownloadRevoked = errors.New("download link has been revoked")
+	ErrPDFDownloadExpired = errors.New("pdf is expired")
+	ErrPDFDownloadLimit   = errors.New("download limit reached")
+	ErrPDFDownloadIPLimit = errors.New("download link has been used from too many different devices")
+)
+
+// Expired returns true if the download link is not valid anymore
+func (pdfDownload PDFDownload) Expired(now time.Time) bool {
+	return pdfDownload.ValidUntil.Valid && now.After(pdfDownload.ValidUntil.Time)
+}
+
+// CheckPolicy checks if the download link may be used. The limit of IP addresses is checked by CheckPDFDownloadIP.
+func (pdfDownload PDFDownload) CheckPolicy(now time.Time) error {
+	if pdfDownload.Revoked {
+		return ErrPDFDownloadRevoked
+	}
+	if pdfDownload.Expired(now) {
+		return ErrPDFDownloadExpired
+	}
+	if pdfDownload.MaxDownloads > 0 && pdfDownload.DownloadCount >= pdfDownload.MaxDownloads {
+		return ErrPDFDownloadLimit
+	}
+	return nil
+}
+
+// Available returns true if the edition can be bought at the given time
+func (pdf PDF) Available(now time.Time) bool {
+	if pdf.AvailableFrom.Valid && now.Before(pdf.AvailableFrom.Time) {
+		return false
+	}
+	return !pdf.AvailableUntil.Valid || now.Before(pdf.AvailableUntil.Time)
+}
+
+// VendorQRCodeURL returns the URL in the QR code of a vendor. It is the UrlID of the vendor if set,
+// otherwise the license ID is appended to QRCodeUrl.
+func (settings Settings) VendorQRCodeURL(vendor Vendor) string {
+	if vendor.UrlID != "" {
+		if strings.Contains(vendor.UrlID, "://") {
+			return vendor.UrlID
+		}
+		return "https://" + vendor.UrlID
+	}
+	return strings.TrimSuffix(settings.QRCodeUrl, "/") + "/" + url.PathEscape(vendor.LicenseID.String)
+}
+
+// Errors returned if a voucher code may not be redeemed
+var (
+	ErrVoucherNotValid = errors.New("the voucher code is not valid")
+	ErrVoucherUsedUp   = errors.New("the voucher code has already been used")
+	ErrVoucherNoItems  = errors.New("the voucher does not apply to the items of the order")
+)
+
+// Check checks if a voucher may be redeemed at now. The uses of its codes are checked on redemption.
+func (voucher Voucher) Check(now time.Time) error {
+	if voucher.Disabled {
+		return ErrVoucherNotValid
+	}
+	if voucher.ValidFrom.Valid && now.Before(voucher.ValidFrom.Time) {
+		return ErrVoucherNotValid
+	}
+	if voucher.ValidUntil.Valid && now.After(voucher.ValidUntil.Time) {
+		return ErrVoucherNotValid
+	}
+	return nil
+}
+
+// Discount returns the discount of a voucher in cents for the entries of an order that can be discounted
+func (voucher Voucher) Discount(entries []OrderEntry) (discount int) {
+	if voucher.Type == VoucherFreeItem {
+		for _, entry := range entries {
+			if voucher.FreeItem.Valid && entry.Item == int(voucher.FreeItem.Int64) && entry.Quantity > 0 {
+				return entry.Price
+			}
+		}
+		return 0
+	}
+	total := 0
+	for _, entry := range entries {
+		if len(voucher.Items) == 0 || slices.Contains(voucher.Items, entry.Item) {
+			total += entry.Price * entry.Quantity
+		}
+	}
+	switch voucher.Type {
+	case VoucherFixed:
+		return min(voucher.Amount, total)
+	case VoucherPercent:
+		return total * voucher.Percent / 100
+	}
+	return 0
+}
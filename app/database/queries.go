@@ -4,9 +4,12 @@ import (
 	"augustin/config"
 	"augustin/keycloak"
 	"augustin/mailer"
+	"augustin/pdfgen"
+	"augustin/storage"
 	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -30,11 +33,11 @@ func DeferTx(tx pgx.Tx, err error) error {
 		// Re-throw the panic
 		panic(p)
 	} else if err != nil {
-		// Rollback the transaction if an error occurred
+		// Rollback the transaction if an error occurred, the error is returned to the caller
 		log.Error("deferTx: ", err)
-		err = tx.Rollback(context.Background())
-		if err != nil {
-			log.Error("DeferTx rollback on error failed: ", err)
+		rollbackErr := tx.Rollback(context.Background())
+		if rollbackErr != nil {
+			log.Error("DeferTx rollback on error failed: ", rollbackErr)
 		}
 
 	} else {
@@ -62,10 +65,25 @@ func (db *Database) GetHelloWorld() (string, error) {
 
 // Users ----------------------------------------------------------------------
 
+// vendorLicenseValid is the condition for vendors that have a valid license today.
+// Vendors without any license period are not restricted.
+const vendorLicenseValid = "(NOT EXISTS (SELECT 1 FROM VendorLicense WHERE VendorLicense.Vendor = Vendor.ID) OR EXISTS (SELECT 1 FROM VendorLicense WHERE VendorLicense.Vendor = Vendor.ID AND StartDate <= CURRENT_DATE AND EndDate >= CURRENT_DATE))"
+
+// vendorLicenseEndDate is the end of the latest license period of a vendor
+const vendorLicenseEndDate = "(SELECT MAX(EndDate) FROM VendorLicense WHERE VendorLicense.Vendor = Vendor.ID)"
+
+// vendorColumns are the columns of the Vendor table and its license state in the order of scanVendor
+const vendorColumns = "ID, KeycloakID, UrlID, LicenseID, FirstName, LastName, Email, LastPayout, IsDisabled, Longitude, Latitude, Address, PLZ, Location, WorkingTime, Language, Comment, Telephone, RegistrationDate, VendorSince, OnlineMap, HasSmartphone, HasBankAccount, IsDeleted, AccountProofUrl, Photo, " + vendorLicenseEndDate + ", " + vendorLicenseValid
+
+// scanVendor returns the scan destinations for a row selected with vendorColumns
+func scanVendor(vendor *Vendor) []any {
+	return []any{&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap, &vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl, &vendor.Photo, &vendor.LicenseEndDate, &vendor.LicenseValid}
+}
+
 // ListVendors returns all users from the database but not all fields for better overview
 func (db *Database) ListVendors() (vendors []Vendor, err error) {
 	rows, err := db.Dbpool.Query(context.Background(), `
-		SELECT vendor.ID, LicenseID, FirstName, LastName, LastPayout, Balance, IsDisabled
+		SELECT vendor.ID, LicenseID, FirstName, LastName, LastPayout, Balance, IsDisabled, `+vendorLicenseEndDate+`, `+vendorLicenseValid+`
 		FROM Vendor 
 		JOIN Account ON Account.vendor = Vendor.id 
 		WHERE Account.Type = 'Vendor' and IsDeleted = false
@@ -79,7 +97,7 @@ func (db *Database) ListVendors() (vendors []Vendor, err error) {
 
 	for rows.Next() {
 		var vendor Vendor
-		err = rows.Scan(&vendor.ID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.LastPayout, &vendor.Balance, &vendor.IsDisabled)
+		err = rows.Scan(&vendor.ID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.LastPayout, &vendor.Balance, &vendor.IsDisabled, &vendor.LicenseEndDate, &vendor.LicenseValid)
 		if err != nil {
 			log.Error("ListVendors", err)
 			return vendors, err
@@ -93,8 +111,7 @@ func (db *Database) ListVendors() (vendors []Vendor, err error) {
 // GetVendorByLicenseID returns the vendor with the given licenseID
 func (db *Database) GetVendorByLicenseID(licenseID string) (vendor Vendor, err error) {
 	// Get vendor data
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Vendor WHERE LicenseID = $1 and IsDeleted = false", licenseID).Scan(&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap,
-		&vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE LicenseID = $1 and IsDeleted = false", licenseID).Scan(scanVendor(&vendor)...)
 	if err != nil {
 		log.Info("GetVendorByLicenseID: Couldn't get vendor: ", licenseID, err)
 		return vendor, err
@@ -108,11 +125,10 @@ func (db *Database) GetVendorByLicenseID(licenseID string) (vendor Vendor, err e
 	return vendor, err
 }
 
-// GetVendorByLicenseID returns the vendor with the given licenseID
+// GetVendorByLicenseIDWithoutDisabled returns the vendor with the given licenseID if the vendor is enabled and the license is valid
 func (db *Database) GetVendorByLicenseIDWithoutDisabled(licenseID string) (vendor Vendor, err error) {
 	// Get vendor data
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Vendor WHERE LicenseID = $1 and IsDeleted = false and IsDisabled = false", licenseID).Scan(&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap,
-		&vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE LicenseID = $1 and IsDeleted = false and IsDisabled = false and "+vendorLicenseValid, licenseID).Scan(scanVendor(&vendor)...)
 	if err != nil {
 		log.Info("GetVendorByLicenseID: Couldn't get vendor: ", licenseID, err)
 		return vendor, err
@@ -129,7 +145,7 @@ func (db *Database) GetVendorByLicenseIDWithoutDisabled(licenseID string) (vendo
 // GetVendorByEmail returns the vendor with the given licenseID
 func (db *Database) GetVendorByEmail(mail string) (vendor Vendor, err error) {
 	// Get vendor data
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Vendor WHERE Email = $1 and IsDeleted = false", mail).Scan(&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap, &vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE Email = $1 and IsDeleted = false", mail).Scan(scanVendor(&vendor)...)
 	if err != nil {
 		log.Error("GetVendorByEmail: Couldn't get vendor ", mail, err)
 		return vendor, err
@@ -146,7 +162,7 @@ func (db *Database) GetVendorByEmail(mail string) (vendor Vendor, err error) {
 // GetVendor returns the vendor with the given id
 func (db *Database) GetVendor(vendorID int) (vendor Vendor, err error) {
 	// Get vendor data
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Vendor WHERE ID = $1 and IsDeleted = false", vendorID).Scan(&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap, &vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE ID = $1 and IsDeleted = false", vendorID).Scan(scanVendor(&vendor)...)
 	if err != nil {
 		log.Error("GetVendor: Couldn't get vendor ", vendorID, err)
 		return vendor, err
@@ -169,7 +185,7 @@ func (db *Database) GetVendorWithBalanceUpdate(vendorID int) (vendor Vendor, err
 	}
 
 	// Get vendor data
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Vendor WHERE ID = $1 and IsDeleted = false", vendorID).Scan(&vendor.ID, &vendor.KeycloakID, &vendor.UrlID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.LastPayout, &vendor.IsDisabled, &vendor.Longitude, &vendor.Latitude, &vendor.Address, &vendor.PLZ, &vendor.Location, &vendor.WorkingTime, &vendor.Language, &vendor.Comment, &vendor.Telephone, &vendor.RegistrationDate, &vendor.VendorSince, &vendor.OnlineMap, &vendor.HasSmartphone, &vendor.HasBankAccount, &vendor.IsDeleted, &vendor.AccountProofUrl)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE ID = $1 and IsDeleted = false", vendorID).Scan(scanVendor(&vendor)...)
 	if err != nil {
 		log.Error("GetVendorWithBalanceUpdate: Couldn't get vendor ", vendorID, err)
 		return vendor, err
@@ -239,6 +255,69 @@ func (db *Database) UpdateVendor(id int, vendor Vendor) (err error) {
 	return nil
 }
 
+// ListVendorIdentities returns the Keycloak relevant fields of all vendors including deleted ones
+func (db *Database) ListVendorIdentities() (vendors []Vendor, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT ID, KeycloakID, LicenseID, FirstName, LastName, Email, IsDisabled, IsDeleted
+	FROM Vendor
+	ORDER BY ID
+	`)
+	if err != nil {
+		log.Error("ListVendorIdentities: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var vendor Vendor
+		err = rows.Scan(&vendor.ID, &vendor.KeycloakID, &vendor.LicenseID, &vendor.FirstName, &vendor.LastName, &vendor.Email, &vendor.IsDisabled, &vendor.IsDeleted)
+		if err != nil {
+			log.Error("ListVendorIdentities: ", err)
+			return
+		}
+		vendors = append(vendors, vendor)
+	}
+	err = rows.Err()
+	return
+}
+
+// UpdateVendorKeycloakID sets the ID of the Keycloak user of a vendor
+func (db *Database) UpdateVendorKeycloakID(vendorID int, keycloakID string) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "UPDATE Vendor SET KeycloakID = $1 WHERE ID = $2", keycloakID, vendorID)
+	if err != nil {
+		log.Error("UpdateVendorKeycloakID: ", err)
+	}
+	return
+}
+
+// UpdateVendorPhoto sets the photo of a vendor
+func (db *Database) UpdateVendorPhoto(vendorID int, photo string) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "UPDATE Vendor SET Photo = $1 WHERE ID = $2", photo, vendorID)
+	if err != nil {
+		log.Error("UpdateVendorPhoto: ", err)
+	}
+	return
+}
+
+// ListActiveVendors returns all vendors that are neither disabled nor deleted, ordered by license ID
+func (db *Database) ListActiveVendors() (vendors []Vendor, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE IsDisabled = false AND IsDeleted = false ORDER BY LicenseID ASC")
+	if err != nil {
+		log.Error("ListActiveVendors: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var vendor Vendor
+		err = rows.Scan(scanVendor(&vendor)...)
+		if err != nil {
+			log.Error("ListActiveVendors: ", err)
+			return
+		}
+		vendors = append(vendors, vendor)
+	}
+	return vendors, rows.Err()
+}
+
 // DeleteVendor deletes a user in the database and the associated account
 func (db *Database) DeleteVendor(vendorID int) (err error) {
 	// _, err = db.Dbpool.Exec(context.Background(), `
@@ -256,6 +335,19 @@ func (db *Database) DeleteVendor(vendorID int) (err error) {
 	`, vendorID)
 	if err != nil {
 		log.Error("DeleteVendor: ", err)
+		return
+	}
+
+	// Documents of deleted vendors are only kept for the retention period
+	if days := config.Config.VendorDocumentRetentionDays; days > 0 {
+		_, err = db.Dbpool.Exec(context.Background(), `
+		UPDATE VendorDocument
+		SET DeleteAt = CURRENT_DATE + $2::integer
+		WHERE Vendor = $1 AND (DeleteAt IS NULL OR DeleteAt > CURRENT_DATE + $2::integer)
+		`, vendorID, days)
+		if err != nil {
+			log.Error("DeleteVendor: ", err)
+		}
 	}
 
 	return
@@ -263,10 +355,32 @@ func (db *Database) DeleteVendor(vendorID int) (err error) {
 
 // Items ----------------------------------------------------------------------
 
+// itemColumns are the columns of the Item table in the order of scanItem
+const itemColumns = "ID, Name, Description, Price, Image, ImageVariants, LicenseItem, Archived, IsLicenseItem, LicenseGroup, IsPDFItem, PDF, ItemOrder, ItemColor, ItemTextColor, PDFValidityDays, PDFMaxDownloads, PDFMaxIPs, SubscriptionPeriod, LicenseValidityDays"
+
+// scanItem returns the scan destinations for a row selected with itemColumns
+func scanItem(item *Item) []any {
+	return []any{&item.ID, &item.Name, &item.Description, &item.Price, &item.Image, &item.ImageVariants, &item.LicenseItem, &item.Archived, &item.IsLicenseItem, &item.LicenseGroup, &item.IsPDFItem, &item.PDF, &item.ItemOrder, &item.ItemColor, &item.ItemTextColor, &item.PDFValidityDays, &item.PDFMaxDownloads, &item.PDFMaxIPs, &item.SubscriptionPeriod, &item.LicenseValidityDays}
+}
+
+// keepVariants returns an SQL expression that keeps the stored variants of an image column
+// if the image is unchanged and no new variants are given
+func keepVariants(imageColumn, imageParam, variantsColumn, variantsParam string) string {
+	return "CASE WHEN " + imageColumn + " = " + imageParam + " AND " + variantsParam + "::jsonb = '{}'::jsonb THEN " + variantsColumn + " ELSE " + variantsParam + "::jsonb END"
+}
+
+// variantsOrEmpty replaces nil variants, since the variant columns are not nullable
+func variantsOrEmpty(variants map[string]string) map[string]string {
+	if variants == nil {
+		return map[string]string{}
+	}
+	return variants
+}
+
 // ListItems returns all items from the database
 func (db *Database) ListItems(skipHiddenItems bool, skipLicenses bool) ([]Item, error) {
 	var items []Item
-	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM Item WHERE archived = false ORDER BY ItemOrder DESC")
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+itemColumns+" FROM Item WHERE archived = false ORDER BY ItemOrder DESC")
 	if err != nil {
 		log.Error("ListItems: ", err)
 		return items, err
@@ -274,14 +388,14 @@ func (db *Database) ListItems(skipHiddenItems bool, skipLicenses bool) ([]Item,
 	defer rows.Close()
 	for rows.Next() {
 		var item Item
-		err = rows.Scan(&item.ID, &item.Name, &item.Description, &item.Price, &item.Image, &item.LicenseItem, &item.Archived, &item.IsLicenseItem, &item.LicenseGroup, &item.IsPDFItem, &item.PDF, &item.ItemOrder, &item.ItemColor, &item.ItemTextColor)
+		err = rows.Scan(scanItem(&item)...)
 		if err != nil {
 			log.Error("ListItems: ", err)
 			return items, err
 		}
 
-		// Hardcode check: Do not add default items with their config names TransactionCostsName and DonationName
-		if skipHiddenItems && (item.Name == config.Config.TransactionCostsName || item.Name == config.Config.DonationName) {
+		// Hardcode check: Do not add default items with their config names TransactionCostsName, DonationName and VoucherName
+		if skipHiddenItems && (item.Name == config.Config.TransactionCostsName || item.Name == config.Config.DonationName || item.Name == config.Config.VoucherName) {
 			continue
 		}
 
@@ -297,7 +411,7 @@ func (db *Database) ListItems(skipHiddenItems bool, skipLicenses bool) ([]Item,
 
 // GetItemByName returns the item with the given name
 func (db *Database) GetItemByName(name string) (item Item, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Item WHERE Name = $1 and archived = false", name).Scan(&item.ID, &item.Name, &item.Description, &item.Price, &item.Image, &item.LicenseItem, &item.Archived, &item.IsLicenseItem, &item.LicenseGroup, &item.IsPDFItem, &item.PDF, &item.ItemOrder, &item.ItemColor, &item.ItemTextColor)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+itemColumns+" FROM Item WHERE Name = $1 and archived = false", name).Scan(scanItem(&item)...)
 	if err != nil {
 		log.Error("GetItemByName: ", err)
 	}
@@ -306,7 +420,7 @@ func (db *Database) GetItemByName(name string) (item Item, err error) {
 
 // GetItem returns the item with the given ID
 func (db *Database) GetItem(id int) (item Item, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM Item WHERE ID = $1 and archived = false", id).Scan(&item.ID, &item.Name, &item.Description, &item.Price, &item.Image, &item.LicenseItem, &item.Archived, &item.IsLicenseItem, &item.LicenseGroup, &item.IsPDFItem, &item.PDF, &item.ItemOrder, &item.ItemColor, &item.ItemTextColor)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+itemColumns+" FROM Item WHERE ID = $1 and archived = false", id).Scan(scanItem(&item)...)
 	if err != nil {
 		log.Error("GetItem: failed in Getitem() ", err)
 	}
@@ -315,7 +429,7 @@ func (db *Database) GetItem(id int) (item Item, err error) {
 
 // GetItemTx returns the item with the given ID
 func (db *Database) GetItemTx(tx pgx.Tx, id int) (item Item, err error) {
-	err = tx.QueryRow(context.Background(), "SELECT * FROM Item WHERE ID = $1", id).Scan(&item.ID, &item.Name, &item.Description, &item.Price, &item.Image, &item.LicenseItem, &item.Archived, &item.IsLicenseItem, &item.LicenseGroup, &item.IsPDFItem, &item.PDF, &item.ItemOrder, &item.ItemColor, &item.ItemTextColor)
+	err = tx.QueryRow(context.Background(), "SELECT "+itemColumns+" FROM Item WHERE ID = $1", id).Scan(scanItem(&item)...)
 	if err != nil {
 		log.Error("GetItem: failed in GetItemTx() ", err)
 	}
@@ -345,25 +459,35 @@ func (db *Database) CreateItem(item Item) (id int, err error) {
 	// Insert the new item
 	err = db.Dbpool.QueryRow(context.Background(), `
 	INSERT INTO Item
-	(Name, Description, Price, Image, LicenseItem, Archived, IsLicenseItem, LicenseGroup, IsPDFItem, PDF)
-	values ($1, $2, $3, '', $4, $5, $6, $7, $8, NULL)
+	(Name, Description, Price, Image, LicenseItem, Archived, IsLicenseItem, LicenseGroup, IsPDFItem, PDF, PDFValidityDays, PDFMaxDownloads, PDFMaxIPs, SubscriptionPeriod, LicenseValidityDays)
+	values ($1, $2, $3, '', $4, $5, $6, $7, $8, NULL, $9, $10, $11, $12, $13)
 	RETURNING ID
-	`, item.Name, item.Description, item.Price, item.LicenseItem, item.Archived, item.IsLicenseItem, item.LicenseGroup, item.IsPDFItem).Scan(&id)
+	`, item.Name, item.Description, item.Price, item.LicenseItem, item.Archived, item.IsLicenseItem, item.LicenseGroup, item.IsPDFItem, item.PDFValidityDays, item.PDFMaxDownloads, item.PDFMaxIPs, item.SubscriptionPeriod, item.LicenseValidityDays).Scan(&id)
 	if err != nil {
 		log.Error("CreateItem: failed to insert item ", err)
 	}
 	return id, err
 }
 
-// UpdateItem updates an item in the database
+// UpdateItem updates an item in the database.
+// The image variants are kept if the image is unchanged and no new variants are given.
 func (db *Database) UpdateItem(id int, item Item) (err error) {
 	_, err = db.Dbpool.Exec(context.Background(), `
 	UPDATE Item
-	SET Name = $2, Description = $3, Price = $4, Image = $5, LicenseItem = $6, Archived = $7, IsLicenseItem = $8, LicenseGroup = $9, IsPDFItem = $10, PDF = $11, ItemOrder = $12, ItemColor = $13, ItemTextColor = $14
+	SET Name = $2, Description = $3, Price = $4, Image = $5, LicenseItem = $6, Archived = $7, IsLicenseItem = $8, LicenseGroup = $9, IsPDFItem = $10, PDF = $11, ItemOrder = $12, ItemColor = $13, ItemTextColor = $14, PDFValidityDays = $15, PDFMaxDownloads = $16, PDFMaxIPs = $17, SubscriptionPeriod = $19, LicenseValidityDays = $20,
+	ImageVariants = `+keepVariants("Image", "$5", "ImageVariants", "$18")+`
 	WHERE ID = $1
-	`, id, item.Name, item.Description, item.Price, item.Image, item.LicenseItem, item.Archived, item.IsLicenseItem, item.LicenseGroup, item.IsPDFItem, item.PDF, item.ItemOrder, item.ItemColor, item.ItemTextColor)
+	`, id, item.Name, item.Description, item.Price, item.Image, item.LicenseItem, item.Archived, item.IsLicenseItem, item.LicenseGroup, item.IsPDFItem, item.PDF, item.ItemOrder, item.ItemColor, item.ItemTextColor, item.PDFValidityDays, item.PDFMaxDownloads, item.PDFMaxIPs, variantsOrEmpty(item.ImageVariants), item.SubscriptionPeriod, item.LicenseValidityDays)
 	if err != nil {
 		log.Error("DB UpdateItem: ", err)
+		return
+	}
+	// A PDF uploaded with the item becomes an edition of the item
+	if item.PDF.Valid {
+		_, err = db.Dbpool.Exec(context.Background(), "UPDATE PDF SET Item = $1, PublicationDate = COALESCE(PublicationDate, Timestamp::date) WHERE ID = $2 AND Item IS NULL", id, item.PDF)
+		if err != nil {
+			log.Error("DB UpdateItem: failed to link pdf ", err)
+		}
 	}
 	return
 }
@@ -383,9 +507,17 @@ func (db *Database) DeleteItem(id int) (err error) {
 
 // Orders ---------------------------------------------------------------------
 
+// orderColumns are the columns of the PaymentOrder table in the order of scanOrder
+const orderColumns = "ID, OrderCode, TransactionID, Verified, TransactionTypeID, Timestamp, UserID, Vendor, CustomerEmail, Language, RecipientEmail, RecipientName, GiftMessage, DeliveryDate, DeliveredAt"
+
+// scanOrder returns the scan destinations for a row selected with orderColumns
+func scanOrder(order *Order) []any {
+	return []any{&order.ID, &order.OrderCode, &order.TransactionID, &order.Verified, &order.TransactionTypeID, &order.Timestamp, &order.User, &order.Vendor, &order.CustomerEmail, &order.Language, &order.RecipientEmail, &order.RecipientName, &order.GiftMessage, &order.DeliveryDate, &order.DeliveredAt}
+}
+
 // GetOrderEntries returns all entries of an order
 func (db *Database) GetOrderEntries(orderID int) (entries []OrderEntry, err error) {
-	rows, err := db.Dbpool.Query(context.Background(), "SELECT OrderEntry.ID, Item, Quantity, Price, Sender, Receiver, SenderAccount.Name, ReceiverAccount.Name, IsSale FROM OrderEntry JOIN Account as SenderAccount ON SenderAccount.ID = Sender JOIN Account as ReceiverAccount ON ReceiverAccount.ID = Receiver WHERE paymentorder = $1 ", orderID)
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT OrderEntry.ID, Item, Quantity, Price, Sender, Receiver, SenderAccount.Name, ReceiverAccount.Name, IsSale, PDF, VoucherCode FROM OrderEntry JOIN Account as SenderAccount ON SenderAccount.ID = Sender JOIN Account as ReceiverAccount ON ReceiverAccount.ID = Receiver WHERE paymentorder = $1 ", orderID)
 	if err != nil {
 		log.Error("GetOrderEntries: ", err)
 		return
@@ -393,7 +525,7 @@ func (db *Database) GetOrderEntries(orderID int) (entries []OrderEntry, err erro
 	defer rows.Close()
 	for rows.Next() {
 		var entry OrderEntry
-		err = rows.Scan(&entry.ID, &entry.Item, &entry.Quantity, &entry.Price, &entry.Sender, &entry.Receiver, &entry.SenderName, &entry.ReceiverName, &entry.IsSale)
+		err = rows.Scan(&entry.ID, &entry.Item, &entry.Quantity, &entry.Price, &entry.Sender, &entry.Receiver, &entry.SenderName, &entry.ReceiverName, &entry.IsSale, &entry.PDF, &entry.VoucherCode)
 		if err != nil {
 			log.Error("GetOrderEntries: ", err)
 			return
@@ -403,7 +535,7 @@ func (db *Database) GetOrderEntries(orderID int) (entries []OrderEntry, err erro
 	return
 }
 func (db *Database) GetOrderEntriesTx(tx pgx.Tx, orderID int) (entries []OrderEntry, err error) {
-	rows, err := tx.Query(context.Background(), "SELECT OrderEntry.ID, Item, Quantity, Price, Sender, Receiver, SenderAccount.Name, ReceiverAccount.Name, IsSale FROM OrderEntry JOIN Account as SenderAccount ON SenderAccount.ID = Sender JOIN Account as ReceiverAccount ON ReceiverAccount.ID = Receiver WHERE paymentorder = $1 ", orderID)
+	rows, err := tx.Query(context.Background(), "SELECT OrderEntry.ID, Item, Quantity, Price, Sender, Receiver, SenderAccount.Name, ReceiverAccount.Name, IsSale, PDF, VoucherCode FROM OrderEntry JOIN Account as SenderAccount ON SenderAccount.ID = Sender JOIN Account as ReceiverAccount ON ReceiverAccount.ID = Receiver WHERE paymentorder = $1 ", orderID)
 	if err != nil {
 		log.Error("GetOrderEntriesTx: ", err)
 		return
@@ -412,7 +544,7 @@ func (db *Database) GetOrderEntriesTx(tx pgx.Tx, orderID int) (entries []OrderEn
 
 	for rows.Next() {
 		var entry OrderEntry
-		err = rows.Scan(&entry.ID, &entry.Item, &entry.Quantity, &entry.Price, &entry.Sender, &entry.Receiver, &entry.SenderName, &entry.ReceiverName, &entry.IsSale)
+		err = rows.Scan(&entry.ID, &entry.Item, &entry.Quantity, &entry.Price, &entry.Sender, &entry.Receiver, &entry.SenderName, &entry.ReceiverName, &entry.IsSale, &entry.PDF, &entry.VoucherCode)
 		if err != nil {
 			log.Error("GetOrderEntriesTx: ", err)
 			return
@@ -461,7 +593,7 @@ func (db *Database) GetOrders() (orders []Order, err error) {
 
 // GetOrderByID returns Order by OrderID
 func (db *Database) GetOrderByID(id int) (order Order, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PaymentOrder WHERE ID = $1", id).Scan(&order.ID, &order.OrderCode, &order.TransactionID, &order.Verified, &order.TransactionTypeID, &order.Timestamp, &order.User, &order.Vendor, &order.CustomerEmail)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+orderColumns+" FROM PaymentOrder WHERE ID = $1", id).Scan(scanOrder(&order)...)
 	if err != nil {
 		log.Error("GetOrderByID: ", err)
 		return
@@ -478,7 +610,7 @@ func (db *Database) GetOrderByID(id int) (order Order, err error) {
 
 // GetOrderByIDTx returns Order by OrderID
 func (db *Database) GetOrderByIDTx(tx pgx.Tx, id int) (order Order, err error) {
-	err = tx.QueryRow(context.Background(), "SELECT * FROM PaymentOrder WHERE ID = $1", id).Scan(&order.ID, &order.OrderCode, &order.TransactionID, &order.Verified, &order.TransactionTypeID, &order.Timestamp, &order.User, &order.Vendor, &order.CustomerEmail)
+	err = tx.QueryRow(context.Background(), "SELECT "+orderColumns+" FROM PaymentOrder WHERE ID = $1", id).Scan(scanOrder(&order)...)
 	if err != nil {
 		log.Error("GetOrderByIDTx: ", err)
 		return
@@ -496,7 +628,7 @@ func (db *Database) GetOrderByIDTx(tx pgx.Tx, id int) (order Order, err error) {
 // GetOrderByOrderCode returns Order by OrderCode
 func (db *Database) GetOrderByOrderCode(OrderCode string) (order Order, err error) {
 
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PaymentOrder WHERE OrderCode = $1", OrderCode).Scan(&order.ID, &order.OrderCode, &order.TransactionID, &order.Verified, &order.TransactionTypeID, &order.Timestamp, &order.User, &order.Vendor, &order.CustomerEmail)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+orderColumns+" FROM PaymentOrder WHERE OrderCode = $1", OrderCode).Scan(scanOrder(&order)...)
 	if err != nil {
 		log.Error("GetOrderByOrderCode: ", err)
 		return
@@ -526,7 +658,7 @@ func (db *Database) CreateOrder(order Order) (orderID int, err error) {
 		}
 	}()
 
-	err = tx.QueryRow(context.Background(), "INSERT INTO PaymentOrder (OrderCode, Vendor, CustomerEmail) values ($1, $2, $3) RETURNING ID", order.OrderCode, order.Vendor, order.CustomerEmail).Scan(&orderID)
+	err = tx.QueryRow(context.Background(), "INSERT INTO PaymentOrder (OrderCode, Vendor, CustomerEmail, Language, RecipientEmail, RecipientName, GiftMessage, DeliveryDate) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID", order.OrderCode, order.Vendor, order.CustomerEmail, order.Language, order.RecipientEmail, order.RecipientName, order.GiftMessage, order.DeliveryDate).Scan(&orderID)
 	if err != nil {
 		log.Error("CreateOrder failed: ", err)
 		return
@@ -534,6 +666,12 @@ func (db *Database) CreateOrder(order Order) (orderID int, err error) {
 
 	// Create order items
 	for _, entry := range order.Entries {
+		if entry.VoucherCode.Valid {
+			err = redeemVoucherCodeTx(tx, int(entry.VoucherCode.Int64))
+			if err != nil {
+				return
+			}
+		}
 		_, err = createOrderEntryTx(tx, orderID, entry)
 		if err != nil {
 			log.Errorf("CreateOrder create order entries: %+v %+v", entry, err)
@@ -559,17 +697,20 @@ func (db *Database) DeleteOrder(id int) (err error) {
 // createOrderEntryTx adds an entry to an order in an transaction
 func createOrderEntryTx(tx pgx.Tx, orderID int, entry OrderEntry) (OrderEntry, error) {
 
-	// Get current item price
-	var item Item
-	err := tx.QueryRow(context.Background(), "SELECT Price FROM Item WHERE ID = $1", entry.Item).Scan(&item.Price)
-	if err != nil {
-		log.Error("createOrderEntryTx: query row", err)
-		return entry, err
+	// Get current item price, the price of discounts of vouchers is the discount
+	var err error
+	if !entry.VoucherCode.Valid {
+		var item Item
+		err = tx.QueryRow(context.Background(), "SELECT Price FROM Item WHERE ID = $1", entry.Item).Scan(&item.Price)
+		if err != nil {
+			log.Error("createOrderEntryTx: query row", err)
+			return entry, err
+		}
+		entry.Price = item.Price
 	}
-	entry.Price = item.Price
 
 	// Create order entry
-	err = tx.QueryRow(context.Background(), "INSERT INTO OrderEntry (Item, Price, Quantity, PaymentOrder, Sender, Receiver, IsSale) values ($1, $2, $3, $4, $5, $6, $7) RETURNING ID", entry.Item, entry.Price, entry.Quantity, orderID, entry.Sender, entry.Receiver, entry.IsSale).Scan(&entry.ID)
+	err = tx.QueryRow(context.Background(), "INSERT INTO OrderEntry (Item, Price, Quantity, PaymentOrder, Sender, Receiver, IsSale, PDF, VoucherCode) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING ID", entry.Item, entry.Price, entry.Quantity, orderID, entry.Sender, entry.Receiver, entry.IsSale, entry.PDF, entry.VoucherCode).Scan(&entry.ID)
 	if err != nil {
 		log.Error("createOrderEntryTx: insert ", err)
 	}
@@ -619,6 +760,16 @@ func (db *Database) VerifyOrderAndCreatePayments(orderID int, transactionTypeID
 	}
 
 	defer func() { err = DeferTx(tx, err) }()
+
+	// Lock the order, so that concurrent verifications (webhook and redirect) are processed one after another
+	// and emails are only queued once
+	var alreadyVerified bool
+	err = tx.QueryRow(context.Background(), "SELECT Verified FROM PaymentOrder WHERE ID = $1 FOR UPDATE", orderID).Scan(&alreadyVerified)
+	if err != nil {
+		log.Error("VerifyOrderAndCreatePayments: lock payment order", orderID, err)
+		return err
+	}
+
 	// Verify payment order
 	_, err = tx.Exec(context.Background(), `
 	UPDATE PaymentOrder
@@ -635,107 +786,301 @@ func (db *Database) VerifyOrderAndCreatePayments(orderID int, transactionTypeID
 		log.Error("VerifyOrderAndCreatePayments: get order by id", orderID, err)
 		return err
 	}
-	if order.CustomerEmail.Valid && order.CustomerEmail.String != "" {
+	// Uses of voucher codes are only reserved during the checkout. The discount of an order paid later has been captured,
+	// so the order is verified anyway and the office is notified (through the error log) that the code has been overused.
+	if !alreadyVerified {
 		for _, entry := range order.Entries {
-			item, err := db.GetItemTx(tx, entry.Item)
+			if !entry.VoucherCode.Valid {
+				continue
+			}
+			var overused bool
+			overused, err = voucherCodeOverusedTx(tx, int(entry.VoucherCode.Int64), orderID)
 			if err != nil {
-				log.Error("VerifyOrderAndCreatePayments: failed to get item: ", orderID, err)
+				return err
+			}
+			if overused {
+				log.Error("VerifyOrderAndCreatePayments: voucher code ", entry.VoucherCode.Int64, " of order ", orderID, " has been used more often than allowed")
+			}
+		}
+	}
+	// Gifts are delivered once to the recipient, at the delivery date if it is in the future
+	delivered := alreadyVerified
+	if order.IsGift() {
+		delivered = order.DeliveredAt.Valid
+	}
+	if order.RecipientErased() {
+		log.Info("VerifyOrderAndCreatePayments: recipient of gift of order ", orderID, " has been erased")
+	} else if order.IsGift() && !delivered && order.DeliveryDate.Valid && order.DeliveryDate.Time.After(time.Now()) {
+		log.Info("VerifyOrderAndCreatePayments: gift of order ", orderID, " is delivered on ", order.DeliveryDate.Time)
+	} else {
+		err = db.deliverOrderTx(tx, order, delivered)
+		if err != nil {
+			return err
+		}
+	}
+	// Create payments
+	for _, entry := range order.Entries {
+		_, err = createPaymentForOrderEntryTx(tx, orderID, entry, false)
+		if err != nil {
+			log.Error("VerifyOrderAndCreatePayments: create payments for order entry: ", orderID, err)
+			return err
+		}
+	}
+
+	// Send order confirmation with receipt, buyers of gifts always get it as they don't get the items
+	if !alreadyVerified && (config.Config.SendOrderConfirmation || order.IsGift()) && order.CustomerEmail.Valid && order.CustomerEmail.String != "" {
+		// The confirmation is optional and must not prevent the verification of the order.
+		// It is queued in a savepoint, as a failed statement would abort the whole transaction.
+		savepoint, confirmationErr := tx.Begin(context.Background())
+		if confirmationErr == nil {
+			if wantsOrderConfirmationTx(savepoint, order.CustomerEmail.String) {
+				confirmationErr = db.queueOrderConfirmationTx(savepoint, order)
+			}
+			if confirmationErr != nil {
+				savepoint.Rollback(context.Background())
+			} else {
+				confirmationErr = savepoint.Commit(context.Background())
 			}
-			if item.LicenseItem.Valid {
+		}
+		if confirmationErr != nil {
+			log.Error("VerifyOrderAndCreatePayments: failed to queue order confirmation: ", orderID, confirmationErr)
+		}
+	}
+
+	return
+}
 
-				if !item.IsPDFItem {
-					// add customer to licenseItemGroup
+// deliverOrderTx adds the license holder of an order to the license groups of its digital license items,
+// creates the download links of its PDFs and queues their emails. Emails are not queued again on redelivery.
+// Gifts are marked as delivered.
+func (db *Database) deliverOrderTx(tx pgx.Tx, order Order, redelivery bool) (err error) {
+	holder := order.LicenseHolder()
+	if holder == "" {
+		return nil
+	}
+	if order.IsGift() && !redelivery {
+		templateData := map[string]interface{}{
+			"RecipientName": order.RecipientName,
+			"GiftMessage":   order.GiftMessage,
+		}
+		_, err = db.QueueEmailTx(tx, holder, EmailTemplateGift, order.Language, templateData)
+		if err != nil {
+			log.Error("deliverOrderTx: failed to queue gift mail: ", order.ID, err)
+			return err
+		}
+		_, err = tx.Exec(context.Background(), "UPDATE PaymentOrder SET DeliveredAt = NOW() WHERE ID = $1", order.ID)
+		if err != nil {
+			log.Error("deliverOrderTx: ", order.ID, err)
+			return err
+		}
+	}
+	for _, entry := range order.Entries {
+		item, err := db.GetItemTx(tx, entry.Item)
+		if err != nil {
+			log.Error("deliverOrderTx: failed to get item: ", order.ID, err)
+		}
+		if item.LicenseItem.Valid {
 
-					customer, err := keycloak.KeycloakClient.GetOrCreateUser(order.CustomerEmail.String)
+			if !item.IsPDFItem {
+				// Verifying the order again must not give back access that has expired
+				if redelivery {
+					expired, err := licenseAccessExpiredTx(tx, order, item)
 					if err != nil {
-						log.Error("VerifyOrderAndCreatePayments: failed to create keycloak customer: ", orderID, err)
+						return err
 					}
-					// add customer to customer group
-					err = keycloak.KeycloakClient.AssignGroup(customer, "customer")
-					if err != nil {
-						log.Error("VerifyOrderAndCreatePayments: failed to assign customer to group: ", orderID, err)
+					if expired {
+						continue
 					}
-					err = keycloak.KeycloakClient.AssignDigitalLicenseGroup(customer, item.LicenseGroup.String)
-					if err != nil {
-						log.Error("VerifyOrderAndCreatePayments: failed to assign customer to license group: ", orderID, err)
+				}
+
+				// add customer to licenseItemGroup
+
+				customer, err := keycloak.KeycloakClient.GetOrCreateUser(holder)
+				if err != nil {
+					log.Error("deliverOrderTx: failed to create keycloak customer: ", order.ID, err)
+				}
+				// add customer to customer group
+				err = keycloak.KeycloakClient.AssignGroup(customer, "customer")
+				if err != nil {
+					log.Error("deliverOrderTx: failed to assign customer to group: ", order.ID, err)
+				}
+				err = keycloak.KeycloakClient.AssignDigitalLicenseGroup(customer, item.LicenseGroup.String)
+				if err != nil {
+					log.Error("deliverOrderTx: failed to assign customer to license group: ", order.ID, err)
+				}
+				// Queue email with link to the license Item
+				if !redelivery {
+					templateData := map[string]interface{}{
+						"URL": config.Config.OnlinePaperUrl,
 					}
-					// Send email with link to the license Item
-					templateData := struct {
-						URL string
-					}{
-						URL: config.Config.OnlinePaperUrl,
+					if item.SubscriptionPeriod != "" {
+						subscription, err := renewSubscriptionTx(tx, order, item, entry.Quantity, customer)
+						if err != nil {
+							log.Error("deliverOrderTx: failed to renew subscription: ", order.ID, err)
+							return err
+						}
+						templateData["ValidUntil"] = subscription.ValidUntil.In(config.Config.TimeZone).Format("02.01.2006")
+					} else {
+						grant, err := createLicenseGrantTx(tx, order, item, customer)
+						if err != nil {
+							log.Error("deliverOrderTx: failed to create license grant: ", order.ID, err)
+							return err
+						}
+						if grant.EndDate.Valid {
+							templateData["ValidUntil"] = grant.EndDate.Time.In(config.Config.TimeZone).Format("02.01.2006")
+						}
 					}
-					receivers := []string{order.CustomerEmail.String}
-					mail, err := mailer.NewRequestFromTemplate(receivers, "A new newspaper has been purchased", "digitalLicenceItemTemplate.html", templateData)
+					_, err = db.QueueEmailTx(tx, holder, EmailTemplateDigitalLicenceItem, order.Language, templateData)
 					if err != nil {
-						log.Error("VerifyOrderAndCreatePayments: failed to create mail: ", orderID, err)
+						log.Error("deliverOrderTx: failed to queue mail: ", order.ID, err)
+						return err
 					}
-					success, err := mail.SendEmail()
-					if err != nil || !success {
-						log.Error("VerifyOrderAndCreatePayments: failed to send mail: ", orderID, err)
-					}
-				} else {
-					// Generate download link and send it to the
+				}
+			} else {
+				// Generate download link and send it to the
+				// The edition is stored in the entry, older orders use the PDF of the item
+				pdf_id := entry.PDF.ValueOrZero()
+				if !entry.PDF.Valid {
 					if !item.PDF.Valid {
-						log.Error("VerifyOrderAndCreatePayments: item has no pdf: oder id: ", orderID, "itemid: ", item.ID, err)
+						log.Error("deliverOrderTx: item has no pdf: oder id: ", order.ID, "itemid: ", item.ID, err)
 					}
-					pdf_id := item.PDF.ValueOrZero()
-					// TODO: check if pdf exists
-					pdf, err := db.GetPDFByID(pdf_id)
-					if err != nil {
-						log.Error("VerifyOrderAndCreatePayments: failed to get pdf: orderid", orderID, "item", item.ID, err)
-					}
-					// Check if link already created for Download
+					pdf_id = item.PDF.ValueOrZero()
+				}
+				// TODO: check if pdf exists
+				pdf, err := db.GetPDFByID(pdf_id)
+				if err != nil {
+					log.Error("deliverOrderTx: failed to get pdf: orderid", order.ID, "item", item.ID, err)
+				}
+				// Check if link already created for Download
 
-					pdfDownload, err := db.GetPDFDownloadByOrderIdAndItemTx(tx, orderID, item.ID)
+				pdfDownload, err := db.GetPDFDownloadByOrderIdAndItemTx(tx, order.ID, item.ID, pdf.ID)
 
+				if err != nil {
+					log.Debug("deliverOrderTx:create pdf download: ", order.ID, item.ID, err)
+					pdfDownload, err = db.CreatePDFDownload(tx, pdf, order.ID, item)
 					if err != nil {
-						log.Debug("VerifyOrderAndCreatePayments:create pdf download: ", orderID, item.ID, err)
-						pdfDownload, err = db.CreatePDFDownload(tx, pdf, orderID, item.ID)
-						if err != nil {
-							log.Error("VerifyOrderAndCreatePayments: failed to create pdf download: ", orderID, err)
-						}
+						log.Error("deliverOrderTx: failed to create pdf download: ", order.ID, err)
 					}
+				}
 
-					if !pdfDownload.EmailSent {
-						url := config.Config.FrontendURL + "/pdf/" + pdfDownload.LinkID
-						templateData := struct {
-							URL string
-						}{
-							URL: url,
-						}
-						receivers := []string{order.CustomerEmail.String}
-						mail, err := mailer.NewRequestFromTemplate(receivers, "Deine Zeitung ist bereit zum Download", "PDFLicenceItemTemplate.html", templateData)
-						if err != nil {
-							log.Error("VerifyOrderAndCreatePayments: failed to create mail: ", orderID, err)
-						}
-						success, err := mail.SendEmail()
-						if err != nil || !success {
-							log.Error("VerifyOrderAndCreatePayments: failed to send mail: ", orderID, err)
-						}
-						pdfDownload.EmailSent = true
-						pdfDownload.OrderID = null.IntFrom(int64(orderID))
-						pdfDownload.ItemID = null.IntFrom(int64(item.ID))
-						err = db.UpdatePdfDownloadTx(tx, pdfDownload)
-						if err != nil {
-							log.Error("VerifyOrderAndCreatePayments; failed to update pdfdownload ", err)
-						}
+				if !pdfDownload.EmailSent {
+					templateData := map[string]interface{}{
+						"URL": config.Config.FrontendURL + "/pdf/" + pdfDownload.LinkID,
+					}
+					_, err = db.QueueEmailTx(tx, holder, EmailTemplatePDFLicenceItem, order.Language, templateData)
+					if err != nil {
+						log.Error("deliverOrderTx: failed to queue mail: ", order.ID, err)
+						return err
+					}
+					// EmailSent marks that the email has been handed over to the outbox
+					pdfDownload.EmailSent = true
+					pdfDownload.OrderID = null.IntFrom(int64(order.ID))
+					pdfDownload.ItemID = null.IntFrom(int64(item.ID))
+					err = db.UpdatePdfDownloadTx(tx, pdfDownload)
+					if err != nil {
+						log.Error("deliverOrderTx; failed to update pdfdownload ", err)
 					}
-
 				}
 
 			}
+
 		}
 	}
-	// Create payments
+	return
+}
+
+// queueOrderConfirmationTx queues the order confirmation email with a PDF receipt attached
+func (db *Database) queueOrderConfirmationTx(tx pgx.Tx, order Order) (err error) {
+	settings, err := db.GetSettings()
+	if err != nil {
+		return
+	}
+	vendor, err := db.GetVendor(order.Vendor)
+	if err != nil {
+		return
+	}
+
+	var logo []byte
+	if settings.Logo != "" {
+		logo, err = storage.ReadAll(context.Background(), settings.Logo)
+		if err != nil {
+			// The receipt is sent without logo
+			log.Warn("queueOrderConfirmationTx: failed to read logo: ", err)
+		}
+	}
+
+	receipt := pdfgen.Receipt{
+		NewspaperName:   settings.NewspaperName,
+		Color:           settings.Color,
+		Logo:            logo,
+		OrderCode:       order.OrderCode.String,
+		Date:            order.Timestamp,
+		VendorFirstName: vendor.FirstName,
+		Total:           order.GetTotal(),
+		Language:        order.Language,
+	}
+	var entries []map[string]interface{}
 	for _, entry := range order.Entries {
-		_, err = createPaymentForOrderEntryTx(tx, orderID, entry, false)
+		if entry.VoucherCode.Valid {
+			receipt.Discount += entry.Price * entry.Quantity
+			continue
+		}
+		// Only entries the customer paid for are listed
+		if !entry.IsSale {
+			continue
+		}
+		var item Item
+		item, err = db.GetItemTx(tx, entry.Item)
 		if err != nil {
-			log.Error("VerifyOrderAndCreatePayments: create payments for order entry: ", orderID, err)
-			return err
+			return
 		}
+		if item.Name == config.Config.DonationName {
+			receipt.Donation += entry.Price * entry.Quantity
+			continue
+		}
+		receipt.Lines = append(receipt.Lines, pdfgen.ReceiptLine{Name: item.Name, Quantity: entry.Quantity, Price: entry.Price})
+		entries = append(entries, map[string]interface{}{
+			"Name":     item.Name,
+			"Quantity": entry.Quantity,
+			"Price":    pdfgen.FormatEuro(entry.Price),
+			"Total":    pdfgen.FormatEuro(entry.Price * entry.Quantity),
+		})
+	}
+
+	pdf, err := pdfgen.GenerateReceipt(receipt)
+	if err != nil {
+		return
 	}
 
+	templateData := map[string]interface{}{
+		"NewspaperName":   settings.NewspaperName,
+		"Color":           settings.Color,
+		"VendorFirstName": vendor.FirstName,
+		"OrderCode":       order.OrderCode.String,
+		"Entries":         entries,
+		"Donation":        "",
+		"Total":           pdfgen.FormatEuro(receipt.Total),
+		"Recipient":       "",
+		"Discount":        "",
+	}
+	if receipt.Donation > 0 {
+		templateData["Donation"] = pdfgen.FormatEuro(receipt.Donation)
+	}
+	if receipt.Discount > 0 {
+		templateData["Discount"] = pdfgen.FormatEuro(-receipt.Discount)
+	}
+	if order.IsGift() {
+		templateData["Recipient"] = order.RecipientEmail.String
+		if order.RecipientName != "" {
+			templateData["Recipient"] = order.RecipientName
+		}
+	}
+	attachment := mailer.Attachment{
+		Filename:    "receipt-" + order.OrderCode.String + ".pdf",
+		ContentType: "application/pdf",
+		Data:        pdf,
+	}
+	_, err = db.QueueEmailTx(tx, order.CustomerEmail.String, EmailTemplateOrderConfirmation, order.Language, templateData, attachment)
 	return
 }
 
@@ -1210,9 +1555,9 @@ func (db *Database) InitiateSettings() (err error) {
 func (db *Database) GetSettings() (Settings, error) {
 	var settings Settings
 	err := db.Dbpool.QueryRow(context.Background(), `
-	SELECT Settings.ID, Color, FontColor, Logo, MainItem, MaxOrderAmount, OrgaCoversTransactionCosts, Name, Price, Description, Image, WebshopIsClosed, VendorNotFoundHelpUrl, MaintainanceModeHelpUrl, VendorEmailPostfix, NewspaperName, QRCodeUrl, QRCodeLogoImgUrl, AGBUrl, MapCenterLat, MapCenterLong, UseVendorLicenseIdInShop, Favicon, QrCodeSettings, QRCodeEnableLogo  from Settings LEFT JOIN Item ON Item.ID = MainItem LIMIT 1
+	SELECT Settings.ID, Color, FontColor, Logo, LogoVariants, FaviconVariants, MainItem, MaxOrderAmount, OrgaCoversTransactionCosts, Name, Price, Description, Image, WebshopIsClosed, VendorNotFoundHelpUrl, MaintainanceModeHelpUrl, VendorEmailPostfix, NewspaperName, QRCodeUrl, QRCodeLogoImgUrl, AGBUrl, MapCenterLat, MapCenterLong, UseVendorLicenseIdInShop, Favicon, QrCodeSettings, QRCodeEnableLogo  from Settings LEFT JOIN Item ON Item.ID = MainItem LIMIT 1
 	`).Scan(&settings.ID, &settings.Color, &settings.FontColor,
-		&settings.Logo, &settings.MainItem, &settings.MaxOrderAmount,
+		&settings.Logo, &settings.LogoVariants, &settings.FaviconVariants, &settings.MainItem, &settings.MaxOrderAmount,
 		&settings.OrgaCoversTransactionCosts, &settings.MainItemName,
 		&settings.MainItemPrice, &settings.MainItemDescription, &settings.MainItemImage,
 		&settings.WebshopIsClosed, &settings.VendorNotFoundHelpUrl,
@@ -1240,7 +1585,9 @@ func (db *Database) UpdateSettings(settings Settings) (err error) {
 
 	_, err = tx.Exec(context.Background(), `
 	UPDATE Settings
-	SET Color = $1, FontColor = $2, Logo = $3, MainItem = $4, MaxOrderAmount = $5, OrgaCoversTransactionCosts = $6, WebshopIsClosed = $7, VendorNotFoundHelpUrl = $8, MaintainanceModeHelpUrl = $9, VendorEmailPostfix = $10, NewspaperName = $11, QRCodeUrl = $12, QRCodeLogoImgUrl = $13, AGBUrl = $14, MapCenterLat = $15, MapCenterLong = $16, UseVendorLicenseIdInShop = $17, Favicon = $18, QrCodeSettings = $19, QRCodeEnableLogo = $20
+	SET Color = $1, FontColor = $2, Logo = $3, MainItem = $4, MaxOrderAmount = $5, OrgaCoversTransactionCosts = $6, WebshopIsClosed = $7, VendorNotFoundHelpUrl = $8, MaintainanceModeHelpUrl = $9, VendorEmailPostfix = $10, NewspaperName = $11, QRCodeUrl = $12, QRCodeLogoImgUrl = $13, AGBUrl = $14, MapCenterLat = $15, MapCenterLong = $16, UseVendorLicenseIdInShop = $17, Favicon = $18, QrCodeSettings = $19, QRCodeEnableLogo = $20,
+	LogoVariants = `+keepVariants("Logo", "$3", "LogoVariants", "$21")+`,
+	FaviconVariants = `+keepVariants("Logo", "$3", "FaviconVariants", "$22")+`
 	WHERE ID = 1`,
 		settings.Color, settings.FontColor, settings.Logo,
 		settings.MainItem, settings.MaxOrderAmount,
@@ -1250,6 +1597,7 @@ func (db *Database) UpdateSettings(settings Settings) (err error) {
 		settings.QRCodeLogoImgUrl, settings.AGBUrl, settings.MapCenterLat, settings.MapCenterLong,
 		settings.UseVendorLicenseIdInShop,
 		&settings.Favicon, &settings.QRCodeSettings, &settings.QRCodeEnableLogo,
+		variantsOrEmpty(settings.LogoVariants), variantsOrEmpty(settings.FaviconVariants),
 	)
 	if err != nil {
 		log.Error("db UpdateSettings: ", err)
@@ -1257,6 +1605,38 @@ func (db *Database) UpdateSettings(settings Settings) (err error) {
 	return err
 }
 
+// UnusedImageKeys returns the storage keys of images that are neither used by the settings nor by an item.
+// Uploaded images are content-addressed, so the same file may be used several times.
+func (db *Database) UnusedImageKeys(keys []string) (unused []string, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT Key FROM (
+		SELECT ltrim(Logo, '/') AS Key FROM Settings
+		UNION SELECT ltrim(Favicon, '/') FROM Settings
+		UNION SELECT ltrim(QRCodeLogoImgUrl, '/') FROM Settings
+		UNION SELECT ltrim(value, '/') FROM Settings, jsonb_each_text(LogoVariants)
+		UNION SELECT ltrim(value, '/') FROM Settings, jsonb_each_text(FaviconVariants)
+		UNION SELECT ltrim(Image, '/') FROM Item
+		UNION SELECT ltrim(value, '/') FROM Item, jsonb_each_text(ImageVariants)
+		UNION SELECT ltrim(CoverImage, '/') FROM PDF
+	) AS Used WHERE Key = ANY($1)
+	`, keys)
+	if err != nil {
+		log.Error("UnusedImageKeys: ", err)
+		return
+	}
+	used, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("UnusedImageKeys: ", err)
+		return
+	}
+	for _, key := range keys {
+		if !slices.Contains(used, key) && !slices.Contains(unused, key) {
+			unused = append(unused, key)
+		}
+	}
+	return
+}
+
 // DBSettings -----------------------------------------------------------------
 
 // InitiateDBSettings creates default settings if they don't exist
@@ -1301,54 +1681,172 @@ func (db *Database) GetDBSettings() (DBSettings, error) {
 
 // LocationData is used to return the location data of a vendor for the online map
 type LocationData struct {
-	ID        int         `json:"id"`
-	FirstName string      `json:"firstName"`
-	LicenseID null.String `json:"licenseID"`
-	Longitude float64     `json:"longitude"`
-	Latitude  float64     `json:"latitude"`
+	ID         int              `json:"id"`
+	FirstName  string           `json:"firstName"`
+	LicenseID  null.String      `json:"licenseID"`
+	Longitude  float64          `json:"longitude"`
+	Latitude   float64          `json:"latitude"`
+	LocationID int              `json:"locationID,omitempty"` // Not set for vendors without locations, which are shown at the point of the vendor
+	Name       string           `json:"name,omitempty"`
+	Address    string           `json:"address,omitempty"`
+	TimeSlots  []VendorTimeSlot `json:"timeSlots,omitempty"`
+	// WorkingTime is the free text of vendors without locations, locations have time slots instead
+	WorkingTime string `json:"workingTime,omitempty"`
 }
 
-// GetVendorLocations returns a list of all longitudes and latitudes given by the vendors table
-func (db *Database) GetVendorLocations() (locationData []LocationData, err error) {
-	rows, err := db.Dbpool.Query(context.Background(), `
-	SELECT vendor.ID, LicenseID, FirstName, Longitude, Latitude 
-	from Vendor 
-	JOIN Account ON Account.Vendor = Vendor.id 
-	WHERE Account.Type = 'Vendor'
-	`)
+// MapFilter restricts the locations of the online map
+type MapFilter struct {
+	At          null.Time // Only locations with a time slot at this time
+	Public      bool      // Only active vendors with a valid license that opted in to the online map
+	BoundingBox []float64 // Optional min longitude, min latitude, max longitude and max latitude
+}
+
+// GetVendorLocations returns every selling location of the vendors for the online map.
+// Vendors without locations are returned with the longitude and latitude of the vendors table.
+// If filter.At is set, only the locations with a time slot at this time are returned ("who sells where right now").
+func (db *Database) GetVendorLocations(filter MapFilter) (locationData []LocationData, err error) {
+	var args []any
+	// where returns the conditions of the filter for a location with the given coordinate columns
+	where := func(longitude string, latitude string) string {
+		conditions := "Account.Type = 'Vendor'"
+		if filter.Public {
+			conditions += " AND Vendor.OnlineMap AND NOT Vendor.IsDisabled AND NOT Vendor.IsDeleted AND " + vendorLicenseValid +
+				" AND NOT (" + longitude + " = 0 AND " + latitude + " = 0)"
+		}
+		if len(filter.BoundingBox) == 4 {
+			args = append(args, filter.BoundingBox[0], filter.BoundingBox[1], filter.BoundingBox[2], filter.BoundingBox[3])
+			n := len(args)
+			conditions += " AND " + longitude + " BETWEEN $" + strconv.Itoa(n-3) + " AND $" + strconv.Itoa(n-1) +
+				" AND " + latitude + " BETWEEN $" + strconv.Itoa(n-2) + " AND $" + strconv.Itoa(n)
+		}
+		return conditions
+	}
+
+	query := `
+	SELECT Vendor.ID, Vendor.LicenseID, Vendor.FirstName, VendorLocation.Longitude, VendorLocation.Latitude, VendorLocation.ID, VendorLocation.Name, VendorLocation.Address, ''
+	FROM VendorLocation
+	JOIN Vendor ON Vendor.ID = VendorLocation.Vendor
+	JOIN Account ON Account.Vendor = Vendor.ID
+	WHERE ` + where("VendorLocation.Longitude", "VendorLocation.Latitude")
+	if filter.At.Valid {
+		local := filter.At.Time.In(config.Config.TimeZone)
+		weekday := int(local.Weekday())
+		if weekday == 0 {
+			weekday = 7
+		}
+		args = append(args, weekday, local.Format("15:04:05"))
+		n := len(args)
+		query += `
+		AND EXISTS (SELECT 1 FROM VendorLocationTimeSlot WHERE Location = VendorLocation.ID AND Weekday = $` + strconv.Itoa(n-1) + ` AND StartTime <= $` + strconv.Itoa(n) + `::time AND EndTime > $` + strconv.Itoa(n) + `::time)`
+	} else {
+		// The address of a vendor is the private postal address, only the addresses of selling locations are public
+		vendorAddress := "Address"
+		if filter.Public {
+			vendorAddress = "''"
+		}
+		query += `
+	UNION ALL
+	SELECT Vendor.ID, LicenseID, FirstName, Longitude, Latitude, 0, Location, ` + vendorAddress + `, WorkingTime
+	FROM Vendor
+	JOIN Account ON Account.Vendor = Vendor.ID
+	WHERE ` + where("Vendor.Longitude", "Vendor.Latitude") + ` AND NOT EXISTS (SELECT 1 FROM VendorLocation WHERE VendorLocation.Vendor = Vendor.ID)`
+	}
+	rows, err := db.Dbpool.Query(context.Background(), query, args...)
 	if err != nil {
 		log.Error("GetVendorLocations: ", err)
 		return locationData, err
 	}
+	defer rows.Close()
+	var locationIDs []int
 	for rows.Next() {
 		var nextLocationData LocationData
-		err = rows.Scan(&nextLocationData.ID, &nextLocationData.LicenseID, &nextLocationData.FirstName, &nextLocationData.Longitude, &nextLocationData.Latitude)
+		err = rows.Scan(&nextLocationData.ID, &nextLocationData.LicenseID, &nextLocationData.FirstName, &nextLocationData.Longitude, &nextLocationData.Latitude, &nextLocationData.LocationID, &nextLocationData.Name, &nextLocationData.Address, &nextLocationData.WorkingTime)
 		if err != nil {
 			log.Error("GetVendorLocations: ", err)
 			return locationData, err
 		}
 		locationData = append(locationData, nextLocationData)
+		if nextLocationData.LocationID != 0 {
+			locationIDs = append(locationIDs, nextLocationData.LocationID)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("GetVendorLocations: ", err)
+		return locationData, err
+	}
+
+	timeSlots, err := db.listVendorTimeSlots(locationIDs)
+	if err != nil {
+		log.Error("GetVendorLocations: ", err)
+		return locationData, err
+	}
+	for i := range locationData {
+		locationData[i].TimeSlots = timeSlots[locationData[i].LocationID]
 	}
 	return locationData, nil
 }
 
-// DeletePDF removes pdfs if their creation date is older than 6 weeks
-func (db *Database) DeletePDF() (err error) {
-	deleteInterval := config.Config.IntervalToDeletePDFsInWeeks
-	log.Info("DeletePDF entered: ", deleteInterval)
-	_, err = db.Dbpool.Exec(context.Background(), "DELETE FROM PDF WHERE timestamp < NOW() - $1 * INTERVAL '1 week'", deleteInterval)
+// pdfColumns are the columns of the PDF table in the order of scanPDF
+const pdfColumns = "ID, Path, Timestamp, Item, IssueNumber, PublicationDate, CoverImage, AvailableFrom, AvailableUntil"
+
+// scanPDF returns the scan destinations for a row selected with pdfColumns
+func scanPDF(pdf *PDF) []any {
+	return []any{&pdf.ID, &pdf.Path, &pdf.Timestamp, &pdf.Item, &pdf.IssueNumber, &pdf.PublicationDate, &pdf.CoverImage, &pdf.AvailableFrom, &pdf.AvailableUntil}
+}
+
+// allowDeleteTx permits deletes on PDF and PDFDownload for the rest of the transaction,
+// which are otherwise prevented by triggers (see migrations 011 and 025)
+func allowDeleteTx(tx pgx.Tx) (err error) {
+	_, err = tx.Exec(context.Background(), "SET LOCAL augustin.allow_delete = 'on'")
+	return
+}
+
+// DeletePDFTx removes PDFs that are neither sold anymore nor used by a download link and returns their paths.
+// PDFs without item (and not used as PDF of an item) are removed if they have been created before the given time,
+// editions if their availability has ended before the given time.
+// Editions sold with a verified order keep their row for the sales history, only their file is removed.
+func (db *Database) DeletePDFTx(tx pgx.Tx, before time.Time) (paths []string, err error) {
+	const expired = `PDF.Timestamp < $1
+	AND (PDF.Item IS NULL OR PDF.AvailableUntil < $1)
+	AND NOT EXISTS (SELECT 1 FROM PDFDownload WHERE PDFDownload.PDF = PDF.ID)
+	AND NOT EXISTS (SELECT 1 FROM Item WHERE Item.PDF = PDF.ID)`
+	const sold = `EXISTS (SELECT 1 FROM OrderEntry JOIN PaymentOrder ON PaymentOrder.ID = OrderEntry.PaymentOrder WHERE OrderEntry.PDF = PDF.ID AND PaymentOrder.Verified)`
+	rows, err := tx.Query(context.Background(), `
+	DELETE FROM PDF
+	WHERE `+expired+` AND NOT `+sold+`
+	RETURNING Path`, before)
 	if err != nil {
-		log.Error("DeletePDF: ", err)
-		return err
+		log.Error("DeletePDFTx: ", err)
+		return
 	}
-	return err
+	paths, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("DeletePDFTx: ", err)
+		return
+	}
+	rows, err = tx.Query(context.Background(), `
+	UPDATE PDF SET Path = ''
+	FROM PDF AS Old
+	WHERE Old.ID = PDF.ID AND PDF.Path <> '' AND `+expired+` AND `+sold+`
+	RETURNING Old.Path`, before)
+	if err != nil {
+		log.Error("DeletePDFTx: ", err)
+		return
+	}
+	soldPaths, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("DeletePDFTx: ", err)
+		return
+	}
+	paths = append(paths, soldPaths...)
+	return
 }
 
 // CreatePDF creates an instance of the PDF with given path and timestamp into the database
 func (db *Database) CreatePDF(pdf PDF) (pdfId int64, err error) {
 
 	// CreatePDF creates an instance of the PDF with given path and timestamp into the database
-	err = db.Dbpool.QueryRow(context.Background(), "INSERT INTO PDF (Path, Timestamp) values ($1, $2) RETURNING ID", pdf.Path, pdf.Timestamp).Scan(&pdf.ID)
+	err = db.Dbpool.QueryRow(context.Background(), "INSERT INTO PDF (Path, Timestamp, Item, IssueNumber, PublicationDate, CoverImage, AvailableFrom, AvailableUntil) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID", pdf.Path, pdf.Timestamp, pdf.Item, pdf.IssueNumber, pdf.PublicationDate, pdf.CoverImage, pdf.AvailableFrom, pdf.AvailableUntil).Scan(&pdf.ID)
 	if err != nil {
 		log.Error("CreatePDF: failed to add to database ", err)
 	}
@@ -1361,7 +1859,7 @@ func (db *Database) CreatePDF(pdf PDF) (pdfId int64, err error) {
 
 // GetPDF returns the latest PDF from the database
 func (db *Database) GetPDF() (pdf PDF, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PDF ORDER BY ID DESC LIMIT 1").Scan(&pdf.ID, &pdf.Path, &pdf.Timestamp)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+pdfColumns+" FROM PDF ORDER BY ID DESC LIMIT 1").Scan(scanPDF(&pdf)...)
 	if err != nil {
 		log.Error("GetPDF: ", err)
 	}
@@ -1370,32 +1868,109 @@ func (db *Database) GetPDF() (pdf PDF, err error) {
 
 // GetPDFByID returns the PDF with the given ID
 func (db *Database) GetPDFByID(id int64) (pdf PDF, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PDF WHERE ID = $1", id).Scan(&pdf.ID, &pdf.Path, &pdf.Timestamp)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+pdfColumns+" FROM PDF WHERE ID = $1", id).Scan(scanPDF(&pdf)...)
 	if err != nil {
 		log.Error("GetPDFByID: failed for id:", id, err)
 	}
 	return pdf, err
 }
 
-// CreatePDFDownload creates an instance of the PDFDownload with given linkID and timestamp into the database
-func (db *Database) CreatePDFDownload(tx pgx.Tx, pdf PDF, orderId, itemId int) (pdfDownload PDFDownload, err error) {
-	// generate download id
-	linkID := uuid.New()
-	pdfDownload = PDFDownload{
-		LinkID:        linkID.String(),
-		PDF:           pdf.ID,
-		Timestamp:     time.Now(),
-		EmailSent:     false,
-		LastDownload:  time.Time{},
-		DownloadCount: 0,
-		OrderID:       null.IntFrom(int64(orderId)),
-		ItemID:        null.IntFrom(int64(itemId)),
-	}
+// GetPDFByPath returns the PDF with the given storage key
+func (db *Database) GetPDFByPath(path string) (pdf PDF, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+pdfColumns+" FROM PDF WHERE Path = $1", path).Scan(scanPDF(&pdf)...)
+	return pdf, err
+}
 
-	// CreatePDF creates an instance of the PDF with given path and timestamp into the database
-	err = db.Dbpool.QueryRow(context.Background(), "INSERT INTO PDFDownload (LinkID, PDF, Timestamp, OrderId, ItemId) values ($1, $2, $3, $4, $5) RETURNING ID", pdfDownload.LinkID, pdfDownload.PDF, pdfDownload.Timestamp, pdfDownload.OrderID, pdfDownload.ItemID).Scan(&pdfDownload.ID)
-	if err != nil {
-		log.Error("CreatePDFDownload: ", err)
+// pdfAvailable is the condition for editions that can be bought right now
+const pdfAvailable = "(AvailableFrom IS NULL OR AvailableFrom <= NOW()) AND (AvailableUntil IS NULL OR AvailableUntil > NOW())"
+
+// ListEditions returns the editions of an item, the newest first
+func (db *Database) ListEditions(itemID int, onlyAvailable bool) (editions []PDF, err error) {
+	query := "SELECT " + pdfColumns + " FROM PDF WHERE Item = $1"
+	if onlyAvailable {
+		query += " AND " + pdfAvailable
+	}
+	rows, err := db.Dbpool.Query(context.Background(), query+" ORDER BY PublicationDate DESC NULLS LAST, ID DESC", itemID)
+	if err != nil {
+		log.Error("ListEditions: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var edition PDF
+		err = rows.Scan(scanPDF(&edition)...)
+		if err != nil {
+			log.Error("ListEditions: ", err)
+			return
+		}
+		editions = append(editions, edition)
+	}
+	return editions, rows.Err()
+}
+
+// GetCurrentEdition returns the newest edition of an item that can be bought.
+// Items without editions fall back to the PDF of the item.
+func (db *Database) GetCurrentEdition(item Item) (edition PDF, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+pdfColumns+" FROM PDF WHERE Item = $1 AND "+pdfAvailable+" ORDER BY PublicationDate DESC NULLS LAST, ID DESC LIMIT 1", item.ID).Scan(scanPDF(&edition)...)
+	if errors.Is(err, pgx.ErrNoRows) && item.PDF.Valid {
+		return db.GetPDFByID(item.PDF.Int64)
+	}
+	if err != nil {
+		log.Error("GetCurrentEdition: item ", item.ID, err)
+	}
+	return
+}
+
+// UpdateEdition updates the metadata of an edition
+func (db *Database) UpdateEdition(edition PDF) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), `
+	UPDATE PDF
+	SET Item = $2, IssueNumber = $3, PublicationDate = $4, CoverImage = $5, AvailableFrom = $6, AvailableUntil = $7
+	WHERE ID = $1
+	`, edition.ID, edition.Item, edition.IssueNumber, edition.PublicationDate, edition.CoverImage, edition.AvailableFrom, edition.AvailableUntil)
+	if err != nil {
+		log.Error("UpdateEdition: ", err)
+	}
+	return
+}
+
+// pdfDownloadColumns are the columns of the PDFDownload table in the order of scanPDFDownload
+const pdfDownloadColumns = "ID, PDF, LinkID, Timestamp, EmailSent, OrderID, LastDownload, DownloadCount, ItemID, StampedPath, ValidUntil, MaxDownloads, MaxIPs, Revoked"
+
+// scanPDFDownload returns the scan destinations for a row selected with pdfDownloadColumns
+func scanPDFDownload(pdfDownload *PDFDownload) []any {
+	return []any{&pdfDownload.ID, &pdfDownload.PDF, &pdfDownload.LinkID, &pdfDownload.Timestamp, &pdfDownload.EmailSent, &pdfDownload.OrderID, &pdfDownload.LastDownload, &pdfDownload.DownloadCount, &pdfDownload.ItemID, &pdfDownload.StampedPath, &pdfDownload.ValidUntil, &pdfDownload.MaxDownloads, &pdfDownload.MaxIPs, &pdfDownload.Revoked}
+}
+
+// CreatePDFDownload creates an instance of the PDFDownload with given linkID and timestamp into the database.
+// The download policy of the item is copied to the link, so that later changes of the item do not affect sold links.
+func (db *Database) CreatePDFDownload(tx pgx.Tx, pdf PDF, orderId int, item Item) (pdfDownload PDFDownload, err error) {
+	// generate download id
+	linkID := uuid.New()
+	pdfDownload = PDFDownload{
+		LinkID:        linkID.String(),
+		PDF:           pdf.ID,
+		Timestamp:     time.Now(),
+		EmailSent:     false,
+		LastDownload:  time.Time{},
+		DownloadCount: 0,
+		OrderID:       null.IntFrom(int64(orderId)),
+		ItemID:        null.IntFrom(int64(item.ID)),
+		MaxDownloads:  item.PDFMaxDownloads,
+		MaxIPs:        item.PDFMaxIPs,
+	}
+	validityDays := item.PDFValidityDays
+	if validityDays == 0 {
+		validityDays = config.Config.PDFLinkValidityDays
+	}
+	if validityDays > 0 {
+		pdfDownload.ValidUntil = null.TimeFrom(pdfDownload.Timestamp.AddDate(0, 0, validityDays))
+	}
+
+	// CreatePDF creates an instance of the PDF with given path and timestamp into the database
+	err = tx.QueryRow(context.Background(), "INSERT INTO PDFDownload (LinkID, PDF, Timestamp, OrderId, ItemId, ValidUntil, MaxDownloads, MaxIPs) values ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID", pdfDownload.LinkID, pdfDownload.PDF, pdfDownload.Timestamp, pdfDownload.OrderID, pdfDownload.ItemID, pdfDownload.ValidUntil, pdfDownload.MaxDownloads, pdfDownload.MaxIPs).Scan(&pdfDownload.ID)
+	if err != nil {
+		log.Error("CreatePDFDownload: ", err)
 	}
 	return
 }
@@ -1405,41 +1980,99 @@ func (db *Database) GetPDFDownload(linkID string) (pdfDownload PDFDownload, err
 	if len(linkID) == 0 {
 		return pdfDownload, errors.New("linkID is empty")
 	}
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PDFDownload WHERE LinkID = $1", linkID).Scan(&pdfDownload.ID, &pdfDownload.PDF, &pdfDownload.LinkID, &pdfDownload.Timestamp, &pdfDownload.EmailSent, &pdfDownload.OrderID, &pdfDownload.LastDownload, &pdfDownload.DownloadCount, &pdfDownload.ItemID)
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE LinkID = $1", linkID).Scan(scanPDFDownload(&pdfDownload)...)
 	if err != nil {
 		log.Error("GetPDFDownload: ", linkID, "err: ", err)
 	}
 	return pdfDownload, err
 }
 
-// GetPDFDownload returns the latest PDFDownload from the database
-func (db *Database) GetPDFDownloadTx(tx pgx.Tx, linkID string) (pdfDownload PDFDownload, err error) {
+// GetPDFDownloadForUpdateTx returns a PDFDownload and locks it until the end of the transaction,
+// so that the download limits are checked and counted by one request at a time
+func (db *Database) GetPDFDownloadForUpdateTx(tx pgx.Tx, linkID string) (pdfDownload PDFDownload, err error) {
 	if len(linkID) == 0 {
 		return pdfDownload, errors.New("linkID is empty")
 	}
-	err = tx.QueryRow(context.Background(), "SELECT * FROM PDFDownload WHERE LinkID = $1", linkID).Scan(&pdfDownload.ID, &pdfDownload.PDF, &pdfDownload.LinkID, &pdfDownload.Timestamp, &pdfDownload.EmailSent, &pdfDownload.OrderID, &pdfDownload.LastDownload, &pdfDownload.DownloadCount, &pdfDownload.ItemID)
+	err = tx.QueryRow(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE LinkID = $1 FOR UPDATE", linkID).Scan(scanPDFDownload(&pdfDownload)...)
 	if err != nil {
-		log.Error("GetPDFDownload: ", linkID, "err: ", err)
+		log.Error("GetPDFDownloadForUpdateTx: ", linkID, "err: ", err)
 	}
 	return pdfDownload, err
 }
 
-// DeletePDFDownload removes pdfs if their creation date is older than 6 weeks
-func (db *Database) DeletePDFDownload() (err error) {
-	// Get interval from config
-	deleteInterval := config.Config.IntervalToDeletePDFsInWeeks
-	_, err = db.Dbpool.Exec(context.Background(), "DELETE FROM PDFDownload WHERE timestamp < NOW() - $1 * INTERVAL '1 week'", deleteInterval)
+// DeletePDFDownloadTx removes download links that expired or have been revoked before the given time
+// and returns the paths of their watermarked copies
+func (db *Database) DeletePDFDownloadTx(tx pgx.Tx, before time.Time) (stampedPaths []string, err error) {
+	rows, err := tx.Query(context.Background(), `
+	DELETE FROM PDFDownload
+	WHERE ValidUntil < $1 OR (Revoked AND Timestamp < $1)
+	RETURNING StampedPath`, before)
 	if err != nil {
-		log.Error("DeletePDFDownload: ", err)
-		return err
+		log.Error("DeletePDFDownloadTx: ", err)
+		return
 	}
-	return err
+	stampedPaths, err = pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("DeletePDFDownloadTx: ", err)
+	}
+	return
+}
+
+// PurgeExpiredPDFs removes expired download links and the PDFs that are not needed anymore.
+// It returns the paths of all files that belonged to the removed rows.
+func (db *Database) PurgeExpiredPDFs(before time.Time) (report PDFRetentionReport, paths []string, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = allowDeleteTx(tx)
+	if err != nil {
+		return
+	}
+	stampedPaths, err := db.DeletePDFDownloadTx(tx, before)
+	if err != nil {
+		return
+	}
+	report.PDFDownloads = len(stampedPaths)
+	pdfPaths, err := db.DeletePDFTx(tx, before)
+	if err != nil {
+		return
+	}
+	report.PDFs = len(pdfPaths)
+	for _, path := range append(stampedPaths, pdfPaths...) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return
+}
+
+// ListPDFFilePaths returns the paths of all PDF files that are still in use (PDFs and watermarked copies)
+func (db *Database) ListPDFFilePaths() (paths map[string]bool, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT Path FROM PDF UNION SELECT StampedPath FROM PDFDownload WHERE StampedPath != ''")
+	if err != nil {
+		log.Error("ListPDFFilePaths: ", err)
+		return
+	}
+	list, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("ListPDFFilePaths: ", err)
+		return
+	}
+	paths = make(map[string]bool, len(list))
+	for _, path := range list {
+		// Paths are storage keys, older rows may start with a slash
+		paths[strings.TrimPrefix(path, "/")] = true
+	}
+	return
 }
 
 func (db *Database) UpdatePdfDownloadTx(tx pgx.Tx, pdfDownload PDFDownload) (err error) {
 	_, err = tx.Exec(context.Background(), `
-	UPDATE PDFDownload SET PDF = $1, LinkID = $2, Timestamp = $3, EmailSent = $4, OrderID = $5, LastDownload = $6, DownloadCount = $7, ItemId = $8 WHERE ID = $9`,
-		pdfDownload.PDF, pdfDownload.LinkID, pdfDownload.Timestamp, pdfDownload.EmailSent, pdfDownload.OrderID, pdfDownload.LastDownload, pdfDownload.DownloadCount, pdfDownload.ItemID, pdfDownload.ID)
+	UPDATE PDFDownload SET PDF = $1, LinkID = $2, Timestamp = $3, EmailSent = $4, OrderID = $5, LastDownload = $6, DownloadCount = $7, ItemId = $8, StampedPath = $9, ValidUntil = $10, MaxDownloads = $11, MaxIPs = $12, Revoked = $13 WHERE ID = $14`,
+		pdfDownload.PDF, pdfDownload.LinkID, pdfDownload.Timestamp, pdfDownload.EmailSent, pdfDownload.OrderID, pdfDownload.LastDownload, pdfDownload.DownloadCount, pdfDownload.ItemID, pdfDownload.StampedPath, pdfDownload.ValidUntil, pdfDownload.MaxDownloads, pdfDownload.MaxIPs, pdfDownload.Revoked, pdfDownload.ID)
 	if err != nil {
 		log.Error("UpdatePdfDownload: ", err)
 	}
@@ -1464,7 +2097,7 @@ func (db *Database) UpdatePdfDownload(pdfDownload PDFDownload) (err error) {
 }
 
 func (db *Database) GetPDFDownloadByOrderIdTx(tx pgx.Tx, order int) (pdfDownload []PDFDownload, err error) {
-	rows, err := tx.Query(context.Background(), "SELECT * FROM PDFDownload WHERE OrderId = $1", order)
+	rows, err := tx.Query(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE OrderId = $1", order)
 	if err != nil {
 		log.Error("GetPDFDownloadByOrderIdTx: ", err)
 		return pdfDownload, err
@@ -1472,7 +2105,7 @@ func (db *Database) GetPDFDownloadByOrderIdTx(tx pgx.Tx, order int) (pdfDownload
 	defer rows.Close()
 	for rows.Next() {
 		var nextPdfDownload PDFDownload
-		err = rows.Scan(&nextPdfDownload.ID, &nextPdfDownload.PDF, &nextPdfDownload.LinkID, &nextPdfDownload.Timestamp, &nextPdfDownload.EmailSent, &nextPdfDownload.OrderID, &nextPdfDownload.LastDownload, &nextPdfDownload.DownloadCount, &nextPdfDownload.ItemID)
+		err = rows.Scan(scanPDFDownload(&nextPdfDownload)...)
 		if err != nil {
 			log.Error("GetPDFDownloadByOrderIdTx: ", err)
 			return pdfDownload, err
@@ -1497,7 +2130,1968 @@ func (db *Database) GetPDFDownloadByOrderId(order int) (pdfDownload []PDFDownloa
 	return db.GetPDFDownloadByOrderIdTx(tx, order)
 }
 
-func (db *Database) GetPDFDownloadByOrderIdAndItemTx(tx pgx.Tx, order int, item int) (pdfDownload PDFDownload, err error) {
-	err = db.Dbpool.QueryRow(context.Background(), "SELECT * FROM PDFDownload WHERE OrderId = $1 AND ItemId = $2", order, item).Scan(&pdfDownload.ID, &pdfDownload.PDF, &pdfDownload.LinkID, &pdfDownload.Timestamp, &pdfDownload.EmailSent, &pdfDownload.OrderID, &pdfDownload.LastDownload, &pdfDownload.DownloadCount, &pdfDownload.ItemID)
+// GetPDFDownloadByOrderIdAndItemTx returns the download link of an edition that has been bought with an order
+func (db *Database) GetPDFDownloadByOrderIdAndItemTx(tx pgx.Tx, order int, item int, pdf int) (pdfDownload PDFDownload, err error) {
+	err = tx.QueryRow(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE OrderId = $1 AND ItemId = $2 AND PDF = $3", order, item, pdf).Scan(scanPDFDownload(&pdfDownload)...)
 	return pdfDownload, err
 }
+
+// CheckPDFDownloadIP checks if a download link may be used from the given IP address
+func (db *Database) CheckPDFDownloadIP(pdfDownload PDFDownload, ip string) (err error) {
+	if pdfDownload.MaxIPs <= 0 {
+		return nil
+	}
+	var known bool
+	var count int
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT COALESCE(bool_or(IP = $2), false), COUNT(DISTINCT IP) FROM PDFDownloadAccess WHERE PDFDownload = $1", pdfDownload.ID, ip).Scan(&known, &count)
+	if err != nil {
+		log.Error("CheckPDFDownloadIP: ", err)
+		return err
+	}
+	if !known && count >= pdfDownload.MaxIPs {
+		return ErrPDFDownloadIPLimit
+	}
+	return nil
+}
+
+// CreatePDFDownloadAccessTx records a download of a link from an IP address
+func (db *Database) CreatePDFDownloadAccessTx(tx pgx.Tx, pdfDownload PDFDownload, ip string) (err error) {
+	_, err = tx.Exec(context.Background(), "INSERT INTO PDFDownloadAccess (PDFDownload, IP) VALUES ($1, $2)", pdfDownload.ID, ip)
+	if err != nil {
+		log.Error("CreatePDFDownloadAccessTx: ", err)
+	}
+	return
+}
+
+// ExtendPDFDownload extends the validity of a download link by the given number of days
+// and allows additional downloads if the number of downloads is limited
+func (db *Database) ExtendPDFDownload(linkID string, days int, downloads int) (pdfDownload PDFDownload, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = tx.QueryRow(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE LinkID = $1 FOR UPDATE", linkID).Scan(scanPDFDownload(&pdfDownload)...)
+	if err != nil {
+		log.Error("ExtendPDFDownload: ", err)
+		return
+	}
+	if days > 0 && pdfDownload.ValidUntil.Valid {
+		// Expired links are extended from now on
+		validUntil := pdfDownload.ValidUntil.Time
+		if validUntil.Before(time.Now()) {
+			validUntil = time.Now()
+		}
+		pdfDownload.ValidUntil = null.TimeFrom(validUntil.AddDate(0, 0, days))
+	}
+	if downloads > 0 && pdfDownload.MaxDownloads > 0 {
+		pdfDownload.MaxDownloads += downloads
+	}
+	pdfDownload.Revoked = false
+	err = db.UpdatePdfDownloadTx(tx, pdfDownload)
+	return
+}
+
+// RevokePDFDownload disables a download link
+func (db *Database) RevokePDFDownload(linkID string) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), "UPDATE PDFDownload SET Revoked = true WHERE LinkID = $1", linkID)
+	if err != nil {
+		log.Error("RevokePDFDownload: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return
+}
+
+// ResendPDFDownloadLinks queues the emails with the download links of an order again.
+// The email address has to match the one of the license holder of the order. Revoked and expired links are skipped.
+func (db *Database) ResendPDFDownloadLinks(orderCode string, email string) (count int, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	var order Order
+	err = tx.QueryRow(context.Background(), "SELECT "+orderColumns+" FROM PaymentOrder WHERE OrderCode = $1", orderCode).Scan(scanOrder(&order)...)
+	if err != nil {
+		return 0, err
+	}
+	if !order.Verified || order.LicenseHolder() == "" || !strings.EqualFold(strings.TrimSpace(order.LicenseHolder()), strings.TrimSpace(email)) {
+		return 0, pgx.ErrNoRows
+	}
+	pdfDownloads, err := db.GetPDFDownloadByOrderIdTx(tx, order.ID)
+	if err != nil {
+		return
+	}
+	for _, pdfDownload := range pdfDownloads {
+		if pdfDownload.Revoked || pdfDownload.Expired(time.Now()) {
+			continue
+		}
+		templateData := map[string]interface{}{
+			"URL": config.Config.FrontendURL + "/pdf/" + pdfDownload.LinkID,
+		}
+		_, err = db.QueueEmailTx(tx, order.LicenseHolder(), EmailTemplatePDFLicenceItem, order.Language, templateData)
+		if err != nil {
+			log.Error("ResendPDFDownloadLinks: failed to queue mail: ", order.ID, err)
+			return 0, err
+		}
+		count++
+	}
+	return
+}
+
+// Email queue ----------------------------------------------------------------
+
+// emailRetryBackoff is the delay before the first retry of a failed email,
+// it is doubled for every further attempt
+const emailRetryBackoff = time.Minute
+
+// emailSendLease is how long a claimed email is reserved for sending before another worker may claim it
+const emailSendLease = "10 minutes"
+
+// QueueEmailTx adds an email to the outbox within the given transaction.
+// The email is delivered by the email worker once the transaction is committed.
+func (db *Database) QueueEmailTx(tx pgx.Tx, recipient string, template string, language string, data map[string]interface{}, attachments ...mailer.Attachment) (id int, err error) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	// The language chosen by the customer takes precedence
+	err = tx.QueryRow(context.Background(), `
+	INSERT INTO EmailQueue (Recipient, Template, Language, Data)
+	VALUES ($1, $2, COALESCE((SELECT NULLIF(Language, '') FROM CustomerPreference WHERE Email = lower($1)), $3), $4) RETURNING ID
+	`, recipient, template, language, data).Scan(&id)
+	if err != nil {
+		log.Error("QueueEmailTx: ", err)
+		return
+	}
+	for _, attachment := range attachments {
+		_, err = tx.Exec(context.Background(), `
+		INSERT INTO EmailAttachment (Email, Filename, ContentType, Data) VALUES ($1, $2, $3, $4)
+		`, id, attachment.Filename, attachment.ContentType, attachment.Data)
+		if err != nil {
+			log.Error("QueueEmailTx: attachment ", err)
+			return
+		}
+	}
+	return
+}
+
+// getEmailAttachments returns the attachments of a queued email
+func (db *Database) getEmailAttachments(emailID int) (attachments []mailer.Attachment, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT Filename, ContentType, Data FROM EmailAttachment WHERE Email = $1 ORDER BY ID", emailID)
+	if err != nil {
+		return
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[mailer.Attachment])
+}
+
+// QueueEmail adds an email to the outbox
+func (db *Database) QueueEmail(recipient string, template string, language string, data map[string]interface{}) (id int, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		log.Error("QueueEmail: ", err)
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+	return db.QueueEmailTx(tx, recipient, template, language, data)
+}
+
+// ListQueuedEmails returns the emails of the outbox, newest first.
+// If failedOnly is set, only emails that could not be delivered yet and have a last error are returned.
+func (db *Database) ListQueuedEmails(failedOnly bool) (emails []QueuedEmail, err error) {
+	query := "SELECT ID, Recipient, Template, Language, Data, Attempts, LastError, NextAttempt, SentAt, Timestamp FROM EmailQueue"
+	if failedOnly {
+		query += " WHERE SentAt IS NULL AND LastError IS NOT NULL"
+	}
+	query += " ORDER BY ID DESC"
+	rows, err := db.Dbpool.Query(context.Background(), query)
+	if err != nil {
+		log.Error("ListQueuedEmails: ", err)
+		return
+	}
+	emails, err = pgx.CollectRows(rows, pgx.RowToStructByName[QueuedEmail])
+	if err != nil {
+		log.Error("ListQueuedEmails: ", err)
+	}
+	return
+}
+
+// ResendQueuedEmail resets the attempts of an email so that the worker delivers it again
+func (db *Database) ResendQueuedEmail(id int) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), `
+	UPDATE EmailQueue SET Attempts = 0, NextAttempt = NOW(), SentAt = NULL, LastError = NULL WHERE ID = $1
+	`, id)
+	if err != nil {
+		log.Error("ResendQueuedEmail: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("email not found")
+	}
+	return
+}
+
+// SendQueuedEmails delivers up to limit pending emails of the outbox.
+// The emails are claimed first and sent outside of any transaction, so that a slow mail server neither keeps rows locked nor
+// holds a database connection. Each result is recorded on its own, so that sent emails are never sent again because of a later error.
+// Failed emails are retried with exponential backoff until maxAttempts is reached.
+func (db *Database) SendQueuedEmails(limit int, maxAttempts int) (sent int, failed int, err error) {
+	emails, err := db.claimQueuedEmails(limit, maxAttempts)
+	if err != nil {
+		return
+	}
+
+	for _, email := range emails {
+		// Attachments that can't be loaded fail the email like a failed delivery, the other emails are still sent
+		attachments, sendErr := db.getEmailAttachments(email.ID)
+		if sendErr == nil {
+			sendErr = db.sendQueuedEmail(email, attachments)
+		}
+		var updateErr error
+		if sendErr == nil {
+			_, updateErr = db.Dbpool.Exec(context.Background(), "UPDATE EmailQueue SET SentAt = NOW(), LastError = NULL WHERE ID = $1", email.ID)
+			sent++
+		} else {
+			backoff := emailRetryBackoff * time.Duration(1<<(email.Attempts-1))
+			if email.Attempts >= maxAttempts {
+				log.Error("SendQueuedEmails: giving up on email ", email.ID, " to ", email.Recipient, ": ", sendErr)
+			} else {
+				log.Warn("SendQueuedEmails: failed to send email ", email.ID, ", retrying in ", backoff, ": ", sendErr)
+			}
+			_, updateErr = db.Dbpool.Exec(context.Background(), "UPDATE EmailQueue SET LastError = $1, NextAttempt = $2 WHERE ID = $3", sendErr.Error(), time.Now().Add(backoff), email.ID)
+			failed++
+		}
+		if updateErr != nil {
+			// The email is processed again once its lease has expired
+			log.Error("SendQueuedEmails: update email ", email.ID, updateErr)
+			err = updateErr
+		}
+	}
+	return
+}
+
+// claimQueuedEmails claims up to limit pending emails of the outbox and counts the attempt.
+// Rows are selected with SKIP LOCKED, so multiple instances of the backend can process the queue at the same time.
+// Claimed emails are leased until NextAttempt, if the backend stops while sending, they are sent again afterwards.
+func (db *Database) claimQueuedEmails(limit int, maxAttempts int) (emails []QueuedEmail, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	UPDATE EmailQueue SET Attempts = Attempts + 1, NextAttempt = NOW() + $3::interval
+	WHERE ID IN (
+		SELECT ID FROM EmailQueue
+		WHERE SentAt IS NULL AND Attempts < $1 AND NextAttempt <= NOW()
+		ORDER BY ID
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ID, Recipient, Template, Language, Data, Attempts, LastError, NextAttempt, SentAt, Timestamp
+	`, maxAttempts, limit, emailSendLease)
+	if err != nil {
+		log.Error("claimQueuedEmails: ", err)
+		return
+	}
+	emails, err = pgx.CollectRows(rows, pgx.RowToStructByName[QueuedEmail])
+	if err != nil {
+		log.Error("claimQueuedEmails: ", err)
+		return
+	}
+	slices.SortFunc(emails, func(a, b QueuedEmail) int { return a.ID - b.ID })
+	return
+}
+
+// sendQueuedEmail renders and sends a single email of the outbox
+func (db *Database) sendQueuedEmail(email QueuedEmail, attachments []mailer.Attachment) error {
+	tmpl, err := db.GetLocalizedEmailTemplate(email.Template, email.Language)
+	if err != nil {
+		return err
+	}
+	mail, err := mailer.NewRequestFromTemplate([]string{email.Recipient}, tmpl.MailerTemplate(), email.Data)
+	if err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		mail.Attach(attachment.Filename, attachment.ContentType, attachment.Data)
+	}
+	success, err := mail.SendEmail()
+	if err != nil {
+		return err
+	}
+	if !success {
+		return errors.New("mail could not be sent")
+	}
+	return nil
+}
+
+// Email templates ------------------------------------------------------------
+
+// Names of the email templates that are used by the backend
+const (
+	EmailTemplateDigitalLicenceItem  = "digitalLicenceItem"
+	EmailTemplatePDFLicenceItem      = "PDFLicenceItem"
+	EmailTemplateOrderConfirmation   = "orderConfirmation"
+	EmailTemplateSubscriptionRenewal = "subscriptionRenewal"
+	EmailTemplateGift                = "gift"
+)
+
+// ListEmailTemplates returns all email templates
+func (db *Database) ListEmailTemplates() (templates []EmailTemplate, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM EmailTemplate ORDER BY Name, Language")
+	if err != nil {
+		log.Error("ListEmailTemplates: ", err)
+		return
+	}
+	templates, err = pgx.CollectRows(rows, pgx.RowToStructByName[EmailTemplate])
+	if err != nil {
+		log.Error("ListEmailTemplates: ", err)
+	}
+	return
+}
+
+// GetEmailTemplate returns the email template with the given id
+func (db *Database) GetEmailTemplate(id int) (tmpl EmailTemplate, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM EmailTemplate WHERE ID = $1", id)
+	if err != nil {
+		log.Error("GetEmailTemplate: ", err)
+		return
+	}
+	tmpl, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[EmailTemplate])
+	if err != nil {
+		log.Error("GetEmailTemplate: ", id, err)
+	}
+	return
+}
+
+// GetLocalizedEmailTemplate returns the template with the given name in the best matching language.
+// The exact language (e.g. "de-AT") is preferred, followed by the base language ("de"),
+// the default language of the configuration and finally any language.
+func (db *Database) GetLocalizedEmailTemplate(name string, language string) (tmpl EmailTemplate, err error) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	baseLanguage, _, _ := strings.Cut(language, "-")
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT * FROM EmailTemplate
+	WHERE Name = $1
+	ORDER BY
+		CASE
+			WHEN lower(Language) = $2 THEN 0
+			WHEN lower(Language) = $3 THEN 1
+			WHEN lower(Language) = $4 THEN 2
+			ELSE 3
+		END, ID
+	LIMIT 1
+	`, name, language, baseLanguage, strings.ToLower(config.Config.DefaultLanguage))
+	if err != nil {
+		log.Error("GetLocalizedEmailTemplate: ", err)
+		return
+	}
+	tmpl, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[EmailTemplate])
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = errors.New("email template " + name + " does not exist")
+	}
+	if err != nil {
+		log.Error("GetLocalizedEmailTemplate: ", name, " ", language, " ", err)
+	}
+	return
+}
+
+// CreateEmailTemplate creates a new email template
+func (db *Database) CreateEmailTemplate(tmpl EmailTemplate) (id int, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), `
+	INSERT INTO EmailTemplate (Name, Language, Subject, HTMLBody, TextBody)
+	VALUES ($1, $2, $3, $4, $5) RETURNING ID
+	`, tmpl.Name, tmpl.Language, tmpl.Subject, tmpl.HTMLBody, tmpl.TextBody).Scan(&id)
+	if err != nil {
+		log.Error("CreateEmailTemplate: ", err)
+	}
+	return
+}
+
+// UpdateEmailTemplate updates an email template
+func (db *Database) UpdateEmailTemplate(id int, tmpl EmailTemplate) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), `
+	UPDATE EmailTemplate
+	SET Name = $1, Language = $2, Subject = $3, HTMLBody = $4, TextBody = $5, Timestamp = NOW()
+	WHERE ID = $6
+	`, tmpl.Name, tmpl.Language, tmpl.Subject, tmpl.HTMLBody, tmpl.TextBody, id)
+	if err != nil {
+		log.Error("UpdateEmailTemplate: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("email template not found")
+	}
+	return
+}
+
+// DeleteEmailTemplate deletes an email template
+func (db *Database) DeleteEmailTemplate(id int) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "DELETE FROM EmailTemplate WHERE ID = $1", id)
+	if err != nil {
+		log.Error("DeleteEmailTemplate: ", err)
+	}
+	return
+}
+
+// Vendor documents -----------------------------------------------------------
+
+// vendorDocumentColumns are the columns of the VendorDocument table in the order of scanVendorDocument
+const vendorDocumentColumns = "ID, Vendor, Type, Filename, Path, ContentType, Size, ExpiresAt, DeleteAt, UploadedBy, Timestamp"
+
+// scanVendorDocument returns the scan destinations for a row selected with vendorDocumentColumns
+func scanVendorDocument(document *VendorDocument) []any {
+	return []any{&document.ID, &document.Vendor, &document.Type, &document.Filename, &document.Path, &document.ContentType, &document.Size, &document.ExpiresAt, &document.DeleteAt, &document.UploadedBy, &document.Timestamp}
+}
+
+// CreateVendorDocument adds a document to a vendor, the file has to be stored before
+func (db *Database) CreateVendorDocument(document VendorDocument) (id int, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), `
+	INSERT INTO VendorDocument (Vendor, Type, Filename, Path, ContentType, Size, ExpiresAt, DeleteAt, UploadedBy)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING ID
+	`, document.Vendor, document.Type, document.Filename, document.Path, document.ContentType, document.Size, document.ExpiresAt, document.DeleteAt, document.UploadedBy).Scan(&id)
+	if err != nil {
+		log.Error("CreateVendorDocument: ", err)
+	}
+	return
+}
+
+// ListVendorDocuments returns the documents of a vendor, the newest first
+func (db *Database) ListVendorDocuments(vendorID int) (documents []VendorDocument, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+vendorDocumentColumns+" FROM VendorDocument WHERE Vendor = $1 ORDER BY Timestamp DESC, ID DESC", vendorID)
+	if err != nil {
+		log.Error("ListVendorDocuments: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var document VendorDocument
+		err = rows.Scan(scanVendorDocument(&document)...)
+		if err != nil {
+			log.Error("ListVendorDocuments: ", err)
+			return
+		}
+		documents = append(documents, document)
+	}
+	return documents, rows.Err()
+}
+
+// GetVendorDocument returns a document of a vendor
+func (db *Database) GetVendorDocument(vendorID int, documentID int) (document VendorDocument, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorDocumentColumns+" FROM VendorDocument WHERE ID = $1 AND Vendor = $2", documentID, vendorID).Scan(scanVendorDocument(&document)...)
+	if err != nil {
+		log.Error("GetVendorDocument: ", err)
+	}
+	return
+}
+
+// UpdateVendorDocument updates the type, expiry date and retention of a document
+func (db *Database) UpdateVendorDocument(document VendorDocument) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), `
+	UPDATE VendorDocument
+	SET Type = $3, ExpiresAt = $4, DeleteAt = $5
+	WHERE ID = $1 AND Vendor = $2
+	`, document.ID, document.Vendor, document.Type, document.ExpiresAt, document.DeleteAt)
+	if err != nil {
+		log.Error("UpdateVendorDocument: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("vendor document not found")
+	}
+	return
+}
+
+// unusedVendorDocumentPaths returns the paths that don't belong to any document or badge photo anymore.
+// Files are content-addressed, so the same file can belong to several documents.
+func unusedVendorDocumentPaths(tx pgx.Tx, paths []string) (unused []string, err error) {
+	rows, err := tx.Query(context.Background(), "SELECT Path FROM VendorDocument WHERE Path = ANY($1) UNION SELECT Photo FROM Vendor WHERE Photo = ANY($1)", paths)
+	if err != nil {
+		return
+	}
+	used, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return
+	}
+	for _, path := range paths {
+		if !slices.Contains(used, path) && !slices.Contains(unused, path) {
+			unused = append(unused, path)
+		}
+	}
+	return
+}
+
+// removeBadgePhotosTx removes the badge photos of vendors whose photo document has been removed
+func removeBadgePhotosTx(tx pgx.Tx, paths []string) (err error) {
+	_, err = tx.Exec(context.Background(), `
+	UPDATE Vendor SET Photo = ''
+	WHERE Photo = ANY($1) AND NOT EXISTS (SELECT 1 FROM VendorDocument WHERE VendorDocument.Vendor = Vendor.ID AND VendorDocument.Path = Vendor.Photo)
+	`, paths)
+	return
+}
+
+// DeleteVendorDocument removes a document. It returns the path of the file if no other document uses it,
+// so that it can be removed from the storage.
+func (db *Database) DeleteVendorDocument(vendorID int, documentID int) (unusedPath string, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	var path string
+	err = tx.QueryRow(context.Background(), "DELETE FROM VendorDocument WHERE ID = $1 AND Vendor = $2 RETURNING Path", documentID, vendorID).Scan(&path)
+	if err != nil {
+		log.Error("DeleteVendorDocument: ", err)
+		return
+	}
+	err = removeBadgePhotosTx(tx, []string{path})
+	if err != nil {
+		log.Error("DeleteVendorDocument: ", err)
+		return
+	}
+	unused, err := unusedVendorDocumentPaths(tx, []string{path})
+	if err != nil {
+		log.Error("DeleteVendorDocument: ", err)
+		return
+	}
+	if len(unused) > 0 {
+		unusedPath = unused[0]
+	}
+	return
+}
+
+// PurgeVendorDocuments removes the documents whose retention ended before or on the given day.
+// It returns the number of removed documents and the paths of the files that are not used anymore.
+func (db *Database) PurgeVendorDocuments(day time.Time) (count int, unusedPaths []string, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	rows, err := tx.Query(context.Background(), "DELETE FROM VendorDocument WHERE DeleteAt <= $1 RETURNING Path", day)
+	if err != nil {
+		log.Error("PurgeVendorDocuments: ", err)
+		return
+	}
+	paths, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("PurgeVendorDocuments: ", err)
+		return
+	}
+	count = len(paths)
+	if count == 0 {
+		return
+	}
+	err = removeBadgePhotosTx(tx, paths)
+	if err != nil {
+		log.Error("PurgeVendorDocuments: ", err)
+		return
+	}
+	unusedPaths, err = unusedVendorDocumentPaths(tx, paths)
+	if err != nil {
+		log.Error("PurgeVendorDocuments: ", err)
+	}
+	return
+}
+
+// Vendor licenses ------------------------------------------------------------
+
+// vendorLicenseColumns are the columns of the VendorLicense table in the order of scanVendorLicense
+const vendorLicenseColumns = "VendorLicense.ID, VendorLicense.Vendor, StartDate, EndDate, VendorLicense.Comment, CreatedBy, ExpiryNotified, VendorLicense.Timestamp"
+
+// scanVendorLicense returns the scan destinations for a row selected with vendorLicenseColumns
+func scanVendorLicense(license *VendorLicense) []any {
+	return []any{&license.ID, &license.Vendor, &license.StartDate, &license.EndDate, &license.Comment, &license.CreatedBy, &license.ExpiryNotified, &license.Timestamp}
+}
+
+// ErrVendorLicenseOverlap is returned if a license period overlaps another period of the vendor
+var ErrVendorLicenseOverlap = errors.New("license period overlaps another license period of the vendor")
+
+// checkVendorLicenseOverlapTx returns ErrVendorLicenseOverlap if the period overlaps another period of the vendor
+func checkVendorLicenseOverlapTx(tx pgx.Tx, license VendorLicense) (err error) {
+	var overlaps bool
+	err = tx.QueryRow(context.Background(), `
+	SELECT EXISTS (SELECT 1 FROM VendorLicense WHERE Vendor = $1 AND ID != $2 AND StartDate <= $4 AND EndDate >= $3)
+	`, license.Vendor, license.ID, license.StartDate, license.EndDate).Scan(&overlaps)
+	if err == nil && overlaps {
+		err = ErrVendorLicenseOverlap
+	}
+	return
+}
+
+// ListVendorLicenses returns the license periods of a vendor, the latest first
+func (db *Database) ListVendorLicenses(vendorID int) (licenses []VendorLicense, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+vendorLicenseColumns+" FROM VendorLicense WHERE Vendor = $1 ORDER BY StartDate DESC", vendorID)
+	if err != nil {
+		log.Error("ListVendorLicenses: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var license VendorLicense
+		err = rows.Scan(scanVendorLicense(&license)...)
+		if err != nil {
+			log.Error("ListVendorLicenses: ", err)
+			return
+		}
+		licenses = append(licenses, license)
+	}
+	return licenses, rows.Err()
+}
+
+// GetVendorLicense returns a license period of a vendor
+func (db *Database) GetVendorLicense(vendorID int, licenseID int) (license VendorLicense, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorLicenseColumns+" FROM VendorLicense WHERE ID = $1 AND Vendor = $2", licenseID, vendorID).Scan(scanVendorLicense(&license)...)
+	if err != nil {
+		log.Error("GetVendorLicense: ", err)
+	}
+	return
+}
+
+// CreateVendorLicense adds a license period to a vendor, e.g. for a renewal
+func (db *Database) CreateVendorLicense(license VendorLicense) (id int, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = checkVendorLicenseOverlapTx(tx, license)
+	if err != nil {
+		return
+	}
+	err = tx.QueryRow(context.Background(), `
+	INSERT INTO VendorLicense (Vendor, StartDate, EndDate, Comment, CreatedBy)
+	VALUES ($1, $2, $3, $4, $5) RETURNING ID
+	`, license.Vendor, license.StartDate, license.EndDate, license.Comment, license.CreatedBy).Scan(&id)
+	if err != nil {
+		log.Error("CreateVendorLicense: ", err)
+	}
+	return
+}
+
+// UpdateVendorLicense corrects the dates and the comment of a license period.
+// The office is notified again if the end date changes.
+func (db *Database) UpdateVendorLicense(license VendorLicense) (err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = checkVendorLicenseOverlapTx(tx, license)
+	if err != nil {
+		return
+	}
+	res, err := tx.Exec(context.Background(), `
+	UPDATE VendorLicense
+	SET StartDate = $3, EndDate = $4, Comment = $5, ExpiryNotified = ExpiryNotified AND EndDate = $4
+	WHERE ID = $1 AND Vendor = $2
+	`, license.ID, license.Vendor, license.StartDate, license.EndDate, license.Comment)
+	if err != nil {
+		log.Error("UpdateVendorLicense: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("vendor license not found")
+	}
+	return
+}
+
+// ListExpiringVendorLicenses returns the license periods of active vendors that end between today and until
+// and are not followed by another period
+func (db *Database) ListExpiringVendorLicenses(until time.Time) (licenses []ExpiringVendorLicense, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT `+vendorLicenseColumns+`, Vendor.LicenseID, Vendor.FirstName, Vendor.LastName
+	FROM VendorLicense
+	JOIN Vendor ON Vendor.ID = VendorLicense.Vendor
+	WHERE EndDate >= CURRENT_DATE AND EndDate <= $1 AND Vendor.IsDeleted = false AND Vendor.IsDisabled = false
+	AND NOT EXISTS (SELECT 1 FROM VendorLicense Renewal WHERE Renewal.Vendor = VendorLicense.Vendor AND Renewal.StartDate > VendorLicense.EndDate)
+	ORDER BY EndDate, Vendor.LicenseID
+	`, until)
+	if err != nil {
+		log.Error("ListExpiringVendorLicenses: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var license ExpiringVendorLicense
+		err = rows.Scan(append(scanVendorLicense(&license.VendorLicense), &license.LicenseID, &license.FirstName, &license.LastName)...)
+		if err != nil {
+			log.Error("ListExpiringVendorLicenses: ", err)
+			return
+		}
+		licenses = append(licenses, license)
+	}
+	return licenses, rows.Err()
+}
+
+// SetVendorLicensesNotified marks license periods whose upcoming expiry has been reported to the office
+func (db *Database) SetVendorLicensesNotified(ids []int) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "UPDATE VendorLicense SET ExpiryNotified = true WHERE ID = ANY($1)", ids)
+	if err != nil {
+		log.Error("SetVendorLicensesNotified: ", err)
+	}
+	return
+}
+
+// Vendor locations -----------------------------------------------------------
+
+// listVendorTimeSlots returns the time slots of the given locations by location ID
+func (db *Database) listVendorTimeSlots(locationIDs []int) (timeSlots map[int][]VendorTimeSlot, err error) {
+	timeSlots = make(map[int][]VendorTimeSlot)
+	if len(locationIDs) == 0 {
+		return
+	}
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT Location, Weekday, to_char(StartTime, 'HH24:MI'), to_char(EndTime, 'HH24:MI')
+	FROM VendorLocationTimeSlot
+	WHERE Location = ANY($1)
+	ORDER BY Weekday, StartTime
+	`, locationIDs)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var locationID int
+		var timeSlot VendorTimeSlot
+		err = rows.Scan(&locationID, &timeSlot.Weekday, &timeSlot.StartTime, &timeSlot.EndTime)
+		if err != nil {
+			return
+		}
+		timeSlots[locationID] = append(timeSlots[locationID], timeSlot)
+	}
+	return timeSlots, rows.Err()
+}
+
+// ListVendorLocations returns the selling locations of a vendor with their time slots
+func (db *Database) ListVendorLocations(vendorID int) (locations []VendorLocation, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT ID, Vendor, Name, Address, PLZ, Longitude, Latitude, Comment
+	FROM VendorLocation WHERE Vendor = $1 ORDER BY ID
+	`, vendorID)
+	if err != nil {
+		log.Error("ListVendorLocations: ", err)
+		return
+	}
+	defer rows.Close()
+	var locationIDs []int
+	for rows.Next() {
+		var location VendorLocation
+		err = rows.Scan(&location.ID, &location.Vendor, &location.Name, &location.Address, &location.PLZ, &location.Longitude, &location.Latitude, &location.Comment)
+		if err != nil {
+			log.Error("ListVendorLocations: ", err)
+			return
+		}
+		locations = append(locations, location)
+		locationIDs = append(locationIDs, location.ID)
+	}
+	if err = rows.Err(); err != nil {
+		log.Error("ListVendorLocations: ", err)
+		return
+	}
+	timeSlots, err := db.listVendorTimeSlots(locationIDs)
+	if err != nil {
+		log.Error("ListVendorLocations: ", err)
+		return
+	}
+	for i := range locations {
+		locations[i].TimeSlots = timeSlots[locations[i].ID]
+	}
+	return
+}
+
+// insertVendorTimeSlotsTx adds the time slots of a location
+func insertVendorTimeSlotsTx(tx pgx.Tx, location VendorLocation) (err error) {
+	for _, timeSlot := range location.TimeSlots {
+		_, err = tx.Exec(context.Background(), `
+		INSERT INTO VendorLocationTimeSlot (Location, Weekday, StartTime, EndTime) VALUES ($1, $2, $3::time, $4::time)
+		`, location.ID, timeSlot.Weekday, timeSlot.StartTime, timeSlot.EndTime)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// CreateVendorLocation adds a selling location with its time slots to a vendor
+func (db *Database) CreateVendorLocation(location VendorLocation) (id int, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = tx.QueryRow(context.Background(), `
+	INSERT INTO VendorLocation (Vendor, Name, Address, PLZ, Longitude, Latitude, Comment)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ID
+	`, location.Vendor, location.Name, location.Address, location.PLZ, location.Longitude, location.Latitude, location.Comment).Scan(&location.ID)
+	if err != nil {
+		log.Error("CreateVendorLocation: ", err)
+		return
+	}
+	err = insertVendorTimeSlotsTx(tx, location)
+	if err != nil {
+		log.Error("CreateVendorLocation: ", err)
+	}
+	return location.ID, err
+}
+
+// UpdateVendorLocation updates a selling location and replaces its time slots
+func (db *Database) UpdateVendorLocation(location VendorLocation) (err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	res, err := tx.Exec(context.Background(), `
+	UPDATE VendorLocation
+	SET Name = $3, Address = $4, PLZ = $5, Longitude = $6, Latitude = $7, Comment = $8
+	WHERE ID = $1 AND Vendor = $2
+	`, location.ID, location.Vendor, location.Name, location.Address, location.PLZ, location.Longitude, location.Latitude, location.Comment)
+	if err != nil {
+		log.Error("UpdateVendorLocation: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		return errors.New("vendor location not found")
+	}
+	_, err = tx.Exec(context.Background(), "DELETE FROM VendorLocationTimeSlot WHERE Location = $1", location.ID)
+	if err != nil {
+		log.Error("UpdateVendorLocation: ", err)
+		return
+	}
+	err = insertVendorTimeSlotsTx(tx, location)
+	if err != nil {
+		log.Error("UpdateVendorLocation: ", err)
+	}
+	return
+}
+
+// DeleteVendorLocation removes a selling location and its time slots
+func (db *Database) DeleteVendorLocation(vendorID int, locationID int) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), "DELETE FROM VendorLocation WHERE ID = $1 AND Vendor = $2", locationID, vendorID)
+	if err != nil {
+		log.Error("DeleteVendorLocation: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("vendor location not found")
+	}
+	return
+}
+
+// Vendor offboarding ---------------------------------------------------------
+
+// OffboardVendor disables and deletes a vendor and schedules the anonymization of its personal data
+func (db *Database) OffboardVendor(vendorID int, anonymizeAt time.Time) (err error) {
+	res, err := db.Dbpool.Exec(context.Background(), `
+	UPDATE Vendor
+	SET IsDisabled = true, IsDeleted = true, OnlineMap = false, OffboardedAt = NOW(), AnonymizeAt = $2
+	WHERE ID = $1 AND IsDeleted = false
+	`, vendorID, anonymizeAt)
+	if err != nil {
+		log.Error("OffboardVendor: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		err = errors.New("vendor not found")
+	}
+	return
+}
+
+// ListVendorsToAnonymize returns the offboarded vendors whose anonymization is due on or before the given day
+func (db *Database) ListVendorsToAnonymize(day time.Time) (vendors []Vendor, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT ID, KeycloakID, LicenseID
+	FROM Vendor
+	WHERE AnonymizeAt <= $1::date AND AnonymizedAt IS NULL
+	ORDER BY ID
+	`, day)
+	if err != nil {
+		log.Error("ListVendorsToAnonymize: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var vendor Vendor
+		err = rows.Scan(&vendor.ID, &vendor.KeycloakID, &vendor.LicenseID)
+		if err != nil {
+			log.Error("ListVendorsToAnonymize: ", err)
+			return
+		}
+		vendors = append(vendors, vendor)
+	}
+	err = rows.Err()
+	return
+}
+
+// AnonymizeVendor removes the personal data of a vendor: names, contact data, addresses, comments,
+// selling locations and documents. The license ID, the account and the payments are kept for the ledger.
+// It returns the paths of the files that are not used anymore, so that they can be removed from the storage.
+func (db *Database) AnonymizeVendor(vendorID int) (unusedPaths []string, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	var photo string
+	err = tx.QueryRow(context.Background(), "SELECT Photo FROM Vendor WHERE ID = $1", vendorID).Scan(&photo)
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	rows, err := tx.Query(context.Background(), "DELETE FROM VendorDocument WHERE Vendor = $1 RETURNING Path", vendorID)
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	paths, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	if photo != "" {
+		paths = append(paths, photo)
+	}
+	_, err = tx.Exec(context.Background(), "DELETE FROM VendorLocation WHERE Vendor = $1", vendorID)
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	_, err = tx.Exec(context.Background(), "UPDATE VendorLicense SET Comment = '' WHERE Vendor = $1", vendorID)
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	_, err = tx.Exec(context.Background(), `
+	UPDATE Vendor
+	SET KeycloakID = '', FirstName = '', LastName = '', Email = '', Telephone = '', Address = '', PLZ = '', Location = '',
+		Longitude = 0, Latitude = 0, WorkingTime = '', Language = '', Comment = '', RegistrationDate = '', VendorSince = '',
+		HasSmartphone = false, HasBankAccount = false, AccountProofUrl = NULL, Photo = '', AnonymizedAt = NOW()
+	WHERE ID = $1
+	`, vendorID)
+	if err != nil {
+		log.Error("AnonymizeVendor: ", err)
+		return
+	}
+	if len(paths) > 0 {
+		unusedPaths, err = unusedVendorDocumentPaths(tx, paths)
+		if err != nil {
+			log.Error("AnonymizeVendor: ", err)
+		}
+	}
+	return
+}
+
+// Data subjects --------------------------------------------------------------
+
+// subjectOrders selects the orders of a data subject by customer email ($1) or Keycloak ID ($2)
+const subjectOrders = "(($1 <> '' AND lower(CustomerEmail) = lower($1)) OR ($2 <> '' AND UserID::text = $2))"
+
+// holderOrders selects the orders whose digital license items and PDFs belong to a customer by email ($1) or Keycloak ID ($2).
+// Gifts belong to the recipient instead of the buyer.
+const holderOrders = "(($1 <> '' AND lower(COALESCE(RecipientEmail, CustomerEmail)) = lower($1)) OR ($2 <> '' AND RecipientEmail IS NULL AND UserID::text = $2))"
+
+// ListOrdersOfSubject returns the orders with their entries that have been placed with the email or by the Keycloak user
+func (db *Database) ListOrdersOfSubject(email string, keycloakID string) (orders []Order, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+orderColumns+" FROM PaymentOrder WHERE "+subjectOrders+" ORDER BY ID", email, keycloakID)
+	if err != nil {
+		log.Error("ListOrdersOfSubject: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var order Order
+		err = rows.Scan(scanOrder(&order)...)
+		if err != nil {
+			log.Error("ListOrdersOfSubject: ", err)
+			return
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	for i := range orders {
+		orders[i].Entries, err = db.GetOrderEntries(orders[i].ID)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ListPDFDownloadsOfOrders returns the download links of the orders
+func (db *Database) ListPDFDownloadsOfOrders(orderIDs []int) (pdfDownloads []PDFDownload, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE OrderID = ANY($1) ORDER BY ID", orderIDs)
+	if err != nil {
+		log.Error("ListPDFDownloadsOfOrders: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pdfDownload PDFDownload
+		err = rows.Scan(scanPDFDownload(&pdfDownload)...)
+		if err != nil {
+			log.Error("ListPDFDownloadsOfOrders: ", err)
+			return
+		}
+		pdfDownloads = append(pdfDownloads, pdfDownload)
+	}
+	err = rows.Err()
+	return
+}
+
+// ListPDFDownloadAccesses returns the recorded downloads of the links with their IP addresses
+func (db *Database) ListPDFDownloadAccesses(pdfDownloadIDs []int) (accesses []PDFDownloadAccess, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT ID, PDFDownload, IP, Timestamp FROM PDFDownloadAccess WHERE PDFDownload = ANY($1) ORDER BY ID", pdfDownloadIDs)
+	if err != nil {
+		log.Error("ListPDFDownloadAccesses: ", err)
+		return
+	}
+	accesses, err = pgx.CollectRows(rows, pgx.RowToStructByName[PDFDownloadAccess])
+	if err != nil {
+		log.Error("ListPDFDownloadAccesses: ", err)
+	}
+	return
+}
+
+// ListQueuedEmailsTo returns the emails of the outbox to a recipient, newest first
+func (db *Database) ListQueuedEmailsTo(recipient string) (emails []QueuedEmail, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT ID, Recipient, Template, Language, Data, Attempts, LastError, NextAttempt, SentAt, Timestamp FROM EmailQueue WHERE lower(Recipient) = lower($1) ORDER BY ID DESC", recipient)
+	if err != nil {
+		log.Error("ListQueuedEmailsTo: ", err)
+		return
+	}
+	emails, err = pgx.CollectRows(rows, pgx.RowToStructByName[QueuedEmail])
+	if err != nil {
+		log.Error("ListQueuedEmailsTo: ", err)
+	}
+	return
+}
+
+// GetVendorOfSubject returns the vendor with the email or Keycloak ID including deleted vendors.
+// It returns pgx.ErrNoRows if there is none.
+func (db *Database) GetVendorOfSubject(email string, keycloakID string) (vendor Vendor, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+vendorColumns+" FROM Vendor WHERE ($1 <> '' AND lower(Email) = lower($1)) OR ($2 <> '' AND KeycloakID = $2) ORDER BY IsDeleted, ID DESC LIMIT 1", email, keycloakID).Scan(scanVendor(&vendor)...)
+	if err != nil {
+		return
+	}
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT Balance FROM Account WHERE Vendor = $1", vendor.ID).Scan(&vendor.Balance)
+	if err != nil {
+		log.Error("GetVendorOfSubject: couldn't get balance: ", err)
+	}
+	return
+}
+
+// ListAuditEntries returns the payments, vendor documents and license periods that have been created by one of the names
+func (db *Database) ListAuditEntries(names []string) (entries []AuditEntry, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT 'payment' AS Type, ID, Timestamp, 'Authorized payment of ' || Amount || ' cents' AS Description FROM Payment WHERE AuthorizedBy = ANY($1)
+	UNION ALL
+	SELECT 'vendor_document', ID, Timestamp, 'Uploaded document ' || Filename || ' of vendor ' || Vendor FROM VendorDocument WHERE UploadedBy = ANY($1)
+	UNION ALL
+	SELECT 'vendor_license', ID, Timestamp, 'Created license period ' || StartDate || ' to ' || EndDate || ' of vendor ' || Vendor FROM VendorLicense WHERE CreatedBy = ANY($1)
+	ORDER BY Timestamp, Type, ID
+	`, names)
+	if err != nil {
+		log.Error("ListAuditEntries: ", err)
+		return
+	}
+	entries, err = pgx.CollectRows(rows, pgx.RowToStructByName[AuditEntry])
+	if err != nil {
+		log.Error("ListAuditEntries: ", err)
+	}
+	return
+}
+
+// retainedUntil returns the first day after the bookkeeping retention period of a record,
+// which ends with the calendar year retentionYears after the year of the record
+func retainedUntil(timestamp time.Time, retentionYears int) time.Time {
+	return time.Date(timestamp.Year()+retentionYears+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EraseSubject removes the email and user of the orders of a data subject whose bookkeeping retention period ended,
+// revokes all download links of its orders, removes the IP addresses of their downloads and its emails in the outbox.
+// Orders within the retention period are kept unchanged and listed in the report.
+// It returns the watermarked copies of PDFs that have to be removed from the storage.
+func (db *Database) EraseSubject(email string, keycloakID string, retentionYears int) (report ErasureReport, stampedPaths []string, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	rows, err := tx.Query(context.Background(), "SELECT ID, OrderCode, Timestamp FROM PaymentOrder WHERE "+subjectOrders+" ORDER BY ID", email, keycloakID)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	var orderIDs, erasable []int
+	var order RetainedOrder
+	_, err = pgx.ForEachRow(rows, []any{&order.ID, &order.OrderCode, &order.Timestamp}, func() error {
+		orderIDs = append(orderIDs, order.ID)
+		order.RetainedUntil = retainedUntil(order.Timestamp, retentionYears)
+		if order.RetainedUntil.After(time.Now()) {
+			report.RetainedOrders = append(report.RetainedOrders, order)
+		} else {
+			erasable = append(erasable, order.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+
+	res, err := tx.Exec(context.Background(), "UPDATE PaymentOrder SET CustomerEmail = NULL, UserID = NULL WHERE ID = ANY($1)", erasable)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	report.OrdersErased = int(res.RowsAffected())
+
+	// The recipient of a gift is not part of the bookkeeping. The empty recipient marks the erasure,
+	// the order stays a gift, so that nobody gets its items and the gift jobs skip it.
+	if email != "" {
+		res, err = tx.Exec(context.Background(), "UPDATE PaymentOrder SET RecipientEmail = '', RecipientName = '', GiftMessage = '' WHERE lower(RecipientEmail) = lower($1)", email)
+		if err != nil {
+			log.Error("EraseSubject: ", err)
+			return
+		}
+		report.GiftsErased = int(res.RowsAffected())
+	}
+
+	res, err = tx.Exec(context.Background(), "DELETE FROM PDFDownloadAccess WHERE PDFDownload IN (SELECT ID FROM PDFDownload WHERE OrderID = ANY($1))", orderIDs)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	report.PDFDownloadAccesses = int(res.RowsAffected())
+
+	// The watermarked copies contain the email of the buyer
+	rows, err = tx.Query(context.Background(), `
+	UPDATE PDFDownload SET Revoked = true, StampedPath = ''
+	FROM (SELECT ID, StampedPath FROM PDFDownload WHERE OrderID = ANY($1)) AS Old
+	WHERE PDFDownload.ID = Old.ID
+	RETURNING Old.StampedPath
+	`, orderIDs)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	paths, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	report.PDFDownloadsRevoked = len(paths)
+	for _, path := range paths {
+		if path != "" {
+			stampedPaths = append(stampedPaths, path)
+		}
+	}
+
+	if email != "" {
+		res, err = tx.Exec(context.Background(), "DELETE FROM EmailQueue WHERE lower(Recipient) = lower($1)", email)
+		if err != nil {
+			log.Error("EraseSubject: ", err)
+			return
+		}
+		report.EmailsDeleted = int(res.RowsAffected())
+		_, err = tx.Exec(context.Background(), "DELETE FROM CustomerPreference WHERE Email = lower($1)", email)
+		if err != nil {
+			log.Error("EraseSubject: ", err)
+			return
+		}
+	}
+
+	res, err = tx.Exec(context.Background(), "DELETE FROM Subscription WHERE ($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2)", email, keycloakID)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	report.SubscriptionsDeleted = int(res.RowsAffected())
+
+	res, err = tx.Exec(context.Background(), "DELETE FROM LicenseGrant WHERE ($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2)", email, keycloakID)
+	if err != nil {
+		log.Error("EraseSubject: ", err)
+		return
+	}
+	report.LicenseGrantsDeleted = int(res.RowsAffected())
+	return
+}
+
+// Customers ------------------------------------------------------------------
+
+// ListCustomerOrders returns the verified orders of a customer by email or Keycloak ID, newest first
+func (db *Database) ListCustomerOrders(email string, keycloakID string) (orders []CustomerOrder, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT PaymentOrder.ID, PaymentOrder.OrderCode, PaymentOrder.Timestamp, Item.ID, Item.Name, OrderEntry.Quantity, OrderEntry.Price, OrderEntry.VoucherCode IS NOT NULL
+	FROM PaymentOrder
+	JOIN OrderEntry ON OrderEntry.PaymentOrder = PaymentOrder.ID
+	JOIN Item ON Item.ID = OrderEntry.Item
+	WHERE PaymentOrder.Verified AND (OrderEntry.IsSale OR OrderEntry.VoucherCode IS NOT NULL) AND `+subjectOrders+`
+	ORDER BY PaymentOrder.ID DESC, OrderEntry.ID
+	`, email, keycloakID)
+	if err != nil {
+		log.Error("ListCustomerOrders: ", err)
+		return
+	}
+	var order CustomerOrder
+	var entry CustomerOrderEntry
+	var isDiscount bool
+	_, err = pgx.ForEachRow(rows, []any{&order.ID, &order.OrderCode, &order.Timestamp, &entry.Item, &entry.Name, &entry.Quantity, &entry.Price, &isDiscount}, func() error {
+		if len(orders) == 0 || orders[len(orders)-1].ID != order.ID {
+			orders = append(orders, CustomerOrder{ID: order.ID, OrderCode: order.OrderCode, Timestamp: order.Timestamp})
+		}
+		last := &orders[len(orders)-1]
+		if isDiscount {
+			last.Discount += entry.Price * entry.Quantity
+			last.Total -= entry.Price * entry.Quantity
+			return nil
+		}
+		last.Entries = append(last.Entries, entry)
+		last.Total += entry.Price * entry.Quantity
+		return nil
+	})
+	if err != nil {
+		log.Error("ListCustomerOrders: ", err)
+	}
+	return
+}
+
+// ListCustomerLicensePurchases returns the license groups of the digital license items a customer bought or got as a gift
+// with the time of the first purchase and the end of the access
+func (db *Database) ListCustomerLicensePurchases(email string, keycloakID string) (licenses []CustomerLicense, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT Item.LicenseGroup, MIN(PaymentOrder.Timestamp), (
+		SELECT CASE WHEN bool_or(EndDate IS NULL) THEN NULL ELSE MAX(EndDate) END FROM (
+			SELECT EndDate FROM LicenseGrant
+			WHERE LicenseGrant.LicenseGroup = Item.LicenseGroup AND ExpiredAt IS NULL AND (($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2))
+			UNION ALL
+			SELECT ValidUntil FROM Subscription JOIN Item AS SubscriptionItem ON SubscriptionItem.ID = Subscription.Item
+			WHERE SubscriptionItem.LicenseGroup = Item.LicenseGroup AND ExpiredAt IS NULL AND (($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2))
+		) AS Access
+	)
+	FROM PaymentOrder
+	JOIN OrderEntry ON OrderEntry.PaymentOrder = PaymentOrder.ID
+	JOIN Item ON Item.ID = OrderEntry.Item
+	WHERE PaymentOrder.Verified AND Item.LicenseItem IS NOT NULL AND NOT Item.IsPDFItem AND Item.LicenseGroup <> '' AND `+holderOrders+`
+	GROUP BY Item.LicenseGroup
+	ORDER BY Item.LicenseGroup
+	`, email, keycloakID)
+	if err != nil {
+		log.Error("ListCustomerLicensePurchases: ", err)
+		return
+	}
+	var license CustomerLicense
+	_, err = pgx.ForEachRow(rows, []any{&license.LicenseGroup, &license.Since, &license.ValidUntil}, func() error {
+		licenses = append(licenses, license)
+		return nil
+	})
+	if err != nil {
+		log.Error("ListCustomerLicensePurchases: ", err)
+	}
+	return
+}
+
+// customerPDFDownloads selects the download links of the verified orders of a customer, see holderOrders
+const customerPDFDownloads = "OrderID IN (SELECT ID FROM PaymentOrder WHERE Verified AND " + holderOrders + ")"
+
+// ListCustomerPDFDownloads returns the download links of the verified orders of a customer, newest first
+func (db *Database) ListCustomerPDFDownloads(email string, keycloakID string) (pdfDownloads []CustomerPDFDownload, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+pdfDownloadColumns+", COALESCE((SELECT Name FROM Item WHERE Item.ID = PDFDownload.ItemID), '') FROM PDFDownload WHERE "+customerPDFDownloads+" ORDER BY ID DESC", email, keycloakID)
+	if err != nil {
+		log.Error("ListCustomerPDFDownloads: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pdfDownload CustomerPDFDownload
+		err = rows.Scan(append(scanPDFDownload(&pdfDownload.PDFDownload), &pdfDownload.ItemName)...)
+		if err != nil {
+			log.Error("ListCustomerPDFDownloads: ", err)
+			return
+		}
+		pdfDownloads = append(pdfDownloads, pdfDownload)
+	}
+	err = rows.Err()
+	return
+}
+
+// ReissuePDFDownload replaces the link ID of a download link of a customer, so that the old link stops working,
+// restarts its validity period and emails the new link to the customer. Revoked links can't be reissued.
+func (db *Database) ReissuePDFDownload(linkID string, email string, keycloakID string) (pdfDownload PDFDownload, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	err = tx.QueryRow(context.Background(), "SELECT "+pdfDownloadColumns+" FROM PDFDownload WHERE LinkID = $3 AND "+customerPDFDownloads+" FOR UPDATE", email, keycloakID, linkID).Scan(scanPDFDownload(&pdfDownload)...)
+	if err != nil {
+		return
+	}
+	if pdfDownload.Revoked {
+		return pdfDownload, ErrPDFDownloadRevoked
+	}
+	if pdfDownload.ValidUntil.Valid {
+		validityDays := config.Config.PDFLinkValidityDays
+		if pdfDownload.ItemID.Valid {
+			var itemValidityDays int
+			err = tx.QueryRow(context.Background(), "SELECT PDFValidityDays FROM Item WHERE ID = $1", pdfDownload.ItemID).Scan(&itemValidityDays)
+			if err != nil {
+				log.Error("ReissuePDFDownload: ", err)
+				return
+			}
+			if itemValidityDays != 0 {
+				validityDays = itemValidityDays
+			}
+		}
+		if validityDays > 0 {
+			pdfDownload.ValidUntil = null.TimeFrom(time.Now().AddDate(0, 0, validityDays))
+		}
+	}
+	pdfDownload.LinkID = uuid.New().String()
+	err = db.UpdatePdfDownloadTx(tx, pdfDownload)
+	if err != nil {
+		return
+	}
+	templateData := map[string]interface{}{
+		"URL": config.Config.FrontendURL + "/pdf/" + pdfDownload.LinkID,
+	}
+	_, err = db.QueueEmailTx(tx, email, EmailTemplatePDFLicenceItem, "", templateData)
+	return
+}
+
+// GetCustomerPreference returns the email preferences of a customer, the defaults if there are none
+func (db *Database) GetCustomerPreference(email string) (preference CustomerPreference, err error) {
+	preference = CustomerPreference{Email: strings.ToLower(email), OrderConfirmations: true}
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT Email, Language, OrderConfirmations, Timestamp FROM CustomerPreference WHERE Email = lower($1)", email).Scan(&preference.Email, &preference.Language, &preference.OrderConfirmations, &preference.Timestamp)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return preference, nil
+	}
+	if err != nil {
+		log.Error("GetCustomerPreference: ", err)
+	}
+	return
+}
+
+// UpdateCustomerPreference stores the email preferences of a customer
+func (db *Database) UpdateCustomerPreference(preference CustomerPreference) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), `
+	INSERT INTO CustomerPreference (Email, Language, OrderConfirmations) VALUES (lower($1), $2, $3)
+	ON CONFLICT (Email) DO UPDATE SET Language = EXCLUDED.Language, OrderConfirmations = EXCLUDED.OrderConfirmations, Timestamp = NOW()
+	`, preference.Email, preference.Language, preference.OrderConfirmations)
+	if err != nil {
+		log.Error("UpdateCustomerPreference: ", err)
+	}
+	return
+}
+
+// wantsOrderConfirmationTx returns false if the customer turned off order confirmations
+func wantsOrderConfirmationTx(tx pgx.Tx, email string) bool {
+	var orderConfirmations bool
+	err := tx.QueryRow(context.Background(), "SELECT OrderConfirmations FROM CustomerPreference WHERE Email = lower($1)", email).Scan(&orderConfirmations)
+	return err != nil || orderConfirmations
+}
+
+// Subscriptions --------------------------------------------------------------
+
+// subscriptionColumns are the columns of a subscription joined with its item in the order of scanSubscription
+const subscriptionColumns = "Subscription.ID, Subscription.Item, Item.Name, COALESCE(Item.LicenseGroup, ''), Item.SubscriptionPeriod, CustomerEmail, UserID, Vendor, Language, LastOrder, ValidUntil, RenewalToken, ReminderSentAt, CancelledAt, ExpiredAt, Subscription.Timestamp FROM Subscription JOIN Item ON Item.ID = Subscription.Item"
+
+// scanSubscription returns the scan destinations for a row selected with subscriptionColumns
+func scanSubscription(subscription *Subscription) []any {
+	return []any{&subscription.ID, &subscription.Item, &subscription.ItemName, &subscription.LicenseGroup, &subscription.Period, &subscription.CustomerEmail, &subscription.UserID, &subscription.Vendor, &subscription.Language, &subscription.LastOrder, &subscription.ValidUntil, &subscription.RenewalToken, &subscription.ReminderSentAt, &subscription.CancelledAt, &subscription.ExpiredAt, &subscription.Timestamp}
+}
+
+// listSubscriptions returns the subscriptions matching the condition, which can use the arguments
+func (db *Database) listSubscriptions(condition string, args ...any) (subscriptions []Subscription, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+subscriptionColumns+" WHERE "+condition+" ORDER BY ValidUntil", args...)
+	if err != nil {
+		log.Error("listSubscriptions: ", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var subscription Subscription
+		err = rows.Scan(scanSubscription(&subscription)...)
+		if err != nil {
+			log.Error("listSubscriptions: ", err)
+			return
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	err = rows.Err()
+	return
+}
+
+// subscriptionEnd returns the end of quantity periods of a subscription item starting at from
+func subscriptionEnd(from time.Time, period string, quantity int) time.Time {
+	if period == SubscriptionYearly {
+		return from.AddDate(quantity, 0, 0)
+	}
+	return from.AddDate(0, quantity, 0)
+}
+
+// renewSubscriptionTx creates the subscription of the customer of a verified order to a subscription item
+// or extends it by quantity periods. Expired subscriptions start again at the time of the order.
+func renewSubscriptionTx(tx pgx.Tx, order Order, item Item, quantity int, userID string) (subscription Subscription, err error) {
+	email := strings.ToLower(order.LicenseHolder())
+	err = tx.QueryRow(context.Background(), "SELECT "+subscriptionColumns+" WHERE CustomerEmail = $1 AND Subscription.Item = $2 FOR UPDATE OF Subscription", email, item.ID).Scan(scanSubscription(&subscription)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		validUntil := subscriptionEnd(time.Now(), item.SubscriptionPeriod, quantity)
+		err = tx.QueryRow(context.Background(), `
+		INSERT INTO Subscription (Item, CustomerEmail, UserID, Vendor, Language, LastOrder, ValidUntil, RenewalToken)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID
+		`, item.ID, email, userID, order.Vendor, order.Language, order.ID, validUntil, uuid.New().String()).Scan(&subscription.ID)
+		if err != nil {
+			log.Error("renewSubscriptionTx: ", err)
+			return
+		}
+		err = tx.QueryRow(context.Background(), "SELECT "+subscriptionColumns+" WHERE Subscription.ID = $1", subscription.ID).Scan(scanSubscription(&subscription)...)
+		return
+	}
+	if err != nil {
+		log.Error("renewSubscriptionTx: ", err)
+		return
+	}
+	start := time.Now()
+	if !subscription.ExpiredAt.Valid && subscription.ValidUntil.After(start) {
+		start = subscription.ValidUntil
+	}
+	subscription.ValidUntil = subscriptionEnd(start, item.SubscriptionPeriod, quantity)
+	if userID != "" {
+		subscription.UserID = userID
+	}
+	subscription.Vendor = order.Vendor
+	subscription.LastOrder = null.IntFrom(int64(order.ID))
+	if order.Language != "" {
+		subscription.Language = order.Language
+	}
+	subscription.ReminderSentAt = null.Time{}
+	subscription.CancelledAt = null.Time{}
+	subscription.ExpiredAt = null.Time{}
+	_, err = tx.Exec(context.Background(), `
+	UPDATE Subscription
+	SET ValidUntil = $2, UserID = $3, Vendor = $4, LastOrder = $5, Language = $6, ReminderSentAt = NULL, CancelledAt = NULL, ExpiredAt = NULL
+	WHERE ID = $1
+	`, subscription.ID, subscription.ValidUntil, subscription.UserID, subscription.Vendor, subscription.LastOrder, subscription.Language)
+	if err != nil {
+		log.Error("renewSubscriptionTx: ", err)
+	}
+	return
+}
+
+// GetSubscription returns a subscription by ID
+func (db *Database) GetSubscription(id int) (subscription Subscription, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+subscriptionColumns+" WHERE Subscription.ID = $1", id).Scan(scanSubscription(&subscription)...)
+	return
+}
+
+// GetSubscriptionByToken returns the subscription of a renewal link
+func (db *Database) GetSubscriptionByToken(token string) (subscription Subscription, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+subscriptionColumns+" WHERE RenewalToken = $1", token).Scan(scanSubscription(&subscription)...)
+	return
+}
+
+// ListSubscriptions returns all subscriptions or the ones of an email, by end of validity
+func (db *Database) ListSubscriptions(email string) (subscriptions []Subscription, err error) {
+	return db.listSubscriptions("($1 = '' OR CustomerEmail = lower($1))", email)
+}
+
+// ListCustomerSubscriptions returns the subscriptions of a customer by email or Keycloak ID
+func (db *Database) ListCustomerSubscriptions(email string, keycloakID string) (subscriptions []Subscription, err error) {
+	return db.listSubscriptions("(($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2))", email, keycloakID)
+}
+
+// CancelSubscription stops the renewal reminders of a subscription of a customer.
+// The subscription stays valid until ValidUntil, a renewal resumes it.
+func (db *Database) CancelSubscription(id int, email string, keycloakID string) (subscription Subscription, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), `
+	UPDATE Subscription SET CancelledAt = COALESCE(CancelledAt, NOW())
+	WHERE ID = $1 AND (($2 <> '' AND CustomerEmail = lower($2)) OR ($3 <> '' AND UserID = $3))
+	RETURNING ID
+	`, id, email, keycloakID).Scan(&id)
+	if err != nil {
+		return
+	}
+	return db.GetSubscription(id)
+}
+
+// ListSubscriptionsToRemind returns the active subscriptions that end before the given time and have not been reminded
+func (db *Database) ListSubscriptionsToRemind(until time.Time) (subscriptions []Subscription, err error) {
+	return db.listSubscriptions("ExpiredAt IS NULL AND CancelledAt IS NULL AND ReminderSentAt IS NULL AND ValidUntil <= $1", until)
+}
+
+// QueueSubscriptionReminder queues the renewal reminder with the renewal link of a subscription.
+// The reminder is claimed first, so that it is only queued once if several instances run the job.
+// It returns false if the reminder has already been queued.
+func (db *Database) QueueSubscriptionReminder(subscription Subscription) (queued bool, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	res, err := tx.Exec(context.Background(), "UPDATE Subscription SET ReminderSentAt = NOW() WHERE ID = $1 AND ReminderSentAt IS NULL", subscription.ID)
+	if err != nil {
+		log.Error("QueueSubscriptionReminder: ", err)
+		return
+	}
+	if res.RowsAffected() != 1 {
+		return
+	}
+	templateData := map[string]interface{}{
+		"ItemName":   subscription.ItemName,
+		"ValidUntil": subscription.ValidUntil.In(config.Config.TimeZone).Format("02.01.2006"),
+		"URL":        config.Config.FrontendURL + "/subscriptions/renew/" + subscription.RenewalToken,
+	}
+	_, err = db.QueueEmailTx(tx, subscription.CustomerEmail, EmailTemplateSubscriptionRenewal, subscription.Language, templateData)
+	return err == nil, err
+}
+
+// ListSubscriptionsToExpire returns the subscriptions that ended before the given time and still grant access
+func (db *Database) ListSubscriptionsToExpire(before time.Time) (subscriptions []Subscription, err error) {
+	return db.listSubscriptions("ExpiredAt IS NULL AND ValidUntil < $1", before)
+}
+
+// ExpireSubscription marks that the customer of a subscription has been removed from its license group
+func (db *Database) ExpireSubscription(id int) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "UPDATE Subscription SET ExpiredAt = NOW() WHERE ID = $1", id)
+	if err != nil {
+		log.Error("ExpireSubscription: ", err)
+	}
+	return
+}
+
+// License grants -------------------------------------------------------------
+
+// licenseGrantEnd returns the end of the access bought with an item, null for unlimited access
+func licenseGrantEnd(start time.Time, item Item) null.Time {
+	days := item.LicenseValidityDays
+	if days == 0 {
+		days = config.Config.LicenseValidityDays
+	}
+	if days <= 0 {
+		return null.Time{}
+	}
+	return null.TimeFrom(start.AddDate(0, 0, days))
+}
+
+// createLicenseGrantTx records the access to the license group of a digital license item bought with a verified order.
+// Verifying the order again returns the existing grant.
+func createLicenseGrantTx(tx pgx.Tx, order Order, item Item, userID string) (grant LicenseGrant, err error) {
+	now := time.Now()
+	_, err = tx.Exec(context.Background(), `
+	INSERT INTO LicenseGrant (PaymentOrder, Item, LicenseGroup, CustomerEmail, UserID, StartDate, EndDate)
+	VALUES ($1, $2, $3, lower($4), $5, $6, $7)
+	ON CONFLICT (PaymentOrder, Item) DO NOTHING
+	`, order.ID, item.ID, item.LicenseGroup.String, order.LicenseHolder(), userID, now, licenseGrantEnd(now, item))
+	if err != nil {
+		log.Error("createLicenseGrantTx: ", err)
+		return
+	}
+	rows, err := tx.Query(context.Background(), "SELECT * FROM LicenseGrant WHERE PaymentOrder = $1 AND Item = $2", order.ID, item.ID)
+	if err != nil {
+		log.Error("createLicenseGrantTx: ", err)
+		return
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[LicenseGrant])
+}
+
+// licenseAccessExpiredTx returns whether the access to the license group of an item bought with an order has expired
+func licenseAccessExpiredTx(tx pgx.Tx, order Order, item Item) (expired bool, err error) {
+	err = tx.QueryRow(context.Background(), `
+	SELECT EXISTS (SELECT 1 FROM LicenseGrant WHERE PaymentOrder = $1 AND Item = $2 AND ExpiredAt IS NOT NULL)
+	OR EXISTS (SELECT 1 FROM Subscription WHERE CustomerEmail = lower($3) AND Item = $2 AND ExpiredAt IS NOT NULL)
+	`, order.ID, item.ID, order.LicenseHolder()).Scan(&expired)
+	if err != nil {
+		log.Error("licenseAccessExpiredTx: ", err)
+	}
+	return
+}
+
+// GetLicenseGrant returns a license grant by ID
+func (db *Database) GetLicenseGrant(id int) (grant LicenseGrant, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM LicenseGrant WHERE ID = $1", id)
+	if err != nil {
+		log.Error("GetLicenseGrant: ", err)
+		return
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToStructByName[LicenseGrant])
+}
+
+// ListLicenseGrants returns the license grants of an email or all of them, newest first.
+// Expired grants are skipped if activeOnly is set.
+func (db *Database) ListLicenseGrants(email string, activeOnly bool) (grants []LicenseGrant, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT * FROM LicenseGrant
+	WHERE ($1 = '' OR CustomerEmail = lower($1)) AND (NOT $2 OR ExpiredAt IS NULL)
+	ORDER BY ID DESC
+	`, email, activeOnly)
+	if err != nil {
+		log.Error("ListLicenseGrants: ", err)
+		return
+	}
+	grants, err = pgx.CollectRows(rows, pgx.RowToStructByName[LicenseGrant])
+	if err != nil {
+		log.Error("ListLicenseGrants: ", err)
+	}
+	return
+}
+
+// ListCustomerLicenseGrants returns the license grants of a customer by email or Keycloak ID, newest first
+func (db *Database) ListCustomerLicenseGrants(email string, keycloakID string) (grants []LicenseGrant, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM LicenseGrant WHERE ($1 <> '' AND CustomerEmail = lower($1)) OR ($2 <> '' AND UserID = $2) ORDER BY ID DESC", email, keycloakID)
+	if err != nil {
+		log.Error("ListCustomerLicenseGrants: ", err)
+		return
+	}
+	grants, err = pgx.CollectRows(rows, pgx.RowToStructByName[LicenseGrant])
+	if err != nil {
+		log.Error("ListCustomerLicenseGrants: ", err)
+	}
+	return
+}
+
+// ListLicenseGrantsToExpire returns the grants that ended before the given time and still grant access
+func (db *Database) ListLicenseGrantsToExpire(before time.Time) (grants []LicenseGrant, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT * FROM LicenseGrant WHERE ExpiredAt IS NULL AND EndDate < $1 ORDER BY EndDate", before)
+	if err != nil {
+		log.Error("ListLicenseGrantsToExpire: ", err)
+		return
+	}
+	grants, err = pgx.CollectRows(rows, pgx.RowToStructByName[LicenseGrant])
+	if err != nil {
+		log.Error("ListLicenseGrantsToExpire: ", err)
+	}
+	return
+}
+
+// ExpireLicenseGrant marks that the customer of a grant has been removed from its license group
+func (db *Database) ExpireLicenseGrant(id int) (err error) {
+	_, err = db.Dbpool.Exec(context.Background(), "UPDATE LicenseGrant SET ExpiredAt = NOW() WHERE ID = $1", id)
+	if err != nil {
+		log.Error("ExpireLicenseGrant: ", err)
+	}
+	return
+}
+
+// ExtendLicenseGrant adds days to the end of a grant, grants that ended are extended from now on.
+// Expired grants are active again, the customer has to be added to the license group again.
+func (db *Database) ExtendLicenseGrant(id int, days int) (grant LicenseGrant, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	rows, err := tx.Query(context.Background(), "SELECT * FROM LicenseGrant WHERE ID = $1 FOR UPDATE", id)
+	if err != nil {
+		log.Error("ExtendLicenseGrant: ", err)
+		return
+	}
+	grant, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[LicenseGrant])
+	if err != nil {
+		return
+	}
+	if days > 0 && grant.EndDate.Valid {
+		endDate := grant.EndDate.Time
+		if endDate.Before(time.Now()) {
+			endDate = time.Now()
+		}
+		grant.EndDate = null.TimeFrom(endDate.AddDate(0, 0, days))
+	}
+	grant.ExpiredAt = null.Time{}
+	_, err = tx.Exec(context.Background(), "UPDATE LicenseGrant SET EndDate = $2, ExpiredAt = NULL WHERE ID = $1", id, grant.EndDate)
+	if err != nil {
+		log.Error("ExtendLicenseGrant: ", err)
+	}
+	return
+}
+
+// HasLicenseAccess returns whether a customer has access to a license group by a grant that has not ended
+// or by a subscription that has not expired. The given grant and subscription are not taken into account.
+func (db *Database) HasLicenseAccess(email string, licenseGroup string, exceptGrant int, exceptSubscription int) (access bool, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), `
+	SELECT EXISTS (
+		SELECT 1 FROM LicenseGrant
+		WHERE ID <> $3 AND CustomerEmail = lower($1) AND LicenseGroup = $2 AND ExpiredAt IS NULL AND (EndDate IS NULL OR EndDate > NOW())
+	) OR EXISTS (
+		SELECT 1 FROM Subscription JOIN Item ON Item.ID = Subscription.Item
+		WHERE Subscription.ID <> $4 AND CustomerEmail = lower($1) AND Item.LicenseGroup = $2 AND ExpiredAt IS NULL
+	)
+	`, email, licenseGroup, exceptGrant, exceptSubscription).Scan(&access)
+	if err != nil {
+		log.Error("HasLicenseAccess: ", err)
+	}
+	return
+}
+
+// Gifts ----------------------------------------------------------------------
+
+// ListGiftsToDeliver returns the IDs of the verified gift orders whose delivery date is before until and that have not been delivered.
+// Gifts whose recipient has been erased are left out.
+func (db *Database) ListGiftsToDeliver(until time.Time) (orderIDs []int, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT ID FROM PaymentOrder WHERE Verified AND RecipientEmail <> '' AND DeliveredAt IS NULL AND DeliveryDate <= $1 ORDER BY DeliveryDate", until)
+	if err != nil {
+		log.Error("ListGiftsToDeliver: ", err)
+		return
+	}
+	orderIDs, err = pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		log.Error("ListGiftsToDeliver: ", err)
+	}
+	return
+}
+
+// DeliverGift delivers the digital license items and PDFs of a verified gift order to the recipient.
+// Gifts that have already been delivered or whose recipient has been erased are skipped.
+func (db *Database) DeliverGift(orderID int) (err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	var verified, erased bool
+	var deliveredAt null.Time
+	err = tx.QueryRow(context.Background(), "SELECT Verified, DeliveredAt, RecipientEmail = '' FROM PaymentOrder WHERE ID = $1 AND RecipientEmail IS NOT NULL FOR UPDATE", orderID).Scan(&verified, &deliveredAt, &erased)
+	if err != nil {
+		log.Error("DeliverGift: ", orderID, err)
+		return
+	}
+	if !verified || deliveredAt.Valid || erased {
+		return nil
+	}
+	order, err := db.GetOrderByIDTx(tx, orderID)
+	if err != nil {
+		return
+	}
+	return db.deliverOrderTx(tx, order, false)
+}
+
+// ListGiftsOfRecipient returns the gift orders that have been sent to an email, newest first
+func (db *Database) ListGiftsOfRecipient(email string) (gifts []ReceivedGift, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT ID AS OrderID, OrderCode, Timestamp, RecipientName, GiftMessage, DeliveryDate, DeliveredAt
+	FROM PaymentOrder WHERE $1 <> '' AND lower(RecipientEmail) = lower($1) ORDER BY ID DESC
+	`, email)
+	if err != nil {
+		log.Error("ListGiftsOfRecipient: ", err)
+		return
+	}
+	gifts, err = pgx.CollectRows(rows, pgx.RowToStructByName[ReceivedGift])
+	if err != nil {
+		log.Error("ListGiftsOfRecipient: ", err)
+	}
+	return
+}
+
+// Vouchers -------------------------------------------------------------------
+
+// voucherCheckoutTime is how long unverified orders count as uses of their voucher codes, the time to complete the checkout
+const voucherCheckoutTime = "1 hour"
+
+// voucherCodeUses counts the orders with the voucher code of the row that are verified or within the checkout
+const voucherCodeUses = `(SELECT COUNT(DISTINCT PaymentOrder.ID) FROM OrderEntry JOIN PaymentOrder ON PaymentOrder.ID = OrderEntry.PaymentOrder
+	WHERE OrderEntry.VoucherCode = VoucherCode.ID AND (PaymentOrder.Verified OR PaymentOrder.Timestamp > NOW() - interval '` + voucherCheckoutTime + `'))`
+
+// voucherColumns are the columns of the Voucher table in the order of scanVoucher
+const voucherColumns = `Voucher.ID, Voucher.Name, Voucher.Type, Voucher.Amount, Voucher.Percent, Voucher.FreeItem, Voucher.Items, Voucher.ValidFrom, Voucher.ValidUntil,
+	Voucher.MaxUses, Voucher.Disabled, Voucher.CreatedBy, Voucher.Timestamp,
+	(SELECT COUNT(*) FROM VoucherCode WHERE VoucherCode.Voucher = Voucher.ID),
+	(SELECT COUNT(DISTINCT PaymentOrder.ID) FROM OrderEntry JOIN VoucherCode ON VoucherCode.ID = OrderEntry.VoucherCode JOIN PaymentOrder ON PaymentOrder.ID = OrderEntry.PaymentOrder
+	WHERE VoucherCode.Voucher = Voucher.ID AND PaymentOrder.Verified)`
+
+// scanVoucher returns the scan destinations for a row selected with voucherColumns
+func scanVoucher(voucher *Voucher) []any {
+	return []any{&voucher.ID, &voucher.Name, &voucher.Type, &voucher.Amount, &voucher.Percent, &voucher.FreeItem, &voucher.Items, &voucher.ValidFrom, &voucher.ValidUntil,
+		&voucher.MaxUses, &voucher.Disabled, &voucher.CreatedBy, &voucher.Timestamp, &voucher.Codes, &voucher.Uses}
+}
+
+// CreateVoucher creates a voucher with its codes, which have to be upper case
+func (db *Database) CreateVoucher(voucher Voucher, codes []string) (id int, err error) {
+	tx, err := db.Dbpool.Begin(context.Background())
+	if err != nil {
+		return
+	}
+	defer func() { err = DeferTx(tx, err) }()
+
+	if voucher.Items == nil {
+		voucher.Items = []int{}
+	}
+	err = tx.QueryRow(context.Background(), `
+	INSERT INTO Voucher (Name, Type, Amount, Percent, FreeItem, Items, ValidFrom, ValidUntil, MaxUses, Disabled, CreatedBy)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING ID
+	`, voucher.Name, voucher.Type, voucher.Amount, voucher.Percent, voucher.FreeItem, voucher.Items, voucher.ValidFrom, voucher.ValidUntil, voucher.MaxUses, voucher.Disabled, voucher.CreatedBy).Scan(&id)
+	if err != nil {
+		log.Error("CreateVoucher: ", err)
+		return
+	}
+	_, err = tx.Exec(context.Background(), "INSERT INTO VoucherCode (Voucher, Code) SELECT $1, unnest($2::text[])", id, codes)
+	if err != nil {
+		log.Error("CreateVoucher: ", err)
+	}
+	return
+}
+
+// UpdateVoucher updates a voucher, its codes stay the same
+func (db *Database) UpdateVoucher(voucher Voucher) (err error) {
+	if voucher.Items == nil {
+		voucher.Items = []int{}
+	}
+	res, err := db.Dbpool.Exec(context.Background(), `
+	UPDATE Voucher
+	SET Name = $2, Type = $3, Amount = $4, Percent = $5, FreeItem = $6, Items = $7, ValidFrom = $8, ValidUntil = $9, MaxUses = $10, Disabled = $11
+	WHERE ID = $1
+	`, voucher.ID, voucher.Name, voucher.Type, voucher.Amount, voucher.Percent, voucher.FreeItem, voucher.Items, voucher.ValidFrom, voucher.ValidUntil, voucher.MaxUses, voucher.Disabled)
+	if err != nil {
+		log.Error("UpdateVoucher: ", err)
+		return
+	}
+	if res.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return
+}
+
+// GetVoucher returns a voucher by ID
+func (db *Database) GetVoucher(id int) (voucher Voucher, err error) {
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT "+voucherColumns+" FROM Voucher WHERE ID = $1", id).Scan(scanVoucher(&voucher)...)
+	return
+}
+
+// ListVouchers returns all vouchers, newest first
+func (db *Database) ListVouchers() (vouchers []Voucher, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT "+voucherColumns+" FROM Voucher ORDER BY ID DESC")
+	if err != nil {
+		log.Error("ListVouchers: ", err)
+		return
+	}
+	var voucher Voucher
+	_, err = pgx.ForEachRow(rows, scanVoucher(&voucher), func() error {
+		vouchers = append(vouchers, voucher)
+		return nil
+	})
+	if err != nil {
+		log.Error("ListVouchers: ", err)
+	}
+	return
+}
+
+// ListVoucherCodes returns the codes of a voucher with their uses
+func (db *Database) ListVoucherCodes(voucherID int) (codes []VoucherCode, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT ID, Voucher, Code, "+voucherCodeUses+" AS Uses, Timestamp FROM VoucherCode WHERE Voucher = $1 ORDER BY ID", voucherID)
+	if err != nil {
+		log.Error("ListVoucherCodes: ", err)
+		return
+	}
+	codes, err = pgx.CollectRows(rows, pgx.RowToStructByName[VoucherCode])
+	if err != nil {
+		log.Error("ListVoucherCodes: ", err)
+	}
+	return
+}
+
+// GetVoucherByCode returns a voucher code, case insensitive, with its voucher. It returns ErrVoucherNotValid for unknown codes.
+func (db *Database) GetVoucherByCode(code string) (voucher Voucher, voucherCode VoucherCode, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), "SELECT ID, Voucher, Code, "+voucherCodeUses+" AS Uses, Timestamp FROM VoucherCode WHERE Code = upper($1)", strings.TrimSpace(code))
+	if err != nil {
+		log.Error("GetVoucherByCode: ", err)
+		return
+	}
+	voucherCode, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[VoucherCode])
+	if errors.Is(err, pgx.ErrNoRows) {
+		return voucher, voucherCode, ErrVoucherNotValid
+	}
+	if err != nil {
+		log.Error("GetVoucherByCode: ", err)
+		return
+	}
+	voucher, err = db.GetVoucher(voucherCode.Voucher)
+	return
+}
+
+// redeemVoucherCodeTx checks within the creation of an order that a voucher code may still be used.
+// The code is locked, so that concurrent orders can't exceed the uses of the voucher.
+func redeemVoucherCodeTx(tx pgx.Tx, codeID int) (err error) {
+	_, err = tx.Exec(context.Background(), "SELECT 1 FROM VoucherCode WHERE ID = $1 FOR UPDATE", codeID)
+	if err != nil {
+		log.Error("redeemVoucherCodeTx: ", err)
+		return
+	}
+	var voucher Voucher
+	var uses int
+	err = tx.QueryRow(context.Background(), "SELECT "+voucherColumns+", "+voucherCodeUses+" FROM Voucher JOIN VoucherCode ON VoucherCode.Voucher = Voucher.ID WHERE VoucherCode.ID = $1", codeID).Scan(append(scanVoucher(&voucher), &uses)...)
+	if err != nil {
+		log.Error("redeemVoucherCodeTx: ", err)
+		return
+	}
+	err = voucher.Check(time.Now())
+	if err != nil {
+		return
+	}
+	if voucher.MaxUses > 0 && uses >= voucher.MaxUses {
+		return ErrVoucherUsedUp
+	}
+	return
+}
+
+// voucherCodeOverusedTx returns whether the verified orders of other buyers have used up a voucher code, which an order
+// paid after its checkout can't be refused for. The code is locked, so that concurrent verifications are counted.
+func voucherCodeOverusedTx(tx pgx.Tx, codeID int, orderID int) (overused bool, err error) {
+	var maxUses, uses int
+	err = tx.QueryRow(context.Background(), `
+	SELECT Voucher.MaxUses FROM VoucherCode JOIN Voucher ON Voucher.ID = VoucherCode.Voucher WHERE VoucherCode.ID = $1 FOR UPDATE OF VoucherCode
+	`, codeID).Scan(&maxUses)
+	if err != nil {
+		log.Error("voucherCodeOverusedTx: ", err)
+		return
+	}
+	if maxUses <= 0 {
+		return
+	}
+	err = tx.QueryRow(context.Background(), `
+	SELECT COUNT(DISTINCT PaymentOrder.ID) FROM OrderEntry JOIN PaymentOrder ON PaymentOrder.ID = OrderEntry.PaymentOrder
+	WHERE OrderEntry.VoucherCode = $1 AND PaymentOrder.Verified AND PaymentOrder.ID <> $2
+	`, codeID, orderID).Scan(&uses)
+	if err != nil {
+		log.Error("voucherCodeOverusedTx: ", err)
+		return
+	}
+	return uses >= maxUses, nil
+}
+
+// ListVoucherStatistics returns the redemptions and discounts of the vouchers in verified orders between minDate and maxDate,
+// zero dates are not filtered
+func (db *Database) ListVoucherStatistics(minDate time.Time, maxDate time.Time) (statistics []VoucherStatistics, err error) {
+	rows, err := db.Dbpool.Query(context.Background(), `
+	SELECT Voucher.ID, Voucher.Name, COUNT(DISTINCT PaymentOrder.ID) AS Redemptions, COALESCE(SUM(OrderEntry.Price * OrderEntry.Quantity), 0)::integer AS SumDiscount
+	FROM OrderEntry
+	JOIN PaymentOrder ON PaymentOrder.ID = OrderEntry.PaymentOrder
+	JOIN VoucherCode ON VoucherCode.ID = OrderEntry.VoucherCode
+	JOIN Voucher ON Voucher.ID = VoucherCode.Voucher
+	WHERE PaymentOrder.Verified AND ($1::timestamp IS NULL OR PaymentOrder.Timestamp >= $1) AND ($2::timestamp IS NULL OR PaymentOrder.Timestamp <= $2)
+	GROUP BY Voucher.ID, Voucher.Name
+	ORDER BY Voucher.ID
+	`, null.NewTime(minDate, !minDate.IsZero()), null.NewTime(maxDate, !maxDate.IsZero()))
+	if err != nil {
+		log.Error("ListVoucherStatistics: ", err)
+		return
+	}
+	statistics, err = pgx.CollectRows(rows, pgx.RowToStructByName[VoucherStatistics])
+	if err != nil {
+		log.Error("ListVoucherStatistics: ", err)
+	}
+	return
+}
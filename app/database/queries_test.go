@@ -6,6 +6,7 @@ import (
 	"context"
 	"os"
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -164,7 +165,7 @@ func TestVendors(t *testing.T) {
 	require.Equal(t, vendorName, vendor.FirstName)
 
 	// Get vendor locations
-	vendorMap, err := Db.GetVendorLocations()
+	vendorMap, err := Db.GetVendorLocations(MapFilter{})
 	utils.CheckError(t, err)
 	require.Equal(t, 1, len(vendorMap))
 	require.Equal(t, 10.0, vendorMap[0].Longitude)
@@ -172,6 +173,39 @@ func TestVendors(t *testing.T) {
 	require.Equal(t, vendorName, vendorMap[0].FirstName)
 	require.Equal(t, id, vendorMap[0].ID)
 
+	// Selling locations replace the point of the vendor on the map
+	monday := time.Date(2026, 10, 19, 9, 30, 0, 0, config.Config.TimeZone)
+	_, err = Db.CreateVendorLocation(VendorLocation{Vendor: id, Name: "Station", Longitude: 16.37, Latitude: 48.21, TimeSlots: []VendorTimeSlot{{Weekday: 1, StartTime: "08:00", EndTime: "12:00"}}})
+	utils.CheckError(t, err)
+	_, err = Db.CreateVendorLocation(VendorLocation{Vendor: id, Name: "Market", Longitude: 16.36, Latitude: 48.19, TimeSlots: []VendorTimeSlot{{Weekday: 6, StartTime: "07:00", EndTime: "13:00"}}})
+	utils.CheckError(t, err)
+	vendorMap, err = Db.GetVendorLocations(MapFilter{})
+	utils.CheckError(t, err)
+	require.Equal(t, 2, len(vendorMap))
+	vendorMap, err = Db.GetVendorLocations(MapFilter{At: null.TimeFrom(monday)})
+	utils.CheckError(t, err)
+	require.Equal(t, 1, len(vendorMap))
+	require.Equal(t, "Station", vendorMap[0].Name)
+	require.Equal(t, []VendorTimeSlot{{Weekday: 1, StartTime: "08:00", EndTime: "12:00"}}, vendorMap[0].TimeSlots)
+	vendorMap, err = Db.GetVendorLocations(MapFilter{At: null.TimeFrom(monday.Add(3 * time.Hour))})
+	utils.CheckError(t, err)
+	require.Equal(t, 0, len(vendorMap))
+
+	// The public map only shows vendors that opted in
+	vendorMap, err = Db.GetVendorLocations(MapFilter{Public: true})
+	utils.CheckError(t, err)
+	require.Equal(t, 0, len(vendorMap))
+	vendor.OnlineMap = true
+	err = Db.UpdateVendor(id, vendor)
+	utils.CheckError(t, err)
+	vendorMap, err = Db.GetVendorLocations(MapFilter{Public: true, BoundingBox: []float64{16.365, 48.2, 16.38, 48.22}})
+	utils.CheckError(t, err)
+	require.Equal(t, 1, len(vendorMap))
+	require.Equal(t, "Station", vendorMap[0].Name)
+	vendorMap, err = Db.GetVendorLocations(MapFilter{Public: true, At: null.TimeFrom(monday), BoundingBox: []float64{0, 0, 1, 1}})
+	utils.CheckError(t, err)
+	require.Equal(t, 0, len(vendorMap))
+
 	// Delete vendor
 	err = Db.DeleteVendor(id)
 	utils.CheckError(t, err)
@@ -309,3 +343,200 @@ func TestQueryOrders(t *testing.T) {
 	utils.CheckError(t, err)
 
 }
+
+// TestEmailQueue tests queueing and resetting emails of the outbox
+func TestEmailQueue(t *testing.T) {
+	id, err := Db.QueueEmail("test@example.com", EmailTemplatePDFLicenceItem, "en", map[string]interface{}{"URL": "http://example.com"})
+	require.NoError(t, err)
+
+	emails, err := Db.ListQueuedEmails(false)
+	require.NoError(t, err)
+	require.Equal(t, id, emails[0].ID)
+	require.Equal(t, "test@example.com", emails[0].Recipient)
+	require.Equal(t, "http://example.com", emails[0].Data["URL"])
+	require.False(t, emails[0].SentAt.Valid)
+
+	failed, err := Db.ListQueuedEmails(true)
+	require.NoError(t, err)
+	require.Empty(t, failed)
+
+	// Claimed emails are leased and not claimed again by another worker
+	containsEmail := func(emails []QueuedEmail) bool {
+		return slices.ContainsFunc(emails, func(email QueuedEmail) bool { return email.ID == id })
+	}
+	claimed, err := Db.claimQueuedEmails(1000, 5)
+	require.NoError(t, err)
+	require.True(t, containsEmail(claimed))
+	claimed, err = Db.claimQueuedEmails(1000, 5)
+	require.NoError(t, err)
+	require.False(t, containsEmail(claimed))
+
+	// Resending clears the last failure
+	_, err = Db.Dbpool.Exec(context.Background(), "UPDATE EmailQueue SET LastError = 'connection refused' WHERE ID = $1", id)
+	require.NoError(t, err)
+	require.NoError(t, Db.ResendQueuedEmail(id))
+	require.Error(t, Db.ResendQueuedEmail(id+1000))
+	emails, err = Db.ListQueuedEmails(false)
+	require.NoError(t, err)
+	for _, email := range emails {
+		if email.ID == id {
+			require.False(t, email.LastError.Valid)
+		}
+	}
+}
+
+// TestEmailTemplates tests the language fallback of the email templates
+func TestEmailTemplates(t *testing.T) {
+	// Default templates are created on initialization
+	tmpl, err := Db.GetLocalizedEmailTemplate(EmailTemplatePDFLicenceItem, "en-GB")
+	require.NoError(t, err)
+	require.Equal(t, "en", tmpl.Language)
+
+	// Unknown languages fall back to the default language
+	tmpl, err = Db.GetLocalizedEmailTemplate(EmailTemplatePDFLicenceItem, "fr")
+	require.NoError(t, err)
+	require.Equal(t, config.Config.DefaultLanguage, tmpl.Language)
+
+	id, err := Db.CreateEmailTemplate(EmailTemplate{Name: EmailTemplatePDFLicenceItem, Language: "fr", Subject: "Votre journal"})
+	require.NoError(t, err)
+	tmpl, err = Db.GetLocalizedEmailTemplate(EmailTemplatePDFLicenceItem, "fr")
+	require.NoError(t, err)
+	require.Equal(t, id, tmpl.ID)
+	require.NoError(t, Db.DeleteEmailTemplate(id))
+
+	_, err = Db.GetLocalizedEmailTemplate("doesNotExist", "de")
+	require.Error(t, err)
+}
+
+// TestPDFDownloadPolicy tests the download limits of PDF links
+func TestPDFDownloadPolicy(t *testing.T) {
+	pdfID, err := Db.CreatePDF(PDF{Path: "test.pdf", Timestamp: time.Now()})
+	require.NoError(t, err)
+	vendorID, err := Db.CreateVendor(Vendor{LicenseID: null.StringFrom("pdf-policy")})
+	require.NoError(t, err)
+	orderID, err := Db.CreateOrder(Order{Vendor: int(vendorID), OrderCode: null.StringFrom("pdf-policy")})
+	require.NoError(t, err)
+	itemID, err := Db.CreateItem(Item{Name: "PDF policy", IsPDFItem: true, PDFValidityDays: 10, PDFMaxDownloads: 2, PDFMaxIPs: 1})
+	require.NoError(t, err)
+	item, err := Db.GetItem(itemID)
+	require.NoError(t, err)
+	require.Equal(t, 2, item.PDFMaxDownloads)
+
+	tx, err := Db.Dbpool.Begin(context.Background())
+	require.NoError(t, err)
+	pdfDownload, err := Db.CreatePDFDownload(tx, PDF{ID: int(pdfID)}, orderID, item)
+	require.NoError(t, err)
+	require.NoError(t, Db.CreatePDFDownloadAccessTx(tx, pdfDownload, "10.0.0.1"))
+	require.NoError(t, tx.Commit(context.Background()))
+
+	pdfDownload, err = Db.GetPDFDownload(pdfDownload.LinkID)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().AddDate(0, 0, 10), pdfDownload.ValidUntil.Time, time.Minute)
+	require.NoError(t, pdfDownload.CheckPolicy(time.Now()))
+	require.ErrorIs(t, pdfDownload.CheckPolicy(time.Now().AddDate(0, 0, 11)), ErrPDFDownloadExpired)
+
+	// Only one IP address is allowed
+	require.NoError(t, Db.CheckPDFDownloadIP(pdfDownload, "10.0.0.1"))
+	require.ErrorIs(t, Db.CheckPDFDownloadIP(pdfDownload, "10.0.0.2"), ErrPDFDownloadIPLimit)
+
+	pdfDownload.DownloadCount = 2
+	require.ErrorIs(t, pdfDownload.CheckPolicy(time.Now()), ErrPDFDownloadLimit)
+
+	require.NoError(t, Db.RevokePDFDownload(pdfDownload.LinkID))
+	pdfDownload, err = Db.GetPDFDownload(pdfDownload.LinkID)
+	require.NoError(t, err)
+	require.ErrorIs(t, pdfDownload.CheckPolicy(time.Now()), ErrPDFDownloadRevoked)
+
+	// Extending enables the link again
+	pdfDownload, err = Db.ExtendPDFDownload(pdfDownload.LinkID, 5, 1)
+	require.NoError(t, err)
+	require.False(t, pdfDownload.Revoked)
+	require.Equal(t, 3, pdfDownload.MaxDownloads)
+	require.WithinDuration(t, time.Now().AddDate(0, 0, 15), pdfDownload.ValidUntil.Time, time.Minute)
+}
+
+// TestEditions tests that the newest available edition is sold by default
+func TestEditions(t *testing.T) {
+	itemID, err := Db.CreateItem(Item{Name: "Editions", IsPDFItem: true})
+	require.NoError(t, err)
+	item, err := Db.GetItem(itemID)
+	require.NoError(t, err)
+
+	now := time.Now()
+	oldID, err := Db.CreatePDF(PDF{Path: "old.pdf", Timestamp: now, Item: null.IntFrom(int64(itemID)), IssueNumber: "1", PublicationDate: null.TimeFrom(now.AddDate(0, -1, 0))})
+	require.NoError(t, err)
+	currentID, err := Db.CreatePDF(PDF{Path: "current.pdf", Timestamp: now, Item: null.IntFrom(int64(itemID)), IssueNumber: "2", PublicationDate: null.TimeFrom(now)})
+	require.NoError(t, err)
+	// Not available yet
+	_, err = Db.CreatePDF(PDF{Path: "next.pdf", Timestamp: now, Item: null.IntFrom(int64(itemID)), IssueNumber: "3", PublicationDate: null.TimeFrom(now.AddDate(0, 1, 0)), AvailableFrom: null.TimeFrom(now.AddDate(0, 1, 0))})
+	require.NoError(t, err)
+
+	current, err := Db.GetCurrentEdition(item)
+	require.NoError(t, err)
+	require.Equal(t, int(currentID), current.ID)
+
+	editions, err := Db.ListEditions(itemID, true)
+	require.NoError(t, err)
+	require.Len(t, editions, 2)
+	require.Equal(t, int(oldID), editions[1].ID)
+
+	editions, err = Db.ListEditions(itemID, false)
+	require.NoError(t, err)
+	require.Len(t, editions, 3)
+	require.False(t, editions[0].Available(now))
+}
+
+// TestPurgeSoldEditions tests that sold editions only lose their file, so that orders still show which edition was sold
+func TestPurgeSoldEditions(t *testing.T) {
+	itemID, err := Db.CreateItem(Item{Name: "Sold editions", IsPDFItem: true})
+	require.NoError(t, err)
+	pdfID, err := Db.CreatePDF(PDF{Path: "pdf/sold.pdf", Timestamp: time.Now().AddDate(0, -3, 0), Item: null.IntFrom(int64(itemID)), AvailableUntil: null.TimeFrom(time.Now().AddDate(0, -2, 0))})
+	require.NoError(t, err)
+	vendorID, err := Db.CreateVendor(Vendor{LicenseID: null.StringFrom("soldeditions")})
+	require.NoError(t, err)
+	orga, err := Db.GetAccountByType("Orga")
+	require.NoError(t, err)
+	orderID, err := Db.CreateOrder(Order{Vendor: vendorID, Entries: []OrderEntry{{Item: itemID, Quantity: 1, PDF: null.IntFrom(pdfID), Sender: orga.ID, Receiver: orga.ID}}})
+	require.NoError(t, err)
+	_, err = Db.Dbpool.Exec(context.Background(), "UPDATE PaymentOrder SET Verified = true WHERE ID = $1", orderID)
+	require.NoError(t, err)
+
+	_, paths, err := Db.PurgeExpiredPDFs(time.Now().AddDate(0, -1, 0))
+	require.NoError(t, err)
+	require.Contains(t, paths, "pdf/sold.pdf")
+	pdf, err := Db.GetPDFByID(pdfID)
+	require.NoError(t, err)
+	require.Empty(t, pdf.Path)
+	entries, err := Db.GetOrderEntries(orderID)
+	require.NoError(t, err)
+	require.Equal(t, pdfID, entries[0].PDF.Int64)
+
+	// The file is only reported once
+	_, paths, err = Db.PurgeExpiredPDFs(time.Now().AddDate(0, -1, 0))
+	require.NoError(t, err)
+	require.NotContains(t, paths, "pdf/sold.pdf")
+}
+
+// TestPurgeExpiredPDFs tests that expired links and unused PDFs can be removed despite the delete triggers
+func TestPurgeExpiredPDFs(t *testing.T) {
+	pdfID, err := Db.CreatePDF(PDF{Path: "pdf/expired.pdf", Timestamp: time.Now().AddDate(0, -3, 0)})
+	require.NoError(t, err)
+	tx, err := Db.Dbpool.Begin(context.Background())
+	require.NoError(t, err)
+	_, err = tx.Exec(context.Background(), "INSERT INTO PDFDownload (LinkID, PDF, Timestamp, ValidUntil, StampedPath) VALUES ('expired', $1, $2, $3, 'pdf/stamped/expired.pdf')", pdfID, time.Now().AddDate(0, -3, 0), time.Now().AddDate(0, -2, 0))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(context.Background()))
+
+	// Deletes without permission are still prevented
+	_, err = Db.Dbpool.Exec(context.Background(), "DELETE FROM PDF WHERE ID = $1", pdfID)
+	require.Error(t, err)
+
+	report, paths, err := Db.PurgeExpiredPDFs(time.Now().AddDate(0, -1, 0))
+	require.NoError(t, err)
+	require.Equal(t, 1, report.PDFDownloads)
+	require.Equal(t, 1, report.PDFs)
+	require.ElementsMatch(t, []string{"pdf/expired.pdf", "pdf/stamped/expired.pdf"}, paths)
+
+	_, err = Db.GetPDFByID(pdfID)
+	require.Error(t, err)
+}
@@ -2,6 +2,9 @@ package database
 
 import (
 	"augustin/config"
+	"augustin/utils"
+	"context"
+	"os"
 
 	"go.uber.org/zap"
 	"gopkg.in/guregu/null.v4"
@@ -80,6 +83,27 @@ func (db *Database) InitiateItems() (err error) {
 	return
 }
 
+// InitiateVoucherItem creates the hidden item of the discount entries of vouchers if it doesn't exist
+func (db *Database) InitiateVoucherItem() (err error) {
+	if config.Config.VoucherName == "" {
+		log.Error("VoucherName is not set")
+		return
+	}
+	var exists bool
+	err = db.Dbpool.QueryRow(context.Background(), "SELECT EXISTS (SELECT 1 FROM Item WHERE Name = $1 AND NOT Archived)", config.Config.VoucherName).Scan(&exists)
+	if err != nil || exists {
+		return
+	}
+	// The price of a discount entry is the discount in cents, the price of the item is not used
+	_, err = db.CreateItem(Item{
+		Name:        config.Config.VoucherName,
+		Description: "Rabatt durch Gutscheine, trägt die Organisation",
+		Price:       1,
+		Archived:    false,
+	})
+	return
+}
+
 // UpdateInitialSettings creates test settings for the application
 func (db *Database) UpdateInitialSettings() (err error) {
 	settings := Settings{
@@ -108,3 +132,148 @@ func (db *Database) UpdateInitialSettings() (err error) {
 
 	return err
 }
+
+// defaultEmailTemplates are created on startup if no template with the same name and language exists.
+// The HTML body is read from the legacy template folder (./templates/<Name>Template.html) if the file exists,
+// so that customized templates of existing installations are taken over.
+var defaultEmailTemplates = []EmailTemplate{
+	{
+		Name:     EmailTemplateDigitalLicenceItem,
+		Language: "de",
+		Subject:  "Eine neue Zeitung wurde gekauft",
+		HTMLBody: `<p>Hallo!<br /><br />Deine neue Zeitung ist da!<br /><a href="{{.URL}}">Hier klicken</a> um die Zeitung zu lesen.<br /><br />Viel Spass beim Lesen!</p>`,
+		TextBody: "Hallo!\n\nDeine neue Zeitung ist da!\nHier kannst du die Zeitung lesen: {{.URL}}\n\nViel Spass beim Lesen!\n",
+	},
+	{
+		Name:     EmailTemplateDigitalLicenceItem,
+		Language: "en",
+		Subject:  "A new newspaper has been purchased",
+		HTMLBody: `<p>Hello!<br /><br />Your new newspaper is here!<br /><a href="{{.URL}}">Click here</a> to read it.<br /><br />Enjoy reading!</p>`,
+		TextBody: "Hello!\n\nYour new newspaper is here!\nRead it here: {{.URL}}\n\nEnjoy reading!\n",
+	},
+	{
+		Name:     EmailTemplatePDFLicenceItem,
+		Language: "de",
+		Subject:  "Deine Zeitung ist bereit zum Download",
+		HTMLBody: `<p>Hallo!<br /><br />Deine neue Zeitung ist da!<br /><a href="{{.URL}}">Hier klicken</a> um die Zeitung herunterzuladen.<br /><br />Viel Spass beim Lesen!</p>`,
+		TextBody: "Hallo!\n\nDeine neue Zeitung ist da!\nHier kannst du die Zeitung herunterladen: {{.URL}}\n\nViel Spass beim Lesen!\n",
+	},
+	{
+		Name:     EmailTemplatePDFLicenceItem,
+		Language: "en",
+		Subject:  "Your newspaper is ready for download",
+		HTMLBody: `<p>Hello!<br /><br />Your new newspaper is here!<br /><a href="{{.URL}}">Click here</a> to download it.<br /><br />Enjoy reading!</p>`,
+		TextBody: "Hello!\n\nYour new newspaper is here!\nDownload it here: {{.URL}}\n\nEnjoy reading!\n",
+	},
+	{
+		Name:     EmailTemplateOrderConfirmation,
+		Language: "de",
+		Subject:  "Deine Bestellung {{.OrderCode}} bei {{.NewspaperName}}",
+		HTMLBody: `<h2 style="color: {{.Color}}">{{.NewspaperName}}</h2>
+<p>Hallo!<br /><br />Vielen Dank für deinen Einkauf bei {{.VendorFirstName}}.</p>
+<table>
+{{range .Entries}}<tr><td>{{.Quantity}} x {{.Name}}</td><td style="text-align: right">{{.Total}}</td></tr>
+{{end}}{{if .Donation}}<tr><td>Spende</td><td style="text-align: right">{{.Donation}}</td></tr>
+{{end}}{{if .Discount}}<tr><td>Gutschein</td><td style="text-align: right">{{.Discount}}</td></tr>
+{{end}}<tr><td><b>Gesamt</b></td><td style="text-align: right"><b>{{.Total}}</b></td></tr>
+</table>
+{{if .Recipient}}<p>Das Geschenk wird an {{.Recipient}} geschickt.</p>
+{{end}}<p>Bestellnummer: {{.OrderCode}}<br />Die Kaufbestätigung findest du im Anhang.</p>`,
+		TextBody: `Hallo!
+
+Vielen Dank für deinen Einkauf bei {{.VendorFirstName}}.
+
+{{range .Entries}}{{.Quantity}} x {{.Name}}: {{.Total}}
+{{end}}{{if .Donation}}Spende: {{.Donation}}
+{{end}}{{if .Discount}}Gutschein: {{.Discount}}
+{{end}}Gesamt: {{.Total}}
+{{if .Recipient}}
+Das Geschenk wird an {{.Recipient}} geschickt.
+{{end}}
+Bestellnummer: {{.OrderCode}}
+Die Kaufbestätigung findest du im Anhang.
+`,
+	},
+	{
+		Name:     EmailTemplateOrderConfirmation,
+		Language: "en",
+		Subject:  "Your order {{.OrderCode}} at {{.NewspaperName}}",
+		HTMLBody: `<h2 style="color: {{.Color}}">{{.NewspaperName}}</h2>
+<p>Hello!<br /><br />Thank you for your purchase from {{.VendorFirstName}}.</p>
+<table>
+{{range .Entries}}<tr><td>{{.Quantity}} x {{.Name}}</td><td style="text-align: right">{{.Total}}</td></tr>
+{{end}}{{if .Donation}}<tr><td>Donation</td><td style="text-align: right">{{.Donation}}</td></tr>
+{{end}}{{if .Discount}}<tr><td>Voucher</td><td style="text-align: right">{{.Discount}}</td></tr>
+{{end}}<tr><td><b>Total</b></td><td style="text-align: right"><b>{{.Total}}</b></td></tr>
+</table>
+{{if .Recipient}}<p>The gift is sent to {{.Recipient}}.</p>
+{{end}}<p>Order code: {{.OrderCode}}<br />You can find the receipt attached.</p>`,
+		TextBody: `Hello!
+
+Thank you for your purchase from {{.VendorFirstName}}.
+
+{{range .Entries}}{{.Quantity}} x {{.Name}}: {{.Total}}
+{{end}}{{if .Donation}}Donation: {{.Donation}}
+{{end}}{{if .Discount}}Voucher: {{.Discount}}
+{{end}}Total: {{.Total}}
+{{if .Recipient}}
+The gift is sent to {{.Recipient}}.
+{{end}}
+Order code: {{.OrderCode}}
+You can find the receipt attached.
+`,
+	},
+	{
+		Name:     EmailTemplateSubscriptionRenewal,
+		Language: "de",
+		Subject:  "Dein Abo {{.ItemName}} endet am {{.ValidUntil}}",
+		HTMLBody: `<p>Hallo!<br /><br />Dein Abo {{.ItemName}} endet am {{.ValidUntil}}.<br /><a href="{{.URL}}">Hier klicken</a> um es zu verlängern.<br /><br />Danke, dass du uns unterstützt!</p>`,
+		TextBody: "Hallo!\n\nDein Abo {{.ItemName}} endet am {{.ValidUntil}}.\nHier kannst du es verlängern: {{.URL}}\n\nDanke, dass du uns unterstützt!\n",
+	},
+	{
+		Name:     EmailTemplateSubscriptionRenewal,
+		Language: "en",
+		Subject:  "Your subscription {{.ItemName}} ends on {{.ValidUntil}}",
+		HTMLBody: `<p>Hello!<br /><br />Your subscription {{.ItemName}} ends on {{.ValidUntil}}.<br /><a href="{{.URL}}">Click here</a> to renew it.<br /><br />Thank you for your support!</p>`,
+		TextBody: "Hello!\n\nYour subscription {{.ItemName}} ends on {{.ValidUntil}}.\nRenew it here: {{.URL}}\n\nThank you for your support!\n",
+	},
+	{
+		Name:     EmailTemplateGift,
+		Language: "de",
+		Subject:  "Du hast eine Zeitung geschenkt bekommen",
+		HTMLBody: `<p>Hallo{{if .RecipientName}} {{.RecipientName}}{{end}}!<br /><br />Jemand hat dir eine Zeitung geschenkt. Die Links zum Lesen bekommst du in eigenen E-Mails.</p>{{if .GiftMessage}}<blockquote>{{.GiftMessage}}</blockquote>{{end}}<p>Viel Spass beim Lesen!</p>`,
+		TextBody: "Hallo{{if .RecipientName}} {{.RecipientName}}{{end}}!\n\nJemand hat dir eine Zeitung geschenkt. Die Links zum Lesen bekommst du in eigenen E-Mails.\n{{if .GiftMessage}}\n{{.GiftMessage}}\n{{end}}\nViel Spass beim Lesen!\n",
+	},
+	{
+		Name:     EmailTemplateGift,
+		Language: "en",
+		Subject:  "You have received a newspaper as a gift",
+		HTMLBody: `<p>Hello{{if .RecipientName}} {{.RecipientName}}{{end}}!<br /><br />Someone gave you a newspaper as a gift. You get the links to read it in separate emails.</p>{{if .GiftMessage}}<blockquote>{{.GiftMessage}}</blockquote>{{end}}<p>Enjoy reading!</p>`,
+		TextBody: "Hello{{if .RecipientName}} {{.RecipientName}}{{end}}!\n\nSomeone gave you a newspaper as a gift. You get the links to read it in separate emails.\n{{if .GiftMessage}}\n{{.GiftMessage}}\n{{end}}\nEnjoy reading!\n",
+	},
+}
+
+// InitiateEmailTemplates creates the default email templates if they don't exist
+func (db *Database) InitiateEmailTemplates() (err error) {
+	for _, tmpl := range defaultEmailTemplates {
+		legacyPath := "./templates/" + tmpl.Name + "Template.html"
+		if tmpl.Language == config.Config.DefaultLanguage && utils.FileExists(legacyPath) {
+			content, err := os.ReadFile(legacyPath)
+			if err != nil {
+				log.Error("InitiateEmailTemplates: failed to read ", legacyPath, err)
+			} else {
+				tmpl.HTMLBody = string(content)
+			}
+		}
+		_, err = db.Dbpool.Exec(context.Background(), `
+		INSERT INTO EmailTemplate (Name, Language, Subject, HTMLBody, TextBody)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (Name, Language) DO NOTHING
+		`, tmpl.Name, tmpl.Language, tmpl.Subject, tmpl.HTMLBody, tmpl.TextBody)
+		if err != nil {
+			log.Error("InitiateEmailTemplates: ", err)
+			return err
+		}
+	}
+	return
+}
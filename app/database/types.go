@@ -37,6 +37,11 @@ type Vendor struct {
 	OnlineMap        bool
 	HasSmartphone    bool
 	HasBankAccount   bool
+	Photo            string           // Storage key of the photo on the ID badge
+	LicenseEndDate   null.Time        `swaggertype:"string" format:"date"` // End of the latest license period, null if there is none
+	LicenseValid     bool             // The vendor has a license period today or no license periods at all
+	Locations        []VendorLocation `json:",omitempty"` // Selling locations, only set for a single vendor
+	Documents        []VendorDocument `json:",omitempty"` // Only listed for admins with the vendor documents role
 }
 
 // Account is a struct that is used for the account table
@@ -58,6 +63,7 @@ type Item struct {
 	Description   string
 	Name          string
 	Image         string
+	ImageVariants map[string]string // Resized copies of the image by variant name, e.g. thumbnail
 	IsLicenseItem bool
 	IsPDFItem     bool
 	ItemOrder     int // Order in the webshop
@@ -65,6 +71,14 @@ type Item struct {
 	LicenseItem   null.Int // License has to be bought before item
 	PDF           null.Int
 	Price         int // Price in cents
+	// Download policy of PDF items, a limit of 0 means unlimited
+	PDFValidityDays int // Days a download link is valid, 0 uses the default of the configuration
+	PDFMaxDownloads int // Maximum number of downloads per link
+	PDFMaxIPs       int // Maximum number of different IP addresses per link
+	// Days of access to the license group of digital license items, 0 uses the default of the configuration
+	LicenseValidityDays int
+	// Digital license items with a period are sold as subscriptions, see Subscription
+	SubscriptionPeriod string // SubscriptionMonthly or SubscriptionYearly, empty for one-off purchases
 }
 
 // Order is a struct that is used for the order table
@@ -79,6 +93,12 @@ type Order struct {
 	Vendor            int
 	Entries           []OrderEntry
 	CustomerEmail     null.String
+	Language          string      // Language of the customer, used for emails
+	RecipientEmail    null.String // Gift orders are delivered to the recipient, the buyer gets the receipt
+	RecipientName     string
+	GiftMessage       string
+	DeliveryDate      null.Time // Gifts are delivered on this date, immediately if null
+	DeliveredAt       null.Time
 }
 
 // OrderEntry is a struct that is used for the order_entry table
@@ -91,7 +111,9 @@ type OrderEntry struct {
 	Receiver     int
 	SenderName   string
 	ReceiverName string
-	IsSale       bool // Whether to include this item in sales payment
+	IsSale       bool     // Whether to include this item in sales payment
+	PDF          null.Int // Edition that has been bought, if the item is a PDF item
+	VoucherCode  null.Int // Voucher code of a discount entry, which is subtracted from the total
 }
 
 // Payment is a struct that is used for the payment table
@@ -121,8 +143,10 @@ type Settings struct {
 	Color                      string
 	FontColor                  string
 	Logo                       string
+	LogoVariants               map[string]string // Resized copies of the logo by variant name
 	Favicon                    string
-	MainItem                   null.Int `swaggertype:"integer"`
+	FaviconVariants            map[string]string // Favicons generated from the logo by size, e.g. favicon-32
+	MainItem                   null.Int          `swaggertype:"integer"`
 	MaxOrderAmount             int
 	MainItemName               null.String
 	MainItemPrice              null.Int
@@ -149,10 +173,17 @@ type DBSettings struct {
 	IsInitialized bool
 }
 
+// PDF is an edition of a PDF item
 type PDF struct {
-	ID        int
-	Path      string
-	Timestamp time.Time
+	ID              int
+	Path            string
+	Timestamp       time.Time
+	Item            null.Int
+	IssueNumber     string
+	PublicationDate null.Time
+	CoverImage      string
+	AvailableFrom   null.Time // Edition can be bought from this time on, null means immediately
+	AvailableUntil  null.Time // Edition can be bought until this time, null means forever
 }
 
 type PDFDownload struct {
@@ -165,4 +196,297 @@ type PDFDownload struct {
 	LastDownload  time.Time
 	DownloadCount int
 	ItemID        null.Int
+	StampedPath   string    `json:"-"` // Watermarked copy of the PDF for this download
+	ValidUntil    null.Time // Link does not expire if null
+	MaxDownloads  int       // 0 means no limit
+	MaxIPs        int       // Maximum number of different IP addresses, 0 means no limit
+	Revoked       bool
+}
+
+// VendorLocation is a place where a vendor sells, a vendor can have several of them
+type VendorLocation struct {
+	ID        int
+	Vendor    int
+	Name      string // e.g. the name of the supermarket or station
+	Address   string
+	PLZ       string
+	Longitude float64
+	Latitude  float64
+	Comment   string
+	TimeSlots []VendorTimeSlot
+}
+
+// VendorTimeSlot is a weekly recurring time in which a vendor sells at a location
+type VendorTimeSlot struct {
+	Weekday   int    // ISO weekday, 1 is Monday and 7 is Sunday
+	StartTime string // 15:04 in the time zone TIME_ZONE
+	EndTime   string // 15:04, 24:00 at the latest
+}
+
+// VendorLicense is a validity period of the license of a vendor
+type VendorLicense struct {
+	ID             int
+	Vendor         int
+	StartDate      time.Time `swaggertype:"string" format:"date"`
+	EndDate        time.Time `swaggertype:"string" format:"date"` // Last day on which the license is valid
+	Comment        string
+	CreatedBy      string
+	ExpiryNotified bool // The office has been notified about the upcoming expiry
+	Timestamp      time.Time
+}
+
+// ExpiringVendorLicense is a license period that ends soon and has not been renewed
+type ExpiringVendorLicense struct {
+	VendorLicense
+	LicenseID null.String // License ID of the vendor
+	FirstName string
+	LastName  string
+}
+
+// Types of vendor documents
+const (
+	VendorDocumentPhoto        = "photo"
+	VendorDocumentID           = "id"
+	VendorDocumentRegistration = "registration"
+	VendorDocumentAgreement    = "agreement"
+)
+
+// VendorDocumentTypes are the allowed types of vendor documents
+var VendorDocumentTypes = []string{VendorDocumentPhoto, VendorDocumentID, VendorDocumentRegistration, VendorDocumentAgreement}
+
+// VendorDocument is a file about a vendor that is only accessible for permitted admins
+type VendorDocument struct {
+	ID          int
+	Vendor      int
+	Type        string
+	Filename    string // Name of the uploaded file
+	Path        string `json:"-"` // Storage key of the file
+	ContentType string
+	Size        int64
+	ExpiresAt   null.Time `swaggertype:"string" format:"date"` // e.g. expiry date of an ID card
+	DeleteAt    null.Time `swaggertype:"string" format:"date"` // Document is removed by the retention job on this day, null means it is kept
+	UploadedBy  string
+	Timestamp   time.Time
+}
+
+// PDFRetentionReport summarizes what has been removed by a run of the PDF retention job
+type PDFRetentionReport struct {
+	Timestamp    time.Time
+	PDFDownloads int      // Removed download links
+	PDFs         int      // Removed PDFs, sold editions only lose their file
+	Files        []string // Removed files, including orphaned files
+	FreedBytes   int64
+}
+
+// QueuedEmail is a struct that is used for the email queue (outbox) table
+type QueuedEmail struct {
+	ID          int
+	Recipient   string
+	Template    string                 // Name of the email template
+	Language    string                 // Preferred language of the recipient
+	Data        map[string]interface{} // Template data, stored as jsonb
+	Attempts    int
+	LastError   null.String
+	NextAttempt time.Time
+	SentAt      null.Time `swaggertype:"string" format:"date-time"`
+	Timestamp   time.Time
+}
+
+// EmailTemplate is a struct that is used for the email template table.
+// Templates are identified by name and language.
+type EmailTemplate struct {
+	ID        int
+	Name      string
+	Language  string
+	Subject   string
+	HTMLBody  string `db:"htmlbody"`
+	TextBody  string
+	Timestamp time.Time
+}
+
+// PDFDownloadAccess is a download of a link from an IP address
+type PDFDownloadAccess struct {
+	ID          int
+	PDFDownload int
+	IP          string
+	Timestamp   null.Time `swaggertype:"string" format:"date-time"`
+}
+
+// AuditEntry is an action that has been recorded with the name of the user who did it
+type AuditEntry struct {
+	Type        string // payment, vendor_document or vendor_license
+	ID          int    // ID of the payment, document or license period
+	Timestamp   time.Time
+	Description string
+}
+
+// RetainedOrder is an order of an erased data subject that is kept for bookkeeping
+type RetainedOrder struct {
+	ID            int
+	OrderCode     null.String
+	Timestamp     time.Time
+	RetainedUntil time.Time `swaggertype:"string" format:"date"` // First day on which the order can be erased
+}
+
+// ErasureReport summarizes what has been erased and what has been kept of a data subject
+type ErasureReport struct {
+	Timestamp            time.Time
+	UserDeleted          bool            // The Keycloak user has been deleted
+	VendorAnonymized     bool            // The vendor record has been anonymized
+	OrdersErased         int             // Orders whose email and user have been removed
+	RetainedOrders       []RetainedOrder // Orders within the bookkeeping retention period, they are kept unchanged
+	PDFDownloadsRevoked  int
+	PDFDownloadAccesses  int // Removed IP addresses of downloads
+	EmailsDeleted        int
+	SubscriptionsDeleted int
+	LicenseGrantsDeleted int
+	GiftsErased          int // Received gifts whose recipient has been removed
+	AuditEntries         int // Actions of the subject as an admin, they are kept for bookkeeping
+}
+
+// CustomerOrder is a verified order as shown to the customer, only with the entries the customer paid for
+type CustomerOrder struct {
+	ID        int
+	OrderCode null.String
+	Timestamp time.Time
+	Discount  int // Discount of a voucher in cents
+	Total     int // Sum of the entries minus the discount in cents
+	Entries   []CustomerOrderEntry
+}
+
+// CustomerOrderEntry is an item of a customer order
+type CustomerOrderEntry struct {
+	Item     int
+	Name     string
+	Quantity int
+	Price    int // Price at time of purchase in cents
+}
+
+// CustomerDonation is a donation of a customer within an order
+type CustomerDonation struct {
+	Order     int
+	OrderCode null.String
+	Timestamp time.Time
+	Amount    int // In cents
+}
+
+// CustomerLicense is a digital license of a customer
+type CustomerLicense struct {
+	LicenseGroup string
+	Since        null.Time `swaggertype:"string" format:"date-time"` // First purchase, null if the license has been granted otherwise
+	ValidUntil   null.Time `swaggertype:"string" format:"date-time"` // End of the access by license grants and subscriptions, null if unlimited
+	Active       bool      // The customer is in the license group and can read the online edition
+	URL          string    // Online edition
+}
+
+// CustomerPDFDownload is a download link of a customer
+type CustomerPDFDownload struct {
+	PDFDownload
+	ItemName string
+	URL      string
+	Usable   bool // The link is not revoked, not expired and the download limit is not reached
+}
+
+// CustomerPreference contains the email preferences of a customer
+type CustomerPreference struct {
+	Email              string
+	Language           string // Language of emails, empty means the language of the order
+	OrderConfirmations bool   // Receive order confirmations with receipt
+	Timestamp          time.Time
+}
+
+// Periods of subscription items
+const (
+	SubscriptionMonthly = "month"
+	SubscriptionYearly  = "year"
+)
+
+// Subscription gives a customer access to the license group of a subscription item until ValidUntil.
+// Every verified order of the item extends it by the period of the item.
+type Subscription struct {
+	ID             int
+	Item           int
+	ItemName       string
+	LicenseGroup   string
+	Period         string // SubscriptionMonthly or SubscriptionYearly
+	CustomerEmail  string
+	UserID         string // Keycloak ID of the customer
+	Vendor         int    // Renewals are sold by this vendor by default
+	Language       string
+	LastOrder      null.Int `swaggertype:"integer"`
+	ValidUntil     time.Time
+	RenewalToken   string    `json:"-"` // Secret of the renewal link
+	ReminderSentAt null.Time `swaggertype:"string" format:"date-time"`
+	CancelledAt    null.Time `swaggertype:"string" format:"date-time"` // No more reminders, the subscription ends with ValidUntil
+	ExpiredAt      null.Time `swaggertype:"string" format:"date-time"` // The customer has been removed from the license group
+	Timestamp      time.Time
+}
+
+// LicenseGrant gives the customer of an order access to the license group of a digital license item
+// from StartDate to EndDate
+type LicenseGrant struct {
+	ID            int
+	PaymentOrder  null.Int `swaggertype:"integer"`
+	Item          null.Int `swaggertype:"integer"`
+	LicenseGroup  string
+	CustomerEmail string
+	UserID        string // Keycloak ID of the customer
+	StartDate     time.Time
+	EndDate       null.Time `swaggertype:"string" format:"date-time"` // Null for unlimited access
+	ExpiredAt     null.Time `swaggertype:"string" format:"date-time"` // The customer has been removed from the license group
+	Timestamp     time.Time
+}
+
+// ReceivedGift is a gift order as seen by its recipient, without the data of the buyer
+type ReceivedGift struct {
+	OrderID       int
+	OrderCode     null.String
+	Timestamp     time.Time
+	RecipientName string
+	GiftMessage   string
+	DeliveryDate  null.Time `swaggertype:"string" format:"date-time"`
+	DeliveredAt   null.Time `swaggertype:"string" format:"date-time"`
+}
+
+// Types of vouchers
+const (
+	VoucherFixed    = "fixed"     // Amount in cents off the discounted items
+	VoucherPercent  = "percent"   // Percent off the discounted items
+	VoucherFreeItem = "free_item" // One unit of FreeItem is free
+)
+
+// Voucher is a discount campaign of the webshop with one or more codes. The organization bears the discount.
+type Voucher struct {
+	ID         int
+	Name       string
+	Type       string
+	Amount     int // Discount in cents of fixed vouchers
+	Percent    int
+	FreeItem   null.Int  `swaggertype:"integer"`
+	Items      []int     // Items that are discounted, empty for all items
+	ValidFrom  null.Time `swaggertype:"string" format:"date-time"`
+	ValidUntil null.Time `swaggertype:"string" format:"date-time"`
+	MaxUses    int       // Uses per code, 0 for unlimited
+	Disabled   bool
+	CreatedBy  string
+	Timestamp  time.Time
+	Codes      int // Number of codes
+	Uses       int // Number of verified orders with one of the codes
+}
+
+// VoucherCode is a code that customers enter to redeem a voucher
+type VoucherCode struct {
+	ID        int
+	Voucher   int
+	Code      string
+	Uses      int // Verified orders and orders within the checkout
+	Timestamp time.Time
+}
+
+// VoucherStatistics are the redemptions of a voucher within a period
+type VoucherStatistics struct {
+	ID          int
+	Name        string
+	Redemptions int
+	SumDiscount int // Discount borne by the organization in cents
 }
@@ -0,0 +1,324 @@
+// Package gdpr exports and erases the data that is stored about a person (data subject),
+// who is identified by email or Keycloak ID
+package gdpr
+
+import (
+	"archive/zip"
+	"augustin/config"
+	"augustin/database"
+	"augustin/jobs"
+	"augustin/keycloak"
+	"augustin/storage"
+	"augustin/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/jackc/pgx/v5"
+)
+
+var log = utils.GetLogger()
+
+// ErrNoSubject is returned if neither an email nor a Keycloak ID is given
+var ErrNoSubject = errors.New("email or Keycloak ID required")
+
+// ErrActiveVendor is returned when erasing a vendor that has not been offboarded
+var ErrActiveVendor = errors.New("the person is an active vendor, offboard the vendor first")
+
+// Subject is the person whose data is exported or erased
+type Subject struct {
+	Email      string
+	KeycloakID string
+	Username   string // Name in Keycloak, which is recorded in audit entries
+}
+
+// names returns the names under which actions of the subject are recorded
+func (s Subject) names() (names []string) {
+	for _, name := range []string{s.Email, s.Username, s.KeycloakID} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// User is the Keycloak user of a subject
+type User struct {
+	ID        string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Enabled   bool
+	Created   time.Time
+	Groups    []string // Paths of the groups
+	Roles     []string
+}
+
+// Export contains all data that is stored about a subject
+type Export struct {
+	Timestamp           time.Time
+	Subject             Subject
+	User                *User                        `json:",omitempty"`
+	LicenseGroups       []string                     // Digital licenses of the user
+	Orders              []database.Order             // Orders with their entries
+	PDFDownloads        []database.PDFDownload       // Download links of the orders
+	PDFDownloadAccesses []database.PDFDownloadAccess // Downloads of the links with IP address
+	Emails              []database.QueuedEmail       // Emails sent to the subject
+	EmailPreference     database.CustomerPreference  // Email language and order confirmations chosen by the subject
+	Subscriptions       []database.Subscription      // Subscriptions to the online edition
+	LicenseGrants       []database.LicenseGrant      // Access to the online edition bought with orders
+	ReceivedGifts       []database.ReceivedGift      // Orders of others that have been given to the subject
+	Vendor              *database.Vendor             `json:",omitempty"` // Vendor record with documents and selling locations
+	VendorLicenses      []database.VendorLicense
+	AuditEntries        []database.AuditEntry // Actions of the subject as an admin
+}
+
+// FindSubject completes the email or Keycloak ID of a subject from its Keycloak user, if there is one
+func FindSubject(email string, keycloakID string) (subject Subject, user *gocloak.User, err error) {
+	subject = Subject{Email: strings.TrimSpace(email), KeycloakID: strings.TrimSpace(keycloakID)}
+	switch {
+	case subject.KeycloakID != "":
+		user, err = keycloak.KeycloakClient.GetUserByID(subject.KeycloakID)
+	case subject.Email != "":
+		user, err = keycloak.KeycloakClient.GetUserByEmail(subject.Email)
+	default:
+		return subject, nil, ErrNoSubject
+	}
+	if keycloak.IsNotFound(err) {
+		return subject, nil, nil
+	}
+	if err != nil {
+		return
+	}
+	subject.KeycloakID = gocloak.PString(user.ID)
+	subject.Username = gocloak.PString(user.Username)
+	if subject.Email == "" {
+		subject.Email = gocloak.PString(user.Email)
+	}
+	return
+}
+
+// exportUser returns the Keycloak user with its groups and roles
+func exportUser(user *gocloak.User) (*User, error) {
+	export := &User{
+		ID:        gocloak.PString(user.ID),
+		Username:  gocloak.PString(user.Username),
+		Email:     gocloak.PString(user.Email),
+		FirstName: gocloak.PString(user.FirstName),
+		LastName:  gocloak.PString(user.LastName),
+		Enabled:   gocloak.PBool(user.Enabled),
+	}
+	if user.CreatedTimestamp != nil {
+		export.Created = time.UnixMilli(*user.CreatedTimestamp)
+	}
+	groups, err := keycloak.KeycloakClient.GetUserGroups(export.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		export.Groups = append(export.Groups, gocloak.PString(group.Path))
+	}
+	roles, err := keycloak.KeycloakClient.GetUserRoles(export.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		export.Roles = append(export.Roles, gocloak.PString(role.Name))
+	}
+	return export, nil
+}
+
+// Collect compiles all data that is stored about a subject
+func Collect(email string, keycloakID string) (export Export, err error) {
+	subject, user, err := FindSubject(email, keycloakID)
+	if err != nil {
+		return
+	}
+	export = Export{Timestamp: time.Now(), Subject: subject}
+	if user != nil {
+		export.User, err = exportUser(user)
+		if err != nil {
+			return
+		}
+		for _, group := range export.User.Groups {
+			if licenseGroup, ok := strings.CutPrefix(group, keycloak.DigitalLicenseGroupPath("")); ok {
+				export.LicenseGroups = append(export.LicenseGroups, licenseGroup)
+			}
+		}
+	}
+
+	export.Orders, err = database.Db.ListOrdersOfSubject(subject.Email, subject.KeycloakID)
+	if err != nil {
+		return
+	}
+	orderIDs := make([]int, len(export.Orders))
+	for i, order := range export.Orders {
+		orderIDs[i] = order.ID
+	}
+	export.PDFDownloads, err = database.Db.ListPDFDownloadsOfOrders(orderIDs)
+	if err != nil {
+		return
+	}
+	pdfDownloadIDs := make([]int, len(export.PDFDownloads))
+	for i, pdfDownload := range export.PDFDownloads {
+		pdfDownloadIDs[i] = pdfDownload.ID
+	}
+	export.PDFDownloadAccesses, err = database.Db.ListPDFDownloadAccesses(pdfDownloadIDs)
+	if err != nil {
+		return
+	}
+	if subject.Email != "" {
+		export.Emails, err = database.Db.ListQueuedEmailsTo(subject.Email)
+		if err != nil {
+			return
+		}
+		export.EmailPreference, err = database.Db.GetCustomerPreference(subject.Email)
+		if err != nil {
+			return
+		}
+		export.ReceivedGifts, err = database.Db.ListGiftsOfRecipient(subject.Email)
+		if err != nil {
+			return
+		}
+	}
+
+	export.Subscriptions, err = database.Db.ListCustomerSubscriptions(subject.Email, subject.KeycloakID)
+	if err != nil {
+		return
+	}
+	export.LicenseGrants, err = database.Db.ListCustomerLicenseGrants(subject.Email, subject.KeycloakID)
+	if err != nil {
+		return
+	}
+
+	vendor, err := database.Db.GetVendorOfSubject(subject.Email, subject.KeycloakID)
+	if err == nil {
+		vendor.Documents, err = database.Db.ListVendorDocuments(vendor.ID)
+		if err != nil {
+			return
+		}
+		vendor.Locations, err = database.Db.ListVendorLocations(vendor.ID)
+		if err != nil {
+			return
+		}
+		export.VendorLicenses, err = database.Db.ListVendorLicenses(vendor.ID)
+		if err != nil {
+			return
+		}
+		export.Vendor = &vendor
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+
+	export.AuditEntries, err = database.Db.ListAuditEntries(subject.names())
+	return
+}
+
+// WriteZip writes the export as data.json together with the files of the vendor documents
+func WriteZip(w io.Writer, export Export) (err error) {
+	archive := zip.NewWriter(w)
+	defer func() {
+		closeErr := archive.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	file, err := archive.Create("data.json")
+	if err != nil {
+		return
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(export)
+	if err != nil || export.Vendor == nil {
+		return
+	}
+	for _, document := range export.Vendor.Documents {
+		err = writeZipFile(archive, "documents/"+strconv.Itoa(document.ID)+"-"+path.Base(document.Filename), document.Path)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// writeZipFile copies a file of the storage into the archive
+func writeZipFile(archive *zip.Writer, name string, key string) error {
+	in, _, err := storage.GetStorage().Open(context.Background(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Warn("GDPR export: file ", key, " of ", name, " does not exist")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// Erase deletes the Keycloak user of a subject, anonymizes its vendor record and removes its personal data from
+// orders, download links and emails. Orders within the bookkeeping retention period (BOOKKEEPING_RETENTION_YEARS)
+// and audit entries are kept. Active vendors have to be offboarded first.
+func Erase(email string, keycloakID string) (report database.ErasureReport, err error) {
+	subject, _, err := FindSubject(email, keycloakID)
+	if err != nil {
+		return
+	}
+	vendor, err := database.Db.GetVendorOfSubject(subject.Email, subject.KeycloakID)
+	hasVendor := err == nil
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return
+	}
+	if hasVendor && !vendor.IsDeleted {
+		return report, ErrActiveVendor
+	}
+
+	var userDeleted bool
+	if subject.KeycloakID != "" {
+		err = keycloak.KeycloakClient.DeleteUserByID(subject.KeycloakID)
+		if err != nil && !keycloak.IsNotFound(err) {
+			return
+		}
+		userDeleted = err == nil
+	}
+
+	report, stampedPaths, err := database.Db.EraseSubject(subject.Email, subject.KeycloakID, config.Config.BookkeepingRetentionYears)
+	if err != nil {
+		return
+	}
+	report.Timestamp = time.Now()
+	report.UserDeleted = userDeleted
+	for _, key := range stampedPaths {
+		err = storage.GetStorage().Delete(context.Background(), key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Error("GDPR erasure: failed to remove ", key, ": ", err)
+		}
+	}
+
+	if hasVendor {
+		err = jobs.AnonymizeVendor(vendor)
+		if err != nil {
+			return
+		}
+		report.VendorAnonymized = true
+	}
+
+	auditEntries, err := database.Db.ListAuditEntries(subject.names())
+	if err != nil {
+		return
+	}
+	report.AuditEntries = len(auditEntries)
+	return report, nil
+}
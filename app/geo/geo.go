@@ -0,0 +1,202 @@
+package geo
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FeatureCollection is a GeoJSON feature collection (RFC 7946)
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// Feature is a GeoJSON feature with a point geometry
+type Feature struct {
+	Type       string         `json:"type"`
+	Geometry   Point          `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+// Point is a GeoJSON point geometry
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // Longitude and latitude
+}
+
+// NewFeatureCollection wraps features into a feature collection, which is never null in JSON
+func NewFeatureCollection(features []Feature) FeatureCollection {
+	if features == nil {
+		features = []Feature{}
+	}
+	return FeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// NewFeature creates a point feature
+func NewFeature(longitude float64, latitude float64, properties map[string]any) Feature {
+	if properties == nil {
+		properties = map[string]any{}
+	}
+	return Feature{
+		Type:       "Feature",
+		Geometry:   Point{Type: "Point", Coordinates: [2]float64{longitude, latitude}},
+		Properties: properties,
+	}
+}
+
+// Longitude of the feature
+func (f Feature) Longitude() float64 {
+	return f.Geometry.Coordinates[0]
+}
+
+// Latitude of the feature
+func (f Feature) Latitude() float64 {
+	return f.Geometry.Coordinates[1]
+}
+
+// parseFloats reads a comma separated list of n numbers
+func parseFloats(value string, n int) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != n {
+		return nil, errors.New("expected " + strconv.Itoa(n) + " comma separated numbers")
+	}
+	numbers := make([]float64, n)
+	for i, part := range parts {
+		number, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+			return nil, errors.New("invalid number " + part)
+		}
+		numbers[i] = number
+	}
+	return numbers, nil
+}
+
+// validCoordinates checks the range of a longitude and latitude
+func validCoordinates(longitude float64, latitude float64) bool {
+	return longitude >= -180 && longitude <= 180 && latitude >= -90 && latitude <= 90
+}
+
+// ParseBoundingBox reads a bounding box like "16.2,48.1,16.5,48.3"
+// (min longitude, min latitude, max longitude, max latitude, as in GeoJSON)
+func ParseBoundingBox(value string) ([]float64, error) {
+	box, err := parseFloats(value, 4)
+	if err != nil {
+		return nil, err
+	}
+	if !validCoordinates(box[0], box[1]) || !validCoordinates(box[2], box[3]) || box[0] > box[2] || box[1] > box[3] {
+		return nil, errors.New("invalid bounding box, use min longitude, min latitude, max longitude, max latitude")
+	}
+	return box, nil
+}
+
+// ParsePoint reads a point like "16.37,48.21" (longitude, latitude)
+func ParsePoint(value string) (longitude float64, latitude float64, err error) {
+	point, err := parseFloats(value, 2)
+	if err != nil {
+		return
+	}
+	if !validCoordinates(point[0], point[1]) {
+		return 0, 0, errors.New("invalid point, use longitude, latitude")
+	}
+	return point[0], point[1], nil
+}
+
+// earthRadius is the mean radius of the earth in meters
+const earthRadius = 6371000
+
+// Distance returns the great-circle distance between two points in meters
+func Distance(longitude1 float64, latitude1 float64, longitude2 float64, latitude2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (latitude2 - latitude1) * toRad
+	dLon := (longitude2 - longitude1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(latitude1*toRad)*math.Cos(latitude2*toRad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// Nearest returns the n features closest to the point, sorted by distance.
+// The distance in meters is added to the properties of the features.
+func Nearest(features []Feature, longitude float64, latitude float64, n int) []Feature {
+	nearest := make([]Feature, len(features))
+	distances := make([]float64, len(features))
+	indexes := make([]int, len(features))
+	for i, feature := range features {
+		distances[i] = Distance(longitude, latitude, feature.Longitude(), feature.Latitude())
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return distances[indexes[i]] < distances[indexes[j]]
+	})
+	for i, index := range indexes {
+		nearest[i] = features[index]
+		nearest[i].Properties["distance"] = math.Round(distances[index])
+	}
+	if n >= 0 && n < len(nearest) {
+		nearest = nearest[:n]
+	}
+	return nearest
+}
+
+// MaxZoom is the highest zoom level of web maps, there is no clustering from this level on
+const MaxZoom = 20
+
+// clusterCellsPerTile is the number of grid cells per map tile side,
+// with tiles of 256 pixels points closer than about 64 pixels are clustered
+const clusterCellsPerTile = 4
+
+// gridCell returns the cell of a point in the clustering grid of a zoom level (Web Mercator projection)
+func gridCell(longitude float64, latitude float64, zoom int) [2]int {
+	cells := float64(uint(1)<<zoom) * clusterCellsPerTile
+	// Web Mercator is not defined at the poles
+	latitude = math.Max(-85.05112878, math.Min(85.05112878, latitude))
+	x := (longitude + 180) / 360
+	sinLat := math.Sin(latitude * math.Pi / 180)
+	y := 0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)
+	return [2]int{int(math.Floor(x * cells)), int(math.Floor(y * cells))}
+}
+
+// Cluster combines features that are close to each other at the given zoom level into cluster features.
+// Clusters are placed at the center of their features and have the properties cluster (true) and count.
+// Single features are kept unchanged. The result is in the order of the first feature of each cluster.
+func Cluster(features []Feature, zoom int) []Feature {
+	if zoom >= MaxZoom {
+		return features
+	}
+	zoom = max(0, zoom)
+	type cluster struct {
+		features  []Feature
+		longitude float64
+		latitude  float64
+	}
+	clusters := map[[2]int]*cluster{}
+	var order [][2]int
+	for _, feature := range features {
+		cell := gridCell(feature.Longitude(), feature.Latitude(), zoom)
+		c, ok := clusters[cell]
+		if !ok {
+			c = &cluster{}
+			clusters[cell] = c
+			order = append(order, cell)
+		}
+		c.features = append(c.features, feature)
+		c.longitude += feature.Longitude()
+		c.latitude += feature.Latitude()
+	}
+	result := make([]Feature, 0, len(order))
+	for _, cell := range order {
+		c := clusters[cell]
+		if len(c.features) == 1 {
+			result = append(result, c.features[0])
+			continue
+		}
+		count := float64(len(c.features))
+		result = append(result, NewFeature(c.longitude/count, c.latitude/count, map[string]any{
+			"cluster": true,
+			"count":   len(c.features),
+		}))
+	}
+	return result
+}
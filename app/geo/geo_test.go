@@ -0,0 +1,78 @@
+package geo
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	box, err := ParseBoundingBox("16.2, 48.1,16.5,48.3")
+	require.NoError(t, err)
+	require.Equal(t, []float64{16.2, 48.1, 16.5, 48.3}, box)
+	for _, invalid := range []string{"", "16.2,48.1,16.5", "16.5,48.1,16.2,48.3", "16.2,48.1,16.5,NaN", "a,b,c,d", "0,0,200,10"} {
+		_, err = ParseBoundingBox(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	longitude, latitude, err := ParsePoint("16.37,48.21")
+	require.NoError(t, err)
+	require.Equal(t, 16.37, longitude)
+	require.Equal(t, 48.21, latitude)
+	_, _, err = ParsePoint("48.21,100")
+	require.Error(t, err)
+}
+
+func TestDistance(t *testing.T) {
+	// Stephansplatz to Westbahnhof in Vienna
+	require.InDelta(t, 2910, Distance(16.3725, 48.2085, 16.3375, 48.1966), 10)
+	require.Equal(t, 0.0, Distance(16.37, 48.21, 16.37, 48.21))
+}
+
+func TestNearest(t *testing.T) {
+	features := []Feature{
+		NewFeature(16.50, 48.20, map[string]any{"name": "far"}),
+		NewFeature(16.37, 48.21, map[string]any{"name": "here"}),
+		NewFeature(16.40, 48.21, map[string]any{"name": "near"}),
+	}
+	nearest := Nearest(features, 16.37, 48.21, 2)
+	require.Len(t, nearest, 2)
+	require.Equal(t, "here", nearest[0].Properties["name"])
+	require.Equal(t, 0.0, nearest[0].Properties["distance"])
+	require.Equal(t, "near", nearest[1].Properties["name"])
+	require.Len(t, Nearest(features, 16.37, 48.21, 10), 3)
+}
+
+func TestCluster(t *testing.T) {
+	features := []Feature{
+		NewFeature(16.3700, 48.2100, map[string]any{"name": "a"}),
+		NewFeature(16.3702, 48.2101, map[string]any{"name": "b"}),
+		NewFeature(14.2858, 48.3069, map[string]any{"name": "linz"}),
+	}
+
+	// Close points are combined at low zoom levels
+	clustered := Cluster(features, 10)
+	require.Len(t, clustered, 2)
+	require.Equal(t, true, clustered[0].Properties["cluster"])
+	require.Equal(t, 2, clustered[0].Properties["count"])
+	require.InDelta(t, 16.3701, clustered[0].Longitude(), 0.00001)
+	require.Equal(t, "linz", clustered[1].Properties["name"])
+
+	// Everything is one cluster on the world map
+	require.Len(t, Cluster(features, 0), 1)
+
+	// And nothing is clustered when zoomed in
+	require.Len(t, Cluster(features, 19), 3)
+	require.Equal(t, features, Cluster(features, MaxZoom))
+}
+
+func TestFeatureCollectionJSON(t *testing.T) {
+	data, err := json.Marshal(NewFeatureCollection(nil))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, string(data))
+
+	data, err = json.Marshal(NewFeature(16.37, 48.21, nil))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"type": "Feature", "geometry": {"type": "Point", "coordinates": [16.37, 48.21]}, "properties": {}}`, string(data))
+}
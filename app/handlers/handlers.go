@@ -2,26 +2,41 @@ package handlers
 
 import (
 	"augustin/config"
+	"augustin/geo"
+	"augustin/jobs"
 	"augustin/keycloak"
+	"augustin/mailer"
+	"augustin/middlewares"
+	"augustin/pdfgen"
+	"augustin/storage"
 	"augustin/utils"
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"net/http"
-	"os"
+	"path"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/Nerzal/gocloak/v13"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"gopkg.in/guregu/null.v4"
 
 	"github.com/mitchellh/mapstructure"
 
 	"augustin/database"
+	"augustin/gdpr"
+	"augustin/imaging"
 
 	_ "github.com/swaggo/files"        // swagger embed files
 	_ "github.com/swaggo/http-swagger" // http-swagger middleware
@@ -88,6 +103,37 @@ func HelloWorldAuth(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// fakeLoginRequest is the body of FakeLogin
+type fakeLoginRequest struct {
+	Username string
+	Password string
+}
+
+// FakeLogin godoc
+//
+//	@Summary		Log in with the in-memory identity provider (IDENTITY_PROVIDER=memory only)
+//	@Description	Returns a token for the Authorization header, since there is no Keycloak server to log in
+//	@Tags			Core
+//	@Accept			json
+//	@Produce		json
+//	@Param			data body fakeLoginRequest true "Username or email and password"
+//	@Success		200	{object}	gocloak.JWT
+//	@Router			/auth/token/ [post]
+func FakeLogin(w http.ResponseWriter, r *http.Request) {
+	var credentials fakeLoginRequest
+	err := utils.ReadJSON(w, r, &credentials)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	token, err := keycloak.KeycloakClient.GetUserToken(credentials.Username, credentials.Password)
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("invalid username or password"), http.StatusUnauthorized)
+		return
+	}
+	respond(w, nil, token)
+}
+
 // Users ----------------------------------------------------------------------
 
 type checkLicenseIDResponse struct {
@@ -200,6 +246,7 @@ func CreateVendor(w http.ResponseWriter, r *http.Request) {
 // GetVendor godoc
 //
 //	 	@Summary 		Get Vendor
+//		@Description	Documents of the vendor are only listed for admins with the vendordocuments role
 //		@Tags			Vendors
 //		@Accept			json
 //		@Produce		json
@@ -218,6 +265,10 @@ func GetVendor(w http.ResponseWriter, r *http.Request) {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	vendor.Locations, err = database.Db.ListVendorLocations(vendorID)
+	if err == nil && middlewares.HasVendorDocumentsRole(r) {
+		vendor.Documents, err = database.Db.ListVendorDocuments(vendorID)
+	}
 	respond(w, err, vendor)
 }
 
@@ -388,6 +439,98 @@ func DeleteVendor(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+type offboardVendorResponse struct {
+	PayoutID     null.Int  `swaggertype:"integer"` // Payment of the final payout, null if there was nothing to pay out
+	PayoutAmount int       // Amount of the final payout in cents
+	AnonymizeAt  time.Time `swaggertype:"string" format:"date"` // Day the personal data of the vendor is anonymized
+}
+
+// OffboardVendor godoc
+//
+//	@Summary		Offboard a vendor
+//	@Description	Pays out the open balance of the vendor, deletes the vendor, then disables its Keycloak user and removes it from the vendor group.
+//	@Description	The personal data of the vendor is anonymized VENDOR_ANONYMIZE_AFTER_DAYS later, its account and payments are kept.
+//	@Description	Vendors that owe money can't be offboarded.
+//	@Tags			Vendors
+//	@Produce		json
+//	@Param			id	path		int	true	"Vendor ID"
+//	@Success		200	{object}	offboardVendorResponse
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/offboard/ [post]
+func OffboardVendor(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	authenticatedUserID := r.Header.Get("X-Auth-User-Name")
+	vendor, err := database.Db.GetVendor(vendorID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusNotFound)
+		return
+	}
+
+	// Check the final payout before changing anything
+	vendorAccount, paymentsToBePaidOut, amount, err := offboardingPayout(vendor)
+	if err != nil {
+		log.Error("OffboardVendor: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if amount < 0 {
+		utils.ErrorJSON(w, fmt.Errorf("vendor owes %d cents, settle the balance before offboarding", -amount), http.StatusBadRequest)
+		return
+	}
+	if vendorAccount.Balance < amount {
+		utils.ErrorJSON(w, errors.New("payout amount bigger than vendor account balance"), http.StatusBadRequest)
+		return
+	}
+
+	// Final payout
+	var response offboardVendorResponse
+	if amount > 0 {
+		paymentID, err := database.Db.CreatePaymentPayout(vendor, vendorAccount.ID, authenticatedUserID, amount, paymentsToBePaidOut)
+		if err != nil {
+			log.Error("OffboardVendor: payout ", err)
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		response.PayoutID = null.IntFrom(int64(paymentID))
+		response.PayoutAmount = amount
+	}
+
+	now := time.Now()
+	response.AnonymizeAt = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, config.Config.VendorAnonymizeAfterDays)
+	err = database.Db.OffboardVendor(vendorID, response.AnonymizeAt)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	// The login is disabled last, so that a failed payout doesn't leave a disabled vendor with money on the account.
+	// Legacy vendors may have no user.
+	userID := vendor.KeycloakID
+	if userID == "" && vendor.Email != "" {
+		user, err := keycloak.KeycloakClient.GetUserByEmail(vendor.Email)
+		if err == nil {
+			userID = *user.ID
+		}
+	}
+	if userID != "" {
+		err = keycloak.KeycloakClient.SetUserEnabled(userID, false)
+		if err == nil {
+			err = keycloak.KeycloakClient.UnassignGroup(userID, config.Config.KeycloakVendorGroup)
+		}
+		if err != nil && !keycloak.IsNotFound(err) {
+			log.Error("OffboardVendor: disable user in keycloak for "+fmt.Sprint(vendorID)+" failed: ", err)
+			utils.ErrorJSON(w, fmt.Errorf("vendor was offboarded, but its Keycloak user could not be disabled, check the vendor sync report: %w", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Info(authenticatedUserID, " offboarded vendor ", vendor.LicenseID.String, " with a final payout of ", amount, " cents")
+	respond(w, nil, response)
+}
+
 func UpdateVendorByLicenseID(w http.ResponseWriter, r *http.Request) {
 	licenseID := chi.URLParam(r, "licenseID")
 	if licenseID == "" {
@@ -435,1728 +578,4535 @@ func GetVendorByLicenseID(w http.ResponseWriter, r *http.Request) {
 	respond(w, err, vendor)
 }
 
-// Items (that can be sold) ---------------------------------------------------
+// Vendor QR codes and badges -------------------------------------------------
 
-// ListItems godoc
-//
-//	 	@Summary 		List Items
-//		@Tags			Items
-//		@Accept			json
-//		@Produce		json
-//		@Success		200	{array}	database.Item
-//		@Router			/items/ [get]
-func ListItems(w http.ResponseWriter, r *http.Request) {
-	items, err := database.Db.ListItems(true, true)
-	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
-		return
-	}
-	err = utils.WriteJSON(w, http.StatusOK, items)
-	if err != nil {
-		log.Error("ListItems", err)
+// Sizes of rendered QR codes in pixels
+const (
+	qrCodeDefaultSize = 512
+	qrCodeMinSize     = 64
+	qrCodeMaxSize     = 2048
+	qrCodeBadgeSize   = 600
+	vendorPhotoSize   = 800
+)
+
+// vendorQRCodeStyle returns the QR code style of the settings, with the QR code logo if it is enabled
+func vendorQRCodeStyle(ctx context.Context, settings database.Settings) imaging.QRCodeStyle {
+	style := imaging.ParseQRCodeStyle(settings.QRCodeSettings)
+	if settings.QRCodeEnableLogo && settings.QRCodeLogoImgUrl != "" {
+		data, err := storage.ReadAll(ctx, settings.QRCodeLogoImgUrl)
+		if err == nil {
+			style.Logo, _, err = imaging.Decode(data, 0)
+		}
+		if err != nil {
+			log.Warn("vendorQRCodeStyle: QR code is rendered without logo: ", err)
+		}
 	}
+	return style
 }
 
-// ListItemsBackoffice godoc
-//
-//	 	@Summary 		List Items for backoffice overview
-//		@Tags			Items
-//		@Accept			json
-//		@Produce		json
-//	    @Param			skipHiddenItems query bool false "No donation and transaction cost items"
-//		@Param 			skipLicenses query bool false "No license items"
-//		@Success		200	{array}	database.Item
-//		@Security		KeycloakAuth
-//		@Router			/items/backoffice [get]
-func ListItemsBackoffice(w http.ResponseWriter, r *http.Request) {
-
-	// Get filter parameters
-	skipHiddenItemsRaw := r.URL.Query().Get("skipHiddenItems")
-	skipLicensesRaw := r.URL.Query().Get("skipLicenses")
+// filenamePart removes all characters from s that are not safe in a file name of a Content-Disposition header
+func filenamePart(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, s)
+}
 
-	// Parse filter parameters
-	skipHiddenItems, err := parseBool(skipHiddenItemsRaw)
+// writeVendorQRCode renders the QR code of a vendor as PNG or SVG (query parameters format and size)
+func writeVendorQRCode(w http.ResponseWriter, r *http.Request, vendor database.Vendor) {
+	size := qrCodeDefaultSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size < qrCodeMinSize || size > qrCodeMaxSize {
+			utils.ErrorJSON(w, fmt.Errorf("size must be between %d and %d", qrCodeMinSize, qrCodeMaxSize), http.StatusBadRequest)
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		utils.ErrorJSON(w, errors.New("format must be png or svg"), http.StatusBadRequest)
+		return
+	}
+	settings, err := database.Db.GetSettings()
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	skipLicenses, err := parseBool(skipLicensesRaw)
+	code, err := imaging.NewQRCode(settings.VendorQRCodeURL(vendor), vendorQRCodeStyle(r.Context(), settings))
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Error("writeVendorQRCode: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-
-	items, err := database.Db.ListItems(skipHiddenItems, skipLicenses)
+	var data []byte
+	if format == "svg" {
+		data, err = code.SVG(size)
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		data, err = code.PNG(size)
+		w.Header().Set("Content-Type", "image/png")
+	}
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Error("writeVendorQRCode: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, items)
+	w.Header().Set("Content-Disposition", `inline; filename="qrcode-`+filenamePart(vendor.LicenseID.String)+"."+format+`"`)
+	_, err = w.Write(data)
 	if err != nil {
-		log.Error("ListItemsBackoffice: ", err)
+		log.Error("writeVendorQRCode: ", err)
 	}
 }
 
-// CreateItem godoc
+// GetVendorQRCode godoc
 //
-//	 	@Summary 		Create Item
-//		@Tags			Items
-//		@Accept			json
-//		@Produce		json
-//	    @Param		    data body database.Item true "Item Representation"
-//		@Success		200	 {integer}	id
-//		@Security		KeycloakAuth
-//		@Router			/items/ [post]
-func CreateItem(w http.ResponseWriter, r *http.Request) {
-	// Read multipart form
-	err := r.ParseMultipartForm(32 << 20)
+//	@Summary		Get QR code of vendor
+//	@Description	Renders the QR code of a vendor with the QR code settings and logo. It contains the UrlID of the vendor or QRCodeUrl followed by the license ID.
+//	@Tags			Vendors
+//	@Produce		png
+//	@Produce		image/svg+xml
+//	@Param			id path int true "Vendor ID"
+//	@Param			format query string false "png (default) or svg"
+//	@Param			size query int false "Width and height in pixels (default 512)"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/qrcode/ [get]
+func GetVendorQRCode(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("CreateItem: failed to parse Multipartform ", err)
-		return
-	}
-
-	mForm := r.MultipartForm
-	if mForm == nil {
-		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Handle normal fields
-	item, err := updateItemNormal(mForm.Value)
+	vendor, err := database.Db.GetVendor(vendorID)
 	if err != nil {
-		log.Error("CreateItem: updateItemNormal failed ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	writeVendorQRCode(w, r, vendor)
+}
 
-	// Handle image field
-	path, _ := updateItemImage(w, r)
-	if path != "" {
-		item.Image = path
+// GetVendorOwnQRCode godoc
+//
+//	@Summary		Get own QR code
+//	@Description	Renders the QR code of the logged in vendor, see GetVendorQRCode
+//	@Tags			Vendors
+//	@Produce		png
+//	@Produce		image/svg+xml
+//	@Param			format query string false "png (default) or svg"
+//	@Param			size query int false "Width and height in pixels (default 512)"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/me/qrcode/ [get]
+func GetVendorOwnQRCode(w http.ResponseWriter, r *http.Request) {
+	vendorEmail := r.Header.Get("X-Auth-User-Email")
+	if vendorEmail == "" {
+		utils.ErrorJSON(w, errors.New("user has no email defined"), http.StatusBadRequest)
+		return
 	}
-
-	// Handle pdf field
-	pdfId, err := handleItemPDF(w, r)
+	vendor, err := database.Db.GetVendorByEmail(vendorEmail)
 	if err != nil {
-		log.Error("CreateItem: handleItemPDF failed ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if pdfId != 0 {
-		item.PDF = null.IntFrom(pdfId)
+	writeVendorQRCode(w, r, vendor)
+}
+
+// saveVendorPhoto stores the photo of a vendor as JPEG, which can be embedded in PDFs,
+// outside of the public img folder and returns its key
+func saveVendorPhoto(ctx context.Context, img image.Image) (key string, err error) {
+	photo, err := imaging.Encode(imaging.Resize(img, vendorPhotoSize, false), imaging.FormatJPEG)
+	if err != nil {
+		return
 	}
+	return storage.SaveContent(ctx, "vendor", ".jpg", photo, "image/jpeg")
+}
 
-	// Save item to database
-	id, err := database.Db.CreateItem(item)
+// UpdateVendorPhoto godoc
+//
+//	@Summary		Upload photo of vendor
+//	@Description	Requires multipart form with the field Photo. The photo is printed on the ID badge and is not publicly accessible.
+//	@Tags			Vendors
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/photo/ [post]
+func UpdateVendorPhoto(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("CreateItem: Database call create item failed", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, id)
+	_, err = database.Db.GetVendor(vendorID)
 	if err != nil {
-		log.Error("CreateItem: WriteJSON failed", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-}
-
-func updateItemImage(w http.ResponseWriter, r *http.Request) (path string, err error) {
-	// Get file from image field
-	file, header, err := r.FormFile("Image")
+	maxSize := int64(config.Config.ImageMaxUploadMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	file, _, err := r.FormFile("Photo")
 	if err != nil {
-		return // No file passed, which is ok
+		utils.ErrorJSON(w, errors.New("missing photo"), http.StatusBadRequest)
+		return
 	}
 	defer file.Close()
-
-	// Debugging
-	name := strings.Split(header.Filename, ".")
-	if len(name) < 2 {
-		log.Error("updateItemImage: image name is wrong")
-		utils.ErrorJSON(w, errors.New("invalid filename"), http.StatusBadRequest)
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	buf := bytes.NewBuffer(nil)
-	if _, err = io.Copy(buf, file); err != nil {
+	img, _, err := imaging.Decode(data, maxSize)
+	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	dir, err := os.Getwd()
+	key, err := saveVendorPhoto(r.Context(), img)
 	if err != nil {
-		log.Error("updateItemImage: ", err)
-	}
-	// Generate unique filename
-	i := 0
-	for {
-		path = "img/" + name[0] + "_" + strconv.Itoa(i) + "." + name[len(name)-1]
-		_, err = os.Stat(dir + "/" + path)
-		if errors.Is(err, os.ErrNotExist) {
-			break
-		}
-		i++
-		if i > 1000 {
-			log.Error("updateItemImage: too many files with same name", err)
-			utils.ErrorJSON(w, errors.New("too many files with same name"), http.StatusBadRequest)
-			return
-		}
+		log.Error("UpdateVendorPhoto: ", err)
+		utils.ErrorJSON(w, errors.New("failed to store photo"), http.StatusInternalServerError)
+		return
 	}
-	// current file path from os
-
-	// Save file with unique name
-	err = os.WriteFile(dir+"/"+path, buf.Bytes(), 0666)
+	err = database.Db.UpdateVendorPhoto(vendorID, key)
 	if err != nil {
-		log.Error("updateItemImage: failed to write file", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
-	return
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func handleItemPDF(w http.ResponseWriter, r *http.Request) (pdfId int64, err error) {
-	pdfId = -1
-	// Check if a digit is sent instead of pdf
-	// contentType := r.Header.Get("Content-Type")
-	// if contentType != "multipart/form-data" {
-	// 	log.Info("handleItemPDF: Content-Type is not multipart/form-data", contentType)
-	// 	// Assuming that if it's not multipart/form-data, it's a digit being sent
-	// 	// Handle the case where a digit is sent
-	// 	pdfId, err = strconv.ParseInt(r.FormValue("pdfId"), 10, 64)
-	// 	if err != nil {
-	// 		log.Error("handleItemPDF: parsing the id failed", err)
-	// 	}
-	// 	return pdfId, nil
-	// }
-
-	// Get file from pdf field
-	file, header, err := r.FormFile("PDF")
+// vendorBadge collects the content of the ID badge of a vendor
+func vendorBadge(ctx context.Context, settings database.Settings, style imaging.QRCodeStyle, vendor database.Vendor) (badge pdfgen.Badge, err error) {
+	code, err := imaging.NewQRCode(settings.VendorQRCodeURL(vendor), style)
 	if err != nil {
-		return pdfId, nil // No file passed, which is ok
-	}
-	defer file.Close()
-
-	// Debugging
-	name := strings.Split(header.Filename, ".")
-	if len(name) < 2 {
-		log.Error("handleItemPDF: pdf name is wrong")
-		utils.ErrorJSON(w, errors.New("invalid filename"), http.StatusBadRequest)
 		return
 	}
-
-	buf := bytes.NewBuffer(nil)
-	if _, err = io.Copy(buf, file); err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+	badge.QRCode, err = code.PNG(qrCodeBadgeSize)
+	if err != nil {
 		return
 	}
-	// create base dir if not exist
+	badge.Name = strings.TrimSpace(vendor.FirstName + " " + vendor.LastName)
+	badge.LicenseID = vendor.LicenseID.String
+	if vendor.Photo != "" {
+		badge.Photo, err = storage.ReadAll(ctx, vendor.Photo)
+		if err != nil {
+			// The badge is printed with a placeholder
+			log.Warn("vendorBadge: failed to read photo of vendor ", vendor.ID, ": ", err)
+			err = nil
+		}
+	}
+	return
+}
 
-	dir, err := os.Getwd()
+// writeBadges renders the badges of vendors as PDF
+func writeBadges(w http.ResponseWriter, r *http.Request, vendors []database.Vendor, filename string) {
+	settings, err := database.Db.GetSettings()
 	if err != nil {
-		log.Error("UploadPDF: Failed to get current directory ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	// Add a human readable timestamp to the filename to make it unique
-	timeStamp := time.Now().Format("2006-01-02_15-04-05")
-	path := "pdf/" + timeStamp + "_" + name[0] + "." + name[len(name)-1]
-	_, err = os.Stat(dir + "/pdf")
-	if errors.Is(err, os.ErrNotExist) {
-		err = os.Mkdir(dir+"/pdf", 0777)
+	language := r.URL.Query().Get("lang")
+	if language == "" {
+		language = config.Config.DefaultLanguage
+	}
+	sheet := pdfgen.BadgeSheet{NewspaperName: settings.NewspaperName, Color: settings.Color, Language: language}
+	style := vendorQRCodeStyle(r.Context(), settings)
+	for _, vendor := range vendors {
+		badge, err := vendorBadge(r.Context(), settings, style, vendor)
 		if err != nil {
-			log.Error("handleItemPDF: failed to create directory", err)
+			log.Error("writeBadges: vendor ", vendor.ID, ": ", err)
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
 			return
 		}
+		sheet.Badges = append(sheet.Badges, badge)
 	}
-	err = os.WriteFile(dir+"/"+path, buf.Bytes(), 0666)
+	pdf, err := pdfgen.GenerateBadges(sheet)
 	if err != nil {
-		log.Error("handleItemPDF: failed to write file", err)
+		log.Error("writeBadges: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
-	pdf := database.PDF{
-		Path:      path,
-		Timestamp: time.Now(),
-	}
-
-	pdfId, err = database.Db.CreatePDF(pdf)
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.pdf"`)
+	_, err = w.Write(pdf)
 	if err != nil {
-		log.Error("handleItemPDF: failed to create db entry", err)
+		log.Error("writeBadges: ", err)
 	}
-	return
 }
 
-// fields := mForm.Value               // Values are stored in []string
-func updateItemNormal(fields map[string][]string) (item database.Item, err error) {
-	fieldsClean := make(map[string]any) // Values are stored in string
-	for key, value := range fields {
-		if key == "Price" {
-			fieldsClean[key], err = strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse Price failed ", err)
-				return
-			}
-		} else if key == "IsLicenseItem" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse IfLicenseItem failed ", err)
-				return
-			}
-		} else if key == "IsPDFItem" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse IsPDFItem failed ", err)
-				return
-			}
-		} else if key == "Archived" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse Archived failed ", err)
-				return
-			}
-		} else if key == "LicenseItem" {
-			licensitem, err := strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse LicenseItem failed ", err)
-				return item, err
-			}
-			fieldsClean[key] = null.IntFrom(int64(licensitem))
+// GetVendorBadge godoc
+//
+//	@Summary		Get ID badge of vendor
+//	@Description	Printable PDF with name, license ID, photo and QR code of the vendor
+//	@Tags			Vendors
+//	@Produce		application/pdf
+//	@Param			id path int true "Vendor ID"
+//	@Param			lang query string false "Language of the labels (de, en)"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/badge/ [get]
+func GetVendorBadge(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	vendor, err := database.Db.GetVendor(vendorID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	writeBadges(w, r, []database.Vendor{vendor}, "badge-"+filenamePart(vendor.LicenseID.String))
+}
 
-		} else if key == "ID" {
-			fieldsClean[key], err = strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse ID failed ", err)
-				return
-			}
-		} else if key == "ItemColor" {
-			fieldsClean[key] = null.StringFrom(value[0])
-		} else if key == "ItemTextColor" {
-			fieldsClean[key] = null.StringFrom(value[0])
-		} else if key == "PDF" {
-			pdf, err := strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("updateItemNormal: Parse PDF failed ", err)
-				return item, err
-			}
-			fieldsClean[key] = null.IntFrom(int64(pdf))
-		} else if key == "LicenseGroup" {
-			fieldsClean[key] = null.StringFrom(value[0])
-		} else if key == "ItemOrder" {
-			fieldsClean[key], err = strconv.Atoi(value[0])
-		} else {
-			fieldsClean[key] = value[0]
-		}
+// ListVendorBadges godoc
+//
+//	@Summary		Get ID badges of all active vendors
+//	@Description	Printable PDF with the badges of all vendors that are not disabled, 10 per A4 page
+//	@Tags			Vendors
+//	@Produce		application/pdf
+//	@Param			lang query string false "Language of the labels (de, en)"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/badges/ [get]
+func ListVendorBadges(w http.ResponseWriter, r *http.Request) {
+	vendors, err := database.Db.ListActiveVendors()
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
 	}
-	err = mapstructure.Decode(fieldsClean, &item)
+	writeBadges(w, r, vendors, "badges")
+}
+
+// Vendor documents -----------------------------------------------------------
+
+// vendorDocumentContentTypes are the file types that can be uploaded as vendor documents
+var vendorDocumentContentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/png":       ".png",
+	"image/jpeg":      ".jpg",
+	"image/webp":      ".webp",
+}
+
+// vendorDocumentIDs returns the vendor and document ID of the request path
+func vendorDocumentIDs(r *http.Request) (vendorID int, documentID int, err error) {
+	vendorID, err = strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("updateItemNormal: Decoding fields failed", err)
 		return
 	}
+	documentID, err = strconv.Atoi(chi.URLParam(r, "documentID"))
 	return
 }
 
-// UpdateItem godoc
-//
-//	 	@Summary 		Update Item
-//		@Description	Requires multipart form (for image)
-//		@Tags			Items
-//		@Accept			json
-//		@Produce		json
-//		@Param			id path int true "Item ID"
-//	    @Param		    data body database.Item true "Item Representation"
-//		@Success		200
-//		@Security		KeycloakAuth
-//		@Router			/items/{id}/ [put]
+// updateVendorDocumentFields sets the type, expiry date and retention of a document from a multipart form
+func updateVendorDocumentFields(document *database.VendorDocument, fields map[string][]string) (err error) {
+	for key, value := range fields {
+		switch key {
+		case "Type":
+			document.Type = value[0]
+		case "ExpiresAt":
+			document.ExpiresAt, err = parseEditionTime(value[0])
+		case "DeleteAt":
+			document.DeleteAt, err = parseEditionTime(value[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !slices.Contains(database.VendorDocumentTypes, document.Type) {
+		return fmt.Errorf("invalid document type %q, use one of %v", document.Type, strings.Join(database.VendorDocumentTypes, ", "))
+	}
+	return nil
+}
+
+// ListVendorDocuments godoc
 //
-// UpdateItem requires a multipart form
-// https://www.sobyte.net/post/2022-03/go-multipart-form-data/
-func UpdateItem(w http.ResponseWriter, r *http.Request) {
-	ItemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+//	@Summary		List documents of vendor
+//	@Description	Requires the admin and vendordocuments roles
+//	@Tags			Vendors
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Success		200	{array}	database.VendorDocument
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/documents/ [get]
+func ListVendorDocuments(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("UpdateItem: Can not read ID ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	documents, err := database.Db.ListVendorDocuments(vendorID)
+	respond(w, err, documents)
+}
 
-	// Security check to disable updating Item of ID 2 and 3, which are essential for donations and transaction costs
-	if ItemID == 2 || ItemID == 3 {
-		utils.ErrorJSON(w, errors.New("Nice try! You are not allowed to update this item"), http.StatusBadRequest)
+// CreateVendorDocument godoc
+//
+//	@Summary		Upload document of vendor
+//	@Description	Requires the admin and vendordocuments roles and a multipart form with the fields File (pdf, png, jpeg or webp), Type (photo, id, registration or agreement), ExpiresAt and DeleteAt (2006-01-02).
+//	@Description	Without DeleteAt, documents with an expiry date are removed VENDOR_DOCUMENT_RETENTION_DAYS after they expired. Photos also become the photo on the ID badge.
+//	@Tags			Vendors
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Success		200 {object} database.VendorDocument
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/documents/ [post]
+func CreateVendorDocument(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Read multipart form
-	log.Info("Request Content Length:", r.ContentLength)
-	err = r.ParseMultipartForm(64 << 20)
-	log.Info("Parsed Form Content Length:", r.ContentLength)
+	_, err = database.Db.GetVendor(vendorID)
 	if err != nil {
-		log.Error("UpdateItem: ParseMultipartForm failed ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	mForm := r.MultipartForm
-	if mForm == nil {
-		log.Error("UpdateItem: ", errors.New("invalid form"))
-		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+	maxSize := int64(config.Config.VendorDocumentMaxUploadMB) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	err = r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	document := database.VendorDocument{Vendor: vendorID, UploadedBy: r.Header.Get("X-Auth-User-Name")}
+	err = updateVendorDocumentFields(&document, r.MultipartForm.Value)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	if days := config.Config.VendorDocumentRetentionDays; !document.DeleteAt.Valid && document.ExpiresAt.Valid && days > 0 {
+		document.DeleteAt = null.TimeFrom(document.ExpiresAt.Time.AddDate(0, 0, days))
+	}
 
-	// Handle normal fields
-	item, err := updateItemNormal(mForm.Value)
+	file, header, err := r.FormFile("File")
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("missing file"), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
 	if err != nil {
-		log.Error("UpdateItem: UpdateItemNormal failed ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	if int64(len(data)) > maxSize {
+		utils.ErrorJSON(w, errors.New("file is too large"), http.StatusBadRequest)
+		return
+	}
+	// The file name is not trusted, the type is detected from the content
+	document.ContentType = http.DetectContentType(data)
+	ext, ok := vendorDocumentContentTypes[document.ContentType]
+	if !ok {
+		utils.ErrorJSON(w, errors.New("unsupported file type, use pdf, png, jpeg or webp"), http.StatusBadRequest)
+		return
+	}
+	document.Filename = path.Base(header.Filename)
 
-	path, _ := updateItemImage(w, r)
-	if path != "" {
-		log.Info("UpdateItem: Path is empty", path)
-		item.Image = path
+	if document.Type == database.VendorDocumentPhoto {
+		// Photos are stored like badge photos, which removes their metadata
+		img, _, err := imaging.Decode(data, maxSize)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		document.Path, err = saveVendorPhoto(r.Context(), img)
+		if err == nil {
+			err = database.Db.UpdateVendorPhoto(vendorID, document.Path)
+		}
+		document.ContentType = "image/jpeg"
+	} else {
+		document.Path, err = storage.SaveContent(r.Context(), "vendor/documents", ext, data, document.ContentType)
+	}
+	if err != nil {
+		log.Error("CreateVendorDocument: ", err)
+		utils.ErrorJSON(w, errors.New("failed to store document"), http.StatusInternalServerError)
+		return
+	}
+	info, err := storage.GetStorage().Stat(r.Context(), document.Path)
+	if err == nil {
+		document.Size = info.Size
 	}
 
-	pdfId, err := handleItemPDF(w, r)
+	document.ID, err = database.Db.CreateVendorDocument(document)
 	if err != nil {
-		log.Error("CreateItem: handleItemPDF failed ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if pdfId != -1 {
-		item.PDF = null.IntFrom(pdfId)
-	}
+	log.Info(r.Header.Get("X-Auth-User-Name"), " uploaded ", document.Type, " document ", document.ID, " of vendor ", vendorID)
+	document, err = database.Db.GetVendorDocument(vendorID, document.ID)
+	respond(w, err, document)
+}
 
-	// Save item to database
-	err = database.Db.UpdateItem(ItemID, item)
+// DownloadVendorDocument godoc
+//
+//	@Summary		Download document of vendor
+//	@Description	Requires the admin and vendordocuments roles
+//	@Tags			Vendors
+//	@Produce		octet-stream
+//	@Param			id path int true "Vendor ID"
+//	@Param			documentID path int true "Document ID"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/documents/{documentID}/ [get]
+func DownloadVendorDocument(w http.ResponseWriter, r *http.Request) {
+	vendorID, documentID, err := vendorDocumentIDs(r)
 	if err != nil {
-		log.Error("UpdateItem: db update", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, err)
+	document, err := database.Db.GetVendorDocument(vendorID, documentID)
 	if err != nil {
-		log.Error("UpdateItem: ", err)
+		utils.ErrorJSON(w, errors.New("document not found"), http.StatusNotFound)
+		return
+	}
+	// Documents are always sent by the API and never redirected to the storage, so that every access is checked
+	obj, info, err := storage.GetStorage().Open(r.Context(), document.Path)
+	if err != nil {
+		log.Error("DownloadVendorDocument: failed to open ", document.Path, ": ", err)
+		utils.ErrorJSON(w, errors.New("failed to read document"), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	log.Info(r.Header.Get("X-Auth-User-Name"), " downloaded document ", documentID, " of vendor ", vendorID)
+	w.Header().Set("Content-Type", document.ContentType)
+	w.Header().Set("Cache-Control", "private, no-store")
+	filename := filenamePart(strings.TrimSuffix(document.Filename, path.Ext(document.Filename)))
+	if filename == "" {
+		filename = "document-" + strconv.Itoa(document.ID)
 	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+vendorDocumentContentTypes[document.ContentType]+`"`)
+	http.ServeContent(w, r, "", info.ModTime, obj)
 }
 
-// DeleteItem godoc
+// UpdateVendorDocument godoc
 //
-//		 	@Summary 		Delete Item
-//			@Tags			Items
-//			@Accept			json
-//			@Produce		json
-//			@Success		200
-//			@Security		KeycloakAuth
-//	     	@Param          id   path int  true  "Item ID"
-//			@Router			/items/{id}/ [delete]
-func DeleteItem(w http.ResponseWriter, r *http.Request) {
-	ItemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+//	@Summary		Update document of vendor
+//	@Description	Requires the admin and vendordocuments roles and a multipart form with the fields Type, ExpiresAt and DeleteAt. The file can't be replaced.
+//	@Tags			Vendors
+//	@Accept			mpfd
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Param			documentID path int true "Document ID"
+//	@Success		200 {object} database.VendorDocument
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/documents/{documentID}/ [put]
+func UpdateVendorDocument(w http.ResponseWriter, r *http.Request) {
+	vendorID, documentID, err := vendorDocumentIDs(r)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	err = database.Db.DeleteItem(ItemID)
+	document, err := database.Db.GetVendorDocument(vendorID, documentID)
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("document not found"), http.StatusNotFound)
+		return
+	}
+	err = r.ParseMultipartForm(1 << 20)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	previousType := document.Type
+	err = updateVendorDocumentFields(&document, r.MultipartForm.Value)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	if (previousType == database.VendorDocumentPhoto) != (document.Type == database.VendorDocumentPhoto) {
+		utils.ErrorJSON(w, errors.New("photos can't be changed to other document types"), http.StatusBadRequest)
+		return
+	}
+	err = database.Db.UpdateVendorDocument(document)
+	respond(w, err, document)
+}
 
+// DeleteVendorDocument godoc
+//
+//	@Summary		Delete document of vendor
+//	@Description	Requires the admin and vendordocuments roles. The file is removed from the storage.
+//	@Tags			Vendors
+//	@Param			id path int true "Vendor ID"
+//	@Param			documentID path int true "Document ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/documents/{documentID}/ [delete]
+func DeleteVendorDocument(w http.ResponseWriter, r *http.Request) {
+	vendorID, documentID, err := vendorDocumentIDs(r)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	unusedPath, err := database.Db.DeleteVendorDocument(vendorID, documentID)
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("document not found"), http.StatusNotFound)
+		return
+	}
+	if unusedPath != "" {
+		err = storage.GetStorage().Delete(r.Context(), unusedPath)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Error("DeleteVendorDocument: failed to remove ", unusedPath, ": ", err)
+		}
+	}
+	log.Info(r.Header.Get("X-Auth-User-Name"), " deleted document ", documentID, " of vendor ", vendorID)
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// Orders ---------------------------------------------------------------------
-
-type createOrderRequestEntry struct {
-	Item     int
-	Quantity int
-}
+// Vendor licenses ------------------------------------------------------------
 
-type createOrderRequest struct {
-	Entries         []createOrderRequestEntry
-	User            string
-	VendorLicenseID string
-	CustomerEmail   null.String
+type vendorLicenseRequest struct {
+	StartDate string // 2006-01-02, defaults to the day after the latest period or today
+	EndDate   string // 2006-01-02, last day on which the license is valid
+	Comment   string
 }
 
-type createOrderResponse struct {
-	SmartCheckoutURL string
+// parseVendorLicense validates the dates of a license period. The start date defaults to start.
+func parseVendorLicense(request vendorLicenseRequest, license *database.VendorLicense, start time.Time) error {
+	startDate, err := parseEditionTime(request.StartDate)
+	if err != nil {
+		return err
+	}
+	endDate, err := parseEditionTime(request.EndDate)
+	if err != nil {
+		return err
+	}
+	if !endDate.Valid {
+		return errors.New("missing EndDate")
+	}
+	license.StartDate = start
+	if startDate.Valid {
+		license.StartDate = startDate.Time
+	}
+	license.EndDate = endDate.Time
+	license.Comment = request.Comment
+	if license.EndDate.Before(license.StartDate) {
+		return errors.New("EndDate has to be after StartDate")
+	}
+	return nil
 }
 
-// PaymentOrders ---------------------------------------------------------------------
-
-// hasDuplicitValues checks if a map has duplicate values
-// Credit to: https://stackoverflow.com/a/57237165/19932351
-func hasDuplicitValues(m map[int]int) bool {
-	// Create empty map
-	x := make(map[int]struct{})
-
-	// Iterate over map
-	for _, v := range m {
-		// Add value to map by using it as key
-		if _, has := x[v]; has {
-			// Return true if value is already in map
-			return true
-		}
-		// Add empty struct to map
-		x[v] = struct{}{}
+// ListVendorLicenses godoc
+//
+//	@Summary		List license periods of vendor
+//	@Description	The license periods are the renewal history of the vendor, the latest first
+//	@Tags			Vendors
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Success		200	{array}	database.VendorLicense
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/licenses/ [get]
+func ListVendorLicenses(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-
-	return false
+	licenses, err := database.Db.ListVendorLicenses(vendorID)
+	respond(w, err, licenses)
 }
 
-// CreatePaymentOrder godoc
+// CreateVendorLicense godoc
 //
-//	 	@Summary 		Create Payment Order
-//		@Description	Submits payment order to provider & saves it to database. Entries need to have an item id and a quantity (for entries without a price like tips, the quantity is the amount of cents). If no user is given, the order is anonymous.
-//		@Tags			Orders
-//		@Accept			json
-//		@Produce		json
-//	    @Param		    data body createOrderRequest true "Payment Order"
-//		@Success		200 {object} createOrderResponse
-//		@Router			/orders/ [post]
-func CreatePaymentOrder(w http.ResponseWriter, r *http.Request) {
-
-	// Read payment order from request
-	var requestData createOrderRequest
-	var order database.Order
-	err := utils.ReadJSON(w, r, &requestData)
+//	@Summary		Add or renew license period of vendor
+//	@Description	Without StartDate the period starts the day after the latest period, or today if the license has lapsed.
+//	@Description	Vendors with license periods can only sell while one of them is valid, vendors without any period are not restricted.
+//	@Tags			Vendors
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Param			data body vendorLicenseRequest true "License period"
+//	@Success		200 {object} database.VendorLicense
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/licenses/ [post]
+func CreateVendorLicense(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Security checks for entries
-	for _, entry := range requestData.Entries {
-
-		// 1. Check: Quantity has to be > 0 for any item except donation
-		if entry.Quantity <= 0 && entry.Item != 2 {
-			utils.ErrorJSON(w, errors.New("Nice try! Quantity has to be greater than 0"), http.StatusBadRequest)
-			return
-		}
-
-		// 2. Check: All items have to exist
-		item, err := database.Db.GetItem(entry.Item)
-		if err != nil {
-			utils.ErrorJSON(w, errors.New("Nice try! Item does not exist"), http.StatusBadRequest)
-			return
-		}
-
-		// 3. Check: Transaction costs (id == 3) are not allowed to be in entries
-		if entry.Item == 3 {
-			utils.ErrorJSON(w, errors.New("Nice try! You are not allowed to purchase this item"), http.StatusBadRequest)
-			return
-		}
-
-		// 4. Check: If there is a item that needs a customerEmail, the user has to be given
-
-		if item.LicenseItem.Valid {
-			if !requestData.CustomerEmail.Valid || requestData.CustomerEmail.String == "" {
-				utils.ErrorJSON(w, errors.New("you are not allowed to purchase this item without a customer email"), http.StatusBadRequest)
-				return
-			}
-			order.CustomerEmail = requestData.CustomerEmail
-		}
+	vendor, err := database.Db.GetVendor(vendorID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-
-	// 5. Check: If there is more than one entry, each item id has to be unique
-	if len(requestData.Entries) > 1 {
-		// Create map with item ids as keys
-		uniqueItemIDs := make(map[int]int)
-		for idx, entry := range requestData.Entries {
-			uniqueItemIDs[idx] = entry.Item
-		}
-		// Check if there are duplicate item ids
-		if hasDuplicitValues(uniqueItemIDs) {
-			utils.ErrorJSON(w, errors.New("Nice try! You are not supposed to have duplicate item ids in your order request"), http.StatusBadRequest)
-			return
-		}
+	var request vendorLicenseRequest
+	err = utils.ReadJSON(w, r, &request)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-
-	// 6. Check: If item 2 (donation) is ordered without another item
-	if len(requestData.Entries) == 1 && requestData.Entries[0].Item == 2 {
-		// Throw error
-		utils.ErrorJSON(w, errors.New("Nice try! You are not allowed to purchase this item without another item"), http.StatusBadRequest)
+	// Dates of the database are scanned as midnight UTC
+	year, month, day := time.Now().Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	start := today
+	if vendor.LicenseEndDate.Valid && !vendor.LicenseEndDate.Time.Before(today) {
+		start = vendor.LicenseEndDate.Time.AddDate(0, 0, 1)
+	}
+	license := database.VendorLicense{Vendor: vendorID, CreatedBy: r.Header.Get("X-Auth-User-Name")}
+	err = parseVendorLicense(request, &license, start)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Create slice of order entries depending on size of requestData.Entries
-	order.Entries = make([]database.OrderEntry, len(requestData.Entries))
-
-	// Add entries to each ordered item
-	for idx, entry := range requestData.Entries {
-		order.Entries[idx].Item = entry.Item
-		order.Entries[idx].Quantity = entry.Quantity
+	license.ID, err = database.Db.CreateVendorLicense(license)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	license, err = database.Db.GetVendorLicense(vendorID, license.ID)
+	respond(w, err, license)
+}
 
-	// Get vendor id from license id
-	vendor, err := database.Db.GetVendorByLicenseID(requestData.VendorLicenseID)
+// UpdateVendorLicense godoc
+//
+//	@Summary		Correct license period of vendor
+//	@Tags			Vendors
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Param			licenseID path int true "License period ID"
+//	@Param			data body vendorLicenseRequest true "License period"
+//	@Success		200 {object} database.VendorLicense
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/licenses/{licenseID}/ [put]
+func UpdateVendorLicense(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	order.Vendor = vendor.ID
-
-	var settings database.Settings
-	if settings, err = database.Db.GetSettings(); err != nil {
+	licenseID, err := strconv.Atoi(chi.URLParam(r, "licenseID"))
+	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Add user to order
-	// TODO-Question: This line is not necessary anymore, since the user is already in the request?
-	order.User.String = requestData.User
-
-	// Get accounts
-	var buyerAccountID int
-	authenticatedUserID := r.Header.Get("X-Auth-User-Name")
-	if authenticatedUserID != "" {
-		buyerAccount, err := database.Db.GetOrCreateAccountByUserID(authenticatedUserID)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-			return
-		}
-		buyerAccountID = buyerAccount.ID
-	} else {
-		buyerAccountID, err = database.Db.GetAccountTypeID("UserAnon")
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-			return
-		}
+	license, err := database.Db.GetVendorLicense(vendorID, licenseID)
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("license period not found"), http.StatusNotFound)
+		return
 	}
-
-	vendorAccount, err := database.Db.GetAccountByVendorID(order.Vendor)
+	var request vendorLicenseRequest
+	err = utils.ReadJSON(w, r, &request)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	orgaAccount, err := database.Db.GetAccountByType("Orga")
+	err = parseVendorLicense(request, &license, license.StartDate)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	err = database.Db.UpdateVendorLicense(license)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	license, err = database.Db.GetVendorLicense(vendorID, licenseID)
+	respond(w, err, license)
+}
 
-	// Amount of added license items
-	// Since for each license item an additional entry is added,
-	// Therefore, the index of the for loop has to be increased by 1
-	licenseItemAdded := 0
-
-	// Extend order entries
-	for idx, entry := range order.Entries {
-		// Increase index depending on how many license items were added
-		idx = idx + licenseItemAdded
-		// Get item from database
-		item, err := database.Db.GetItem(entry.Item)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
+// ListExpiringVendorLicenses godoc
+//
+//	@Summary		List expiring licenses
+//	@Description	License periods of active vendors that end within the next days and have not been renewed
+//	@Tags			Vendors
+//	@Produce		json
+//	@Param			days query int false "Number of days, defaults to VENDOR_LICENSE_EXPIRY_NOTICE_DAYS"
+//	@Success		200	{array}	database.ExpiringVendorLicense
+//	@Security		KeycloakAuth
+//	@Router			/vendors/licenses/expiring/ [get]
+func ListExpiringVendorLicenses(w http.ResponseWriter, r *http.Request) {
+	days := config.Config.VendorLicenseExpiryNoticeDays
+	if value := r.URL.Query().Get("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 0 {
+			utils.ErrorJSON(w, errors.New("invalid number of days"), http.StatusBadRequest)
 			return
 		}
+	}
+	licenses, err := database.Db.ListExpiringVendorLicenses(time.Now().AddDate(0, 0, days))
+	respond(w, err, licenses)
+}
 
-		// Define flow of money from buyer to vendor
-		order.Entries[idx].Sender = buyerAccountID
-		order.Entries[idx].Receiver = vendorAccount.ID
-		order.Entries[idx].Price = item.Price // Take current item price
-		order.Entries[idx].IsSale = true      // Will be used for sales payment
-
-		// If there is a license item, prepend it before the actual item
-		if item.LicenseItem.Valid {
-			// Get license item from database
-			licenseItem, err := database.Db.GetItem(int(item.LicenseItem.Int64))
-			if err != nil {
-				utils.ErrorJSON(w, err, http.StatusBadRequest)
-				return
-			}
-			// Define flow of money from vendor to orga
-			licenseItemEntry := database.OrderEntry{
-				Item:         int(item.LicenseItem.Int64),
-				Quantity:     entry.Quantity,
-				Price:        licenseItem.Price,
-				Sender:       vendorAccount.ID,
-				Receiver:     orgaAccount.ID,
-				SenderName:   vendorAccount.Name,
-				ReceiverName: orgaAccount.Name,
-			}
-			// Prepend license item without overwriting next entries
-			order.Entries = append([]database.OrderEntry{licenseItemEntry}, order.Entries...)
-			// Add customer email to order
-			order.CustomerEmail = requestData.CustomerEmail
-			// Increase licenseItemAdded by one
-			licenseItemAdded++
+// Vendor Keycloak sync -------------------------------------------------------
 
-		}
+// GetVendorSyncReport godoc
+//
+//	@Summary		Compare vendors with their Keycloak users
+//	@Description	Lists the differences between the vendors and their Keycloak users (existence, email, names, vendor group and enabled state) with the action to repair them
+//	@Tags			Vendors
+//	@Produce		json
+//	@Success		200	{array}	jobs.VendorSyncIssue
+//	@Security		KeycloakAuth
+//	@Router			/vendors/sync/ [get]
+func GetVendorSyncReport(w http.ResponseWriter, r *http.Request) {
+	issues, err := jobs.CheckVendorSync()
+	if err != nil {
+		log.Error("GetVendorSyncReport: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	respond(w, nil, issues)
+}
 
+// RepairVendorSyncIssue godoc
+//
+//	@Summary		Repair a difference between a vendor and its Keycloak user
+//	@Description	Applies the repair action of an issue of the report, which is identified by Type, VendorID and KeycloakID. Returns the remaining issues.
+//	@Tags			Vendors
+//	@Accept			json
+//	@Produce		json
+//	@Param			data body jobs.VendorSyncIssue true "Issue of the report"
+//	@Success		200	{array}	jobs.VendorSyncIssue
+//	@Security		KeycloakAuth
+//	@Router			/vendors/sync/repair/ [post]
+func RepairVendorSyncIssue(w http.ResponseWriter, r *http.Request) {
+	var issue jobs.VendorSyncIssue
+	err := utils.ReadJSON(w, r, &issue)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	// ignore MaxOrderAmount if its 0
-	if settings.MaxOrderAmount != 0 && order.GetTotal() >= settings.MaxOrderAmount {
-		utils.ErrorJSON(w, errors.New("Order amount is too high"), http.StatusBadRequest)
+	log.Info(r.Header.Get("X-Auth-User-Name"), " is repairing vendor sync issue ", issue.Type, " of vendor ", issue.VendorID, " and user ", issue.KeycloakID)
+	err = jobs.RepairVendorSyncIssue(issue)
+	if errors.Is(err, jobs.ErrVendorSyncIssueNotFound) {
+		utils.ErrorJSON(w, err, http.StatusNotFound)
 		return
 	}
-	// Submit order to vivawallet (disabled in tests)
-	var OrderCode int
-	if database.Db.IsProduction {
-		accessToken, err := paymentprovider.AuthenticateToVivaWallet()
+	if err != nil {
+		log.Error("RepairVendorSyncIssue: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	GetVendorSyncReport(w, r)
+}
+
+// Vendor locations -----------------------------------------------------------
+
+// validateVendorLocation checks the coordinates and time slots of a location
+func validateVendorLocation(location database.VendorLocation) error {
+	if location.Longitude < -180 || location.Longitude > 180 || location.Latitude < -90 || location.Latitude > 90 {
+		return errors.New("invalid coordinates")
+	}
+	for _, timeSlot := range location.TimeSlots {
+		if timeSlot.Weekday < 1 || timeSlot.Weekday > 7 {
+			return errors.New("weekday has to be between 1 (Monday) and 7 (Sunday)")
+		}
+		start, err := time.Parse("15:04", timeSlot.StartTime)
 		if err != nil {
-			log.Error("Authentication failed: ", err)
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-			return
+			return errors.New("invalid start time " + timeSlot.StartTime + ", use 15:04")
 		}
-		OrderCode, err = paymentprovider.CreatePaymentOrder(accessToken, order, requestData.VendorLicenseID)
+		// Slots can end at midnight, which is not a valid clock time
+		end, err := time.Parse("15:04", strings.Replace(timeSlot.EndTime, "24:00", "23:59", 1))
 		if err != nil {
-			log.Errorf("Creating payment order failed for %+v with order id %+v failed", requestData.VendorLicenseID, order.ID, err)
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-			return
+			return errors.New("invalid end time " + timeSlot.EndTime + ", use 15:04")
+		}
+		if !end.After(start) {
+			return errors.New("end time has to be after start time")
 		}
 	}
+	return nil
+}
 
-	// Save order to database
-	order.OrderCode.String = strconv.Itoa(OrderCode)
-	order.OrderCode.Valid = true // This means that it is not null
-	_, err = database.Db.CreateOrder(order)
+// readVendorLocation reads and validates a location of the vendor in the request path from the request body
+func readVendorLocation(w http.ResponseWriter, r *http.Request) (location database.VendorLocation, err error) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Check if VivaWalletSmartCheckoutURL is set
-	if config.Config.VivaWalletSmartCheckoutURL == "" {
-		utils.ErrorJSON(w, errors.New("VivaWalletSmartCheckoutURL is not set"), http.StatusBadRequest)
+	err = utils.ReadJSON(w, r, &location)
+	if err != nil {
 		return
 	}
+	location.Vendor = vendorID
+	err = validateVendorLocation(location)
+	return
+}
 
-	// Create response
-	checkoutURL := config.Config.VivaWalletSmartCheckoutURL + strconv.Itoa(OrderCode)
-
-	// Add color code to URL
-	if settings.Color == "" {
-		log.Info("Color code is not set")
-	} else {
-
-		var colorCode string
-		// Check if color code is valid with # at the beginning
-		if settings.Color[0] == '#' {
-			// Remove # from color code due to VivaWallet's policy
-			colorCode = settings.Color[1:]
-		} else {
-			log.Info("Color code is not valid: ", settings.Color)
-		}
-		// Make color code lowercase
-		colorCode = strings.ToLower(colorCode)
-
-		// Add color code and necessary attachment to URL
-		colorCodeAttachment := fmt.Sprintf("%s%s", "&color=", colorCode)
-
-		// Add color code to URL
-		checkoutURL = fmt.Sprintf("%s%s", checkoutURL, colorCodeAttachment)
+// ListVendorLocations godoc
+//
+//	@Summary		List selling locations of vendor
+//	@Tags			Vendors
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Success		200	{array}	database.VendorLocation
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/locations/ [get]
+func ListVendorLocations(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	locations, err := database.Db.ListVendorLocations(vendorID)
+	respond(w, err, locations)
+}
 
-	response := createOrderResponse{
-		SmartCheckoutURL: checkoutURL,
+// CreateVendorLocation godoc
+//
+//	@Summary		Add selling location to vendor
+//	@Description	Time slots are weekly (Weekday 1 is Monday, 7 is Sunday) with StartTime and EndTime like 08:30 in the time zone TIME_ZONE
+//	@Tags			Vendors
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Param			data body database.VendorLocation true "Location"
+//	@Success		200 {integer} int "ID of the location"
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/locations/ [post]
+func CreateVendorLocation(w http.ResponseWriter, r *http.Request) {
+	location, err := readVendorLocation(w, r)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, response)
+	_, err = database.Db.GetVendor(location.Vendor)
 	if err != nil {
-		log.Error("CreatePaymentOrder: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	id, err := database.Db.CreateVendorLocation(location)
+	respond(w, err, id)
 }
 
-// VerifyPaymentOrderResponse is the response to VerifyPaymentOrder
-type VerifyPaymentOrderResponse struct {
-	TimeStamp        time.Time
-	FirstName        string
-	PurchasedItems   []database.OrderEntry
-	TotalSum         int
-	PDFDownloadLinks *[]database.PDFDownloadLinks
+// UpdateVendorLocation godoc
+//
+//	@Summary		Update selling location of vendor
+//	@Description	The time slots of the location are replaced
+//	@Tags			Vendors
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Vendor ID"
+//	@Param			locationID path int true "Location ID"
+//	@Param			data body database.VendorLocation true "Location"
+//	@Success		200
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/locations/{locationID}/ [put]
+func UpdateVendorLocation(w http.ResponseWriter, r *http.Request) {
+	location, err := readVendorLocation(w, r)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	location.ID, err = strconv.Atoi(chi.URLParam(r, "locationID"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = database.Db.UpdateVendorLocation(location)
+	respond(w, err, location)
 }
 
-// VerifyPaymentOrder godoc
+// DeleteVendorLocation godoc
 //
-//	 	@Summary 		Verify Payment Order
-//		@Description	Verifies order and creates payments
-//		@Tags			Orders
+//	@Summary		Delete selling location of vendor
+//	@Tags			Vendors
+//	@Param			id path int true "Vendor ID"
+//	@Param			locationID path int true "Location ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/vendors/{id}/locations/{locationID}/ [delete]
+func DeleteVendorLocation(w http.ResponseWriter, r *http.Request) {
+	vendorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	locationID, err := strconv.Atoi(chi.URLParam(r, "locationID"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = database.Db.DeleteVendorLocation(vendorID, locationID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Items (that can be sold) ---------------------------------------------------
+
+// ListItems godoc
+//
+//	 	@Summary 		List Items
+//		@Tags			Items
 //		@Accept			json
 //		@Produce		json
-//		@Success		200 {object} VerifyPaymentOrderResponse
-//		@Param			s query string true "Order Code" Format(3043685539722561)
-//		@Param			t query string true "Transaction ID" Format(882d641c-01cc-442f-b894-2b51250340b5)
-//		@Router			/orders/verify/ [get]
-func VerifyPaymentOrder(w http.ResponseWriter, r *http.Request) {
+//		@Success		200	{array}	database.Item
+//		@Router			/items/ [get]
+func ListItems(w http.ResponseWriter, r *http.Request) {
+	items, err := database.Db.ListItems(true, true)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = utils.WriteJSON(w, http.StatusOK, items)
+	if err != nil {
+		log.Error("ListItems", err)
+	}
+}
 
-	// Get transaction ID from URL parameter
-	OrderCode := r.URL.Query().Get("s")
-	if OrderCode == "" {
-		utils.ErrorJSON(w, errors.New("missing parameter s"), http.StatusBadRequest)
+// ListItemsBackoffice godoc
+//
+//	 	@Summary 		List Items for backoffice overview
+//		@Tags			Items
+//		@Accept			json
+//		@Produce		json
+//	    @Param			skipHiddenItems query bool false "No donation and transaction cost items"
+//		@Param 			skipLicenses query bool false "No license items"
+//		@Success		200	{array}	database.Item
+//		@Security		KeycloakAuth
+//		@Router			/items/backoffice [get]
+func ListItemsBackoffice(w http.ResponseWriter, r *http.Request) {
+
+	// Get filter parameters
+	skipHiddenItemsRaw := r.URL.Query().Get("skipHiddenItems")
+	skipLicensesRaw := r.URL.Query().Get("skipLicenses")
+
+	// Parse filter parameters
+	skipHiddenItems, err := parseBool(skipHiddenItemsRaw)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	TransactionID := r.URL.Query().Get("t")
-	if TransactionID == "" {
-		utils.ErrorJSON(w, errors.New("missing parameter t"), http.StatusBadRequest)
+	skipLicenses, err := parseBool(skipLicensesRaw)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	// Get payment order from database
-	order, err := database.Db.GetOrderByOrderCode(OrderCode)
+	items, err := database.Db.ListItems(skipHiddenItems, skipLicenses)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	err = utils.WriteJSON(w, http.StatusOK, items)
+	if err != nil {
+		log.Error("ListItemsBackoffice: ", err)
+	}
+}
 
-	if database.Db.IsProduction && !config.Config.Development {
-		// Verify transaction
-		_, err := paymentprovider.VerifyTransactionID(TransactionID, true)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-			return
-		}
+// CreateItem godoc
+//
+//	 	@Summary 		Create Item
+//		@Tags			Items
+//		@Accept			json
+//		@Produce		json
+//	    @Param		    data body database.Item true "Item Representation"
+//		@Success		200	 {integer}	id
+//		@Security		KeycloakAuth
+//		@Router			/items/ [post]
+func CreateItem(w http.ResponseWriter, r *http.Request) {
+	// Read multipart form
+	err := r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		log.Error("CreateItem: failed to parse Multipartform ", err)
+		return
 	}
 
-	if config.Config.Development {
-		// Verify transaction
-		err = database.Db.VerifyOrderAndCreatePayments(order.ID, 0)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-		}
+	mForm := r.MultipartForm
+	if mForm == nil {
+		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		return
 	}
 
-	// Make sure that transaction timestamp is not older than 15 minutes (900 seconds) to time.Now()
-	if time.Since(order.Timestamp) > 900*time.Second {
-		utils.ErrorJSON(w, errors.New("Transaction timestamp is older than 15 minutes"), http.StatusBadRequest)
+	// Handle normal fields
+	item, err := updateItemNormal(mForm.Value)
+	if err != nil {
+		log.Error("CreateItem: updateItemNormal failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	var verifyPaymentOrderResponse VerifyPaymentOrderResponse
+	// Handle image field
+	path, variants, err := updateItemImage(r)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if path != "" {
+		item.Image = path
+		item.ImageVariants = variants
+	}
 
-	// Declare timestamp from order
-	verifyPaymentOrderResponse.TimeStamp = order.Timestamp
+	// Handle pdf field
+	pdfId, err := handleItemPDF(w, r)
+	if err != nil {
+		log.Error("CreateItem: handleItemPDF failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if pdfId != 0 {
+		item.PDF = null.IntFrom(pdfId)
+	}
 
-	for _, entry := range order.Entries {
-		if entry.IsSale {
-			verifyPaymentOrderResponse.PurchasedItems = append(verifyPaymentOrderResponse.PurchasedItems, entry)
-		} else {
-			continue
-		}
+	// Save item to database
+	id, err := database.Db.CreateItem(item)
+	if err != nil {
+		log.Error("CreateItem: Database call create item failed", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = utils.WriteJSON(w, http.StatusOK, id)
+	if err != nil {
+		log.Error("CreateItem: WriteJSON failed", err)
 	}
+}
 
-	// Declare total sum from order
-	verifyPaymentOrderResponse.TotalSum = order.GetTotal()
-	verifyPaymentOrderResponse.PDFDownloadLinks = order.GetPDFDownloadLinks()
+func updateItemImage(r *http.Request) (key string, variants map[string]string, err error) {
+	return saveFormImage(r, "Image", "", imaging.ImageVariants)
+}
 
-	// Get first name of vendor from vendor id in order
-	vendor, err := database.Db.GetVendor(order.Vendor)
+// saveFormImage checks the image of a form field by its content, stores it without metadata and
+// generates the variants. The image keeps its format unless format is set.
+// An empty key is returned if no file has been sent.
+func saveFormImage(r *http.Request, field string, format string, variants []imaging.Variant) (key string, variantKeys map[string]string, err error) {
+	// Get file from image field
+	file, header, err := r.FormFile(field)
+	if err != nil {
+		return "", nil, nil // No file passed, which is ok
+	}
+	defer file.Close()
+
+	maxSize := int64(config.Config.ImageMaxUploadMB) << 20
+	if header.Size > maxSize {
+		return "", nil, fmt.Errorf("%s: %w", field, imaging.ErrTooLarge)
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
 	if err != nil {
-		log.Error("Getting vendor's first name failed: ", err)
 		return
 	}
-	// Declare first name from vendor
-	verifyPaymentOrderResponse.FirstName = vendor.FirstName
+	img, imgFormat, err := imaging.Decode(data, maxSize)
+	if err != nil {
+		log.Info("saveFormImage: rejected ", field, ": ", err)
+		return "", nil, fmt.Errorf("%s: %w", field, err)
+	}
+	if format == "" {
+		format = imgFormat
+	}
+	// Files are content-addressed, so the same image is only stored once
+	key, variantKeys, err = imaging.Save(r.Context(), img, format, variants)
+	if err != nil {
+		log.Error("saveFormImage: failed to store ", field, ": ", err)
+	}
+	return
+}
 
-	// Create response
-	err = utils.WriteJSON(w, http.StatusOK, verifyPaymentOrderResponse)
+func handleItemPDF(w http.ResponseWriter, r *http.Request) (pdfId int64, err error) {
+	pdfId = -1
+	// Check if a digit is sent instead of pdf
+	// contentType := r.Header.Get("Content-Type")
+	// if contentType != "multipart/form-data" {
+	// 	log.Info("handleItemPDF: Content-Type is not multipart/form-data", contentType)
+	// 	// Assuming that if it's not multipart/form-data, it's a digit being sent
+	// 	// Handle the case where a digit is sent
+	// 	pdfId, err = strconv.ParseInt(r.FormValue("pdfId"), 10, 64)
+	// 	if err != nil {
+	// 		log.Error("handleItemPDF: parsing the id failed", err)
+	// 	}
+	// 	return pdfId, nil
+	// }
+
+	// Get file from pdf field
+	file, header, err := r.FormFile("PDF")
+	if err != nil {
+		return pdfId, nil // No file passed, which is ok
+	}
+	defer file.Close()
+
+	// Debugging
+	name := strings.Split(header.Filename, ".")
+	if len(name) < 2 {
+		log.Error("handleItemPDF: pdf name is wrong")
+		utils.ErrorJSON(w, errors.New("invalid filename"), http.StatusBadRequest)
+		return
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err = io.Copy(buf, file); err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	key, err := storage.SaveContent(r.Context(), "pdf", "."+name[len(name)-1], buf.Bytes(), "application/pdf")
+	if err != nil {
+		log.Error("handleItemPDF: failed to store file", err)
+		return
+	}
+	// The same file has been uploaded before
+	existing, err := database.Db.GetPDFByPath(key)
+	if err == nil {
+		return int64(existing.ID), nil
+	}
+	pdf := database.PDF{
+		Path:      key,
+		Timestamp: time.Now(),
+	}
+
+	pdfId, err = database.Db.CreatePDF(pdf)
+	if err != nil {
+		log.Error("handleItemPDF: failed to create db entry", err)
+	}
+	return
+}
+
+// fields := mForm.Value               // Values are stored in []string
+func updateItemNormal(fields map[string][]string) (item database.Item, err error) {
+	fieldsClean := make(map[string]any) // Values are stored in string
+	for key, value := range fields {
+		if key == "Price" {
+			fieldsClean[key], err = strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse Price failed ", err)
+				return
+			}
+		} else if key == "IsLicenseItem" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse IfLicenseItem failed ", err)
+				return
+			}
+		} else if key == "IsPDFItem" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse IsPDFItem failed ", err)
+				return
+			}
+		} else if key == "Archived" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse Archived failed ", err)
+				return
+			}
+		} else if key == "LicenseItem" {
+			licensitem, err := strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse LicenseItem failed ", err)
+				return item, err
+			}
+			fieldsClean[key] = null.IntFrom(int64(licensitem))
+
+		} else if key == "ID" {
+			fieldsClean[key], err = strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse ID failed ", err)
+				return
+			}
+		} else if key == "ItemColor" {
+			fieldsClean[key] = null.StringFrom(value[0])
+		} else if key == "ItemTextColor" {
+			fieldsClean[key] = null.StringFrom(value[0])
+		} else if key == "PDF" {
+			pdf, err := strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse PDF failed ", err)
+				return item, err
+			}
+			fieldsClean[key] = null.IntFrom(int64(pdf))
+		} else if key == "PDFValidityDays" || key == "PDFMaxDownloads" || key == "PDFMaxIPs" || key == "LicenseValidityDays" {
+			fieldsClean[key], err = strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("updateItemNormal: Parse "+key+" failed ", err)
+				return
+			}
+		} else if key == "LicenseGroup" {
+			fieldsClean[key] = null.StringFrom(value[0])
+		} else if key == "ItemOrder" {
+			fieldsClean[key], err = strconv.Atoi(value[0])
+		} else if key == "ImageVariants" {
+			continue // Generated from the uploaded image
+		} else {
+			fieldsClean[key] = value[0]
+		}
+	}
+	err = mapstructure.Decode(fieldsClean, &item)
+	if err != nil {
+		log.Error("updateItemNormal: Decoding fields failed", err)
+		return
+	}
+	err = checkSubscriptionItem(item)
+	return
+}
+
+// checkSubscriptionItem checks that subscriptions are digital license items with a valid period
+func checkSubscriptionItem(item database.Item) error {
+	switch item.SubscriptionPeriod {
+	case "":
+		return nil
+	case database.SubscriptionMonthly, database.SubscriptionYearly:
+	default:
+		return errors.New("SubscriptionPeriod has to be month or year")
+	}
+	if !item.LicenseItem.Valid || item.IsPDFItem || item.LicenseGroup.String == "" {
+		return errors.New("subscriptions have to be digital license items with a license item and a license group")
+	}
+	return nil
+}
+
+// UpdateItem godoc
+//
+//	 	@Summary 		Update Item
+//		@Description	Requires multipart form (for image)
+//		@Tags			Items
+//		@Accept			json
+//		@Produce		json
+//		@Param			id path int true "Item ID"
+//	    @Param		    data body database.Item true "Item Representation"
+//		@Success		200
+//		@Security		KeycloakAuth
+//		@Router			/items/{id}/ [put]
+//
+// UpdateItem requires a multipart form
+// https://www.sobyte.net/post/2022-03/go-multipart-form-data/
+func UpdateItem(w http.ResponseWriter, r *http.Request) {
+	ItemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Error("UpdateItem: Can not read ID ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Security check to disable updating Item of ID 2 and 3, which are essential for donations and transaction costs
+	if ItemID == 2 || ItemID == 3 || isVoucherItem(ItemID) {
+		utils.ErrorJSON(w, errors.New("Nice try! You are not allowed to update this item"), http.StatusBadRequest)
+		return
+	}
+
+	// Read multipart form
+	log.Info("Request Content Length:", r.ContentLength)
+	err = r.ParseMultipartForm(64 << 20)
+	log.Info("Parsed Form Content Length:", r.ContentLength)
+	if err != nil {
+		log.Error("UpdateItem: ParseMultipartForm failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	mForm := r.MultipartForm
+	if mForm == nil {
+		log.Error("UpdateItem: ", errors.New("invalid form"))
+		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		return
+	}
+
+	// Handle normal fields
+	item, err := updateItemNormal(mForm.Value)
+	if err != nil {
+		log.Error("UpdateItem: UpdateItemNormal failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	path, variants, err := updateItemImage(r)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if path != "" {
+		log.Info("UpdateItem: new image ", path)
+		item.Image = path
+		item.ImageVariants = variants
+	}
+
+	pdfId, err := handleItemPDF(w, r)
+	if err != nil {
+		log.Error("CreateItem: handleItemPDF failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if pdfId != -1 {
+		item.PDF = null.IntFrom(pdfId)
+	}
+
+	// Save item to database
+	err = database.Db.UpdateItem(ItemID, item)
+	if err != nil {
+		log.Error("UpdateItem: db update", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+	}
+	err = utils.WriteJSON(w, http.StatusOK, err)
+	if err != nil {
+		log.Error("UpdateItem: ", err)
+	}
+}
+
+// DeleteItem godoc
+//
+//		 	@Summary 		Delete Item
+//			@Tags			Items
+//			@Accept			json
+//			@Produce		json
+//			@Success		200
+//			@Security		KeycloakAuth
+//	     	@Param          id   path int  true  "Item ID"
+//			@Router			/items/{id}/ [delete]
+func DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ItemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// The voucher item carries the discounts of orders and is looked up by its name
+	if isVoucherItem(ItemID) {
+		utils.ErrorJSON(w, errors.New("Nice try! You are not allowed to delete this item"), http.StatusBadRequest)
+		return
+	}
+
+	err = database.Db.DeleteItem(ItemID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isVoucherItem returns whether an item is the hidden item of the discounts of vouchers
+func isVoucherItem(id int) bool {
+	item, err := database.Db.GetItem(id)
+	return err == nil && item.Name == config.Config.VoucherName
+}
+
+// Editions -------------------------------------------------------------------
+
+// ListEditions godoc
+//
+//	@Summary		List editions of an item
+//	@Description	Editions of a PDF item that can be bought, the newest first
+//	@Tags			Items
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Item ID"
+//	@Success		200	{array}	database.PDF
+//	@Router			/items/{id}/editions/ [get]
+func ListEditions(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	editions, err := database.Db.ListEditions(itemID, true)
+	// The file is only available via download links
+	for i := range editions {
+		editions[i].Path = ""
+	}
+	respond(w, err, editions)
+}
+
+// ListEditionsBackoffice godoc
+//
+//	@Summary		List all editions of an item for the backoffice
+//	@Tags			Items
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Item ID"
+//	@Success		200	{array}	database.PDF
+//	@Security		KeycloakAuth
+//	@Router			/items/{id}/editions/backoffice/ [get]
+func ListEditionsBackoffice(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	editions, err := database.Db.ListEditions(itemID, false)
+	respond(w, err, editions)
+}
+
+// parseEditionTime parses a date (2006-01-02) or a timestamp (RFC 3339), empty values are null
+func parseEditionTime(value string) (null.Time, error) {
+	if value == "" {
+		return null.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02", value, time.Local)
+	}
+	if err != nil {
+		return null.Time{}, errors.New("invalid date: " + value)
+	}
+	return null.TimeFrom(t), nil
+}
+
+// updateEditionFields sets the metadata of an edition from a multipart form
+func updateEditionFields(edition *database.PDF, fields map[string][]string) (err error) {
+	for key, value := range fields {
+		switch key {
+		case "IssueNumber":
+			edition.IssueNumber = value[0]
+		case "PublicationDate":
+			edition.PublicationDate, err = parseEditionTime(value[0])
+		case "AvailableFrom":
+			edition.AvailableFrom, err = parseEditionTime(value[0])
+		case "AvailableUntil":
+			edition.AvailableUntil, err = parseEditionTime(value[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if edition.AvailableFrom.Valid && edition.AvailableUntil.Valid && !edition.AvailableUntil.Time.After(edition.AvailableFrom.Time) {
+		return errors.New("AvailableUntil has to be after AvailableFrom")
+	}
+	return nil
+}
+
+// CreateEdition godoc
+//
+//	@Summary		Create edition
+//	@Description	Requires multipart form with the fields PDF (file), CoverImage (file), IssueNumber, PublicationDate, AvailableFrom and AvailableUntil
+//	@Tags			Items
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Item ID"
+//	@Success		200 {object} database.PDF
+//	@Security		KeycloakAuth
+//	@Router			/items/{id}/editions/ [post]
+func CreateEdition(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	item, err := database.Db.GetItem(itemID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if !item.IsPDFItem {
+		utils.ErrorJSON(w, errors.New("item is not a pdf item"), http.StatusBadRequest)
+		return
+	}
+	err = r.ParseMultipartForm(64 << 20)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	edition := database.PDF{Item: null.IntFrom(int64(itemID)), PublicationDate: null.TimeFrom(time.Now())}
+	err = updateEditionFields(&edition, r.MultipartForm.Value)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	pdfId, err := handleItemPDF(w, r)
+	if err != nil {
+		log.Error("CreateEdition: handleItemPDF failed ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if pdfId == -1 {
+		utils.ErrorJSON(w, errors.New("missing pdf"), http.StatusBadRequest)
+		return
+	}
+	// Identical files are stored only once, so the PDF may already be an edition
+	existing, err := database.Db.GetPDFByID(pdfId)
+	if err == nil && existing.Item.Valid {
+		utils.ErrorJSON(w, errors.New("this pdf has already been uploaded"), http.StatusBadRequest)
+		return
+	}
+	edition.ID = int(pdfId)
+	edition.CoverImage, _, err = saveFormImage(r, "CoverImage", "", nil)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = database.Db.UpdateEdition(edition)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	edition, err = database.Db.GetPDFByID(pdfId)
+	respond(w, err, edition)
+}
+
+// UpdateEdition godoc
+//
+//	@Summary		Update edition
+//	@Description	Requires multipart form with the fields CoverImage (file), IssueNumber, PublicationDate, AvailableFrom and AvailableUntil.
+//	@Description	Editions can't be deleted, set AvailableUntil to stop selling them.
+//	@Tags			Items
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Item ID"
+//	@Param			editionID path int true "Edition ID"
+//	@Success		200 {object} database.PDF
+//	@Security		KeycloakAuth
+//	@Router			/items/{id}/editions/{editionID}/ [put]
+func UpdateEdition(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	editionID, err := strconv.ParseInt(chi.URLParam(r, "editionID"), 10, 64)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	edition, err := database.Db.GetPDFByID(editionID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if !edition.Item.Valid || int(edition.Item.Int64) != itemID {
+		utils.ErrorJSON(w, errors.New("edition does not belong to the item"), http.StatusBadRequest)
+		return
+	}
+	err = r.ParseMultipartForm(64 << 20)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = updateEditionFields(&edition, r.MultipartForm.Value)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	path, _, err := saveFormImage(r, "CoverImage", "", nil)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if path != "" {
+		edition.CoverImage = path
+	}
+	err = database.Db.UpdateEdition(edition)
+	respond(w, err, edition)
+}
+
+// Orders ---------------------------------------------------------------------
+
+type createOrderRequestEntry struct {
+	Item     int
+	Quantity int
+	PDF      int // Edition of a PDF item, the current edition is bought if not set
+}
+
+type createOrderRequest struct {
+	Entries         []createOrderRequestEntry
+	User            string
+	VendorLicenseID string
+	CustomerEmail   null.String
+	Language        string // Language of the customer for emails, defaults to the Accept-Language header
+	// Gifts: the digital license items and PDFs are delivered to the recipient, the buyer (CustomerEmail) gets the receipt
+	RecipientEmail null.String
+	RecipientName  string
+	GiftMessage    string
+	DeliveryDate   null.Time `swaggertype:"string" format:"date-time"` // The gift is delivered on this date, immediately if not set
+	VoucherCode    string    // Optional code of a voucher, the organization bears the discount
+}
+
+// Limits of the gift fields of an order
+const (
+	maxRecipientNameLength = 100
+	maxGiftMessageLength   = 1000
+	maxGiftDeliveryDays    = 365
+)
+
+type createOrderResponse struct {
+	SmartCheckoutURL string
+}
+
+// PaymentOrders ---------------------------------------------------------------------
+
+// acceptLanguage returns the preferred language of the Accept-Language header
+func acceptLanguage(r *http.Request) string {
+	language, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	language, _, _ = strings.Cut(language, ";")
+	language = strings.TrimSpace(language)
+	if language == "*" {
+		return ""
+	}
+	return language
+}
+
+// checkGift validates the gift fields of an order request, hasLicenseItem is whether the order contains a digital license or PDF item
+func checkGift(requestData createOrderRequest, hasLicenseItem bool) error {
+	if !requestData.RecipientEmail.Valid || requestData.RecipientEmail.String == "" {
+		if requestData.RecipientName != "" || requestData.GiftMessage != "" || requestData.DeliveryDate.Valid {
+			return errors.New("gifts need a recipient email")
+		}
+		return nil
+	}
+	if !hasLicenseItem {
+		return errors.New("only digital license and PDF items can be given as a gift")
+	}
+	if _, err := mailer.ParseAddresses([]string{requestData.RecipientEmail.String}); err != nil {
+		return err
+	}
+	if len([]rune(requestData.RecipientName)) > maxRecipientNameLength {
+		return fmt.Errorf("the recipient name must not be longer than %d characters", maxRecipientNameLength)
+	}
+	if len([]rune(requestData.GiftMessage)) > maxGiftMessageLength {
+		return fmt.Errorf("the gift message must not be longer than %d characters", maxGiftMessageLength)
+	}
+	if requestData.DeliveryDate.Valid && requestData.DeliveryDate.Time.After(time.Now().AddDate(0, 0, maxGiftDeliveryDays)) {
+		return fmt.Errorf("the delivery date must be within %d days", maxGiftDeliveryDays)
+	}
+	return nil
+}
+
+// applyVoucher adds the discount of a voucher code to an order as an entry from the organization to the buyer,
+// so that the vendor gets the full price. Donations are not discounted.
+func applyVoucher(order *database.Order, code string, buyerAccountID int, orgaAccountID int) error {
+	voucher, voucherCode, err := database.Db.GetVoucherByCode(code)
+	if err != nil {
+		return err
+	}
+	err = voucher.Check(time.Now())
+	if err != nil {
+		return err
+	}
+	if voucher.MaxUses > 0 && voucherCode.Uses >= voucher.MaxUses {
+		return database.ErrVoucherUsedUp
+	}
+	var entries []database.OrderEntry
+	for _, entry := range order.Entries {
+		if !entry.IsSale {
+			continue
+		}
+		item, err := database.Db.GetItem(entry.Item)
+		if err != nil {
+			return err
+		}
+		if item.Name != config.Config.DonationName {
+			entries = append(entries, entry)
+		}
+	}
+	discount := voucher.Discount(entries)
+	if discount <= 0 {
+		return database.ErrVoucherNoItems
+	}
+	// Orders are paid with VivaWallet, which needs an amount
+	if discount >= order.GetTotal() {
+		return errors.New("the order has to cost something after the discount of the voucher")
+	}
+	voucherItem, err := database.Db.GetItemByName(config.Config.VoucherName)
+	if err != nil {
+		return err
+	}
+	// The discount is stored as the price of the entry, independent of the price of the voucher item
+	order.Entries = append(order.Entries, database.OrderEntry{
+		Item:        voucherItem.ID,
+		Quantity:    1,
+		Price:       discount,
+		Sender:      orgaAccountID,
+		Receiver:    buyerAccountID,
+		VoucherCode: null.IntFrom(int64(voucherCode.ID)),
+	})
+	return nil
+}
+
+// orderedEdition returns the edition that is bought with an order entry.
+// Back-issues have to belong to the item and be available, otherwise the current edition is used.
+func orderedEdition(entry createOrderRequestEntry) (edition null.Int, err error) {
+	if entry.PDF == 0 {
+		item, err := database.Db.GetItem(entry.Item)
+		if err != nil || !item.IsPDFItem {
+			return edition, err
+		}
+		current, err := database.Db.GetCurrentEdition(item)
+		if err != nil {
+			// Editions are optional, the PDF of the item is used on verification
+			return edition, nil
+		}
+		return null.IntFrom(int64(current.ID)), nil
+	}
+	pdf, err := database.Db.GetPDFByID(int64(entry.PDF))
+	if err != nil || !pdf.Item.Valid || int(pdf.Item.Int64) != entry.Item || !pdf.Available(time.Now()) {
+		return edition, errors.New("edition is not available")
+	}
+	return null.IntFrom(int64(pdf.ID)), nil
+}
+
+// CreatePaymentOrder godoc
+//
+//	 	@Summary 		Create Payment Order
+//		@Description	Submits payment order to provider & saves it to database. Entries need to have an item id and a quantity (for entries without a price like tips, the quantity is the amount of cents). If no user is given, the order is anonymous.
+//		@Tags			Orders
+//		@Accept			json
+//		@Produce		json
+//	    @Param		    data body createOrderRequest true "Payment Order"
+//		@Success		200 {object} createOrderResponse
+//		@Router			/orders/ [post]
+func CreatePaymentOrder(w http.ResponseWriter, r *http.Request) {
+
+	// Read payment order from request
+	var requestData createOrderRequest
+	err := utils.ReadJSON(w, r, &requestData)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	response, err := placePaymentOrder(r, requestData)
+	respond(w, err, response)
+}
+
+// placePaymentOrder checks an order, submits it to the payment provider and saves it to the database
+func placePaymentOrder(r *http.Request, requestData createOrderRequest) (response createOrderResponse, err error) {
+	var order database.Order
+	hasLicenseItem := false
+
+	// Security checks for entries
+	for _, entry := range requestData.Entries {
+
+		// 1. Check: Quantity has to be > 0 for any item except donation
+		if entry.Quantity <= 0 && entry.Item != 2 {
+			return response, errors.New("Nice try! Quantity has to be greater than 0")
+		}
+
+		// 2. Check: All items have to exist
+		item, err := database.Db.GetItem(entry.Item)
+		if err != nil {
+			return response, errors.New("Nice try! Item does not exist")
+		}
+
+		// 3. Check: Transaction costs (id == 3) and discounts are not allowed to be in entries
+		if entry.Item == 3 || item.Name == config.Config.VoucherName {
+			return response, errors.New("Nice try! You are not allowed to purchase this item")
+		}
+
+		// 4. Check: If there is a item that needs a customerEmail, the user has to be given
+
+		if item.LicenseItem.Valid {
+			if !requestData.CustomerEmail.Valid || requestData.CustomerEmail.String == "" {
+				return response, errors.New("you are not allowed to purchase this item without a customer email")
+			}
+			order.CustomerEmail = requestData.CustomerEmail
+			hasLicenseItem = true
+		}
+	}
+
+	// 5. Check: Gifts need a recipient and digital license or PDF items
+	err = checkGift(requestData, hasLicenseItem)
+	if err != nil {
+		return response, err
+	}
+	if requestData.RecipientEmail.Valid && requestData.RecipientEmail.String != "" {
+		order.RecipientEmail = null.StringFrom(strings.TrimSpace(requestData.RecipientEmail.String))
+		order.RecipientName = strings.TrimSpace(requestData.RecipientName)
+		order.GiftMessage = strings.TrimSpace(requestData.GiftMessage)
+		order.DeliveryDate = requestData.DeliveryDate
+	}
+
+	// 6. Check: If item 2 (donation) is ordered without another item
+	if len(requestData.Entries) == 1 && requestData.Entries[0].Item == 2 {
+		// Throw error
+		return response, errors.New("Nice try! You are not allowed to purchase this item without another item")
+	}
+
+	// Create slice of order entries depending on size of requestData.Entries
+	order.Entries = make([]database.OrderEntry, len(requestData.Entries))
+
+	// Add entries to each ordered item
+	for idx, entry := range requestData.Entries {
+		order.Entries[idx].Item = entry.Item
+		order.Entries[idx].Quantity = entry.Quantity
+		edition, err := orderedEdition(entry)
+		if err != nil {
+			return response, err
+		}
+		order.Entries[idx].PDF = edition
+	}
+
+	// 7. Check: If there is more than one entry, each item id has to be unique
+	// Different editions of the same PDF item can be bought together, they are compared after resolving the current edition
+	if len(order.Entries) > 1 {
+		uniqueEntries := make(map[[2]int]struct{})
+		for _, entry := range order.Entries {
+			key := [2]int{entry.Item, int(entry.PDF.Int64)}
+			if _, has := uniqueEntries[key]; has {
+				return response, errors.New("Nice try! You are not supposed to have duplicate item ids in your order request")
+			}
+			uniqueEntries[key] = struct{}{}
+		}
+	}
+
+	// Get vendor id from license id
+	vendor, err := database.Db.GetVendorByLicenseID(requestData.VendorLicenseID)
+	if err != nil {
+		return response, err
+	}
+	if !vendor.LicenseValid {
+		log.Info("CreatePaymentOrder: license of vendor ", vendor.LicenseID.String, " has expired")
+		return response, errors.New("the license of the vendor has expired")
+	}
+	order.Vendor = vendor.ID
+
+	var settings database.Settings
+	if settings, err = database.Db.GetSettings(); err != nil {
+		return response, err
+	}
+
+	// Language is used to localize the emails sent to the customer
+	order.Language = requestData.Language
+	if order.Language == "" {
+		order.Language = acceptLanguage(r)
+	}
+
+	// Add user to order
+	// TODO-Question: This line is not necessary anymore, since the user is already in the request?
+	order.User.String = requestData.User
+
+	// Get accounts
+	var buyerAccountID int
+	authenticatedUserID := r.Header.Get("X-Auth-User-Name")
+	if authenticatedUserID != "" {
+		buyerAccount, err := database.Db.GetOrCreateAccountByUserID(authenticatedUserID)
+		if err != nil {
+			return response, err
+		}
+		buyerAccountID = buyerAccount.ID
+	} else {
+		buyerAccountID, err = database.Db.GetAccountTypeID("UserAnon")
+		if err != nil {
+			return response, err
+		}
+	}
+
+	vendorAccount, err := database.Db.GetAccountByVendorID(order.Vendor)
+	if err != nil {
+		return response, err
+	}
+	orgaAccount, err := database.Db.GetAccountByType("Orga")
+	if err != nil {
+		return response, err
+	}
+
+	// Amount of added license items
+	// Since for each license item an additional entry is added,
+	// Therefore, the index of the for loop has to be increased by 1
+	licenseItemAdded := 0
+
+	// Extend order entries
+	for idx, entry := range order.Entries {
+		// Increase index depending on how many license items were added
+		idx = idx + licenseItemAdded
+		// Get item from database
+		item, err := database.Db.GetItem(entry.Item)
+		if err != nil {
+			return response, err
+		}
+
+		// Define flow of money from buyer to vendor
+		order.Entries[idx].Sender = buyerAccountID
+		order.Entries[idx].Receiver = vendorAccount.ID
+		order.Entries[idx].Price = item.Price // Take current item price
+		order.Entries[idx].IsSale = true      // Will be used for sales payment
+
+		// If there is a license item, prepend it before the actual item
+		if item.LicenseItem.Valid {
+			// Get license item from database
+			licenseItem, err := database.Db.GetItem(int(item.LicenseItem.Int64))
+			if err != nil {
+				return response, err
+			}
+			// Define flow of money from vendor to orga
+			licenseItemEntry := database.OrderEntry{
+				Item:         int(item.LicenseItem.Int64),
+				Quantity:     entry.Quantity,
+				Price:        licenseItem.Price,
+				Sender:       vendorAccount.ID,
+				Receiver:     orgaAccount.ID,
+				SenderName:   vendorAccount.Name,
+				ReceiverName: orgaAccount.Name,
+			}
+			// Prepend license item without overwriting next entries
+			order.Entries = append([]database.OrderEntry{licenseItemEntry}, order.Entries...)
+			// Add customer email to order
+			order.CustomerEmail = requestData.CustomerEmail
+			// Increase licenseItemAdded by one
+			licenseItemAdded++
+
+		}
+
+	}
+	if requestData.VoucherCode != "" {
+		err = applyVoucher(&order, requestData.VoucherCode, buyerAccountID, orgaAccount.ID)
+		if err != nil {
+			return response, err
+		}
+	}
+
+	// ignore MaxOrderAmount if its 0
+	if settings.MaxOrderAmount != 0 && order.GetTotal() >= settings.MaxOrderAmount {
+		return response, errors.New("Order amount is too high")
+	}
+	// Submit order to vivawallet (disabled in tests)
+	var OrderCode int
+	if database.Db.IsProduction {
+		accessToken, err := paymentprovider.AuthenticateToVivaWallet()
+		if err != nil {
+			log.Error("Authentication failed: ", err)
+			return response, err
+		}
+		OrderCode, err = paymentprovider.CreatePaymentOrder(accessToken, order, requestData.VendorLicenseID)
+		if err != nil {
+			log.Errorf("Creating payment order failed for %+v with order id %+v failed", requestData.VendorLicenseID, order.ID, err)
+			return response, err
+		}
+	}
+
+	// Save order to database
+	order.OrderCode.String = strconv.Itoa(OrderCode)
+	order.OrderCode.Valid = true // This means that it is not null
+	_, err = database.Db.CreateOrder(order)
+	if err != nil {
+		return response, err
+	}
+
+	// Check if VivaWalletSmartCheckoutURL is set
+	if config.Config.VivaWalletSmartCheckoutURL == "" {
+		return response, errors.New("VivaWalletSmartCheckoutURL is not set")
+	}
+
+	// Create response
+	checkoutURL := config.Config.VivaWalletSmartCheckoutURL + strconv.Itoa(OrderCode)
+
+	// Add color code to URL
+	if settings.Color == "" {
+		log.Info("Color code is not set")
+	} else {
+
+		var colorCode string
+		// Check if color code is valid with # at the beginning
+		if settings.Color[0] == '#' {
+			// Remove # from color code due to VivaWallet's policy
+			colorCode = settings.Color[1:]
+		} else {
+			log.Info("Color code is not valid: ", settings.Color)
+		}
+		// Make color code lowercase
+		colorCode = strings.ToLower(colorCode)
+
+		// Add color code and necessary attachment to URL
+		colorCodeAttachment := fmt.Sprintf("%s%s", "&color=", colorCode)
+
+		// Add color code to URL
+		checkoutURL = fmt.Sprintf("%s%s", checkoutURL, colorCodeAttachment)
+	}
+
+	response.SmartCheckoutURL = checkoutURL
+	return response, nil
+}
+
+// VerifyPaymentOrderResponse is the response to VerifyPaymentOrder
+type VerifyPaymentOrderResponse struct {
+	TimeStamp        time.Time
+	FirstName        string
+	PurchasedItems   []database.OrderEntry
+	TotalSum         int
+	PDFDownloadLinks *[]database.PDFDownloadLinks
+}
+
+// VerifyPaymentOrder godoc
+//
+//	 	@Summary 		Verify Payment Order
+//		@Description	Verifies order and creates payments
+//		@Tags			Orders
+//		@Accept			json
+//		@Produce		json
+//		@Success		200 {object} VerifyPaymentOrderResponse
+//		@Param			s query string true "Order Code" Format(3043685539722561)
+//		@Param			t query string true "Transaction ID" Format(882d641c-01cc-442f-b894-2b51250340b5)
+//		@Router			/orders/verify/ [get]
+func VerifyPaymentOrder(w http.ResponseWriter, r *http.Request) {
+
+	// Get transaction ID from URL parameter
+	OrderCode := r.URL.Query().Get("s")
+	if OrderCode == "" {
+		utils.ErrorJSON(w, errors.New("missing parameter s"), http.StatusBadRequest)
+		return
+	}
+	TransactionID := r.URL.Query().Get("t")
+	if TransactionID == "" {
+		utils.ErrorJSON(w, errors.New("missing parameter t"), http.StatusBadRequest)
+		return
+	}
+
+	// Get payment order from database
+	order, err := database.Db.GetOrderByOrderCode(OrderCode)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if database.Db.IsProduction && !config.Config.Development {
+		// Verify transaction
+		_, err := paymentprovider.VerifyTransactionID(TransactionID, true)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if config.Config.Development {
+		// Verify transaction
+		err = database.Db.VerifyOrderAndCreatePayments(order.ID, 0)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+
+	// Make sure that transaction timestamp is not older than 15 minutes (900 seconds) to time.Now()
+	if time.Since(order.Timestamp) > 900*time.Second {
+		utils.ErrorJSON(w, errors.New("Transaction timestamp is older than 15 minutes"), http.StatusBadRequest)
+		return
+	}
+
+	var verifyPaymentOrderResponse VerifyPaymentOrderResponse
+
+	// Declare timestamp from order
+	verifyPaymentOrderResponse.TimeStamp = order.Timestamp
+
+	for _, entry := range order.Entries {
+		if entry.IsSale {
+			verifyPaymentOrderResponse.PurchasedItems = append(verifyPaymentOrderResponse.PurchasedItems, entry)
+		} else {
+			continue
+		}
+	}
+
+	// Declare total sum from order
+	verifyPaymentOrderResponse.TotalSum = order.GetTotal()
+	verifyPaymentOrderResponse.PDFDownloadLinks = order.GetPDFDownloadLinks()
+
+	// Get first name of vendor from vendor id in order
+	vendor, err := database.Db.GetVendor(order.Vendor)
+	if err != nil {
+		log.Error("Getting vendor's first name failed: ", err)
+		return
+	}
+	// Declare first name from vendor
+	verifyPaymentOrderResponse.FirstName = vendor.FirstName
+
+	// Create response
+	err = utils.WriteJSON(w, http.StatusOK, verifyPaymentOrderResponse)
+	if err != nil {
+		log.Error("VerifyPaymentOrder: ", err)
+	}
+}
+
+// Payments (from one account to another account) -----------------------------
+
+func parseBool(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// ListPaymentsForPayout godoc
+//
+//	 	@Summary 		Get list of all payments for payout
+//		@Description 	Payments that do not have an associated payout
+//		@Tags			Payments
+//		@Accept			json
+//		@Produce		json
+//		@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//		@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//		@Param			vendor query string false "Vendor LicenseID"
+//		@Success		200	{array}	database.Payment
+//		@Security		KeycloakAuth
+//		@Security		KeycloakAuth
+//		@Router			/payments/forpayout/ [get]
+func ListPaymentsForPayout(w http.ResponseWriter, r *http.Request) {
+	var err error
+	minDateRaw := r.URL.Query().Get("from")
+	maxDateRaw := r.URL.Query().Get("to")
+	vendor := r.URL.Query().Get("vendor")
+	var minDate, maxDate time.Time
+	if minDateRaw != "" {
+		minDate, err = time.Parse(time.RFC3339, minDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+	if maxDateRaw != "" {
+		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+	payments, err := database.Db.ListPaymentsForPayout(minDate, maxDate, vendor)
+	respond(w, err, payments)
+}
+
+// ListPayments godoc
+//
+//		 	@Summary 		Get list of all payments
+//			@Description 	Filter by date, vendor, payouts, sales. If payouts set true, all payments are removed that are not payouts. Same for sales. So sales and payouts can't be true at the same time.
+//			@Tags			Payments
+//			@Accept			json
+//			@Produce		json
+//			@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//			@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//			@Param			vendor query string false "Vendor LicenseID"
+//	     @Param			payouts query bool false "Payouts only"
+//	     @Param          sales query bool false "Sales only"
+//			@Success		200	{array}	database.Payment
+//			@Security		KeycloakAuth
+//			@Security		KeycloakAuth
+//			@Router			/payments/ [get]
+func ListPayments(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Get filter parameters
+	minDateRaw := r.URL.Query().Get("from")
+	maxDateRaw := r.URL.Query().Get("to")
+	payoutRaw := r.URL.Query().Get("payouts")
+	salesRaw := r.URL.Query().Get("sales")
+	vendor := r.URL.Query().Get("vendor")
+
+	// Parse filter parameters
+	payout, err := parseBool(payoutRaw)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	sales, err := parseBool(salesRaw)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var minDate, maxDate time.Time
+	if minDateRaw != "" {
+		minDate, err = time.Parse(time.RFC3339, minDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+	if maxDateRaw != "" {
+		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+
+	// Get payments with filter parameters
+	payments, err := database.Db.ListPayments(minDate, maxDate, vendor, payout, sales, false)
+	respond(w, err, payments)
+}
+
+type ItemStatistics struct {
+	ID          int
+	Name        string
+	SumAmount   int
+	SumQuantity int
+}
+
+// PaymentsStatistics is the response to ListPaymentsStatistics
+type PaymentsStatistics struct {
+	From     time.Time
+	To       time.Time
+	Items    []ItemStatistics
+	Vouchers []database.VoucherStatistics // Redemptions of vouchers in verified orders
+}
+
+// ListPaymentsStatistics godoc
+//
+//	 	@Summary 		Calculate statistics of items & payments
+//		@Description 	Filter by date, get statistical information, sorted by item.
+//		@Tags			Payments
+//		@Accept			json
+//		@Produce		json
+//		@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//		@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
+//		@Success		200	{array}	PaymentsStatistics
+//		@Security		KeycloakAuth
+//		@Security		KeycloakAuth
+//		@Router			/payments/statistics/ [get]
+func ListPaymentsStatistics(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	// Get filter parameters
+	minDateRaw := r.URL.Query().Get("from")
+	maxDateRaw := r.URL.Query().Get("to")
+
+	// Parse filter parameters
+	var minDate, maxDate time.Time
+	if minDateRaw != "" {
+		minDate, err = time.Parse(time.RFC3339, minDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+	if maxDateRaw != "" {
+		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+		}
+	}
+
+	// Get items
+	items, err := database.Db.ListItems(false, false)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Get payments with filter parameters
+	payments, err := database.Db.ListPayments(minDate, maxDate, "", false, false, false)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Create map of items
+	itemsMap := make(map[int]ItemStatistics)
+	for _, item := range items {
+		itemsMap[item.ID] = ItemStatistics{
+			ID:          item.ID,
+			Name:        item.Name,
+			SumAmount:   0,
+			SumQuantity: 0,
+		}
+	}
+
+	// Create sums per item
+	for _, payment := range payments {
+		if !payment.Item.Valid {
+			continue
+		}
+		itemID := int(payment.Item.Int64)
+		if entry, ok := itemsMap[itemID]; ok {
+			entry.SumAmount += payment.Amount
+			entry.SumQuantity += payment.Quantity
+			itemsMap[itemID] = entry
+		} else {
+			utils.ErrorJSON(w, errors.New("item not found"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Create payment statistics
+	var paymentsStatistics PaymentsStatistics
+	paymentsStatistics.From = minDate
+	paymentsStatistics.To = maxDate
+	for _, item := range itemsMap {
+		paymentsStatistics.Items = append(paymentsStatistics.Items, item)
+	}
+	paymentsStatistics.Vouchers, err = database.Db.ListVoucherStatistics(minDate, maxDate)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	respond(w, err, paymentsStatistics)
+}
+
+// CreatePayment godoc
+//
+//	 	@Summary 		Create a payment
+//		@Tags			Payments
+//		@Accept			json
+//		@Produce		json
+//		@Param			amount body database.Payment true " Create Payment"
+//		@Success		200
+func CreatePayment(w http.ResponseWriter, r *http.Request) {
+	var payment database.Payment
+	err := utils.ReadJSON(w, r, &payment)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	paymentID, err := database.Db.CreatePayment(payment)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = utils.WriteJSON(w, http.StatusOK, paymentID)
+	if err != nil {
+		log.Error("CreatePayment: ", err)
+	}
+}
+
+type createPaymentsRequest struct {
+	Payments []database.Payment
+}
+
+// CreatePayments godoc
+//
+//	 	@Summary 		Create a set of payments
+//		@Description 	TODO: This handler is not working right now and to be done for manually setting payments
+//		@Tags			Payments
+//		@Accept			json
+//		@Produce		json
+//		@Param			amount body createPaymentsRequest true "Create Payment"
+//		@Success		200 {integer} id
+//		@Security		KeycloakAuth
+//		@Router			/payments/ [post]
+func CreatePayments(w http.ResponseWriter, r *http.Request) {
+	var paymentBatch createPaymentsRequest
+	err := utils.ReadJSON(w, r, &paymentBatch)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = database.Db.CreatePayments(paymentBatch.Payments)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+}
+
+// offboardingPayout returns the account of a vendor, its payments that have not been paid out
+// and the amount of money for the final payout
+func offboardingPayout(vendor database.Vendor) (vendorAccount database.Account, payments []database.Payment, amount int, err error) {
+	vendorAccount, err = database.Db.GetAccountByVendorID(vendor.ID)
+	if err != nil {
+		return
+	}
+	candidates, err := database.Db.ListPaymentsForPayout(time.Time{}, time.Time{}, vendor.LicenseID.String)
+	if err != nil {
+		return
+	}
+	// Without a license ID the payments are not filtered by vendor, so only the payments of the account are paid out
+	for _, payment := range candidates {
+		if payment.Receiver == vendorAccount.ID {
+			amount += payment.Amount
+		} else if payment.Sender == vendorAccount.ID {
+			amount -= payment.Amount
+		} else {
+			continue
+		}
+		payments = append(payments, payment)
+	}
+	return
+}
+
+type createPaymentPayoutRequest struct {
+	VendorLicenseID string
+	From            time.Time
+	To              time.Time
+}
+
+// CreatePaymentPayout godoc
+//
+//	 	@Summary 		Create a payment from a vendor account to cash
+//		@Tags			Payments
+//		@Accept			json
+//		@Produce		json
+//		@Param			amount body createPaymentPayoutRequest true "Create Payment"
+//		@Success		200 {integer} id
+//		@Security		KeycloakAuth
+//		@Router			/payments/payout/ [post]
+func CreatePaymentPayout(w http.ResponseWriter, r *http.Request) {
+
+	// Read data from request
+	var payoutData createPaymentPayoutRequest
+	err := utils.ReadJSON(w, r, &payoutData)
+	if err != nil {
+		log.Error("CreatePaymentPayout: parse JSON ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Get vendor
+	vendor, err := database.Db.GetVendorByLicenseID(payoutData.VendorLicenseID)
+	if err != nil {
+		log.Error("CreatePaymentPayout: get vendor ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Get vendor account
+	vendorAccount, err := database.Db.GetAccountByVendorID(vendor.ID)
+	if err != nil {
+		log.Error("CreatePaymentPayout: get vendor account ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Get amount of money for payout
+	paymentsToBePaidOut, err := database.Db.ListPaymentsForPayout(payoutData.From, payoutData.To, payoutData.VendorLicenseID)
+	if err != nil {
+		log.Error("CreatePaymentPayout: list payments for payout ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var amount int
+	for _, payment := range paymentsToBePaidOut {
+		if payment.Receiver == vendorAccount.ID {
+			amount += payment.Amount
+		}
+		if payment.Sender == vendorAccount.ID {
+			amount -= payment.Amount
+		}
+	}
+
+	// Check that amount is bigger than 0
+	if amount <= 0 {
+		utils.ErrorJSON(w, errors.New("payout amount must be bigger than 0"), http.StatusBadRequest)
+		return
+	}
+
+	// Check if vendor has enough money
+	if vendorAccount.Balance < amount {
+		log.Error("CreatePaymentPayout: payout amount bigger than vendor account balance")
+		utils.ErrorJSON(w, errors.New("payout amount bigger than vendor account balance"), http.StatusBadRequest)
+		return
+	}
+
+	// Get authenticated user
+	authenticatedUserID := r.Header.Get("X-Auth-User-Name")
+
+	// Execute payout
+	paymentID, err := database.Db.CreatePaymentPayout(vendor, vendorAccount.ID, authenticatedUserID, amount, paymentsToBePaidOut)
+	if err != nil {
+		log.Error("CreatePaymentPayout: db", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	// Return success with paymentID
+	err = utils.WriteJSON(w, http.StatusOK, paymentID)
+	if err != nil {
+		log.Error("CreatePaymentPayout: finish:", err)
+	}
+	log.Infof("Payout of %d cents for vendor %s was successful", amount, vendor.LicenseID)
+
+}
+
+type webhookResponse struct {
+	Status string
+}
+
+// VivaWalletWebhookSuccess godoc
+//
+//	@Summary		Webhook for VivaWallet successful transaction
+//	@Description	Webhook for VivaWallet successful transaction
+//	@Tags			VivaWallet Webhooks
+//	@accept			json
+//	@Produce		json
+//	@Success		200
+//	@Param			data body paymentprovider.TransactionSuccessRequest true "Payment Successful Response"
+//	@Router			/webhooks/vivawallet/success/ [post]
+func VivaWalletWebhookSuccess(w http.ResponseWriter, r *http.Request) {
+
+	// Message to console that handler was entered
+	log.Info("Transaction Success Webhook entered")
+
+	var paymentSuccessful paymentprovider.TransactionSuccessRequest
+	err := utils.ReadJSON(w, r, &paymentSuccessful)
+	if err != nil {
+		log.Info("Reading JSON failed for webhook: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = paymentprovider.HandlePaymentSuccessfulResponse(paymentSuccessful)
+	if err != nil {
+		log.Error("VivaWalletWebhookSuccess: handle payment failed: ", err)
+		return
+	}
+
+	var response webhookResponse
+	response.Status = "OK"
+
+	err = utils.WriteJSON(w, http.StatusOK, response)
+	if err != nil {
+		log.Error("VivaWalletWebhookSuccess: write json: ", err)
+	}
+}
+
+// VivaWalletWebhookFailure godoc
+//
+//	@Summary		Webhook for VivaWallet failed transaction
+//	@Description	Webhook for VivaWallet failed transaction
+//	@Tags			VivaWallet Webhooks
+//	@accept			json
+//	@Produce		json
+//	@Success		200
+//	@Param			data body paymentprovider.TransactionSuccessRequest true "Payment Failure Response"
+//	@Router			/webhooks/vivawallet/failure/ [post]
+func VivaWalletWebhookFailure(w http.ResponseWriter, r *http.Request) {
+	var paymentFailure paymentprovider.TransactionSuccessRequest
+	err := utils.ReadJSON(w, r, &paymentFailure)
+	if err != nil {
+		log.Info("Reading JSON failed for webhook: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = paymentprovider.HandlePaymentFailureResponse(paymentFailure)
+	if err != nil {
+		log.Error("VivaWalletWebhookFailure: ", err)
+		return
+	}
+
+	var response webhookResponse
+	response.Status = "OK"
+
+	err = utils.WriteJSON(w, http.StatusOK, response)
+	if err != nil {
+		log.Error("VivaWalletWebhookFailure: ", err)
+	}
+}
+
+// VivaWalletWebhookPrice godoc
+//
+//	@Summary		Webhook for VivaWallet transaction prices
+//	@Description	Webhook for VivaWallet transaction prices
+//	@Tags			VivaWallet Webhooks
+//	@accept			json
+//	@Produce		json
+//	@Success		200
+//	@Param			data body paymentprovider.TransactionPriceRequest true "Payment Price Response"
+//	@Router			/webhooks/vivawallet/price/ [post]
+func VivaWalletWebhookPrice(w http.ResponseWriter, r *http.Request) {
+
+	// Message to console that handler was entered
+	log.Info("Transaction Price Webhook entered")
+
+	var paymentPrice paymentprovider.TransactionPriceRequest
+	err := utils.ReadJSON(w, r, &paymentPrice)
+	if err != nil {
+		log.Info("Reading JSON failed for webhook: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	err = paymentprovider.HandlePaymentPriceResponse(paymentPrice)
+	if err != nil {
+		log.Error("VivaWalletWebhookPrice: ", err)
+		return
+	}
+
+	var response webhookResponse
+	response.Status = "OK"
+
+	err = utils.WriteJSON(w, http.StatusOK, response)
+	if err != nil {
+		log.Error("VivaWalletWebhookPrice: ", err)
+	}
+}
+
+// VivaWalletVerificationKey godoc
+//
+//	@Summary		Return VivaWallet verification key
+//	@Description	Return VivaWallet verification key
+//	@Tags			VivaWallet Webhooks
+//	@accept			json
+//	@Produce		json
+//	@Success		200	{array}	paymentprovider.VivaWalletVerificationKeyResponse
+//	@Router			/webhooks/vivawallet/price/ [get]
+//	@Router 		/webhooks/vivawallet/success/ [get]
+//	@Router 		/webhooks/vivawallet/failure/ [get]
+func VivaWalletVerificationKey(w http.ResponseWriter, r *http.Request) {
+	key := config.Config.VivaWalletVerificationKey
+	if key == "" {
+		log.Error("VIVA_WALLET_VERIFICATION_KEY not set or can't be found")
+		utils.ErrorJSON(w, errors.New("VIVA_WALLET_VERIFICATION_KEY not set or can't be found"), http.StatusBadRequest)
+		return
+	}
+	response := paymentprovider.VivaWalletVerificationKeyResponse{Key: key}
+	err := utils.WriteJSON(w, http.StatusOK, response)
+	if err != nil {
+		log.Error("VivaWalletVerificationKey: ", err)
+	}
+}
+
+type KeycloakSettings struct {
+	Realm string
+	URL   string
+}
+type ExtendedSettings struct {
+	database.Settings
+	Keycloak KeycloakSettings
+}
+
+// Settings -------------------------------------------------------------------
+
+// getSettings godoc
+//
+//	 	@Summary 		Return settings
+//		@Description	Return configuration data of the system
+//		@Tags			Core
+//		@Accept			json
+//		@Produce		json
+//		@Success		200	{array}	database.Settings
+//		@Router			/settings/ [get]
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := database.Db.GetSettings()
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	exSettings := ExtendedSettings{
+		Settings: settings,
+		Keycloak: KeycloakSettings{
+			Realm: config.Config.KeycloakRealm,
+			URL:   config.Config.KeycloakHostname,
+		},
+	}
+	err = utils.WriteJSON(w, http.StatusOK, exSettings)
+	if err != nil {
+		log.Error("getSettings: ", err)
+	}
+}
+
+type Imagetype string
+
+const (
+	ImagetypeLogo    Imagetype = "Logo"
+	ImagetypeFavicon Imagetype = "Favicon"
+	ImagetypeQrCode  Imagetype = "QRCodeLogoImgUrl"
+)
+
+// settingsImageAliases are the stable URL paths of the images of the settings, which were used before uploads were content-addressed
+var settingsImageAliases = map[Imagetype]string{
+	ImagetypeLogo:    "img/logo.png",
+	ImagetypeFavicon: "img/favicon.png",
+	ImagetypeQrCode:  "img/qrcode.png",
+}
+
+// settingsImagePath returns the URL path of an image of the settings
+func settingsImagePath(settings database.Settings, fileType Imagetype) string {
+	switch fileType {
+	case ImagetypeLogo:
+		return settings.Logo
+	case ImagetypeFavicon:
+		return settings.Favicon
+	case ImagetypeQrCode:
+		return settings.QRCodeLogoImgUrl
+	}
+	return ""
+}
+
+// serveSettingsImage keeps the stable URL path of an image of the settings (e.g. /img/logo.png) working
+// for clients and emails that link to it. It redirects to the current content-addressed image.
+func serveSettingsImage(fileType Imagetype) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		alias := settingsImageAliases[fileType]
+		settings, err := database.Db.GetSettings()
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		current := settingsImagePath(settings, fileType)
+		if strings.TrimPrefix(current, "/") == alias || current == "" {
+			// Installations that never uploaded an image still have the file under the alias
+			serveBlob(w, r, alias)
+			return
+		}
+		if !strings.Contains(current, "://") {
+			current = "/" + strings.TrimPrefix(current, "/")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, current, http.StatusFound)
+	}
+}
+
+// isSettingsImageAlias returns true if the key is the stable alias of an image of the settings
+func isSettingsImageAlias(key string) bool {
+	for _, alias := range settingsImageAliases {
+		if key == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// removeReplacedSettingsImages removes the files of images of the settings that have been replaced by an upload
+// and are not used anymore. The stable aliases are kept.
+func removeReplacedSettingsImages(ctx context.Context, previous database.Settings) {
+	keys := []string{previous.Logo, previous.Favicon, previous.QRCodeLogoImgUrl}
+	for _, variants := range []map[string]string{previous.LogoVariants, previous.FaviconVariants} {
+		for _, path := range variants {
+			keys = append(keys, path)
+		}
+	}
+	var candidates []string
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "/")
+		if !strings.HasPrefix(key, "img/") || isSettingsImageAlias(key) {
+			continue
+		}
+		candidates = append(candidates, key)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	unused, err := database.Db.UnusedImageKeys(candidates)
+	if err != nil {
+		log.Error("removeReplacedSettingsImages: ", err)
+		return
+	}
+	for _, key := range unused {
+		err = storage.GetStorage().Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Error("removeReplacedSettingsImages: failed to remove ", key, ": ", err)
+		}
+	}
+}
+
+// updateSettingsImg stores an image of the settings as PNG and returns the URL paths of the image and its variants
+func updateSettingsImg(r *http.Request, fileType Imagetype, variants []imaging.Variant) (path string, variantPaths map[string]string, err error) {
+	key, variantKeys, err := saveFormImage(r, string(fileType), imaging.FormatPNG, variants)
+	if err != nil || key == "" {
+		return
+	}
+	// Settings contain the URL paths of the images
+	path = "/" + key
+	variantPaths = make(map[string]string, len(variantKeys))
+	for name, variantKey := range variantKeys {
+		variantPaths[name] = "/" + variantKey
+	}
+	log.Info("updateSettingsImg: saved ", fileType, " as ", key)
+	return
+}
+
+var updateSettingsMutex sync.Mutex
+
+// updateSettings godoc
+//
+//	 	@Summary 		Update settings
+//		@Description	Update configuration data of the system. Requires multipart form. Images (Logo, Favicon, QRCodeLogoImgUrl) are converted to png, favicons are generated from the logo
+//		@Tags			Core
+//		@Accept			json
+//		@Produce		json
+//	    @Param		    data body database.Settings true "Settings Representation"
+//		@Success		200
+//		@Security		KeycloakAuth
+//		@Router			/settings/ [put]
+func updateSettings(w http.ResponseWriter, r *http.Request) {
+	updateSettingsMutex.Lock()
+	defer updateSettingsMutex.Unlock()
+	var err error
+
+	// Read multipart form
+	err = r.ParseMultipartForm(32 << 20)
+	if err != nil {
+		log.Error("updateSettings: parse multipart:", err)
+		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		return
+	}
+	mForm := r.MultipartForm
+	if mForm == nil {
+		log.Error("updateSettings: ", errors.New("form is nil"))
+		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		return
+	}
+
+	// Handle normal fields
+	var settings database.Settings
+	fields := mForm.Value               // Values are stored in []string
+	fieldsClean := make(map[string]any) // Values are stored in string
+	for key, value := range fields {
+		if key == "MaxOrderAmount" {
+			fieldsClean[key], err = strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("MaxOrderAmount is not an integer")
+				utils.ErrorJSON(w, errors.New("MaxOrderAmount is not an integer"), http.StatusBadRequest)
+				return
+			}
+		} else if key == "OrgaCoversTransactionCosts" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("OrgaCoversTransactionCosts is not a boolean")
+				utils.ErrorJSON(w, errors.New("OrgaCoversTransactionCosts is not a boolean"), http.StatusBadRequest)
+				return
+			}
+		} else if key == "WebshopIsClosed" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("WebShopIsClosed is not a boolean")
+				utils.ErrorJSON(w, errors.New("WebShopIsClosed is not a boolean"), http.StatusBadRequest)
+				return
+			}
+		} else if key == "QRCodeEnableLogo" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("QRCodeEnableLogo is not a boolean")
+				utils.ErrorJSON(w, errors.New("QRCodeEnableLogo is not a boolean"), http.StatusBadRequest)
+				return
+			}
+		} else if key == "MainItem" {
+			value, err := strconv.Atoi(value[0])
+			if err != nil {
+				log.Error("MainItem is not an integer")
+				utils.ErrorJSON(w, errors.New("MainItem is not an integer"), http.StatusBadRequest)
+				return
+			}
+			fieldsClean[key] = null.NewInt(int64(value), true)
+		} else if key == "MapCenterLat" || key == "MapCenterLong" {
+			if s, err := strconv.ParseFloat(value[0], 64); err == nil {
+				fieldsClean[key] = (s)
+			} else {
+				fieldsClean[key] = 0.1
+			}
+		} else if key == "LogoVariants" || key == "FaviconVariants" {
+			continue // Generated from the uploaded logo
+		} else if key == "UseVendorLicenseIdInShop" {
+			fieldsClean[key], err = strconv.ParseBool(value[0])
+			if err != nil {
+				log.Error("WebShopIsClosed is not a boolean")
+				utils.ErrorJSON(w, errors.New("UseVendorLicenseIdInShop is not a boolean"), http.StatusBadRequest)
+				return
+			}
+		} else {
+			fieldsClean[key] = value[0]
+		}
+	}
+
+	err = mapstructure.Decode(fieldsClean, &settings)
+	if err != nil {
+		log.Error("updateSettings: ", err)
+		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		return
+	}
+	// update the logo, the favicons are generated from it
+	logoPath, logoVariants, err := updateSettingsImg(r, ImagetypeLogo, append(slices.Clone(imaging.ImageVariants), imaging.FaviconVariants...))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if logoPath != "" {
+		settings.Logo = logoPath
+		settings.FaviconVariants = make(map[string]string, len(imaging.FaviconVariants))
+		for _, variant := range imaging.FaviconVariants {
+			settings.FaviconVariants[variant.Name] = logoVariants[variant.Name]
+			delete(logoVariants, variant.Name)
+		}
+		settings.LogoVariants = logoVariants
+		log.Info("updateSettings: settings.Logo is ", settings.Logo)
+	}
+
+	// update the favicon
+	faviconPath, _, err := updateSettingsImg(r, ImagetypeFavicon, nil)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if faviconPath != "" {
+		settings.Favicon = faviconPath
+		log.Info("updateSettings: settings.Favicon is ", settings.Favicon)
+	}
+
+	// update the qrcode logo
+	qrcodePath, _, err := updateSettingsImg(r, ImagetypeQrCode, nil)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if qrcodePath != "" {
+		settings.QRCodeLogoImgUrl = qrcodePath
+		log.Info("updateSettings: settings.QRCodeLogoImgUrl is ", settings.QRCodeLogoImgUrl)
+	}
+
+	// Save settings to database
+	previous, err := database.Db.GetSettings()
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = database.Db.UpdateSettings(settings)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	removeReplacedSettingsImages(r.Context(), previous)
+	err = utils.WriteJSON(w, http.StatusOK, settings)
+	if err != nil {
+		log.Error("updateSettings: ", err)
+	}
+	log.Info("updateSettings: settings updated")
+}
+
+// Online Map -----------------------------------------------------------------
+
+// GetVendorLocations godoc
+//
+//	 	@Summary 		Get longitudes and latitudes of all vendors for online map
+//		@Description	Get every selling location of the vendors with their time slots for the online map. Vendors without locations are shown at the point of the vendor.
+//		@Description	With the parameter at only the locations with a time slot at this time are returned.
+//		@Tags			Map
+//		@Accept			json
+//		@Produce		json
+//		@Param			at query string false "now or a timestamp (RFC 3339)"
+//		@Security		KeycloakAuth
+//		@Success		200	{array}	database.LocationData
+//		@Router			/map/ [get]
+func GetVendorLocations(w http.ResponseWriter, r *http.Request) {
+	at, err := parseMapTime(r.URL.Query().Get("at"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	locationData, err := database.Db.GetVendorLocations(database.MapFilter{At: at})
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = utils.WriteJSON(w, http.StatusOK, locationData)
 	if err != nil {
-		log.Error("VerifyPaymentOrder: ", err)
+		log.Error("GetVendorLocations: ", err)
 	}
 }
 
-// Payments (from one account to another account) -----------------------------
+// parseMapTime reads the at parameter of the map, which is now or a timestamp
+func parseMapTime(value string) (at null.Time, err error) {
+	switch value {
+	case "":
+	case "now":
+		at = null.TimeFrom(time.Now())
+	default:
+		var t time.Time
+		t, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return at, errors.New("at has to be now or a timestamp")
+		}
+		at = null.TimeFrom(t)
+	}
+	return
+}
 
-func parseBool(value string) (bool, error) {
-	if value == "" {
-		return false, nil
+// Limits of the public map
+const (
+	publicMapMaxAge       = 5 * time.Minute // Browsers and proxies may cache the map for this time
+	publicMapNearestLimit = 10              // Default number of locations for near
+	publicMapMaxLimit     = 100
+)
+
+// publicMapFeature converts a location to a GeoJSON feature with the information shown on the public map
+func publicMapFeature(location database.LocationData) geo.Feature {
+	properties := map[string]any{
+		"firstName": location.FirstName,
+		"licenseID": location.LicenseID.String,
 	}
-	return strconv.ParseBool(value)
+	if location.Name != "" {
+		properties["name"] = location.Name
+	}
+	if location.Address != "" {
+		properties["address"] = location.Address
+	}
+	if location.TimeSlots != nil {
+		properties["timeSlots"] = location.TimeSlots
+	}
+	if location.WorkingTime != "" {
+		properties["workingTime"] = location.WorkingTime
+	}
+	return geo.NewFeature(location.Longitude, location.Latitude, properties)
 }
 
-// ListPaymentsForPayout godoc
+// GetPublicVendorMap godoc
 //
-//	 	@Summary 		Get list of all payments for payout
-//		@Description 	Payments that do not have an associated payout
-//		@Tags			Payments
-//		@Accept			json
-//		@Produce		json
-//		@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//		@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//		@Param			vendor query string false "Vendor LicenseID"
-//		@Success		200	{array}	database.Payment
-//		@Security		KeycloakAuth
-//		@Security		KeycloakAuth
-//		@Router			/payments/forpayout/ [get]
-func ListPaymentsForPayout(w http.ResponseWriter, r *http.Request) {
+//	@Summary		Get the public map of vendors as GeoJSON
+//	@Description	Only active vendors with a valid license that opted in to the online map are included, with first name, license ID, selling location and working times.
+//	@Description	The map can be cached by browsers and proxies for 5 minutes.
+//	@Tags			Map
+//	@Produce		json
+//	@Param			at query string false "now or a timestamp (RFC 3339), only locations with a time slot at this time"
+//	@Param			bbox query string false "min longitude, min latitude, max longitude, max latitude"
+//	@Param			near query string false "longitude, latitude: the nearest locations with their distance in meters, sorted by distance"
+//	@Param			limit query int false "Number of locations for near (default 10, at most 100)"
+//	@Param			zoom query int false "Zoom level of the map (0 - 20), close locations are combined into clusters"
+//	@Success		200	{object}	geo.FeatureCollection
+//	@Success		304
+//	@Router			/map/public/ [get]
+func GetPublicVendorMap(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 	var err error
-	minDateRaw := r.URL.Query().Get("from")
-	maxDateRaw := r.URL.Query().Get("to")
-	vendor := r.URL.Query().Get("vendor")
-	var minDate, maxDate time.Time
-	if minDateRaw != "" {
-		minDate, err = time.Parse(time.RFC3339, minDateRaw)
+	filter := database.MapFilter{Public: true}
+	filter.At, err = parseMapTime(query.Get("at"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	if query.Get("bbox") != "" {
+		filter.BoundingBox, err = geo.ParseBoundingBox(query.Get("bbox"))
 		if err != nil {
 			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
 		}
 	}
-	if maxDateRaw != "" {
-		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+	locations, err := database.Db.GetVendorLocations(filter)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	features := make([]geo.Feature, len(locations))
+	for i, location := range locations {
+		features[i] = publicMapFeature(location)
+	}
+
+	if query.Get("near") != "" {
+		longitude, latitude, err := geo.ParsePoint(query.Get("near"))
 		if err != nil {
 			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		limit := publicMapNearestLimit
+		if query.Get("limit") != "" {
+			limit, err = strconv.Atoi(query.Get("limit"))
+			if err != nil || limit < 1 || limit > publicMapMaxLimit {
+				utils.ErrorJSON(w, errors.New("limit has to be between 1 and "+strconv.Itoa(publicMapMaxLimit)), http.StatusBadRequest)
+				return
+			}
 		}
+		features = geo.Nearest(features, longitude, latitude, limit)
+	}
+	if query.Get("zoom") != "" {
+		zoom, err := strconv.Atoi(query.Get("zoom"))
+		if err != nil || zoom < 0 || zoom > geo.MaxZoom {
+			utils.ErrorJSON(w, errors.New("zoom has to be between 0 and "+strconv.Itoa(geo.MaxZoom)), http.StatusBadRequest)
+			return
+		}
+		features = geo.Cluster(features, zoom)
+	}
+
+	body, err := json.Marshal(geo.NewFeatureCollection(features))
+	if err != nil {
+		log.Error("GetPublicVendorMap: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(publicMapMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	if err != nil {
+		log.Error("GetPublicVendorMap: ", err)
 	}
-	payments, err := database.Db.ListPaymentsForPayout(minDate, maxDate, vendor)
-	respond(w, err, payments)
 }
 
-// ListPayments godoc
-//
-//		 	@Summary 		Get list of all payments
-//			@Description 	Filter by date, vendor, payouts, sales. If payouts set true, all payments are removed that are not payouts. Same for sales. So sales and payouts can't be true at the same time.
-//			@Tags			Payments
-//			@Accept			json
-//			@Produce		json
-//			@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//			@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//			@Param			vendor query string false "Vendor LicenseID"
-//	     @Param			payouts query bool false "Payouts only"
-//	     @Param          sales query bool false "Sales only"
-//			@Success		200	{array}	database.Payment
-//			@Security		KeycloakAuth
-//			@Security		KeycloakAuth
-//			@Router			/payments/ [get]
-func ListPayments(w http.ResponseWriter, r *http.Request) {
-	var err error
+// Files ----------------------------------------------------------------------
 
-	// Get filter parameters
-	minDateRaw := r.URL.Query().Get("from")
-	maxDateRaw := r.URL.Query().Get("to")
-	payoutRaw := r.URL.Query().Get("payouts")
-	salesRaw := r.URL.Query().Get("sales")
-	vendor := r.URL.Query().Get("vendor")
+// blobURLExpiry is the validity of URLs that downloads are redirected to
+const blobURLExpiry = 15 * time.Minute
 
-	// Parse filter parameters
-	payout, err := parseBool(payoutRaw)
+// serveBlob sends a file of the storage or redirects to it, if the storage supports direct downloads
+func serveBlob(w http.ResponseWriter, r *http.Request, key string) {
+	url, err := storage.GetStorage().URL(r.Context(), key, blobURLExpiry)
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Error("serveBlob: failed to create url for ", key, err)
+	}
+	if url != "" {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	obj, info, err := storage.GetStorage().Open(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.NotFound(w, r)
 		return
 	}
-	sales, err := parseBool(salesRaw)
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Error("serveBlob: failed to open ", key, err)
+		utils.ErrorJSON(w, errors.New("failed to read file"), http.StatusInternalServerError)
 		return
 	}
-	var minDate, maxDate time.Time
-	if minDateRaw != "" {
-		minDate, err = time.Parse(time.RFC3339, minDateRaw)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-		}
+	defer obj.Close()
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
 	}
-	if maxDateRaw != "" {
-		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+	http.ServeContent(w, r, path.Base(info.Key), info.ModTime, obj)
+}
+
+// serveStorage serves all files of the storage below prefix, e.g. the uploaded images under /img/
+func serveStorage(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := storage.CleanKey(prefix + "/" + chi.URLParam(r, "*"))
 		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			http.NotFound(w, r)
+			return
 		}
+		serveBlob(w, r, key)
 	}
-
-	// Get payments with filter parameters
-	payments, err := database.Db.ListPayments(minDate, maxDate, vendor, payout, sales, false)
-	respond(w, err, payments)
 }
 
-type ItemStatistics struct {
-	ID          int
-	Name        string
-	SumAmount   int
-	SumQuantity int
+// GetPDF godoc
+//
+//	@Summary		Get PDF path
+//	@Description	Get PDF path
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Success		200
+//	@Router			/pdf/ [get]
+func GetPDF(w http.ResponseWriter, r *http.Request) {
+	pdf, err := database.Db.GetPDF()
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = utils.WriteJSON(w, http.StatusOK, pdf)
+	if err != nil {
+		log.Error("GetPDF: ", err)
+	}
 }
 
-// PaymentsStatistics is the response to ListPaymentsStatistics
-type PaymentsStatistics struct {
-	From  time.Time
-	To    time.Time
-	Items []ItemStatistics
+// Download PDF from id
+func downloadPDF(w http.ResponseWriter, r *http.Request) {
+	// Get id from URL
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		log.Error("DownloadPDF: No id passed")
+		utils.ErrorJSON(w, errors.New("missing parameter id"), http.StatusBadRequest)
+		return
+	}
+	key, err := countPDFDownload(r, id)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	// The file is sent after the transaction, so that slow clients don't keep the link locked
+	serveBlob(w, r, key)
 }
 
-// ListPaymentsStatistics godoc
-//
-//	 	@Summary 		Calculate statistics of items & payments
-//		@Description 	Filter by date, get statistical information, sorted by item.
-//		@Tags			Payments
-//		@Accept			json
-//		@Produce		json
-//		@Param			from query string false "Minimum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//		@Param			to query string false "Maximum date (RFC3339, UTC)" example(2006-01-02T15:04:05Z)
-//		@Success		200	{array}	PaymentsStatistics
-//		@Security		KeycloakAuth
-//		@Security		KeycloakAuth
-//		@Router			/payments/statistics/ [get]
-func ListPaymentsStatistics(w http.ResponseWriter, r *http.Request) {
-	var err error
-
-	// Get filter parameters
-	minDateRaw := r.URL.Query().Get("from")
-	maxDateRaw := r.URL.Query().Get("to")
-
-	// Parse filter parameters
-	var minDate, maxDate time.Time
-	if minDateRaw != "" {
-		minDate, err = time.Parse(time.RFC3339, minDateRaw)
-		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
-		}
+// countPDFDownload checks the download policy of a link and counts the download.
+// The link is locked until the download is counted, so that parallel requests can't exceed the limits.
+// It returns the key of the file to send.
+func countPDFDownload(r *http.Request, id string) (key string, err error) {
+	tx, err := database.Db.Dbpool.Begin(context.Background())
+	if err != nil {
+		log.Error("UpdatePdfDownload: failed to start transaction ", err)
+		return
 	}
-	if maxDateRaw != "" {
-		maxDate, err = time.Parse(time.RFC3339, maxDateRaw)
+	defer func() {
+		err = database.DeferTx(tx, err)
 		if err != nil {
-			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			log.Error("DownloadPDF: failed to defer transaction ", err)
 		}
-	}
+	}()
 
-	// Get items
-	items, err := database.Db.ListItems(false, false)
+	// Get PDF from database
+	pdfDownload, err := database.Db.GetPDFDownloadForUpdateTx(tx, id)
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Error("DownloadPDF: Failed to get PDF download from database ", err)
 		return
 	}
-
-	// Get payments with filter parameters
-	payments, err := database.Db.ListPayments(minDate, maxDate, "", false, false, false)
+	ip := utils.ReadUserIP(r)
+	err = checkPDFDownload(pdfDownload, ip)
 	if err != nil {
-		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		log.Info("DownloadPDF: Download of ", id, " denied: ", err)
+		return
+	}
+	// Get PDF from database
+	pdf, err := database.Db.GetPDFByID(int64(pdfDownload.PDF))
+	if err != nil {
+		log.Error("DownloadPDF: Failed to get PDF from database ", err)
 		return
 	}
+	key = pdf.Path
+	if config.Config.PDFWatermark {
+		var stampErr error
+		key, stampErr = watermarkedPDF(r.Context(), &pdfDownload, pdf)
+		if stampErr != nil {
+			// The customer paid for the PDF, so the original is sent instead
+			log.Error("DownloadPDF: Failed to watermark PDF ", pdf.Path, stampErr)
+			key = pdf.Path
+		}
+	}
+	pdfDownload.DownloadCount = pdfDownload.DownloadCount + 1
+	pdfDownload.LastDownload = time.Now()
+	err = database.Db.UpdatePdfDownloadTx(tx, pdfDownload)
+	if err == nil {
+		err = database.Db.CreatePDFDownloadAccessTx(tx, pdfDownload, ip)
+	}
+	if err != nil {
+		log.Error("DownloadPDF: Failed to update downloadpdf ", err)
+	}
+	return
+}
 
-	// Create map of items
-	itemsMap := make(map[int]ItemStatistics)
-	for _, item := range items {
-		itemsMap[item.ID] = ItemStatistics{
-			ID:          item.ID,
-			Name:        item.Name,
-			SumAmount:   0,
-			SumQuantity: 0,
+// watermarkedPDF returns the key of the copy of a PDF that is stamped with the email of the license holder (or order code)
+// and the download time. The copy is created on the first download and reused afterwards.
+func watermarkedPDF(ctx context.Context, pdfDownload *database.PDFDownload, pdf database.PDF) (key string, err error) {
+	if pdfDownload.StampedPath != "" {
+		_, err = storage.GetStorage().Stat(ctx, pdfDownload.StampedPath)
+		if err == nil {
+			return pdfDownload.StampedPath, nil
 		}
 	}
 
-	// Create sums per item
-	for _, payment := range payments {
-		if !payment.Item.Valid {
-			continue
+	buyer := pdfDownload.LinkID
+	if pdfDownload.OrderID.Valid {
+		order, err := database.Db.GetOrderByID(int(pdfDownload.OrderID.Int64))
+		if err != nil {
+			return "", err
 		}
-		itemID := int(payment.Item.Int64)
-		if entry, ok := itemsMap[itemID]; ok {
-			entry.SumAmount += payment.Amount
-			entry.SumQuantity += payment.Quantity
-			itemsMap[itemID] = entry
+		if order.LicenseHolder() != "" {
+			buyer = order.LicenseHolder()
 		} else {
-			utils.ErrorJSON(w, errors.New("item not found"), http.StatusBadRequest)
-			return
+			buyer = order.OrderCode.String
 		}
 	}
+	text := buyer + " | " + time.Now().Format("02.01.2006 15:04")
 
-	// Create payment statistics
-	var paymentsStatistics PaymentsStatistics
-	paymentsStatistics.From = minDate
-	paymentsStatistics.To = maxDate
-	for _, item := range itemsMap {
-		paymentsStatistics.Items = append(paymentsStatistics.Items, item)
+	in, _, err := storage.GetStorage().Open(ctx, pdf.Path)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out := new(bytes.Buffer)
+	err = pdfgen.Watermark(in, out, text)
+	if err != nil {
+		return
 	}
+	key = "pdf/stamped/" + pdfDownload.LinkID + ".pdf"
+	err = storage.GetStorage().Put(ctx, key, out, int64(out.Len()), "application/pdf")
+	if err != nil {
+		return
+	}
+	pdfDownload.StampedPath = key
+	return key, nil
+}
 
-	respond(w, err, paymentsStatistics)
+// checkPDFDownload checks the download policy of a link (expiry, revocation, download and IP limits)
+func checkPDFDownload(pdfDownload database.PDFDownload, ip string) error {
+	if pdfDownload.Timestamp.IsZero() {
+		return errors.New("timestamp is zero")
+	}
+	err := pdfDownload.CheckPolicy(time.Now())
+	if err != nil {
+		return err
+	}
+	return database.Db.CheckPDFDownloadIP(pdfDownload, ip)
 }
 
-// CreatePayment godoc
-//
-//	 	@Summary 		Create a payment
-//		@Tags			Payments
-//		@Accept			json
-//		@Produce		json
-//		@Param			amount body database.Payment true " Create Payment"
-//		@Success		200
-func CreatePayment(w http.ResponseWriter, r *http.Request) {
-	var payment database.Payment
-	err := utils.ReadJSON(w, r, &payment)
+func validatePDFLink(w http.ResponseWriter, r *http.Request) {
+	// Get id from URL
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		log.Error("DownloadPDF: No id passed")
+		utils.ErrorJSON(w, errors.New("missing parameter id"), http.StatusBadRequest)
+		return
+	}
+
+	// Get PDF from database
+	pdfDownload, err := database.Db.GetPDFDownload(id)
 	if err != nil {
+		log.Error("DownloadPDF: Failed to get PDF from database ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	paymentID, err := database.Db.CreatePayment(payment)
+	err = checkPDFDownload(pdfDownload, utils.ReadUserIP(r))
 	if err != nil {
+		log.Info("validatePDFLink: Link ", id, " is not valid: ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	err = utils.WriteJSON(w, http.StatusOK, paymentID)
+	err = utils.WriteJSON(w, http.StatusOK, "valid")
 	if err != nil {
-		log.Error("CreatePayment: ", err)
+		log.Error("validatePDFLink: ", err)
 	}
 }
 
-type createPaymentsRequest struct {
-	Payments []database.Payment
+type resendPDFLinksRequest struct {
+	OrderCode string
+	Email     string
 }
 
-// CreatePayments godoc
+// ResendPDFLinks godoc
 //
-//	 	@Summary 		Create a set of payments
-//		@Description 	TODO: This handler is not working right now and to be done for manually setting payments
-//		@Tags			Payments
-//		@Accept			json
-//		@Produce		json
-//		@Param			amount body createPaymentsRequest true "Create Payment"
-//		@Success		200 {integer} id
-//		@Security		KeycloakAuth
-//		@Router			/payments/ [post]
-func CreatePayments(w http.ResponseWriter, r *http.Request) {
-	var paymentBatch createPaymentsRequest
-	err := utils.ReadJSON(w, r, &paymentBatch)
+//	@Summary		Resend PDF download links
+//	@Description	Sends the download links of an order again, if the email matches the email of the order.
+//	@Description	The response is the same whether links have been sent or not, so that it can't be used to check orders.
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Param			data body resendPDFLinksRequest true "Order code and email"
+//	@Success		200
+//	@Router			/pdf/resend/ [post]
+func ResendPDFLinks(w http.ResponseWriter, r *http.Request) {
+	var request resendPDFLinksRequest
+	err := utils.ReadJSON(w, r, &request)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	if request.OrderCode == "" || request.Email == "" {
+		utils.ErrorJSON(w, errors.New("order code and email are required"), http.StatusBadRequest)
+		return
+	}
+	count, err := database.Db.ResendPDFDownloadLinks(request.OrderCode, request.Email)
+	if err != nil {
+		log.Info("ResendPDFLinks: no links sent for order ", request.OrderCode, ": ", err)
+	} else {
+		log.Info("ResendPDFLinks: queued ", count, " links for order ", request.OrderCode)
+	}
+	err = utils.WriteJSON(w, http.StatusOK, "If the order exists, the download links have been sent to the email address")
+	if err != nil {
+		log.Error("ResendPDFLinks: ", err)
+	}
+}
 
-	err = database.Db.CreatePayments(paymentBatch.Payments)
+// ListPDFDownloads godoc
+//
+//	@Summary		List PDF download links of an order
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Param			order query string true "Order code"
+//	@Success		200	{array}	database.PDFDownload
+//	@Security		KeycloakAuth
+//	@Router			/pdf/downloads/ [get]
+func ListPDFDownloads(w http.ResponseWriter, r *http.Request) {
+	order, err := database.Db.GetOrderByOrderCode(r.URL.Query().Get("order"))
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	pdfDownloads, err := database.Db.GetPDFDownloadByOrderId(order.ID)
+	respond(w, err, pdfDownloads)
 }
 
-type createPaymentPayoutRequest struct {
-	VendorLicenseID string
-	From            time.Time
-	To              time.Time
+type extendPDFDownloadRequest struct {
+	Days      int // Days that are added to the validity
+	Downloads int // Additional downloads, if the number of downloads is limited
 }
 
-// CreatePaymentPayout godoc
+// ExtendPDFDownload godoc
 //
-//	 	@Summary 		Create a payment from a vendor account to cash
-//		@Tags			Payments
-//		@Accept			json
-//		@Produce		json
-//		@Param			amount body createPaymentPayoutRequest true "Create Payment"
-//		@Success		200 {integer} id
-//		@Security		KeycloakAuth
-//		@Router			/payments/payout/ [post]
-func CreatePaymentPayout(w http.ResponseWriter, r *http.Request) {
-
-	// Read data from request
-	var payoutData createPaymentPayoutRequest
-	err := utils.ReadJSON(w, r, &payoutData)
+//	@Summary		Extend PDF download link
+//	@Description	Extends the validity and download limit of a link. Revoked links are enabled again.
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path string true "Link ID"
+//	@Param			data body extendPDFDownloadRequest true "Extension"
+//	@Success		200	{object}	database.PDFDownload
+//	@Security		KeycloakAuth
+//	@Router			/pdf/downloads/{id}/extend/ [post]
+func ExtendPDFDownload(w http.ResponseWriter, r *http.Request) {
+	var request extendPDFDownloadRequest
+	err := utils.ReadJSON(w, r, &request)
 	if err != nil {
-		log.Error("CreatePaymentPayout: parse JSON ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	if request.Days < 0 || request.Downloads < 0 {
+		utils.ErrorJSON(w, errors.New("days and downloads must not be negative"), http.StatusBadRequest)
+		return
+	}
+	linkID := chi.URLParam(r, "id")
+	log.Info(r.Header.Get("X-Auth-User-Name")+" is extending pdf download ", linkID)
+	pdfDownload, err := database.Db.ExtendPDFDownload(linkID, request.Days, request.Downloads)
+	respond(w, err, pdfDownload)
+}
 
-	// Get vendor
-	vendor, err := database.Db.GetVendorByLicenseID(payoutData.VendorLicenseID)
+// RevokePDFDownload godoc
+//
+//	@Summary		Revoke PDF download link
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path string true "Link ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/pdf/downloads/{id}/revoke/ [post]
+func RevokePDFDownload(w http.ResponseWriter, r *http.Request) {
+	linkID := chi.URLParam(r, "id")
+	log.Info(r.Header.Get("X-Auth-User-Name")+" is revoking pdf download ", linkID)
+	err := database.Db.RevokePDFDownload(linkID)
 	if err != nil {
-		log.Error("CreatePaymentPayout: get vendor ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPDFRetentionReport godoc
+//
+//	@Summary		Get PDF retention report
+//	@Description	Report of the last run of the PDF retention job, null if it has not run yet
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	database.PDFRetentionReport
+//	@Security		KeycloakAuth
+//	@Router			/pdf/retention/ [get]
+func GetPDFRetentionReport(w http.ResponseWriter, r *http.Request) {
+	respond(w, nil, jobs.LastPDFRetentionReport())
+}
+
+// RunPDFRetention godoc
+//
+//	@Summary		Run PDF retention
+//	@Description	Removes expired download links, PDFs that are not needed anymore and orphaned files now
+//	@Tags			PDF
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	database.PDFRetentionReport
+//	@Security		KeycloakAuth
+//	@Router			/pdf/retention/ [post]
+func RunPDFRetention(w http.ResponseWriter, r *http.Request) {
+	log.Info(r.Header.Get("X-Auth-User-Name") + " is running the pdf retention")
+	report, err := jobs.PurgePDFs()
+	respond(w, err, report)
+}
+
+// UpdateCss godoc
+//
+//	@Summary 		Update CSS
+//	@Description	Gets a css as a string and saves it to the disk
+//	@Tags			Settings
+//	@Accept			txt
+//	@Produce		txt
+//	@Success		200
+//	@Router			/settings/css [put]
+
+func updateCSS(w http.ResponseWriter, r *http.Request) {
+
+	// Read data from request
+	body, err := io.ReadAll(r.Body)
 
-	// Get vendor account
-	vendorAccount, err := database.Db.GetAccountByVendorID(vendor.ID)
 	if err != nil {
-		log.Error("CreatePaymentPayout: get vendor account ", err)
+		log.Error("Reading body failed for css: ", err)
+		err = errors.New("failed to update css")
+
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	// Get amount of money for payout
-	paymentsToBePaidOut, err := database.Db.ListPaymentsForPayout(payoutData.From, payoutData.To, payoutData.VendorLicenseID)
+	// The CSS is always saved as style.css, since the frontend loads it from a fixed URL
+	err = storage.GetStorage().Put(r.Context(), "public/style.css", bytes.NewReader(body), int64(len(body)), "text/css")
 	if err != nil {
-		log.Error("CreatePaymentPayout: list payments for payout ", err)
+		log.Error("updateCSS: saving failed", err)
+		err = errors.New("failed to update css")
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	log.Info("updateCSS: success")
+}
 
-	var amount int
-	for _, payment := range paymentsToBePaidOut {
-		if payment.Receiver == vendorAccount.ID {
-			amount += payment.Amount
-		}
-		if payment.Sender == vendorAccount.ID {
-			amount -= payment.Amount
-		}
-	}
-
-	// Check that amount is bigger than 0
-	if amount <= 0 {
-		utils.ErrorJSON(w, errors.New("payout amount must be bigger than 0"), http.StatusBadRequest)
-		return
-	}
+// Emails ---------------------------------------------------------------------
 
-	// Check if vendor has enough money
-	if vendorAccount.Balance < amount {
-		log.Error("CreatePaymentPayout: payout amount bigger than vendor account balance")
-		utils.ErrorJSON(w, errors.New("payout amount bigger than vendor account balance"), http.StatusBadRequest)
+// ListQueuedEmails godoc
+//
+//	@Summary		List emails of the outbox
+//	@Description	List all queued and sent emails, newest first
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Param			failed query bool false "Only emails that could not be delivered yet"
+//	@Success		200	{array}	database.QueuedEmail
+//	@Security		KeycloakAuth
+//	@Router			/emails/ [get]
+func ListQueuedEmails(w http.ResponseWriter, r *http.Request) {
+	failedOnly, err := parseBool(r.URL.Query().Get("failed"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	emails, err := database.Db.ListQueuedEmails(failedOnly)
+	respond(w, err, emails)
+}
 
-	// Get authenticated user
-	authenticatedUserID := r.Header.Get("X-Auth-User-Name")
-
-	// Execute payout
-	paymentID, err := database.Db.CreatePaymentPayout(vendor, vendorAccount.ID, authenticatedUserID, amount, paymentsToBePaidOut)
+// ResendQueuedEmail godoc
+//
+//	@Summary		Resend email
+//	@Description	Reset the attempts of a queued email so that it is delivered again by the email worker
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Email ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/emails/{id}/resend/ [post]
+func ResendQueuedEmail(w http.ResponseWriter, r *http.Request) {
+	emailID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("CreatePaymentPayout: db", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Return success with paymentID
-	err = utils.WriteJSON(w, http.StatusOK, paymentID)
+	log.Info(r.Header.Get("X-Auth-User-Name")+" is resending email with id: ", emailID)
+	err = database.Db.ResendQueuedEmail(emailID)
 	if err != nil {
-		log.Error("CreatePaymentPayout: finish:", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	log.Infof("Payout of %d cents for vendor %s was successful", amount, vendor.LicenseID)
-
+	w.WriteHeader(http.StatusNoContent)
 }
 
-type webhookResponse struct {
-	Status string
+// ListEmailTemplates godoc
+//
+//	@Summary		List email templates
+//	@Description	List all localized email templates
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}	database.EmailTemplate
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/ [get]
+func ListEmailTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := database.Db.ListEmailTemplates()
+	respond(w, err, templates)
 }
 
-// VivaWalletWebhookSuccess godoc
+// GetEmailTemplate godoc
 //
-//	@Summary		Webhook for VivaWallet successful transaction
-//	@Description	Webhook for VivaWallet successful transaction
-//	@Tags			VivaWallet Webhooks
-//	@accept			json
+//	@Summary		Get email template
+//	@Tags			Emails
+//	@Accept			json
 //	@Produce		json
-//	@Success		200
-//	@Param			data body paymentprovider.TransactionSuccessRequest true "Payment Successful Response"
-//	@Router			/webhooks/vivawallet/success/ [post]
-func VivaWalletWebhookSuccess(w http.ResponseWriter, r *http.Request) {
-
-	// Message to console that handler was entered
-	log.Info("Transaction Success Webhook entered")
-
-	var paymentSuccessful paymentprovider.TransactionSuccessRequest
-	err := utils.ReadJSON(w, r, &paymentSuccessful)
+//	@Param			id path int true "Template ID"
+//	@Success		200	{object}	database.EmailTemplate
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/{id}/ [get]
+func GetEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Info("Reading JSON failed for webhook: ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	tmpl, err := database.Db.GetEmailTemplate(templateID)
+	respond(w, err, tmpl)
+}
+
+// validateEmailTemplate checks that a template has a name, a language and can be parsed
+func validateEmailTemplate(tmpl database.EmailTemplate) error {
+	if tmpl.Name == "" || tmpl.Language == "" {
+		return errors.New("name and language are required")
+	}
+	// Render with empty data to detect syntax errors
+	_, err := mailer.RenderTemplate(tmpl.MailerTemplate(), map[string]interface{}{})
+	return err
+}
 
-	err = paymentprovider.HandlePaymentSuccessfulResponse(paymentSuccessful)
+// CreateEmailTemplate godoc
+//
+//	@Summary		Create email template
+//	@Description	Create a localized email template. Subject and text body use text/template, the HTML body html/template syntax.
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Param			data body database.EmailTemplate true "Email template"
+//	@Success		200	{integer}	id
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/ [post]
+func CreateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	var tmpl database.EmailTemplate
+	err := utils.ReadJSON(w, r, &tmpl)
 	if err != nil {
-		log.Error("VivaWalletWebhookSuccess: handle payment failed: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	var response webhookResponse
-	response.Status = "OK"
-
-	err = utils.WriteJSON(w, http.StatusOK, response)
+	err = validateEmailTemplate(tmpl)
 	if err != nil {
-		log.Error("VivaWalletWebhookSuccess: write json: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	id, err := database.Db.CreateEmailTemplate(tmpl)
+	respond(w, err, id)
 }
 
-// VivaWalletWebhookFailure godoc
+// UpdateEmailTemplate godoc
 //
-//	@Summary		Webhook for VivaWallet failed transaction
-//	@Description	Webhook for VivaWallet failed transaction
-//	@Tags			VivaWallet Webhooks
-//	@accept			json
+//	@Summary		Update email template
+//	@Tags			Emails
+//	@Accept			json
 //	@Produce		json
+//	@Param			id path int true "Template ID"
+//	@Param			data body database.EmailTemplate true "Email template"
 //	@Success		200
-//	@Param			data body paymentprovider.TransactionSuccessRequest true "Payment Failure Response"
-//	@Router			/webhooks/vivawallet/failure/ [post]
-func VivaWalletWebhookFailure(w http.ResponseWriter, r *http.Request) {
-	var paymentFailure paymentprovider.TransactionSuccessRequest
-	err := utils.ReadJSON(w, r, &paymentFailure)
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/{id}/ [put]
+func UpdateEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	var tmpl database.EmailTemplate
+	err = utils.ReadJSON(w, r, &tmpl)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	err = validateEmailTemplate(tmpl)
 	if err != nil {
-		log.Info("Reading JSON failed for webhook: ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	err = database.Db.UpdateEmailTemplate(templateID, tmpl)
+	respond(w, err, tmpl)
+}
 
-	err = paymentprovider.HandlePaymentFailureResponse(paymentFailure)
+// DeleteEmailTemplate godoc
+//
+//	@Summary		Delete email template
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Param			id path int true "Template ID"
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/{id}/ [delete]
+func DeleteEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("VivaWalletWebhookFailure: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	var response webhookResponse
-	response.Status = "OK"
-
-	err = utils.WriteJSON(w, http.StatusOK, response)
+	err = database.Db.DeleteEmailTemplate(templateID)
 	if err != nil {
-		log.Error("VivaWalletWebhookFailure: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// VivaWalletWebhookPrice godoc
+// PreviewEmailTemplate godoc
 //
-//	@Summary		Webhook for VivaWallet transaction prices
-//	@Description	Webhook for VivaWallet transaction prices
-//	@Tags			VivaWallet Webhooks
-//	@accept			json
+//	@Summary		Preview email template
+//	@Description	Render an email template with the given sample data (e.g. {"URL": "https://example.com"})
+//	@Tags			Emails
+//	@Accept			json
 //	@Produce		json
-//	@Success		200
-//	@Param			data body paymentprovider.TransactionPriceRequest true "Payment Price Response"
-//	@Router			/webhooks/vivawallet/price/ [post]
-func VivaWalletWebhookPrice(w http.ResponseWriter, r *http.Request) {
-
-	// Message to console that handler was entered
-	log.Info("Transaction Price Webhook entered")
-
-	var paymentPrice paymentprovider.TransactionPriceRequest
-	err := utils.ReadJSON(w, r, &paymentPrice)
+//	@Param			id path int true "Template ID"
+//	@Param			data body map[string]interface{} false "Template data"
+//	@Success		200	{object}	mailer.Template
+//	@Security		KeycloakAuth
+//	@Router			/emails/templates/{id}/preview/ [post]
+func PreviewEmailTemplate(w http.ResponseWriter, r *http.Request) {
+	templateID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Info("Reading JSON failed for webhook: ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	err = paymentprovider.HandlePaymentPriceResponse(paymentPrice)
+	data := map[string]interface{}{}
+	if r.ContentLength != 0 {
+		err = utils.ReadJSON(w, r, &data)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	tmpl, err := database.Db.GetEmailTemplate(templateID)
 	if err != nil {
-		log.Error("VivaWalletWebhookPrice: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	rendered, err := mailer.RenderTemplate(tmpl.MailerTemplate(), data)
+	respond(w, err, rendered)
+}
 
-	var response webhookResponse
-	response.Status = "OK"
-
-	err = utils.WriteJSON(w, http.StatusOK, response)
-	if err != nil {
-		log.Error("VivaWalletWebhookPrice: ", err)
+// ListCapturedEmails godoc
+//
+//	@Summary		List captured emails (development only)
+//	@Description	List all emails captured by the in-memory mail transport (MAIL_TRANSPORT=memory)
+//	@Tags			Emails
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{array}	mailer.CapturedEmail
+//	@Security		KeycloakAuth
+//	@Router			/emails/captured/ [get]
+func ListCapturedEmails(w http.ResponseWriter, r *http.Request) {
+	memoryTransport, ok := mailer.GetTransport().(*mailer.MemoryTransport)
+	if !ok {
+		utils.ErrorJSON(w, errors.New("emails are not captured, set MAIL_TRANSPORT=memory"), http.StatusBadRequest)
+		return
 	}
+	respond(w, nil, memoryTransport.Emails())
 }
 
-// VivaWalletVerificationKey godoc
+// DeleteCapturedEmails godoc
 //
-//	@Summary		Return VivaWallet verification key
-//	@Description	Return VivaWallet verification key
-//	@Tags			VivaWallet Webhooks
-//	@accept			json
+//	@Summary		Delete captured emails (development only)
+//	@Tags			Emails
+//	@Accept			json
 //	@Produce		json
-//	@Success		200	{array}	paymentprovider.VivaWalletVerificationKeyResponse
-//	@Router			/webhooks/vivawallet/price/ [get]
-//	@Router 		/webhooks/vivawallet/success/ [get]
-//	@Router 		/webhooks/vivawallet/failure/ [get]
-func VivaWalletVerificationKey(w http.ResponseWriter, r *http.Request) {
-	key := config.Config.VivaWalletVerificationKey
-	if key == "" {
-		log.Error("VIVA_WALLET_VERIFICATION_KEY not set or can't be found")
-		utils.ErrorJSON(w, errors.New("VIVA_WALLET_VERIFICATION_KEY not set or can't be found"), http.StatusBadRequest)
+//	@Success		204
+//	@Security		KeycloakAuth
+//	@Router			/emails/captured/ [delete]
+func DeleteCapturedEmails(w http.ResponseWriter, r *http.Request) {
+	memoryTransport, ok := mailer.GetTransport().(*mailer.MemoryTransport)
+	if !ok {
+		utils.ErrorJSON(w, errors.New("emails are not captured, set MAIL_TRANSPORT=memory"), http.StatusBadRequest)
 		return
 	}
-	response := paymentprovider.VivaWalletVerificationKeyResponse{Key: key}
-	err := utils.WriteJSON(w, http.StatusOK, response)
-	if err != nil {
-		log.Error("VivaWalletVerificationKey: ", err)
-	}
+	memoryTransport.Reset()
+	w.WriteHeader(http.StatusNoContent)
 }
 
-type KeycloakSettings struct {
-	Realm string
-	URL   string
-}
-type ExtendedSettings struct {
-	database.Settings
-	Keycloak KeycloakSettings
-}
+// Data subjects (GDPR) -------------------------------------------------------
 
-// Settings -------------------------------------------------------------------
+type dataSubjectRequest struct {
+	Email      string
+	KeycloakID string
+}
 
-// getSettings godoc
+// ExportDataSubject godoc
 //
-//	 	@Summary 		Return settings
-//		@Description	Return configuration data of the system
-//		@Tags			Core
-//		@Accept			json
-//		@Produce		json
-//		@Success		200	{array}	database.Settings
-//		@Router			/settings/ [get]
-func getSettings(w http.ResponseWriter, r *http.Request) {
-	settings, err := database.Db.GetSettings()
-	if err != nil {
+//	@Summary		Export the data of a person (GDPR)
+//	@Description	Compiles everything that is stored about a person identified by email or Keycloak ID: Keycloak user with groups and roles, orders with entries, PDF download links and their downloads, emails, vendor record with documents, selling locations and license periods, and audit entries.
+//	@Description	With format=zip the data is returned as data.json in a ZIP archive together with the files of the vendor documents.
+//	@Tags			GDPR
+//	@Produce		json
+//	@Produce		application/zip
+//	@Param			email		query		string	false	"Email of the person"
+//	@Param			keycloakID	query		string	false	"Keycloak ID of the person"
+//	@Param			format		query		string	false	"json (default) or zip"
+//	@Success		200			{object}	gdpr.Export
+//	@Security		KeycloakAuth
+//	@Router			/gdpr/export/ [get]
+func ExportDataSubject(w http.ResponseWriter, r *http.Request) {
+	export, err := gdpr.Collect(r.URL.Query().Get("email"), r.URL.Query().Get("keycloakID"))
+	if errors.Is(err, gdpr.ErrNoSubject) {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	exSettings := ExtendedSettings{
-		Settings: settings,
-		Keycloak: KeycloakSettings{
-			Realm: config.Config.KeycloakRealm,
-			URL:   config.Config.KeycloakHostname,
-		},
-	}
-	err = utils.WriteJSON(w, http.StatusOK, exSettings)
 	if err != nil {
-		log.Error("getSettings: ", err)
+		log.Error("ExportDataSubject: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Info(r.Header.Get("X-Auth-User-Name"), " exported the data of ", export.Subject.Email, " ", export.Subject.KeycloakID)
+	w.Header().Set("Cache-Control", "private, no-store")
+	filename := "data-export-" + export.Timestamp.Format("2006-01-02")
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		respond(w, nil, export)
+	case "zip":
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		err = gdpr.WriteZip(w, export)
+		if err != nil {
+			log.Error("ExportDataSubject: zip ", err)
+		}
+	default:
+		utils.ErrorJSON(w, errors.New("format has to be json or zip"), http.StatusBadRequest)
 	}
 }
 
-type Imagetype string
-
-const (
-	ImagetypeLogo    Imagetype = "Logo"
-	ImagetypeFavicon Imagetype = "Favicon"
-	ImagetypeQrCode  Imagetype = "QRCodeLogoImgUrl"
-)
-
-func updateSettingsImg(w http.ResponseWriter, r *http.Request, fileType Imagetype) (path string, err error) {
-
-	// Get file from image field
-	file, header, err := r.FormFile(string(fileType))
+// EraseDataSubject godoc
+//
+//	@Summary		Erase the data of a person (GDPR)
+//	@Description	Deletes the Keycloak user, anonymizes the vendor record and removes email and user from orders, revokes download links and deletes IP addresses of downloads and emails of a person identified by Email or KeycloakID.
+//	@Description	Orders within the bookkeeping retention period (BOOKKEEPING_RETENTION_YEARS after the end of the year of the order) and audit entries are kept and listed in the report. Active vendors have to be offboarded first.
+//	@Tags			GDPR
+//	@Accept			json
+//	@Produce		json
+//	@Param			data	body		dataSubjectRequest	true	"Person"
+//	@Success		200		{object}	database.ErasureReport
+//	@Security		KeycloakAuth
+//	@Router			/gdpr/erase/ [post]
+func EraseDataSubject(w http.ResponseWriter, r *http.Request) {
+	var subject dataSubjectRequest
+	err := utils.ReadJSON(w, r, &subject)
 	if err != nil {
-		// Do not return error, as not passing a file is ok
-		// Could be improved by differentiating between not passed and invalid file
-		err = nil
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
-
-	// Debugging
-	name := strings.Split(header.Filename, ".")
-	if len(name) < 2 {
-		log.Error("updateSettingsLogo: file name to short", err)
-		utils.ErrorJSON(w, errors.New("invalid filename"), http.StatusBadRequest)
+	log.Info(r.Header.Get("X-Auth-User-Name"), " is erasing the data of ", subject.Email, " ", subject.KeycloakID)
+	report, err := gdpr.Erase(subject.Email, subject.KeycloakID)
+	if errors.Is(err, gdpr.ErrNoSubject) || errors.Is(err, gdpr.ErrActiveVendor) {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if name[len(name)-1] != "png" {
-		log.Error("updateSettingsLogo: wrong file ending: ", name[1])
-		utils.ErrorJSON(w, errors.New("file type must be png"), http.StatusBadRequest)
+	if err != nil {
+		log.Error("EraseDataSubject: ", err)
+		utils.ErrorJSON(w, err, http.StatusInternalServerError)
 		return
 	}
+	respond(w, nil, report)
+}
 
-	buf := bytes.NewBuffer(nil)
-	if _, err = io.Copy(buf, file); err != nil {
-		log.Error("updateSettingsLogo: copying file failed", err)
+// Customers (self-service) ---------------------------------------------------
+
+// verifiedEmailOf returns the email of the logged in user if Keycloak has verified it.
+// Users can register with the email of someone else, so unverified emails must not match orders.
+func verifiedEmailOf(r *http.Request) string {
+	if r.Header.Get("X-Auth-User-Email-Verified") != "true" {
+		return ""
+	}
+	return r.Header.Get("X-Auth-User-Email")
+}
+
+// customerOf returns the verified email and the Keycloak ID of the logged in customer.
+// Customers with an unverified email only see what belongs to their Keycloak ID.
+func customerOf(r *http.Request) (email string, keycloakID string, err error) {
+	email = verifiedEmailOf(r)
+	keycloakID = r.Header.Get("X-Auth-User")
+	if email == "" && keycloakID == "" {
+		err = errors.New("user has no verified email")
+	}
+	return
+}
+
+// ListMyOrders godoc
+//
+//	@Summary		List my orders
+//	@Description	Verified orders of the logged in customer (by email or user), newest first. Only the entries the customer paid for are included.
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{array}	database.CustomerOrder
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/orders/ [get]
+func ListMyOrders(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
+	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	orders, err := database.Db.ListCustomerOrders(email, keycloakID)
+	respond(w, err, orders)
+}
 
-	// Save file with name "logo"
-	switch fType := fileType; fType {
-	case "Logo":
-		path = "/img/logo.png"
-	case "Favicon":
-		path = "/img/favicon.png"
-	case "QRCodeLogoImgUrl":
-		path = "/img/qrcode.png"
-	}
-	dir, err := os.Getwd()
+// ListMyDonations godoc
+//
+//	@Summary		List my donations
+//	@Description	Donations within the verified orders of the logged in customer, newest first
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{array}	database.CustomerDonation
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/donations/ [get]
+func ListMyDonations(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
-		log.Error("updateSettingsLogo: couldn't get wd", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = os.WriteFile(dir+"/"+path, buf.Bytes(), 0666)
+	orders, err := database.Db.ListCustomerOrders(email, keycloakID)
 	if err != nil {
-		log.Error("updateSettingsLogo: saving failed", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	log.Info("updateSettingsLogo: saved file to ", dir+path)
-	return
+	donations := []database.CustomerDonation{}
+	for _, order := range orders {
+		donation := database.CustomerDonation{Order: order.ID, OrderCode: order.OrderCode, Timestamp: order.Timestamp}
+		for _, entry := range order.Entries {
+			if entry.Name == config.Config.DonationName {
+				donation.Amount += entry.Price * entry.Quantity
+			}
+		}
+		if donation.Amount > 0 {
+			donations = append(donations, donation)
+		}
+	}
+	respond(w, nil, donations)
 }
 
-var updateSettingsMutex sync.Mutex
-
-// updateSettings godoc
+// ListMyLicenses godoc
 //
-//	 	@Summary 		Update settings
-//		@Description	Update configuration data of the system. Requires multipart form. Logo has to be a png and will always be saved under "img/logo.png"
-//		@Tags			Core
-//		@Accept			json
-//		@Produce		json
-//	    @Param		    data body database.Settings true "Settings Representation"
-//		@Success		200
-//		@Security		KeycloakAuth
-//		@Router			/settings/ [put]
-func updateSettings(w http.ResponseWriter, r *http.Request) {
-	updateSettingsMutex.Lock()
-	defer updateSettingsMutex.Unlock()
-	var err error
-
-	// Read multipart form
-	err = r.ParseMultipartForm(32 << 20)
+//	@Summary		List my digital licenses
+//	@Description	Digital licenses the logged in customer bought or has been granted. A license is active while the user is in its license group.
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{array}	database.CustomerLicense
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/licenses/ [get]
+func ListMyLicenses(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
-		log.Error("updateSettings: parse multipart:", err)
-		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	mForm := r.MultipartForm
-	if mForm == nil {
-		log.Error("updateSettings: ", errors.New("form is nil"))
-		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+	licenses, err := database.Db.ListCustomerLicensePurchases(email, keycloakID)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-
-	// Handle normal fields
-	var settings database.Settings
-	fields := mForm.Value               // Values are stored in []string
-	fieldsClean := make(map[string]any) // Values are stored in string
-	for key, value := range fields {
-		if key == "MaxOrderAmount" {
-			fieldsClean[key], err = strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("MaxOrderAmount is not an integer")
-				utils.ErrorJSON(w, errors.New("MaxOrderAmount is not an integer"), http.StatusBadRequest)
-				return
-			}
-		} else if key == "OrgaCoversTransactionCosts" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("OrgaCoversTransactionCosts is not a boolean")
-				utils.ErrorJSON(w, errors.New("OrgaCoversTransactionCosts is not a boolean"), http.StatusBadRequest)
-				return
-			}
-		} else if key == "WebshopIsClosed" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("WebShopIsClosed is not a boolean")
-				utils.ErrorJSON(w, errors.New("WebShopIsClosed is not a boolean"), http.StatusBadRequest)
-				return
-			}
-		} else if key == "QRCodeEnableLogo" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("QRCodeEnableLogo is not a boolean")
-				utils.ErrorJSON(w, errors.New("QRCodeEnableLogo is not a boolean"), http.StatusBadRequest)
-				return
-			}
-		} else if key == "MainItem" {
-			value, err := strconv.Atoi(value[0])
-			if err != nil {
-				log.Error("MainItem is not an integer")
-				utils.ErrorJSON(w, errors.New("MainItem is not an integer"), http.StatusBadRequest)
-				return
-			}
-			fieldsClean[key] = null.NewInt(int64(value), true)
-		} else if key == "MapCenterLat" || key == "MapCenterLong" {
-			if s, err := strconv.ParseFloat(value[0], 64); err == nil {
-				fieldsClean[key] = (s)
-			} else {
-				fieldsClean[key] = 0.1
-			}
-		} else if key == "UseVendorLicenseIdInShop" {
-			fieldsClean[key], err = strconv.ParseBool(value[0])
-			if err != nil {
-				log.Error("WebShopIsClosed is not a boolean")
-				utils.ErrorJSON(w, errors.New("UseVendorLicenseIdInShop is not a boolean"), http.StatusBadRequest)
-				return
+	active := map[string]bool{}
+	if keycloakID != "" {
+		groups, err := keycloak.KeycloakClient.GetUserGroups(keycloakID)
+		if err != nil {
+			log.Error("ListMyLicenses: ", err)
+			utils.ErrorJSON(w, err, http.StatusInternalServerError)
+			return
+		}
+		for _, group := range groups {
+			if licenseGroup, ok := strings.CutPrefix(gocloak.PString(group.Path), keycloak.DigitalLicenseGroupPath("")); ok {
+				active[licenseGroup] = true
 			}
-		} else {
-			fieldsClean[key] = value[0]
 		}
 	}
+	for i := range licenses {
+		licenses[i].Active = active[licenses[i].LicenseGroup]
+		delete(active, licenses[i].LicenseGroup)
+	}
+	// Licenses that have been granted without a purchase
+	for licenseGroup := range active {
+		licenses = append(licenses, database.CustomerLicense{LicenseGroup: licenseGroup, Active: true})
+	}
+	slices.SortFunc(licenses, func(a, b database.CustomerLicense) int { return strings.Compare(a.LicenseGroup, b.LicenseGroup) })
+	for i := range licenses {
+		licenses[i].URL = config.Config.OnlinePaperUrl
+	}
+	respond(w, nil, licenses)
+}
 
-	err = mapstructure.Decode(fieldsClean, &settings)
+// ListMyPDFDownloads godoc
+//
+//	@Summary		List my PDF download links
+//	@Description	Download links of the verified orders of the logged in customer, newest first
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{array}	database.CustomerPDFDownload
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/downloads/ [get]
+func ListMyPDFDownloads(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
-		log.Error("updateSettings: ", err)
-		utils.ErrorJSON(w, errors.New("invalid form"), http.StatusBadRequest)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	// update the logo
-	logoPath, err := updateSettingsImg(w, r, ImagetypeLogo)
+	pdfDownloads, err := database.Db.ListCustomerPDFDownloads(email, keycloakID)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if logoPath != "" {
-		settings.Logo = logoPath
-		log.Info("updateSettings: settings.Logo is ", settings.Logo)
+	now := time.Now()
+	for i := range pdfDownloads {
+		pdfDownloads[i].URL = config.Config.FrontendURL + "/pdf/" + pdfDownloads[i].LinkID
+		pdfDownloads[i].Usable = pdfDownloads[i].CheckPolicy(now) == nil
 	}
+	respond(w, nil, pdfDownloads)
+}
 
-	// update the favicon
-	faviconPath, err := updateSettingsImg(w, r, ImagetypeFavicon)
+// ReissueMyPDFDownload godoc
+//
+//	@Summary		Reissue one of my PDF download links
+//	@Description	Replaces the link with a new one, which is emailed to the customer. The old link stops working and the validity period starts again. Revoked links can't be reissued.
+//	@Tags			Customers
+//	@Produce		json
+//	@Param			linkID	path		string	true	"Link ID"
+//	@Success		200		{object}	database.CustomerPDFDownload
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/downloads/{linkID}/reissue/ [post]
+func ReissueMyPDFDownload(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if faviconPath != "" {
-		settings.Favicon = faviconPath
-		log.Info("updateSettings: settings.Favicon is ", settings.Favicon)
+	if email == "" {
+		utils.ErrorJSON(w, errors.New("user has no verified email"), http.StatusBadRequest)
+		return
+	}
+	pdfDownload, err := database.Db.ReissuePDFDownload(chi.URLParam(r, "linkID"), email, keycloakID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorJSON(w, errors.New("download link not found"), http.StatusNotFound)
+		return
 	}
-
-	// update the qrcode logo
-	qrcodePath, err := updateSettingsImg(w, r, ImagetypeQrCode)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if qrcodePath != "" {
-		settings.QRCodeLogoImgUrl = qrcodePath
-		log.Info("updateSettings: settings.QRCodeLogoImgUrl is ", settings.QRCodeLogoImgUrl)
+	response := database.CustomerPDFDownload{
+		PDFDownload: pdfDownload,
+		URL:         config.Config.FrontendURL + "/pdf/" + pdfDownload.LinkID,
+		Usable:      pdfDownload.CheckPolicy(time.Now()) == nil,
 	}
+	respond(w, nil, response)
+}
 
-	// Save settings to database
-	err = database.Db.UpdateSettings(settings)
+// GetMyPreferences godoc
+//
+//	@Summary		Get my email preferences
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{object}	database.CustomerPreference
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/preferences/ [get]
+func GetMyPreferences(w http.ResponseWriter, r *http.Request) {
+	email := verifiedEmailOf(r)
+	if email == "" {
+		utils.ErrorJSON(w, errors.New("user has no verified email"), http.StatusBadRequest)
+		return
+	}
+	preference, err := database.Db.GetCustomerPreference(email)
+	respond(w, err, preference)
+}
+
+// UpdateMyPreferences godoc
+//
+//	@Summary		Update my email preferences
+//	@Description	Language of the emails (empty for the language of the order) and whether order confirmations are sent
+//	@Tags			Customers
+//	@Accept			json
+//	@Produce		json
+//	@Param			data	body		database.CustomerPreference	true	"Preferences, Email and Timestamp are ignored"
+//	@Success		200		{object}	database.CustomerPreference
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/preferences/ [put]
+func UpdateMyPreferences(w http.ResponseWriter, r *http.Request) {
+	email := verifiedEmailOf(r)
+	if email == "" {
+		utils.ErrorJSON(w, errors.New("user has no verified email"), http.StatusBadRequest)
+		return
+	}
+	var preference database.CustomerPreference
+	err := utils.ReadJSON(w, r, &preference)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, settings)
+	if len(preference.Language) > 10 {
+		utils.ErrorJSON(w, errors.New("invalid language"), http.StatusBadRequest)
+		return
+	}
+	preference.Email = email
+	err = database.Db.UpdateCustomerPreference(preference)
 	if err != nil {
-		log.Error("updateSettings: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	log.Info("updateSettings: settings updated")
+	preference, err = database.Db.GetCustomerPreference(email)
+	respond(w, err, preference)
 }
 
-// Online Map -----------------------------------------------------------------
-
-// GetVendorLocations godoc
+// ListMySubscriptions godoc
 //
-//	 	@Summary 		Get longitudes and latitudes of all vendors for online map
-//		@Description	Get longitudes and latitudes of all vendors for online map
-//		@Tags			Map
-//		@Accept			json
-//		@Produce		json
-//		@Security		KeycloakAuth
-//		@Success		200	{array}	database.LocationData
-//		@Router			/map/ [get]
-func GetVendorLocations(w http.ResponseWriter, r *http.Request) {
-	locationData, err := database.Db.GetVendorLocations()
+//	@Summary		List my subscriptions
+//	@Tags			Customers
+//	@Produce		json
+//	@Success		200	{array}	database.Subscription
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/subscriptions/ [get]
+func ListMySubscriptions(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, locationData)
-	if err != nil {
-		log.Error("GetVendorLocations: ", err)
-	}
+	subscriptions, err := database.Db.ListCustomerSubscriptions(email, keycloakID)
+	respond(w, err, subscriptions)
 }
 
-// GetPDF godoc
+// CancelMySubscription godoc
 //
-//	@Summary		Get PDF path
-//	@Description	Get PDF path
-//	@Tags			PDF
-//	@Accept			json
+//	@Summary		Cancel one of my subscriptions
+//	@Description	Stops the renewal reminders. The subscription stays valid until ValidUntil, renewing it resumes it.
+//	@Tags			Customers
 //	@Produce		json
-//	@Success		200
-//	@Router			/pdf/ [get]
-func GetPDF(w http.ResponseWriter, r *http.Request) {
-	pdf, err := database.Db.GetPDF()
+//	@Param			id	path		int	true	"Subscription ID"
+//	@Success		200	{object}	database.Subscription
+//	@Security		KeycloakAuth
+//	@Router			/customers/me/subscriptions/{id}/cancel/ [post]
+func CancelMySubscription(w http.ResponseWriter, r *http.Request) {
+	email, keycloakID, err := customerOf(r)
 	if err != nil {
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, pdf)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("GetPDF: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	subscription, err := database.Db.CancelSubscription(id, email, keycloakID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorJSON(w, errors.New("subscription not found"), http.StatusNotFound)
+		return
 	}
+	respond(w, err, subscription)
 }
 
-// Download PDF from id
-func downloadPDF(w http.ResponseWriter, r *http.Request) {
-	// Get id from URL
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		log.Error("DownloadPDF: No id passed")
-		utils.ErrorJSON(w, errors.New("missing parameter id"), http.StatusBadRequest)
+// Subscriptions --------------------------------------------------------------
+
+// ListSubscriptions godoc
+//
+//	@Summary		List subscriptions
+//	@Tags			Subscriptions
+//	@Produce		json
+//	@Param			email	query	string	false	"Email of the customer"
+//	@Success		200		{array}	database.Subscription
+//	@Security		KeycloakAuth
+//	@Router			/subscriptions/ [get]
+func ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := database.Db.ListSubscriptions(r.URL.Query().Get("email"))
+	respond(w, err, subscriptions)
+}
+
+// subscriptionRenewal is the subscription of a renewal link
+type subscriptionRenewal struct {
+	ItemName   string
+	Period     string
+	Price      int // Price of one period in cents
+	ValidUntil time.Time
+	Expired    bool // The customer has no access anymore
+}
+
+// getRenewalSubscription returns the subscription of the token of a renewal link
+func getRenewalSubscription(w http.ResponseWriter, r *http.Request) (subscription database.Subscription, ok bool) {
+	subscription, err := database.Db.GetSubscriptionByToken(chi.URLParam(r, "token"))
+	if err != nil {
+		utils.ErrorJSON(w, errors.New("subscription not found"), http.StatusNotFound)
+		return subscription, false
+	}
+	return subscription, true
+}
+
+// GetSubscriptionRenewal godoc
+//
+//	@Summary		Get the subscription of a renewal link
+//	@Tags			Subscriptions
+//	@Produce		json
+//	@Param			token	path		string	true	"Token of the renewal link"
+//	@Success		200		{object}	subscriptionRenewal
+//	@Router			/subscriptions/renew/{token}/ [get]
+func GetSubscriptionRenewal(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := getRenewalSubscription(w, r)
+	if !ok {
 		return
 	}
-	tx, err := database.Db.Dbpool.Begin(context.Background())
+	item, err := database.Db.GetItem(subscription.Item)
 	if err != nil {
-		log.Error("UpdatePdfDownload: failed to start transaction ", err)
+		utils.ErrorJSON(w, errors.New("the subscription is not available anymore"), http.StatusBadRequest)
 		return
 	}
-	defer func() {
-		err = database.DeferTx(tx, err)
+	respond(w, nil, subscriptionRenewal{
+		ItemName:   subscription.ItemName,
+		Period:     subscription.Period,
+		Price:      item.Price,
+		ValidUntil: subscription.ValidUntil,
+		Expired:    subscription.ExpiredAt.Valid,
+	})
+}
+
+// RenewSubscription godoc
+//
+//	@Summary		Renew a subscription
+//	@Description	Creates a payment order for one period of the subscription of a renewal link. The subscription is extended when the order is verified.
+//	@Description	The order is sold by the vendor of the last order unless another vendor is given.
+//	@Tags			Subscriptions
+//	@Produce		json
+//	@Param			token	path		string	true	"Token of the renewal link"
+//	@Param			vendor	query		string	false	"License ID of the vendor"
+//	@Success		200		{object}	createOrderResponse
+//	@Router			/subscriptions/renew/{token}/ [post]
+func RenewSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, ok := getRenewalSubscription(w, r)
+	if !ok {
+		return
+	}
+	vendorLicenseID := r.URL.Query().Get("vendor")
+	if vendorLicenseID == "" {
+		vendor, err := database.Db.GetVendor(subscription.Vendor)
 		if err != nil {
-			log.Error("DownloadPDF: failed to defer transaction ", err)
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
 		}
-	}()
+		vendorLicenseID = vendor.LicenseID.String
+	}
+	response, err := placePaymentOrder(r, createOrderRequest{
+		Entries:         []createOrderRequestEntry{{Item: subscription.Item, Quantity: 1}},
+		User:            subscription.UserID,
+		VendorLicenseID: vendorLicenseID,
+		CustomerEmail:   null.StringFrom(subscription.CustomerEmail),
+		Language:        subscription.Language,
+	})
+	respond(w, err, response)
+}
 
-	// Get PDF from database
-	pdfDownload, err := database.Db.GetPDFDownloadTx(tx, id)
+// License grants -------------------------------------------------------------
+
+// ListLicenseGrants godoc
+//
+//	@Summary		List license grants
+//	@Description	Access to the license groups of digital license items bought with orders
+//	@Tags			Licenses
+//	@Produce		json
+//	@Param			email	query	string	false	"Email of the customer"
+//	@Param			active	query	bool	false	"Only grants that have not expired"
+//	@Success		200		{array}	database.LicenseGrant
+//	@Security		KeycloakAuth
+//	@Router			/licenses/grants/ [get]
+func ListLicenseGrants(w http.ResponseWriter, r *http.Request) {
+	grants, err := database.Db.ListLicenseGrants(r.URL.Query().Get("email"), r.URL.Query().Get("active") == "true")
+	respond(w, err, grants)
+}
+
+type extendLicenseGrantRequest struct {
+	Days int // Days added to the end of the grant, grants that ended are extended from now on
+}
+
+// ExtendLicenseGrant godoc
+//
+//	@Summary		Extend license grant
+//	@Description	Extends the access of a grant. Expired grants are active again and the customer is added to the license group again.
+//	@Tags			Licenses
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Grant ID"
+//	@Param			data	body		extendLicenseGrantRequest	true	"Extension"
+//	@Success		200		{object}	database.LicenseGrant
+//	@Security		KeycloakAuth
+//	@Router			/licenses/grants/{id}/extend/ [post]
+func ExtendLicenseGrant(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("DownloadPDF: Failed to get PDF download from database ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if pdfDownload.Timestamp.IsZero() {
-		log.Error("DownloadPDF: Timestamp is zero")
-		utils.ErrorJSON(w, errors.New("timestamp is zero"), http.StatusBadRequest)
+	var request extendLicenseGrantRequest
+	err = utils.ReadJSON(w, r, &request)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	// check for expiration < 6 weeks
-	if time.Until(pdfDownload.Timestamp).Hours() < -6*7*24 {
-		log.Error("DownloadPDF: PDF is expired")
-		utils.ErrorJSON(w, errors.New("pdf is expired"), http.StatusBadRequest)
+	if request.Days < 0 {
+		utils.ErrorJSON(w, errors.New("days must not be negative"), http.StatusBadRequest)
+		return
+	}
+	log.Info(r.Header.Get("X-Auth-User-Name")+" is extending license grant ", id, " by ", request.Days, " days")
+	grant, err := database.Db.ExtendLicenseGrant(id, request.Days)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorJSON(w, errors.New("license grant not found"), http.StatusNotFound)
 		return
 	}
-	// Get PDF from database
-	pdf, err := database.Db.GetPDFByID(int64(pdfDownload.PDF))
 	if err != nil {
-		log.Error("DownloadPDF: Failed to get PDF from database ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	pdfDownload.DownloadCount = pdfDownload.DownloadCount + 1
-	pdfDownload.LastDownload = time.Now()
-	err = database.Db.UpdatePdfDownloadTx(tx, pdfDownload)
-	pdfDownload, err = database.Db.GetPDFDownloadTx(tx, id)
+	userID := grant.UserID
+	if userID == "" {
+		userID, err = keycloak.KeycloakClient.GetOrCreateUser(grant.CustomerEmail)
+		if err != nil {
+			utils.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+	err = keycloak.KeycloakClient.AssignDigitalLicenseGroup(userID, grant.LicenseGroup)
+	respond(w, err, grant)
+}
 
-	if err != nil {
-		log.Error("DownloadPDF: Failed to update downloadpdf ", err)
+// Vouchers -------------------------------------------------------------------
+
+const (
+	maxVoucherCodes      = 10000
+	voucherCodeLength    = 10
+	voucherCodeAlphabet  = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // Without characters that are easily confused
+	minVoucherCodeLength = 3
+	maxVoucherCodeLength = 32
+)
+
+type createVoucherRequest struct {
+	database.Voucher
+	Code  string // Code of the voucher, e.g. for a campaign, codes are generated if empty
+	Count int    // Number of generated codes, e.g. single use codes, default 1
+}
+
+// validateVoucher checks the discount and validity of a voucher
+func validateVoucher(voucher database.Voucher) error {
+	if strings.TrimSpace(voucher.Name) == "" {
+		return errors.New("name is required")
+	}
+	switch voucher.Type {
+	case database.VoucherFixed:
+		if voucher.Amount <= 0 {
+			return errors.New("amount has to be positive")
+		}
+	case database.VoucherPercent:
+		if voucher.Percent < 1 || voucher.Percent > 100 {
+			return errors.New("percent has to be between 1 and 100")
+		}
+	case database.VoucherFreeItem:
+		if !voucher.FreeItem.Valid {
+			return errors.New("free item is required")
+		}
+		_, err := database.Db.GetItem(int(voucher.FreeItem.Int64))
+		if err != nil {
+			return errors.New("free item does not exist")
+		}
+	default:
+		return errors.New("type has to be fixed, percent or free_item")
+	}
+	if voucher.ValidFrom.Valid && voucher.ValidUntil.Valid && !voucher.ValidUntil.Time.After(voucher.ValidFrom.Time) {
+		return errors.New("valid until has to be after valid from")
 	}
-	// send file
-	http.ServeFile(w, r, pdf.Path)
+	if voucher.MaxUses < 0 {
+		return errors.New("max uses must not be negative")
+	}
+	return nil
 }
-func validatePDFLink(w http.ResponseWriter, r *http.Request) {
-	// Get id from URL
-	id := chi.URLParam(r, "id")
-	if id == "" {
-		log.Error("DownloadPDF: No id passed")
-		utils.ErrorJSON(w, errors.New("missing parameter id"), http.StatusBadRequest)
-		return
+
+// voucherCodes returns the given code in upper case or count generated codes
+func voucherCodes(code string, count int) ([]string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code != "" {
+		if count > 1 {
+			return nil, errors.New("count can only be used for generated codes")
+		}
+		if len(code) < minVoucherCodeLength || len(code) > maxVoucherCodeLength {
+			return nil, fmt.Errorf("code has to be between %d and %d characters", minVoucherCodeLength, maxVoucherCodeLength)
+		}
+		for _, c := range code {
+			if !(c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return nil, errors.New("code may only contain letters, digits and dashes")
+			}
+		}
+		return []string{code}, nil
+	}
+	if count == 0 {
+		count = 1
+	}
+	if count < 0 || count > maxVoucherCodes {
+		return nil, fmt.Errorf("count has to be between 1 and %d", maxVoucherCodes)
+	}
+	codes := make([]string, count)
+	b := make([]byte, voucherCodeLength)
+	for i := range codes {
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		for j := range b {
+			b[j] = voucherCodeAlphabet[int(b[j])%len(voucherCodeAlphabet)]
+		}
+		codes[i] = string(b)
 	}
+	return codes, nil
+}
 
-	// Get PDF from database
-	pdfDownload, err := database.Db.GetPDFDownload(id)
+// ListVouchers godoc
+//
+//	@Summary		List vouchers
+//	@Description	Vouchers with their number of codes and uses in verified orders
+//	@Tags			Vouchers
+//	@Produce		json
+//	@Success		200	{array}	database.Voucher
+//	@Security		KeycloakAuth
+//	@Router			/vouchers/ [get]
+func ListVouchers(w http.ResponseWriter, r *http.Request) {
+	vouchers, err := database.Db.ListVouchers()
+	respond(w, err, vouchers)
+}
+
+// CreateVoucher godoc
+//
+//	@Summary		Create voucher
+//	@Description	Creates a voucher with a given code or with Count generated codes. MaxUses limits the uses of each code, 0 is unlimited.
+//	@Tags			Vouchers
+//	@Accept			json
+//	@Produce		json
+//	@Param			data	body		createVoucherRequest	true	"Voucher"
+//	@Success		200		{object}	database.Voucher
+//	@Security		KeycloakAuth
+//	@Router			/vouchers/ [post]
+func CreateVoucher(w http.ResponseWriter, r *http.Request) {
+	var request createVoucherRequest
+	err := utils.ReadJSON(w, r, &request)
 	if err != nil {
-		log.Error("DownloadPDF: Failed to get PDF from database ", err)
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	if pdfDownload.Timestamp.IsZero() {
-		log.Error("DownloadPDF: Timestamp is zero")
-		utils.ErrorJSON(w, errors.New("timestamp is zero"), http.StatusBadRequest)
+	err = validateVoucher(request.Voucher)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	// check for expiration < 6 weeks
-	if time.Until(pdfDownload.Timestamp).Hours() < -6*7*24 {
-		log.Error("DownloadPDF: PDF is expired")
-		utils.ErrorJSON(w, errors.New("pdf is expired"), http.StatusBadRequest)
+	codes, err := voucherCodes(request.Code, request.Count)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = utils.WriteJSON(w, http.StatusOK, "valid")
+	request.CreatedBy = r.Header.Get("X-Auth-User-Name")
+	log.Info(request.CreatedBy+" is creating voucher "+request.Name+" with ", len(codes), " codes")
+	id, err := database.Db.CreateVoucher(request.Voucher, codes)
 	if err != nil {
-		log.Error("validatePDFLink: ", err)
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
+	voucher, err := database.Db.GetVoucher(id)
+	respond(w, err, voucher)
 }
 
-// UpdateCss godoc
+// GetVoucher godoc
 //
-//	@Summary 		Update CSS
-//	@Description	Gets a css as a string and saves it to the disk
-//	@Tags			Settings
-//	@Accept			txt
-//	@Produce		txt
-//	@Success		200
-//	@Router			/settings/css [put]
-
-func updateCSS(w http.ResponseWriter, r *http.Request) {
-
-	// Read data from request
-	body, err := io.ReadAll(r.Body)
-
+//	@Summary		Get voucher
+//	@Tags			Vouchers
+//	@Produce		json
+//	@Param			id	path		int	true	"Voucher ID"
+//	@Success		200	{object}	database.Voucher
+//	@Security		KeycloakAuth
+//	@Router			/vouchers/{id}/ [get]
+func GetVoucher(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("Reading body failed for css: ", err)
-		err = errors.New("failed to update css")
-
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
+	voucher, err := database.Db.GetVoucher(id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorJSON(w, errors.New("voucher not found"), http.StatusNotFound)
+		return
+	}
+	respond(w, err, voucher)
+}
 
-	// Save file with name "style.css"
-	path := "/public/style.css"
-	dir, err := os.Getwd()
+// UpdateVoucher godoc
+//
+//	@Summary		Update voucher
+//	@Description	Updates the discount and validity of a voucher, e.g. to disable it. The codes stay the same.
+//	@Tags			Vouchers
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Voucher ID"
+//	@Param			data	body		database.Voucher	true	"Voucher"
+//	@Success		200		{object}	database.Voucher
+//	@Security		KeycloakAuth
+//	@Router			/vouchers/{id}/ [put]
+func UpdateVoucher(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Error("updateCSS: couldn't get wd", err)
-		err = errors.New("failed to update css")
-
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
-	err = os.WriteFile(dir+path, body, 0666)
+	var voucher database.Voucher
+	err = utils.ReadJSON(w, r, &voucher)
 	if err != nil {
-		log.Error("updateCSS: saving failed", err)
-		err = errors.New("failed to update css")
 		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
 	}
-	log.Info("updateCSS: success")
+	voucher.ID = id
+	err = validateVoucher(voucher)
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	log.Info(r.Header.Get("X-Auth-User-Name")+" is updating voucher ", id)
+	err = database.Db.UpdateVoucher(voucher)
+	if errors.Is(err, pgx.ErrNoRows) {
+		utils.ErrorJSON(w, errors.New("voucher not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	voucher, err = database.Db.GetVoucher(id)
+	respond(w, err, voucher)
+}
+
+// ListVoucherCodes godoc
+//
+//	@Summary		List voucher codes
+//	@Description	Codes of a voucher with their uses, e.g. to distribute generated codes
+//	@Tags			Vouchers
+//	@Produce		json
+//	@Param			id	path	int	true	"Voucher ID"
+//	@Success		200	{array}	database.VoucherCode
+//	@Security		KeycloakAuth
+//	@Router			/vouchers/{id}/codes/ [get]
+func ListVoucherCodes(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+	codes, err := database.Db.ListVoucherCodes(id)
+	respond(w, err, codes)
 }
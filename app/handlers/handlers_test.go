@@ -1,14 +1,27 @@
 package handlers
 
 import (
+	"archive/zip"
 	"augustin/config"
 	"augustin/database"
+	"augustin/gdpr"
+	"augustin/geo"
+	"augustin/jobs"
 	"augustin/keycloak"
+	"augustin/mailer"
+	"augustin/middlewares"
 	"augustin/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
 	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strconv"
 	"strings"
@@ -27,6 +40,7 @@ var adminUser string
 var adminUserEmail string
 var adminUserToken *gocloak.JWT
 var mutex_test sync.Mutex
+var capturedEmails *mailer.MemoryTransport
 
 // TestMain is executed before all tests and initializes an empty database
 func TestMain(m *testing.M) {
@@ -38,6 +52,10 @@ func TestMain(m *testing.M) {
 	}
 	config.InitConfig()
 
+	// Capture emails instead of sending them
+	capturedEmails = mailer.NewMemoryTransport()
+	mailer.SetTransport(capturedEmails)
+
 	// Initialize database and empty it
 	// Note: Emptying does not work in Github Actions
 	err = database.Db.InitEmptyTestDb()
@@ -65,6 +83,10 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Errorf("TestMain: Assign role failed: %v \n", err)
 	}
+	err = keycloak.KeycloakClient.AssignRole(adminUser, middlewares.VendorDocumentsRole)
+	if err != nil {
+		log.Errorf("TestMain: Assign role failed: %v \n", err)
+	}
 	adminUserToken, err = keycloak.KeycloakClient.GetUserToken(adminUserEmail, "password")
 	if err != nil {
 		log.Errorf("TestMain: Login failed: %v \n", err)
@@ -178,6 +200,33 @@ func TestVendors(t *testing.T) {
 	require.Equal(t, 16.363449, mapData[0].Longitude)
 	require.Equal(t, 48.210033, mapData[0].Latitude)
 
+	// QR code
+	utils.TestRequest(t, r, "GET", "/api/vendors/"+vendorID+"/qrcode/", nil, 401)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/qrcode/?size=256", nil, 200, adminUserToken)
+	require.Equal(t, "image/png", res.Header().Get("Content-Type"))
+	img, _, err := image.Decode(res.Body)
+	utils.CheckError(t, err)
+	require.Equal(t, 256, img.Bounds().Dx())
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/qrcode/?format=svg", nil, 200, adminUserToken)
+	require.True(t, strings.HasPrefix(res.Body.String(), "<svg"))
+	utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/qrcode/?size=99999", nil, 400, adminUserToken)
+
+	// Photo and badges
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	photo, _ := writer.CreateFormFile("Photo", "photo.jpg")
+	photo.Write(testImageFile(t, "jpeg", 300, 400))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "POST", "/api/vendors/"+vendorID+"/photo/", body, writer.FormDataContentType(), 204, adminUserToken)
+	vendorIDInt, _ := strconv.Atoi(vendorID)
+	vendor, err = database.Db.GetVendor(vendorIDInt)
+	utils.CheckError(t, err)
+	require.True(t, strings.HasPrefix(vendor.Photo, "vendor/"))
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/badge/", nil, 200, adminUserToken)
+	require.Equal(t, "application/pdf", res.Header().Get("Content-Type"))
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/badges/", nil, 200, adminUserToken)
+	require.True(t, strings.HasPrefix(res.Body.String(), "%PDF"))
+
 	// Delete
 	utils.TestRequestWithAuth(t, r, "DELETE", "/api/vendors/"+vendorID+"/", nil, 204, adminUserToken)
 	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/", nil, 200, adminUserToken)
@@ -190,6 +239,1085 @@ func TestVendors(t *testing.T) {
 
 }
 
+func TestVendorDocuments(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testdocuments"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+	documentsURL := "/api/vendors/" + vendorID + "/documents/"
+
+	// Upload
+	utils.TestRequest(t, r, "GET", documentsURL, nil, 401)
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	writer.WriteField("Type", "agreement")
+	writer.WriteField("ExpiresAt", "2030-01-31")
+	file, _ := writer.CreateFormFile("File", "agreement.pdf")
+	file.Write([]byte("%PDF-1.4 signed agreement"))
+	writer.Close()
+	res := utils.TestRequestMultiPartWithAuth(t, r, "POST", documentsURL, body, writer.FormDataContentType(), 200, adminUserToken)
+	var document database.VendorDocument
+	err = json.Unmarshal(res.Body.Bytes(), &document)
+	utils.CheckError(t, err)
+	require.Equal(t, "agreement", document.Type)
+	require.Equal(t, "application/pdf", document.ContentType)
+	require.Equal(t, "2030-01-31", document.ExpiresAt.Time.Format("2006-01-02"))
+
+	// Invalid type and file
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	writer.WriteField("Type", "passport")
+	file, _ = writer.CreateFormFile("File", "passport.pdf")
+	file.Write([]byte("%PDF-1.4"))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "POST", documentsURL, body, writer.FormDataContentType(), 400, adminUserToken)
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	writer.WriteField("Type", "id")
+	file, _ = writer.CreateFormFile("File", "id.pdf")
+	file.Write([]byte("<html>not a pdf</html>"))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "POST", documentsURL, body, writer.FormDataContentType(), 400, adminUserToken)
+
+	// Listed on the vendor
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/", nil, 200, adminUserToken)
+	var vendor database.Vendor
+	err = json.Unmarshal(res.Body.Bytes(), &vendor)
+	utils.CheckError(t, err)
+	require.Len(t, vendor.Documents, 1)
+	require.Equal(t, document.ID, vendor.Documents[0].ID)
+
+	// Download
+	documentURL := documentsURL + strconv.Itoa(document.ID) + "/"
+	res = utils.TestRequestWithAuth(t, r, "GET", documentURL, nil, 200, adminUserToken)
+	require.Equal(t, "%PDF-1.4 signed agreement", res.Body.String())
+	require.Equal(t, `attachment; filename="agreement.pdf"`, res.Header().Get("Content-Disposition"))
+
+	// Update and delete
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	writer.WriteField("Type", "registration")
+	writer.WriteField("DeleteAt", "2031-01-01")
+	writer.Close()
+	res = utils.TestRequestMultiPartWithAuth(t, r, "PUT", documentURL, body, writer.FormDataContentType(), 200, adminUserToken)
+	err = json.Unmarshal(res.Body.Bytes(), &document)
+	utils.CheckError(t, err)
+	require.Equal(t, "registration", document.Type)
+	require.Equal(t, "2031-01-01", document.DeleteAt.Time.Format("2006-01-02"))
+	utils.TestRequestWithAuth(t, r, "DELETE", documentURL, nil, 204, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "GET", documentURL, nil, 404, adminUserToken)
+}
+
+func TestVendorLicenses(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testlicenses"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+	licensesURL := "/api/vendors/" + vendorID + "/licenses/"
+	itemID := CreateTestItem(t, "testlicensesItem", 20, "", "")
+	order := `{"entries": [{"item": ` + itemID + `, "quantity": 1}], "vendorLicenseID": "` + vendorLicenseId + `"}`
+
+	// Vendors without license periods are not restricted
+	utils.TestRequest(t, r, "GET", "/api/vendors/check/"+vendorLicenseId+"/", nil, 200)
+
+	// Lapsed license
+	today := time.Now()
+	lapsed := `{"StartDate": "` + today.AddDate(-1, 0, 0).Format("2006-01-02") + `", "EndDate": "` + today.AddDate(0, 0, -1).Format("2006-01-02") + `", "Comment": "first year"}`
+	res := utils.TestRequestStrWithAuth(t, r, "POST", licensesURL, lapsed, 200, adminUserToken)
+	var license database.VendorLicense
+	err = json.Unmarshal(res.Body.Bytes(), &license)
+	utils.CheckError(t, err)
+	require.Equal(t, "first year", license.Comment)
+	utils.TestRequest(t, r, "GET", "/api/vendors/check/"+vendorLicenseId+"/", nil, 400)
+	res = utils.TestRequestStr(t, r, "POST", "/api/orders/", order, 400)
+	require.Equal(t, `{"error":{"message":"the license of the vendor has expired"}}`, res.Body.String())
+
+	// Overlapping and invalid periods
+	utils.TestRequestStrWithAuth(t, r, "POST", licensesURL, lapsed, 400, adminUserToken)
+	utils.TestRequestStrWithAuth(t, r, "POST", licensesURL, `{"StartDate": "2030-02-01", "EndDate": "2030-01-01"}`, 400, adminUserToken)
+
+	// Renewal starts today, since the license has lapsed
+	renewal := `{"EndDate": "` + today.AddDate(0, 0, 10).Format("2006-01-02") + `"}`
+	res = utils.TestRequestStrWithAuth(t, r, "POST", licensesURL, renewal, 200, adminUserToken)
+	err = json.Unmarshal(res.Body.Bytes(), &license)
+	utils.CheckError(t, err)
+	require.Equal(t, today.Format("2006-01-02"), license.StartDate.Format("2006-01-02"))
+	utils.TestRequest(t, r, "GET", "/api/vendors/check/"+vendorLicenseId+"/", nil, 200)
+
+	res = utils.TestRequestWithAuth(t, r, "GET", licensesURL, nil, 200, adminUserToken)
+	var licenses []database.VendorLicense
+	err = json.Unmarshal(res.Body.Bytes(), &licenses)
+	utils.CheckError(t, err)
+	require.Len(t, licenses, 2)
+	require.Equal(t, license.ID, licenses[0].ID)
+
+	// Expiring licenses
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/licenses/expiring/?days=30", nil, 200, adminUserToken)
+	var expiring []database.ExpiringVendorLicense
+	err = json.Unmarshal(res.Body.Bytes(), &expiring)
+	utils.CheckError(t, err)
+	require.Len(t, expiring, 1)
+	require.Equal(t, vendorLicenseId, expiring[0].LicenseID.String)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/licenses/expiring/?days=5", nil, 200, adminUserToken)
+	require.Equal(t, "null", res.Body.String())
+
+	// Correct the end date
+	utils.TestRequestStrWithAuth(t, r, "PUT", licensesURL+strconv.Itoa(license.ID)+"/", `{"EndDate": "`+today.AddDate(1, 0, 0).Format("2006-01-02")+`"}`, 200, adminUserToken)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/", nil, 200, adminUserToken)
+	var vendor database.Vendor
+	err = json.Unmarshal(res.Body.Bytes(), &vendor)
+	utils.CheckError(t, err)
+	require.True(t, vendor.LicenseValid)
+	require.Equal(t, today.AddDate(1, 0, 0).Format("2006-01-02"), vendor.LicenseEndDate.Time.Format("2006-01-02"))
+}
+
+func TestVendorLocations(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testlocations"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+	locationsURL := "/api/vendors/" + vendorID + "/locations/"
+
+	// Invalid time slots and coordinates
+	utils.TestRequestStrWithAuth(t, r, "POST", locationsURL, `{"Name": "Station", "TimeSlots": [{"Weekday": 8, "StartTime": "08:00", "EndTime": "10:00"}]}`, 400, adminUserToken)
+	utils.TestRequestStrWithAuth(t, r, "POST", locationsURL, `{"Name": "Station", "TimeSlots": [{"Weekday": 1, "StartTime": "10:00", "EndTime": "08:00"}]}`, 400, adminUserToken)
+	utils.TestRequestStrWithAuth(t, r, "POST", locationsURL, `{"Name": "Station", "Latitude": 100}`, 400, adminUserToken)
+
+	// Location that is open all week
+	var slots []string
+	for weekday := 1; weekday <= 7; weekday++ {
+		slots = append(slots, `{"Weekday": `+strconv.Itoa(weekday)+`, "StartTime": "00:00", "EndTime": "24:00"}`)
+	}
+	location := `{"Name": "Station", "Address": "Bahnhofplatz 1", "PLZ": "1100", "Longitude": 16.37, "Latitude": 48.18, "TimeSlots": [` + strings.Join(slots, ",") + `]}`
+	res := utils.TestRequestStrWithAuth(t, r, "POST", locationsURL, location, 200, adminUserToken)
+	locationID := res.Body.String()
+
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/"+vendorID+"/", nil, 200, adminUserToken)
+	var vendor database.Vendor
+	err = json.Unmarshal(res.Body.Bytes(), &vendor)
+	utils.CheckError(t, err)
+	require.Len(t, vendor.Locations, 1)
+	require.Equal(t, "Station", vendor.Locations[0].Name)
+	require.Len(t, vendor.Locations[0].TimeSlots, 7)
+	require.Equal(t, "24:00", vendor.Locations[0].TimeSlots[0].EndTime)
+
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/map/?at=now", nil, 200, adminUserToken)
+	var locations []database.LocationData
+	err = json.Unmarshal(res.Body.Bytes(), &locations)
+	utils.CheckError(t, err)
+	require.Len(t, locations, 1)
+	require.Equal(t, "Station", locations[0].Name)
+	utils.TestRequestWithAuth(t, r, "GET", "/api/map/?at=tomorrow", nil, 400, adminUserToken)
+
+	// Without time slots the location is not shown for a time
+	utils.TestRequestStrWithAuth(t, r, "PUT", locationsURL+locationID+"/", `{"Name": "Station"}`, 200, adminUserToken)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/map/?at=now", nil, 200, adminUserToken)
+	require.Equal(t, "null", res.Body.String())
+
+	utils.TestRequestWithAuth(t, r, "DELETE", locationsURL+locationID+"/", nil, 204, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "DELETE", locationsURL+locationID+"/", nil, 404, adminUserToken)
+}
+
+func TestPublicVendorMap(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testpublicmap"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+
+	// Vendors have to opt in
+	res := utils.TestRequest(t, r, "GET", "/api/map/public/", nil, 200)
+	require.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, res.Body.String())
+	id, err := strconv.Atoi(vendorID)
+	utils.CheckError(t, err)
+	vendor, err := database.Db.GetVendor(id)
+	utils.CheckError(t, err)
+	vendor.OnlineMap = true
+	vendor.WorkingTime = "M"
+	vendor.Address = "Private street 1"
+	err = database.Db.UpdateVendor(id, vendor)
+	utils.CheckError(t, err)
+
+	res = utils.TestRequest(t, r, "GET", "/api/map/public/?near=16.37,48.21&limit=5", nil, 200)
+	// The postal address of a vendor is not public
+	require.NotContains(t, res.Body.String(), vendor.Address)
+	require.Equal(t, "application/geo+json", res.Header().Get("Content-Type"))
+	require.Equal(t, "public, max-age=300", res.Header().Get("Cache-Control"))
+	var collection geo.FeatureCollection
+	err = json.Unmarshal(res.Body.Bytes(), &collection)
+	utils.CheckError(t, err)
+	require.Len(t, collection.Features, 1)
+	require.Equal(t, vendorLicenseId, collection.Features[0].Properties["licenseID"])
+	require.Equal(t, "M", collection.Features[0].Properties["workingTime"])
+	require.NotContains(t, collection.Features[0].Properties, "lastName")
+	require.NotContains(t, collection.Features[0].Properties, "address")
+	require.Greater(t, collection.Features[0].Properties["distance"], 0.0)
+
+	// Unchanged maps are not sent again
+	req, err := http.NewRequest("GET", "/api/map/public/?near=16.37,48.21&limit=5", nil)
+	utils.CheckError(t, err)
+	req.Header.Set("If-None-Match", res.Header().Get("ETag"))
+	utils.SubmitRequestAndCheckResponse(t, req, r, 304)
+
+	// Filters
+	res = utils.TestRequest(t, r, "GET", "/api/map/public/?bbox=0,0,1,1", nil, 200)
+	require.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, res.Body.String())
+	utils.TestRequest(t, r, "GET", "/api/map/public/?bbox=1,1,0,0", nil, 400)
+	utils.TestRequest(t, r, "GET", "/api/map/public/?zoom=30", nil, 400)
+	utils.TestRequest(t, r, "GET", "/api/map/public/?near=16.37,48.21&limit=1000", nil, 400)
+	res = utils.TestRequest(t, r, "GET", "/api/map/public/?zoom=5", nil, 200)
+	err = json.Unmarshal(res.Body.Bytes(), &collection)
+	utils.CheckError(t, err)
+	require.Len(t, collection.Features, 1)
+
+	// Disabled vendors are hidden
+	vendor.IsDisabled = true
+	err = database.Db.UpdateVendor(id, vendor)
+	utils.CheckError(t, err)
+	res = utils.TestRequest(t, r, "GET", "/api/map/public/", nil, 200)
+	require.JSONEq(t, `{"type": "FeatureCollection", "features": []}`, res.Body.String())
+}
+
+// vendorSyncIssues returns the types of the issues of a vendor in the sync report
+func vendorSyncIssues(t *testing.T, vendorID int) (types []string) {
+	res := utils.TestRequestWithAuth(t, r, "GET", "/api/vendors/sync/", nil, 200, adminUserToken)
+	var issues []jobs.VendorSyncIssue
+	err := json.Unmarshal(res.Body.Bytes(), &issues)
+	utils.CheckError(t, err)
+	for _, issue := range issues {
+		if issue.VendorID == vendorID {
+			types = append(types, issue.Type)
+		}
+	}
+	return types
+}
+
+func TestVendorSync(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testsync"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+	id, err := strconv.Atoi(vendorID)
+	utils.CheckError(t, err)
+	vendor, err := database.Db.GetVendor(id)
+	utils.CheckError(t, err)
+
+	// New users only have the email as first name
+	require.Equal(t, []string{jobs.VendorSyncName}, vendorSyncIssues(t, id))
+	repair := `{"Type": "` + jobs.VendorSyncName + `", "VendorID": ` + vendorID + `, "KeycloakID": "` + vendor.KeycloakID + `"}`
+	utils.TestRequestStrWithAuth(t, r, "POST", "/api/vendors/sync/repair/", repair, 200, adminUserToken)
+	require.Empty(t, vendorSyncIssues(t, id))
+	utils.TestRequestStrWithAuth(t, r, "POST", "/api/vendors/sync/repair/", repair, 404, adminUserToken)
+
+	// Stale Keycloak ID and missing group
+	err = database.Db.UpdateVendorKeycloakID(id, "stale")
+	utils.CheckError(t, err)
+	err = keycloak.KeycloakClient.UnassignGroup(vendor.KeycloakID, config.Config.KeycloakVendorGroup)
+	utils.CheckError(t, err)
+	require.Equal(t, []string{jobs.VendorSyncStaleKeycloakID, jobs.VendorSyncMissingGroup}, vendorSyncIssues(t, id))
+	for _, issueType := range []string{jobs.VendorSyncStaleKeycloakID, jobs.VendorSyncMissingGroup} {
+		repair = `{"Type": "` + issueType + `", "VendorID": ` + vendorID + `, "KeycloakID": "` + vendor.KeycloakID + `"}`
+		utils.TestRequestStrWithAuth(t, r, "POST", "/api/vendors/sync/repair/", repair, 200, adminUserToken)
+	}
+	require.Empty(t, vendorSyncIssues(t, id))
+	vendor, err = database.Db.GetVendor(id)
+	utils.CheckError(t, err)
+	require.NotEqual(t, "stale", vendor.KeycloakID)
+
+	// Disabled vendors are disabled in Keycloak
+	vendor.IsDisabled = true
+	err = database.Db.UpdateVendor(id, vendor)
+	utils.CheckError(t, err)
+	require.Equal(t, []string{jobs.VendorSyncEnabled}, vendorSyncIssues(t, id))
+	repair = `{"Type": "` + jobs.VendorSyncEnabled + `", "VendorID": ` + vendorID + `, "KeycloakID": "` + vendor.KeycloakID + `"}`
+	utils.TestRequestStrWithAuth(t, r, "POST", "/api/vendors/sync/repair/", repair, 200, adminUserToken)
+	user, err := keycloak.KeycloakClient.GetUserByID(vendor.KeycloakID)
+	utils.CheckError(t, err)
+	require.False(t, *user.Enabled)
+}
+
+func TestVendorOffboarding(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseId := "testoffboarding"
+	vendorEmail := vendorLicenseId + "@example.com"
+	keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	defer keycloak.KeycloakClient.DeleteUser(vendorEmail)
+	vendorID := createTestVendor(t, vendorLicenseId)
+	id, err := strconv.Atoi(vendorID)
+	utils.CheckError(t, err)
+	vendor, err := database.Db.GetVendor(id)
+	utils.CheckError(t, err)
+	vendorAccount, err := database.Db.GetAccountByVendorID(id)
+	utils.CheckError(t, err)
+	anonUserAccount, err := database.Db.GetAccountByType("UserAnon")
+	utils.CheckError(t, err)
+
+	// Vendors that owe money can't be offboarded
+	_, err = database.Db.CreatePayment(database.Payment{Sender: vendorAccount.ID, Receiver: anonUserAccount.ID, Amount: 100})
+	utils.CheckError(t, err)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/vendors/"+vendorID+"/offboard/", nil, 400, adminUserToken)
+
+	// The open balance is paid out
+	_, err = database.Db.CreatePayment(database.Payment{Sender: anonUserAccount.ID, Receiver: vendorAccount.ID, Amount: 500, IsSale: true})
+	utils.CheckError(t, err)
+	res := utils.TestRequestWithAuth(t, r, "POST", "/api/vendors/"+vendorID+"/offboard/", nil, 200, adminUserToken)
+	var offboarding offboardVendorResponse
+	err = json.Unmarshal(res.Body.Bytes(), &offboarding)
+	utils.CheckError(t, err)
+	require.Equal(t, 400, offboarding.PayoutAmount)
+	require.True(t, offboarding.PayoutID.Valid)
+	require.Equal(t, time.Now().AddDate(0, 0, config.Config.VendorAnonymizeAfterDays).Format(time.DateOnly), offboarding.AnonymizeAt.Format(time.DateOnly))
+	vendorAccount, err = database.Db.GetAccountByVendorID(id)
+	utils.CheckError(t, err)
+	require.Equal(t, 0, vendorAccount.Balance)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/vendors/"+vendorID+"/offboard/", nil, 404, adminUserToken)
+
+	// The login is disabled and the vendor is deleted
+	user, err := keycloak.KeycloakClient.GetUserByID(vendor.KeycloakID)
+	utils.CheckError(t, err)
+	require.False(t, *user.Enabled)
+	_, err = database.Db.GetVendor(id)
+	require.Error(t, err)
+	require.Empty(t, vendorSyncIssues(t, id))
+
+	// Personal data is anonymized after the retention period, the ledger is kept
+	count, err := jobs.AnonymizeVendors(time.Now())
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	count, err = jobs.AnonymizeVendors(offboarding.AnonymizeAt)
+	utils.CheckError(t, err)
+	require.Equal(t, 1, count)
+	vendors, err := database.Db.ListVendorIdentities()
+	utils.CheckError(t, err)
+	require.Len(t, vendors, 1)
+	require.Equal(t, vendorLicenseId, vendors[0].LicenseID.String)
+	require.Empty(t, vendors[0].Email)
+	require.Empty(t, vendors[0].FirstName)
+	require.Empty(t, vendors[0].KeycloakID)
+	_, err = keycloak.KeycloakClient.GetUserByID(vendor.KeycloakID)
+	require.True(t, keycloak.IsNotFound(err))
+	payout, err := database.Db.GetPayment(int(offboarding.PayoutID.Int64))
+	utils.CheckError(t, err)
+	require.Equal(t, 400, payout.Amount)
+	require.Equal(t, vendorLicenseId, payout.SenderName.String)
+	count, err = jobs.AnonymizeVendors(offboarding.AnonymizeAt)
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+}
+
+func TestDataSubject(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	email := "testgdpr@example.com"
+	keycloak.KeycloakClient.DeleteUser(email)
+	defer keycloak.KeycloakClient.DeleteUser(email)
+	keycloak.KeycloakClient.DeleteUser("testgdprvendor@example.com")
+	defer keycloak.KeycloakClient.DeleteUser("testgdprvendor@example.com")
+	userID, err := keycloak.KeycloakClient.CreateUser(email, "Test", "Customer", email, "password")
+	utils.CheckError(t, err)
+	vendorID, err := strconv.Atoi(createTestVendor(t, "testgdprvendor"))
+	utils.CheckError(t, err)
+
+	// One order within the bookkeeping retention period and one older order
+	recentOrderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testgdpr1"), Vendor: vendorID, CustomerEmail: null.StringFrom(email)})
+	utils.CheckError(t, err)
+	oldOrderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testgdpr2"), Vendor: vendorID, CustomerEmail: null.StringFrom(email)})
+	utils.CheckError(t, err)
+	_, err = database.Db.Dbpool.Exec(context.Background(), "UPDATE PaymentOrder SET Timestamp = $1 WHERE ID = $2", time.Now().AddDate(-config.Config.BookkeepingRetentionYears-1, 0, 0), oldOrderID)
+	utils.CheckError(t, err)
+	_, err = database.Db.QueueEmail(email, "test", "de", nil)
+	utils.CheckError(t, err)
+
+	// Export
+	utils.TestRequestWithAuth(t, r, "GET", "/api/gdpr/export/", nil, 400, adminUserToken)
+	res := utils.TestRequestWithAuth(t, r, "GET", "/api/gdpr/export/?email="+email, nil, 200, adminUserToken)
+	var export gdpr.Export
+	err = json.Unmarshal(res.Body.Bytes(), &export)
+	utils.CheckError(t, err)
+	require.Equal(t, userID, export.Subject.KeycloakID)
+	require.Equal(t, "Customer", export.User.LastName)
+	require.Len(t, export.Orders, 2)
+	require.Len(t, export.Emails, 1)
+	require.Nil(t, export.Vendor)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/gdpr/export/?format=zip&keycloakID="+userID, nil, 200, adminUserToken)
+	archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	utils.CheckError(t, err)
+	require.Equal(t, "data.json", archive.File[0].Name)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/gdpr/export/?email=testgdprvendor@example.com", nil, 200, adminUserToken)
+	err = json.Unmarshal(res.Body.Bytes(), &export)
+	utils.CheckError(t, err)
+	require.Equal(t, vendorID, export.Vendor.ID)
+
+	// Active vendors have to be offboarded first
+	utils.TestRequestStrWithAuth(t, r, "POST", "/api/gdpr/erase/", `{"Email": "testgdprvendor@example.com"}`, 400, adminUserToken)
+
+	// Erase keeps the order within the retention period
+	res = utils.TestRequestStrWithAuth(t, r, "POST", "/api/gdpr/erase/", `{"Email": "`+email+`"}`, 200, adminUserToken)
+	var report database.ErasureReport
+	err = json.Unmarshal(res.Body.Bytes(), &report)
+	utils.CheckError(t, err)
+	require.True(t, report.UserDeleted)
+	require.Equal(t, 1, report.OrdersErased)
+	require.Len(t, report.RetainedOrders, 1)
+	require.Equal(t, recentOrderID, report.RetainedOrders[0].ID)
+	require.Equal(t, 1, report.EmailsDeleted)
+	_, err = keycloak.KeycloakClient.GetUserByID(userID)
+	require.True(t, keycloak.IsNotFound(err))
+	oldOrder, err := database.Db.GetOrderByID(oldOrderID)
+	utils.CheckError(t, err)
+	require.False(t, oldOrder.CustomerEmail.Valid)
+	orders, err := database.Db.ListOrdersOfSubject(email, "")
+	utils.CheckError(t, err)
+	require.Len(t, orders, 1)
+}
+
+// TestCustomerPortal tests the self-service endpoints of customers
+func TestCustomerPortal(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	email := "testcustomerportal@example.com"
+	keycloak.KeycloakClient.DeleteUser(email)
+	defer keycloak.KeycloakClient.DeleteUser(email)
+	userID, err := keycloak.KeycloakClient.CreateUser(email, "Test", "Customer", email, "password")
+	utils.CheckError(t, err)
+	token, err := keycloak.KeycloakClient.GetUserToken(email, "password")
+	utils.CheckError(t, err)
+	utils.CheckError(t, keycloak.KeycloakClient.AssignDigitalLicenseGroup(userID, "testcustomerportal"))
+	utils.TestRequest(t, r, "GET", "/api/customers/me/orders/", nil, 401)
+
+	// A verified order with a digital license, a PDF and a donation and an unverified order
+	vendorID, err := strconv.Atoi(createTestVendor(t, "testcustomerportalvendor"))
+	utils.CheckError(t, err)
+	defer keycloak.KeycloakClient.DeleteUser("testcustomerportalvendor@example.com")
+	sender, err := database.Db.GetAccountByType("UserAnon")
+	utils.CheckError(t, err)
+	receiver, err := database.Db.GetAccountByVendorID(vendorID)
+	utils.CheckError(t, err)
+	licenseID, err := database.Db.CreateItem(database.Item{Name: "Digital license", Price: 100, IsLicenseItem: true})
+	utils.CheckError(t, err)
+	digitalID, err := database.Db.CreateItem(database.Item{Name: "Digital edition", Price: 300, LicenseItem: null.IntFrom(int64(licenseID)), LicenseGroup: null.StringFrom("testcustomerportal")})
+	utils.CheckError(t, err)
+	pdfItemID, err := database.Db.CreateItem(database.Item{Name: "PDF edition", Price: 200, IsPDFItem: true, PDFValidityDays: 10})
+	utils.CheckError(t, err)
+	donation, err := database.Db.GetItemByName(config.Config.DonationName)
+	utils.CheckError(t, err)
+	entries := []database.OrderEntry{
+		{Item: digitalID, Quantity: 1, Price: 300, Sender: sender.ID, Receiver: receiver.ID, IsSale: true},
+		{Item: pdfItemID, Quantity: 2, Price: 200, Sender: sender.ID, Receiver: receiver.ID, IsSale: true},
+		{Item: donation.ID, Quantity: 50, Price: 1, Sender: sender.ID, Receiver: receiver.ID, IsSale: true},
+	}
+	orderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testcustomerportal1"), Vendor: vendorID, CustomerEmail: null.StringFrom(email), Entries: entries})
+	utils.CheckError(t, err)
+	_, err = database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testcustomerportal2"), Vendor: vendorID, CustomerEmail: null.StringFrom(email), Entries: entries[:1]})
+	utils.CheckError(t, err)
+	_, err = database.Db.Dbpool.Exec(context.Background(), "UPDATE PaymentOrder SET Verified = true WHERE ID = $1", orderID)
+	utils.CheckError(t, err)
+	pdfID, err := database.Db.CreatePDF(database.PDF{Path: "pdf/testcustomerportal.pdf", Timestamp: time.Now()})
+	utils.CheckError(t, err)
+	pdfItem, err := database.Db.GetItem(pdfItemID)
+	utils.CheckError(t, err)
+	tx, err := database.Db.Dbpool.Begin(context.Background())
+	utils.CheckError(t, err)
+	pdfDownload, err := database.Db.CreatePDFDownload(tx, database.PDF{ID: int(pdfID)}, orderID, pdfItem)
+	utils.CheckError(t, err)
+	utils.CheckError(t, tx.Commit(context.Background()))
+
+	// Orders and donations
+	res := utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/orders/", nil, 200, token)
+	var orders []database.CustomerOrder
+	err = json.Unmarshal(res.Body.Bytes(), &orders)
+	utils.CheckError(t, err)
+	require.Len(t, orders, 1)
+	require.Equal(t, orderID, orders[0].ID)
+	require.Len(t, orders[0].Entries, 3)
+	require.Equal(t, 300+400+50, orders[0].Total)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/donations/", nil, 200, token)
+	var donations []database.CustomerDonation
+	err = json.Unmarshal(res.Body.Bytes(), &donations)
+	utils.CheckError(t, err)
+	require.Len(t, donations, 1)
+	require.Equal(t, 50, donations[0].Amount)
+
+	// Licenses
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/licenses/", nil, 200, token)
+	var licenses []database.CustomerLicense
+	err = json.Unmarshal(res.Body.Bytes(), &licenses)
+	utils.CheckError(t, err)
+	require.Len(t, licenses, 1)
+	require.Equal(t, "testcustomerportal", licenses[0].LicenseGroup)
+	require.True(t, licenses[0].Active)
+	require.True(t, licenses[0].Since.Valid)
+
+	// Download links can be reissued, which disables the old link
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/downloads/", nil, 200, token)
+	var pdfDownloads []database.CustomerPDFDownload
+	err = json.Unmarshal(res.Body.Bytes(), &pdfDownloads)
+	utils.CheckError(t, err)
+	require.Len(t, pdfDownloads, 1)
+	require.Equal(t, pdfDownload.LinkID, pdfDownloads[0].LinkID)
+	require.True(t, pdfDownloads[0].Usable)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/customers/me/downloads/unknown/reissue/", nil, 404, token)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/customers/me/downloads/"+pdfDownload.LinkID+"/reissue/", nil, 404, adminUserToken)
+	res = utils.TestRequestWithAuth(t, r, "POST", "/api/customers/me/downloads/"+pdfDownload.LinkID+"/reissue/", nil, 200, token)
+	var reissued database.CustomerPDFDownload
+	err = json.Unmarshal(res.Body.Bytes(), &reissued)
+	utils.CheckError(t, err)
+	require.NotEqual(t, pdfDownload.LinkID, reissued.LinkID)
+	_, err = database.Db.GetPDFDownload(pdfDownload.LinkID)
+	require.Error(t, err)
+	emails, err := database.Db.ListQueuedEmailsTo(email)
+	utils.CheckError(t, err)
+	reminders := 0
+	for _, queuedEmail := range emails {
+		if queuedEmail.Template == database.EmailTemplateSubscriptionRenewal {
+			reminders++
+		}
+	}
+	require.Equal(t, 1, reminders)
+	require.Equal(t, database.EmailTemplatePDFLicenceItem, emails[0].Template)
+
+	// Preferences
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/preferences/", nil, 200, token)
+	var preference database.CustomerPreference
+	err = json.Unmarshal(res.Body.Bytes(), &preference)
+	utils.CheckError(t, err)
+	require.True(t, preference.OrderConfirmations)
+	res = utils.TestRequestStrWithAuth(t, r, "PUT", "/api/customers/me/preferences/", `{"Language": "en", "OrderConfirmations": false}`, 200, token)
+	err = json.Unmarshal(res.Body.Bytes(), &preference)
+	utils.CheckError(t, err)
+	require.False(t, preference.OrderConfirmations)
+	require.Equal(t, "en", preference.Language)
+	_, err = database.Db.QueueEmail(strings.ToUpper(email), "test", "de", nil)
+	utils.CheckError(t, err)
+	emails, err = database.Db.ListQueuedEmailsTo(email)
+	utils.CheckError(t, err)
+	require.Equal(t, "en", emails[0].Language)
+}
+
+// TestCustomerOf tests that customers are only identified by verified emails
+func TestCustomerOf(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/customers/me/orders/", nil)
+	req.Header.Set("X-Auth-User-Email", "testcustomerof@example.com")
+	_, _, err := customerOf(req)
+	require.Error(t, err)
+	req.Header.Set("X-Auth-User", "testcustomerofid")
+	email, keycloakID, err := customerOf(req)
+	utils.CheckError(t, err)
+	require.Equal(t, "", email)
+	require.Equal(t, "testcustomerofid", keycloakID)
+	req.Header.Set("X-Auth-User-Email-Verified", "true")
+	email, _, err = customerOf(req)
+	utils.CheckError(t, err)
+	require.Equal(t, "testcustomerof@example.com", email)
+}
+
+// TestSubscriptions tests the purchase, renewal, reminders and expiry of subscriptions
+func TestSubscriptions(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	email := "testsubscription@example.com"
+	keycloak.KeycloakClient.DeleteUser(email)
+	defer keycloak.KeycloakClient.DeleteUser(email)
+	vendorLicenseID := "testsubscriptionvendor"
+	vendorID, err := strconv.Atoi(createTestVendor(t, vendorLicenseID))
+	utils.CheckError(t, err)
+	defer keycloak.KeycloakClient.DeleteUser(vendorLicenseID + "@example.com")
+	setMaxOrderAmount(t, 5000)
+
+	// Subscriptions have to be digital license items with a period
+	licenseID, err := database.Db.CreateItem(database.Item{Name: "Subscription license", Price: 100, IsLicenseItem: true})
+	utils.CheckError(t, err)
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	writer.WriteField("Name", "Weekly subscription")
+	writer.WriteField("Price", "100")
+	writer.WriteField("LicenseItem", strconv.Itoa(licenseID))
+	writer.WriteField("LicenseGroup", "testsubscription")
+	writer.WriteField("SubscriptionPeriod", "week")
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "POST", "/api/items/", body, writer.FormDataContentType(), 400, adminUserToken)
+	itemID, err := database.Db.CreateItem(database.Item{Name: "Monthly subscription", Price: 500, LicenseItem: null.IntFrom(int64(licenseID)), LicenseGroup: null.StringFrom("testsubscription"), SubscriptionPeriod: database.SubscriptionMonthly})
+	utils.CheckError(t, err)
+
+	// Every verified order extends the subscription
+	sender, err := database.Db.GetAccountByType("UserAnon")
+	utils.CheckError(t, err)
+	receiver, err := database.Db.GetAccountByVendorID(vendorID)
+	utils.CheckError(t, err)
+	buy := func(code string) {
+		entries := []database.OrderEntry{{Item: itemID, Quantity: 1, Price: 500, Sender: sender.ID, Receiver: receiver.ID, IsSale: true}}
+		orderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom(code), Vendor: vendorID, CustomerEmail: null.StringFrom(email), Entries: entries})
+		utils.CheckError(t, err)
+		utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(orderID, 48))
+	}
+	buy("testsubscription1")
+	subscriptions, err := database.Db.ListSubscriptions(email)
+	utils.CheckError(t, err)
+	require.Len(t, subscriptions, 1)
+	subscription := subscriptions[0]
+	require.WithinDuration(t, time.Now().AddDate(0, 1, 0), subscription.ValidUntil, time.Minute)
+	require.Equal(t, "testsubscription", subscription.LicenseGroup)
+	user, err := keycloak.KeycloakClient.GetUserByEmail(email)
+	utils.CheckError(t, err)
+	require.Equal(t, *user.ID, subscription.UserID)
+	buy("testsubscription2")
+	subscription, err = database.Db.GetSubscription(subscription.ID)
+	utils.CheckError(t, err)
+	require.WithinDuration(t, time.Now().AddDate(0, 2, 0), subscription.ValidUntil, time.Minute)
+
+	// Renewal reminders are sent once
+	count, err := jobs.RemindSubscriptions(time.Now())
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	count, err = jobs.RemindSubscriptions(time.Now().AddDate(0, 2, 0))
+	utils.CheckError(t, err)
+	require.Equal(t, 1, count)
+	count, err = jobs.RemindSubscriptions(time.Now().AddDate(0, 2, 0))
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	// Another instance of the job with the subscription listed before the reminder doesn't queue it again
+	queued, err := database.Db.QueueSubscriptionReminder(subscription)
+	utils.CheckError(t, err)
+	require.False(t, queued)
+	emails, err := database.Db.ListQueuedEmailsTo(email)
+	utils.CheckError(t, err)
+	reminders := 0
+	for _, queuedEmail := range emails {
+		if queuedEmail.Template == database.EmailTemplateSubscriptionRenewal {
+			reminders++
+		}
+	}
+	require.Equal(t, 1, reminders)
+	require.Equal(t, database.EmailTemplateSubscriptionRenewal, emails[0].Template)
+	require.Contains(t, emails[0].Data["URL"], subscription.RenewalToken)
+
+	// Renewal link
+	utils.TestRequest(t, r, "GET", "/api/subscriptions/renew/unknown/", nil, 404)
+	res := utils.TestRequest(t, r, "GET", "/api/subscriptions/renew/"+subscription.RenewalToken+"/", nil, 200)
+	require.Contains(t, res.Body.String(), `"Price":500`)
+	res = utils.TestRequest(t, r, "POST", "/api/subscriptions/renew/"+subscription.RenewalToken+"/", nil, 200)
+	require.Contains(t, res.Body.String(), config.Config.VivaWalletSmartCheckoutURL)
+
+	// Customers see and cancel their subscriptions
+	utils.CheckError(t, keycloak.KeycloakClient.UpdateUserPassword(email, "password"))
+	token, err := keycloak.KeycloakClient.GetUserToken(email, "password")
+	utils.CheckError(t, err)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/customers/me/subscriptions/", nil, 200, token)
+	err = json.Unmarshal(res.Body.Bytes(), &subscriptions)
+	utils.CheckError(t, err)
+	require.Len(t, subscriptions, 1)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/customers/me/subscriptions/"+strconv.Itoa(subscription.ID)+"/cancel/", nil, 404, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/customers/me/subscriptions/"+strconv.Itoa(subscription.ID)+"/cancel/", nil, 200, token)
+	subscription, err = database.Db.GetSubscription(subscription.ID)
+	utils.CheckError(t, err)
+	require.True(t, subscription.CancelledAt.Valid)
+
+	// Access ends after the grace period
+	count, err = jobs.ExpireSubscriptions(time.Now().AddDate(0, 2, 0))
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	count, err = jobs.ExpireSubscriptions(time.Now().AddDate(0, 2, config.Config.SubscriptionGraceDays+1))
+	utils.CheckError(t, err)
+	require.Equal(t, 1, count)
+	groups, err := keycloak.KeycloakClient.GetUserGroups(*user.ID)
+	utils.CheckError(t, err)
+	for _, group := range groups {
+		require.NotEqual(t, keycloak.DigitalLicenseGroupPath("testsubscription"), *group.Path)
+	}
+
+	// Renewing an expired subscription starts a new period
+	buy("testsubscription3")
+	subscription, err = database.Db.GetSubscription(subscription.ID)
+	utils.CheckError(t, err)
+	require.False(t, subscription.ExpiredAt.Valid)
+	require.False(t, subscription.CancelledAt.Valid)
+	require.WithinDuration(t, time.Now().AddDate(0, 1, 0), subscription.ValidUntil, time.Minute)
+	utils.TestRequestWithAuth(t, r, "GET", "/api/subscriptions/?email="+email, nil, 200, adminUserToken)
+}
+
+func TestLicenseGrants(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	email := "testlicensegrant@example.com"
+	keycloak.KeycloakClient.DeleteUser(email)
+	defer keycloak.KeycloakClient.DeleteUser(email)
+	vendorLicenseID := "testlicensegrantvendor"
+	vendorID, err := strconv.Atoi(createTestVendor(t, vendorLicenseID))
+	utils.CheckError(t, err)
+	defer keycloak.KeycloakClient.DeleteUser(vendorLicenseID + "@example.com")
+	setMaxOrderAmount(t, 5000)
+
+	licenseID, err := database.Db.CreateItem(database.Item{Name: "Grant license", Price: 100, IsLicenseItem: true})
+	utils.CheckError(t, err)
+	itemID, err := database.Db.CreateItem(database.Item{Name: "Online edition", Price: 300, LicenseItem: null.IntFrom(int64(licenseID)), LicenseGroup: null.StringFrom("testlicensegrant"), LicenseValidityDays: 10})
+	utils.CheckError(t, err)
+
+	// A verified order grants access for the validity of the item
+	sender, err := database.Db.GetAccountByType("UserAnon")
+	utils.CheckError(t, err)
+	receiver, err := database.Db.GetAccountByVendorID(vendorID)
+	utils.CheckError(t, err)
+	entries := []database.OrderEntry{{Item: itemID, Quantity: 1, Price: 300, Sender: sender.ID, Receiver: receiver.ID, IsSale: true}}
+	orderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testlicensegrant"), Vendor: vendorID, CustomerEmail: null.StringFrom(email), Entries: entries})
+	utils.CheckError(t, err)
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(orderID, 48))
+	grants, err := database.Db.ListLicenseGrants(email, true)
+	utils.CheckError(t, err)
+	require.Len(t, grants, 1)
+	grant := grants[0]
+	require.True(t, grant.EndDate.Valid)
+	require.WithinDuration(t, time.Now().AddDate(0, 0, 10), grant.EndDate.Time, time.Minute)
+	user, err := keycloak.KeycloakClient.GetUserByEmail(email)
+	utils.CheckError(t, err)
+	hasGroup := func() bool {
+		groups, err := keycloak.KeycloakClient.GetUserGroups(*user.ID)
+		utils.CheckError(t, err)
+		for _, group := range groups {
+			if *group.Path == keycloak.DigitalLicenseGroupPath("testlicensegrant") {
+				return true
+			}
+		}
+		return false
+	}
+	require.True(t, hasGroup())
+
+	// Access ends with the grant
+	count, err := jobs.ExpireLicenseGrants(time.Now())
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	count, err = jobs.ExpireLicenseGrants(time.Now().AddDate(0, 0, 11))
+	utils.CheckError(t, err)
+	require.Equal(t, 1, count)
+	require.False(t, hasGroup())
+
+	// Verifying the order again does not give access again
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(orderID, 48))
+	require.False(t, hasGroup())
+
+	// Admins extend grants
+	utils.TestRequestWithAuth(t, r, "GET", "/api/licenses/grants/?email="+email, nil, 200, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/licenses/grants/"+strconv.Itoa(grant.ID)+"/extend/", map[string]int{"Days": -1}, 400, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/licenses/grants/0/extend/", map[string]int{"Days": 5}, 404, adminUserToken)
+	res := utils.TestRequestWithAuth(t, r, "POST", "/api/licenses/grants/"+strconv.Itoa(grant.ID)+"/extend/", map[string]int{"Days": 5}, 200, adminUserToken)
+	err = json.Unmarshal(res.Body.Bytes(), &grant)
+	utils.CheckError(t, err)
+	require.False(t, grant.ExpiredAt.Valid)
+	require.WithinDuration(t, time.Now().AddDate(0, 0, 15), grant.EndDate.Time, time.Minute)
+	require.True(t, hasGroup())
+}
+
+func TestGiftOrders(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	buyer := "testgiftbuyer@example.com"
+	recipient := "testgiftrecipient@example.com"
+	keycloak.KeycloakClient.DeleteUser(buyer)
+	keycloak.KeycloakClient.DeleteUser(recipient)
+	defer keycloak.KeycloakClient.DeleteUser(buyer)
+	defer keycloak.KeycloakClient.DeleteUser(recipient)
+	vendorLicenseID := "testgiftvendor"
+	vendorID, err := strconv.Atoi(createTestVendor(t, vendorLicenseID))
+	utils.CheckError(t, err)
+	defer keycloak.KeycloakClient.DeleteUser(vendorLicenseID + "@example.com")
+	setMaxOrderAmount(t, 5000)
+
+	licenseID, err := database.Db.CreateItem(database.Item{Name: "Gift license", Price: 100, IsLicenseItem: true})
+	utils.CheckError(t, err)
+	itemID, err := database.Db.CreateItem(database.Item{Name: "Online edition", Price: 300, LicenseItem: null.IntFrom(int64(licenseID)), LicenseGroup: null.StringFrom("testgift")})
+	utils.CheckError(t, err)
+	plainItemID, err := database.Db.CreateItem(database.Item{Name: "Print edition", Price: 300})
+	utils.CheckError(t, err)
+
+	// Only digital license and PDF items can be given to a valid recipient
+	order := func(item int, gift map[string]any) map[string]any {
+		request := map[string]any{
+			"Entries":         []map[string]int{{"Item": item, "Quantity": 1}},
+			"VendorLicenseID": vendorLicenseID,
+			"CustomerEmail":   buyer,
+		}
+		for key, value := range gift {
+			request[key] = value
+		}
+		return request
+	}
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(plainItemID, map[string]any{"RecipientEmail": recipient}), 400)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(itemID, map[string]any{"GiftMessage": "Happy birthday!"}), 400)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(itemID, map[string]any{"RecipientEmail": "not an email"}), 400)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(itemID, map[string]any{"RecipientEmail": recipient, "DeliveryDate": time.Now().AddDate(2, 0, 0)}), 400)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(itemID, map[string]any{"RecipientEmail": recipient, "RecipientName": "Anna", "GiftMessage": "Happy birthday!"}), 200)
+
+	// The recipient gets the license, the buyer the receipt
+	gift, err := database.Db.GetOrderByOrderCode("0")
+	utils.CheckError(t, err)
+	require.Equal(t, recipient, gift.RecipientEmail.String)
+	require.Equal(t, "Happy birthday!", gift.GiftMessage)
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(gift.ID, 48))
+	grants, err := database.Db.ListLicenseGrants(recipient, true)
+	utils.CheckError(t, err)
+	require.Len(t, grants, 1)
+	grants, err = database.Db.ListLicenseGrants(buyer, false)
+	utils.CheckError(t, err)
+	require.Len(t, grants, 0)
+	emails, err := database.Db.ListQueuedEmailsTo(recipient)
+	utils.CheckError(t, err)
+	require.Len(t, emails, 2)
+	require.Equal(t, database.EmailTemplateGift, emails[1].Template)
+	require.Equal(t, database.EmailTemplateDigitalLicenceItem, emails[0].Template)
+	emails, err = database.Db.ListQueuedEmailsTo(buyer)
+	utils.CheckError(t, err)
+	require.Len(t, emails, 1)
+	require.Equal(t, database.EmailTemplateOrderConfirmation, emails[0].Template)
+	require.Equal(t, "Anna", emails[0].Data["Recipient"])
+
+	// Verifying the order again does not deliver the gift again
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(gift.ID, 48))
+	emails, err = database.Db.ListQueuedEmailsTo(recipient)
+	utils.CheckError(t, err)
+	require.Len(t, emails, 2)
+
+	// Gifts with a delivery date are delivered by the job
+	sender, err := database.Db.GetAccountByType("UserAnon")
+	utils.CheckError(t, err)
+	receiver, err := database.Db.GetAccountByVendorID(vendorID)
+	utils.CheckError(t, err)
+	entries := []database.OrderEntry{{Item: itemID, Quantity: 1, Price: 300, Sender: sender.ID, Receiver: receiver.ID, IsSale: true}}
+	orderID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testgiftscheduled"), Vendor: vendorID, CustomerEmail: null.StringFrom(buyer), Entries: entries,
+		RecipientEmail: null.StringFrom(recipient), DeliveryDate: null.TimeFrom(time.Now().AddDate(0, 0, 3))})
+	utils.CheckError(t, err)
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(orderID, 48))
+	emails, err = database.Db.ListQueuedEmailsTo(recipient)
+	utils.CheckError(t, err)
+	require.Len(t, emails, 2)
+	count, err := jobs.DeliverGifts(time.Now())
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	count, err = jobs.DeliverGifts(time.Now().AddDate(0, 0, 4))
+	utils.CheckError(t, err)
+	require.Equal(t, 1, count)
+	emails, err = database.Db.ListQueuedEmailsTo(recipient)
+	utils.CheckError(t, err)
+	require.Len(t, emails, 4)
+	scheduled, err := database.Db.GetOrderByID(orderID)
+	utils.CheckError(t, err)
+	require.True(t, scheduled.DeliveredAt.Valid)
+	count, err = jobs.DeliverGifts(time.Now().AddDate(0, 0, 4))
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+
+	// The recipient sees the gifts as own licenses
+	licenses, err := database.Db.ListCustomerLicensePurchases(recipient, "")
+	utils.CheckError(t, err)
+	require.Len(t, licenses, 1)
+	licenses, err = database.Db.ListCustomerLicensePurchases(buyer, "")
+	utils.CheckError(t, err)
+	require.Len(t, licenses, 0)
+
+	// Gifts of an erased recipient stay gifts, they are neither delivered nor given to the buyer
+	pendingID, err := database.Db.CreateOrder(database.Order{OrderCode: null.StringFrom("testgifterased"), Vendor: vendorID, CustomerEmail: null.StringFrom(buyer), Entries: entries,
+		RecipientEmail: null.StringFrom(recipient), DeliveryDate: null.TimeFrom(time.Now().AddDate(0, 0, 3))})
+	utils.CheckError(t, err)
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(pendingID, 48))
+	report, _, err := database.Db.EraseSubject(recipient, "", config.Config.BookkeepingRetentionYears)
+	utils.CheckError(t, err)
+	require.Equal(t, 3, report.GiftsErased)
+	erased, err := database.Db.GetOrderByID(pendingID)
+	utils.CheckError(t, err)
+	require.True(t, erased.IsGift())
+	require.True(t, erased.RecipientErased())
+	require.Equal(t, "", erased.LicenseHolder())
+	count, err = jobs.DeliverGifts(time.Now().AddDate(0, 0, 4))
+	utils.CheckError(t, err)
+	require.Equal(t, 0, count)
+	utils.CheckError(t, database.Db.DeliverGift(pendingID))
+	erased, err = database.Db.GetOrderByID(pendingID)
+	utils.CheckError(t, err)
+	require.False(t, erased.DeliveredAt.Valid)
+	gifts, err := database.Db.ListGiftsOfRecipient("")
+	utils.CheckError(t, err)
+	require.Len(t, gifts, 0)
+	grants, err = database.Db.ListLicenseGrants(buyer, false)
+	utils.CheckError(t, err)
+	require.Len(t, grants, 0)
+}
+
+// TestOrderEditions tests that an edition can't be ordered twice, also if it is the current edition that is ordered by default
+func TestOrderEditions(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	itemID, err := database.Db.CreateItem(database.Item{Name: "PDF edition", Price: 200, IsPDFItem: true})
+	utils.CheckError(t, err)
+	now := time.Now()
+	oldID, err := database.Db.CreatePDF(database.PDF{Path: "pdf/old.pdf", Timestamp: now, Item: null.IntFrom(int64(itemID)), IssueNumber: "1", PublicationDate: null.TimeFrom(now.AddDate(0, -1, 0))})
+	utils.CheckError(t, err)
+	currentID, err := database.Db.CreatePDF(database.PDF{Path: "pdf/current.pdf", Timestamp: now, Item: null.IntFrom(int64(itemID)), IssueNumber: "2", PublicationDate: null.TimeFrom(now)})
+	utils.CheckError(t, err)
+
+	order := func(editions ...int64) map[string]any {
+		var entries []map[string]int
+		for _, edition := range editions {
+			entries = append(entries, map[string]int{"Item": itemID, "Quantity": 1, "PDF": int(edition)})
+		}
+		return map[string]any{"Entries": entries, "VendorLicenseID": "testordereditions", "CustomerEmail": "testordereditions@example.com"}
+	}
+	res := utils.TestRequest(t, r, "POST", "/api/orders/", order(0, currentID), 400)
+	require.Equal(t, `{"error":{"message":"Nice try! You are not supposed to have duplicate item ids in your order request"}}`, res.Body.String())
+	// Different editions pass the check and fail at the unknown vendor
+	res = utils.TestRequest(t, r, "POST", "/api/orders/", order(oldID, currentID), 400)
+	require.NotContains(t, res.Body.String(), "duplicate")
+}
+
+func TestVouchers(t *testing.T) {
+	err := database.Db.InitEmptyTestDb()
+	utils.CheckError(t, err)
+	vendorLicenseID := "testvouchervendor"
+	vendorID, err := strconv.Atoi(createTestVendor(t, vendorLicenseID))
+	utils.CheckError(t, err)
+	defer keycloak.KeycloakClient.DeleteUser(vendorLicenseID + "@example.com")
+	setMaxOrderAmount(t, 5000)
+	itemID, err := database.Db.CreateItem(database.Item{Name: "Newspaper", Price: 300})
+	utils.CheckError(t, err)
+
+	// Vouchers need a valid discount
+	utils.TestRequestWithAuth(t, r, "POST", "/api/vouchers/", map[string]any{"Name": "Too much", "Type": "percent", "Percent": 150}, 400, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/vouchers/", map[string]any{"Name": "Unknown", "Type": "gratis"}, 400, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "POST", "/api/vouchers/", map[string]any{"Name": "Bad code", "Type": "percent", "Percent": 10, "Code": "no spaces"}, 400, adminUserToken)
+
+	// A campaign code and single use codes
+	res := utils.TestRequestWithAuth(t, r, "POST", "/api/vouchers/", map[string]any{"Name": "Summer sale", "Type": "percent", "Percent": 10, "Code": "summer-sale"}, 200, adminUserToken)
+	var campaign database.Voucher
+	utils.CheckError(t, json.Unmarshal(res.Body.Bytes(), &campaign))
+	require.Equal(t, 1, campaign.Codes)
+	require.NotEmpty(t, campaign.CreatedBy)
+	res = utils.TestRequestWithAuth(t, r, "POST", "/api/vouchers/", map[string]any{"Name": "Welcome", "Type": "fixed", "Amount": 100, "Count": 5, "MaxUses": 1}, 200, adminUserToken)
+	var welcome database.Voucher
+	utils.CheckError(t, json.Unmarshal(res.Body.Bytes(), &welcome))
+	require.Equal(t, 5, welcome.Codes)
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/vouchers/"+strconv.Itoa(welcome.ID)+"/codes/", nil, 200, adminUserToken)
+	var codes []database.VoucherCode
+	utils.CheckError(t, json.Unmarshal(res.Body.Bytes(), &codes))
+	require.Len(t, codes, 5)
+	require.Len(t, codes[0].Code, voucherCodeLength)
+	utils.TestRequest(t, r, "GET", "/api/vouchers/", nil, 401)
+
+	// The buyer pays less, the vendor gets the full price from the organization
+	order := func(code string) map[string]any {
+		return map[string]any{
+			"Entries":         []map[string]int{{"Item": itemID, "Quantity": 1}},
+			"VendorLicenseID": vendorLicenseID,
+			"VoucherCode":     code,
+		}
+	}
+	utils.TestRequest(t, r, "POST", "/api/orders/", order("UNKNOWN"), 400)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(strings.ToLower(codes[0].Code)), 200)
+	discounted, err := database.Db.GetOrderByOrderCode("0")
+	utils.CheckError(t, err)
+	require.Equal(t, 200, discounted.GetTotal())
+	require.Equal(t, 100, discounted.GetDiscount())
+	orga, err := database.Db.GetAccountByType("Orga")
+	utils.CheckError(t, err)
+	vendorAccount, err := database.Db.GetAccountByVendorID(vendorID)
+	utils.CheckError(t, err)
+	for _, entry := range discounted.Entries {
+		if entry.VoucherCode.Valid {
+			require.Equal(t, orga.ID, entry.Sender)
+			require.Equal(t, codes[0].ID, int(entry.VoucherCode.Int64))
+			require.Equal(t, 1, entry.Quantity)
+			require.Equal(t, 100, entry.Price)
+		}
+		if entry.IsSale && entry.Item == itemID {
+			require.Equal(t, 300, entry.Price)
+			require.Equal(t, vendorAccount.ID, entry.Receiver)
+		}
+	}
+
+	// Single use codes can't be used again, even before the payment is verified
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(codes[0].Code), 400)
+
+	// Disabled and expired vouchers are not valid
+	campaign.Disabled = true
+	utils.TestRequestWithAuth(t, r, "PUT", "/api/vouchers/"+strconv.Itoa(campaign.ID)+"/", campaign, 200, adminUserToken)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order("SUMMER-SALE"), 400)
+	campaign.Disabled = false
+	campaign.ValidUntil = null.TimeFrom(time.Now().Add(-time.Hour))
+	utils.TestRequestWithAuth(t, r, "PUT", "/api/vouchers/"+strconv.Itoa(campaign.ID)+"/", campaign, 200, adminUserToken)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order("SUMMER-SALE"), 400)
+	utils.TestRequestWithAuth(t, r, "PUT", "/api/vouchers/0/", campaign, 404, adminUserToken)
+
+	// The voucher item can't be bought
+	voucherItem, err := database.Db.GetItemByName(config.Config.VoucherName)
+	utils.CheckError(t, err)
+	utils.TestRequest(t, r, "POST", "/api/orders/", map[string]any{"Entries": []map[string]int{{"Item": voucherItem.ID, "Quantity": 1}}, "VendorLicenseID": vendorLicenseID}, 400)
+	utils.TestRequestWithAuth(t, r, "PUT", "/api/items/"+strconv.Itoa(voucherItem.ID)+"/", nil, 400, adminUserToken)
+	utils.TestRequestWithAuth(t, r, "DELETE", "/api/items/"+strconv.Itoa(voucherItem.ID)+"/", nil, 400, adminUserToken)
+
+	// Redemptions are counted after the verification
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(discounted.ID, 48))
+	res = utils.TestRequestWithAuth(t, r, "GET", "/api/payments/statistics/?from=2020-01-01T00:00:00Z&to=2999-01-01T00:00:00Z", nil, 200, adminUserToken)
+	var statistics PaymentsStatistics
+	utils.CheckError(t, json.Unmarshal(res.Body.Bytes(), &statistics))
+	require.Len(t, statistics.Vouchers, 1)
+	require.Equal(t, welcome.ID, statistics.Vouchers[0].ID)
+	require.Equal(t, 1, statistics.Vouchers[0].Redemptions)
+	require.Equal(t, 100, statistics.Vouchers[0].SumDiscount)
+	welcome, err = database.Db.GetVoucher(welcome.ID)
+	utils.CheckError(t, err)
+	require.Equal(t, 1, welcome.Uses)
+
+	// Uses are only reserved during the checkout, an order paid later is verified although the code has been used up meanwhile
+	codeOrder := func() (orderID int) {
+		utils.CheckError(t, database.Db.Dbpool.QueryRow(context.Background(), "SELECT MAX(PaymentOrder) FROM OrderEntry WHERE VoucherCode = $1", codes[1].ID).Scan(&orderID))
+		return orderID
+	}
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(codes[1].Code), 200)
+	lateID := codeOrder()
+	_, err = database.Db.Dbpool.Exec(context.Background(), "UPDATE PaymentOrder SET Timestamp = $1 WHERE ID = $2", time.Now().Add(-2*time.Hour), lateID)
+	utils.CheckError(t, err)
+	utils.TestRequest(t, r, "POST", "/api/orders/", order(codes[1].Code), 200)
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(codeOrder(), 48))
+	utils.CheckError(t, database.Db.VerifyOrderAndCreatePayments(lateID, 48))
+	late, err := database.Db.GetOrderByID(lateID)
+	utils.CheckError(t, err)
+	require.True(t, late.Verified)
+	require.Equal(t, 100, late.GetDiscount())
+	codes, err = database.Db.ListVoucherCodes(welcome.ID)
+	utils.CheckError(t, err)
+	require.Equal(t, 2, codes[1].Uses)
+}
+
+// testImageFile returns an encoded image with a color gradient
+func testImageFile(t *testing.T, format string, w int, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	utils.CheckError(t, err)
+	return buf.Bytes()
+}
+
 func CreateTestItem(t *testing.T, name string, price int, licenseItemID string, licenseGroup string) string {
 	body := new(bytes.Buffer)
 	writer := multipart.NewWriter(body)
@@ -249,8 +1377,8 @@ func TestItems(t *testing.T) {
 	writer.WriteField("Name", "Updated item name")
 	writer.WriteField("Price", strconv.Itoa(10))
 	writer.WriteField("nonexistingfieldname", "10")
-	image, _ := writer.CreateFormFile("Image", "test.jpg")
-	image.Write([]byte(`i am the content of a jpg file :D`))
+	image, _ := writer.CreateFormFile("Image", "test.png")
+	image.Write(testImageFile(t, "jpeg", 1600, 800))
 	writer.Close()
 	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/items/"+itemID+"/", body, writer.FormDataContentType(), 200, adminUserToken)
 
@@ -260,8 +1388,10 @@ func TestItems(t *testing.T) {
 	utils.CheckError(t, err)
 	require.Equal(t, 2, len(resItems))
 	require.Equal(t, "Updated item name", resItems[1].Name)
-	require.Contains(t, resItems[1].Image, "test")
-	require.Contains(t, resItems[1].Image, ".jpg")
+	// Images are stored under the hash of their content, the type is detected from the content
+	require.Regexp(t, `^img/[0-9a-f]{64}\.jpg$`, resItems[1].Image)
+	require.Len(t, resItems[1].ImageVariants, 3)
+	require.Regexp(t, `^img/[0-9a-f]{64}_thumbnail\.webp$`, resItems[1].ImageVariants["thumbnail"])
 
 	// Check file
 	dir, err := os.Getwd()
@@ -270,7 +1400,22 @@ func TestItems(t *testing.T) {
 	}
 	file, err := os.ReadFile(dir + "/" + resItems[1].Image)
 	utils.CheckError(t, err)
-	require.Equal(t, `i am the content of a jpg file :D`, string(file))
+	require.Equal(t, "image/jpeg", http.DetectContentType(file))
+
+	// Image and variants are served by the API
+	res = utils.TestRequest(t, r, "GET", "/"+resItems[1].Image, nil, 200)
+	require.Equal(t, file, res.Body.Bytes())
+	res = utils.TestRequest(t, r, "GET", "/"+resItems[1].ImageVariants["medium"], nil, 200)
+	require.Equal(t, "image/webp", res.Header().Get("Content-Type"))
+
+	// Files that are not images are rejected
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	writer.WriteField("Name", "Updated item name")
+	image, _ = writer.CreateFormFile("Image", "test.jpg")
+	image.Write([]byte(`i am the content of a jpg file :D`))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/items/"+itemID+"/", body, writer.FormDataContentType(), 400, adminUserToken)
 
 	// Update with image as field (not as a file)
 	body = new(bytes.Buffer)
@@ -502,6 +1647,15 @@ func TestOrders(t *testing.T) {
 	require.Equal(t, *groups[0].Name, "customer")
 	require.Equal(t, *groups[1].Name, "testedition")
 
+	// Check that the customer gets an email with the link to the license item
+	capturedEmails.Reset()
+	_, _, err = database.Db.SendQueuedEmails(10, config.Config.EmailMaxAttempts)
+	utils.CheckError(t, err)
+	emails := capturedEmails.Emails()
+	require.Equal(t, 1, len(emails))
+	require.Equal(t, []string{customerEmail}, emails[0].To)
+	require.Contains(t, emails[0].HTML, config.Config.OnlinePaperUrl)
+
 	// Cleanup
 	for _, payment := range payments {
 		database.Db.DeletePayment(payment.ID)
@@ -807,7 +1961,7 @@ func TestSettings(t *testing.T) {
 	writer.WriteField("MaxOrderAmount", strconv.Itoa(10))
 	writer.WriteField("MainItem", itemID)
 	image, _ := writer.CreateFormFile("Logo", "test.png")
-	image.Write([]byte(`i am the content of a jpg file :D`))
+	image.Write(testImageFile(t, "png", 300, 100))
 	writer.Close()
 	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/settings/", body, writer.FormDataContentType(), 200, adminUserToken)
 
@@ -816,7 +1970,9 @@ func TestSettings(t *testing.T) {
 	res := utils.TestRequest(t, r, "GET", "/api/settings/", nil, 200)
 	err := json.Unmarshal(res.Body.Bytes(), &settings)
 	utils.CheckError(t, err)
-	require.Equal(t, "/img/logo.png", settings.Logo)
+	require.Regexp(t, `^/img/[0-9a-f]{64}\.png$`, settings.Logo)
+	require.Len(t, settings.LogoVariants, 3)
+	require.Regexp(t, `^/img/[0-9a-f]{64}_favicon-32\.png$`, settings.FaviconVariants["favicon-32"])
 	require.Equal(t, 10, settings.MaxOrderAmount)
 
 	// Check item join
@@ -830,7 +1986,56 @@ func TestSettings(t *testing.T) {
 	}
 	file, err := os.ReadFile(dir + "/" + settings.Logo)
 	utils.CheckError(t, err)
-	require.Equal(t, `i am the content of a jpg file :D`, string(file))
+	require.Equal(t, "image/png", http.DetectContentType(file))
+	file, err = os.ReadFile(dir + "/" + settings.FaviconVariants["favicon-32"])
+	utils.CheckError(t, err)
+	favicon, err := png.DecodeConfig(bytes.NewReader(file))
+	utils.CheckError(t, err)
+	require.Equal(t, 32, favicon.Width)
+	require.Equal(t, 32, favicon.Height)
+
+	// Variants are kept if the settings are saved without a new logo
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	writer.WriteField("MaxOrderAmount", strconv.Itoa(10))
+	writer.WriteField("Logo", settings.Logo)
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/settings/", body, writer.FormDataContentType(), 200, adminUserToken)
+	var settingsAfter database.Settings
+	res = utils.TestRequest(t, r, "GET", "/api/settings/", nil, 200)
+	err = json.Unmarshal(res.Body.Bytes(), &settingsAfter)
+	utils.CheckError(t, err)
+	require.Equal(t, settings.FaviconVariants, settingsAfter.FaviconVariants)
+
+	// The stable path of the logo redirects to the uploaded logo
+	res = utils.TestRequest(t, r, "GET", "/img/logo.png", nil, 302)
+	require.Equal(t, settings.Logo, res.Header().Get("Location"))
+
+	// Replaced logos are removed from the storage
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	image, _ = writer.CreateFormFile("Logo", "test.png")
+	image.Write(testImageFile(t, "png", 200, 100))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/settings/", body, writer.FormDataContentType(), 200, adminUserToken)
+	res = utils.TestRequest(t, r, "GET", "/api/settings/", nil, 200)
+	err = json.Unmarshal(res.Body.Bytes(), &settingsAfter)
+	utils.CheckError(t, err)
+	require.NotEqual(t, settings.Logo, settingsAfter.Logo)
+	_, err = os.Stat(dir + "/" + settings.Logo)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dir + "/" + settings.FaviconVariants["favicon-32"])
+	require.True(t, os.IsNotExist(err))
+	res = utils.TestRequest(t, r, "GET", "/img/logo.png", nil, 302)
+	require.Equal(t, settingsAfter.Logo, res.Header().Get("Location"))
+
+	// Only images are accepted, regardless of the file name
+	body = new(bytes.Buffer)
+	writer = multipart.NewWriter(body)
+	image, _ = writer.CreateFormFile("Logo", "test.png")
+	image.Write([]byte(`i am the content of a jpg file :D`))
+	writer.Close()
+	utils.TestRequestMultiPartWithAuth(t, r, "PUT", "/api/settings/", body, writer.FormDataContentType(), 400, adminUserToken)
 
 }
 
@@ -59,6 +59,10 @@ func GetRouter() (r *chi.Mux) {
 
 	// Public routes
 	r.Get("/api/hello/", HelloWorld)
+	// Without Keycloak there is no login page, so tokens are issued by the API
+	if config.Config.IdentityProvider == "memory" && config.Config.Development {
+		r.Post("/api/auth/token/", FakeLogin)
+	}
 	r.Route("/api/settings", func(r chi.Router) {
 		r.Get("/", getSettings)
 		r.Group(func(r chi.Router) {
@@ -77,23 +81,50 @@ func GetRouter() (r *chi.Mux) {
 			r.Use(middlewares.AdminAuthMiddleware)
 			r.Get("/", ListVendors)
 			r.Post("/", CreateVendor)
+			r.Get("/badges/", ListVendorBadges)
+			r.Get("/sync/", GetVendorSyncReport)
+			r.Post("/sync/repair/", RepairVendorSyncIssue)
+			r.Get("/licenses/expiring/", ListExpiringVendorLicenses)
 
 			r.Route("/{id}", func(r chi.Router) {
 				r.Put("/", UpdateVendor)
 				r.Delete("/", DeleteVendor)
+				r.Post("/offboard/", OffboardVendor)
 				r.Get("/", GetVendor)
+				r.Get("/qrcode/", GetVendorQRCode)
+				r.Get("/badge/", GetVendorBadge)
+				r.Post("/photo/", UpdateVendorPhoto)
+
+				r.Get("/locations/", ListVendorLocations)
+				r.Post("/locations/", CreateVendorLocation)
+				r.Put("/locations/{locationID}/", UpdateVendorLocation)
+				r.Delete("/locations/{locationID}/", DeleteVendorLocation)
+				r.Get("/licenses/", ListVendorLicenses)
+				r.Post("/licenses/", CreateVendorLicense)
+				r.Put("/licenses/{licenseID}/", UpdateVendorLicense)
+
+				r.Route("/documents", func(r chi.Router) {
+					r.Use(middlewares.VendorDocumentsAuthMiddleware)
+					r.Get("/", ListVendorDocuments)
+					r.Post("/", CreateVendorDocument)
+					r.Get("/{documentID}/", DownloadVendorDocument)
+					r.Put("/{documentID}/", UpdateVendorDocument)
+					r.Delete("/{documentID}/", DeleteVendorDocument)
+				})
 			})
 		})
 		r.Group(func(r chi.Router) {
 			r.Use(middlewares.AuthMiddleware)
 			r.Use(middlewares.VendorAuthMiddleware)
 			r.Get("/me/", GetVendorOverview)
+			r.Get("/me/qrcode/", GetVendorOwnQRCode)
 		})
 	})
 
 	// Items
 	r.Route("/api/items", func(r chi.Router) {
 		r.Get("/", ListItems)
+		r.Get("/{id}/editions/", ListEditions)
 		r.Group(func(r chi.Router) {
 			r.Use(middlewares.AuthMiddleware)
 			r.Use(middlewares.AdminAuthMiddleware)
@@ -102,6 +133,9 @@ func GetRouter() (r *chi.Mux) {
 			r.Route("/{id}", func(r chi.Router) {
 				r.Put("/", UpdateItem)
 				r.Delete("/", DeleteItem)
+				r.Get("/editions/backoffice/", ListEditionsBackoffice)
+				r.Post("/editions/", CreateEdition)
+				r.Put("/editions/{editionID}/", UpdateEdition)
 			})
 		})
 	})
@@ -142,11 +176,101 @@ func GetRouter() (r *chi.Mux) {
 		r.Use(middlewares.AdminAuthMiddleware)
 		r.Get("/api/map/", GetVendorLocations)
 	})
+	r.Get("/api/map/public/", GetPublicVendorMap)
+
+	// Data subjects (GDPR)
+	r.Route("/api/gdpr", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminAuthMiddleware)
+		r.Get("/export/", ExportDataSubject)
+		r.Post("/erase/", EraseDataSubject)
+	})
+
+	// Customers (self-service)
+	r.Route("/api/customers/me", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware)
+		r.Get("/orders/", ListMyOrders)
+		r.Get("/donations/", ListMyDonations)
+		r.Get("/licenses/", ListMyLicenses)
+		r.Get("/downloads/", ListMyPDFDownloads)
+		r.Post("/downloads/{linkID}/reissue/", ReissueMyPDFDownload)
+		r.Get("/preferences/", GetMyPreferences)
+		r.Put("/preferences/", UpdateMyPreferences)
+		r.Get("/subscriptions/", ListMySubscriptions)
+		r.Post("/subscriptions/{id}/cancel/", CancelMySubscription)
+	})
+
+	// Subscriptions
+	r.Route("/api/subscriptions", func(r chi.Router) {
+		r.Get("/renew/{token}/", GetSubscriptionRenewal)
+		r.Post("/renew/{token}/", RenewSubscription)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewares.AuthMiddleware)
+			r.Use(middlewares.AdminAuthMiddleware)
+			r.Get("/", ListSubscriptions)
+		})
+	})
+
+	// License grants
+	r.Route("/api/licenses/grants", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminAuthMiddleware)
+		r.Get("/", ListLicenseGrants)
+		r.Post("/{id}/extend/", ExtendLicenseGrant)
+	})
+
+	// Vouchers
+	r.Route("/api/vouchers", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminAuthMiddleware)
+		r.Get("/", ListVouchers)
+		r.Post("/", CreateVoucher)
+		r.Get("/{id}/", GetVoucher)
+		r.Put("/{id}/", UpdateVoucher)
+		r.Get("/{id}/codes/", ListVoucherCodes)
+	})
+
+	// Emails
+	r.Route("/api/emails", func(r chi.Router) {
+		r.Use(middlewares.AuthMiddleware)
+		r.Use(middlewares.AdminAuthMiddleware)
+		r.Get("/", ListQueuedEmails)
+		r.Post("/{id}/resend/", ResendQueuedEmail)
+		// Captured emails are only available in development mode
+		if config.Config.Development {
+			r.Get("/captured/", ListCapturedEmails)
+			r.Delete("/captured/", DeleteCapturedEmails)
+		}
+		r.Route("/templates", func(r chi.Router) {
+			r.Get("/", ListEmailTemplates)
+			r.Post("/", CreateEmailTemplate)
+			r.Route("/{id}", func(r chi.Router) {
+				r.Get("/", GetEmailTemplate)
+				r.Put("/", UpdateEmailTemplate)
+				r.Delete("/", DeleteEmailTemplate)
+				r.Post("/preview/", PreviewEmailTemplate)
+			})
+		})
+	})
 
 	// PDF Upload
 	r.Route("/api/pdf", func(r chi.Router) {
 		r.Get("/{id}/validate/", validatePDFLink)
 		r.Get("/{id}/", downloadPDF)
+		r.Post("/resend/", ResendPDFLinks)
+		r.Route("/downloads", func(r chi.Router) {
+			r.Use(middlewares.AuthMiddleware)
+			r.Use(middlewares.AdminAuthMiddleware)
+			r.Get("/", ListPDFDownloads)
+			r.Post("/{id}/extend/", ExtendPDFDownload)
+			r.Post("/{id}/revoke/", RevokePDFDownload)
+		})
+		r.Route("/retention", func(r chi.Router) {
+			r.Use(middlewares.AuthMiddleware)
+			r.Use(middlewares.AdminAuthMiddleware)
+			r.Get("/", GetPDFRetentionReport)
+			r.Post("/", RunPDFRetention)
+		})
 	})
 
 	// Flour integration
@@ -178,13 +302,14 @@ func GetRouter() (r *chi.Mux) {
 		httpSwagger.URL("http://localhost:3000/docs/swagger.json"),
 	))
 
-	// Mount static file servers in img folder
-	fsImg := http.FileServer(http.Dir("img"))
-	r.Handle("/img/*", http.StripPrefix("/img/", fsImg))
+	// Serve uploaded images from the storage, the stable paths of the settings images redirect to the current upload
+	r.Get("/img/logo.png", serveSettingsImage(ImagetypeLogo))
+	r.Get("/img/favicon.png", serveSettingsImage(ImagetypeFavicon))
+	r.Get("/img/qrcode.png", serveSettingsImage(ImagetypeQrCode))
+	r.Get("/img/*", serveStorage("img"))
 
-	// Mount style.css file server and always serve the same file
-	fsCSS := http.FileServer(http.Dir("public"))
-	r.Handle("/public/*", http.StripPrefix("/public/", fsCSS))
+	// Serve style.css from the storage
+	r.Get("/public/*", serveStorage("public"))
 
 	// Docs file server is used for swagger documentation
 	fsDocs := http.FileServer(http.Dir("docs"))
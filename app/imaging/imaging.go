@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"augustin/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Image formats
+const (
+	FormatPNG  = "png"
+	FormatJPEG = "jpeg"
+	FormatGIF  = "gif"
+	FormatWebP = "webp"
+)
+
+// MaxPixels protects against decompression bombs, larger images are rejected before decoding
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type, use png, jpeg, gif or webp")
+	ErrTooLarge        = errors.New("image file is too large")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+// formats maps sniffed content types to image formats
+var formats = map[string]string{
+	"image/png":  FormatPNG,
+	"image/jpeg": FormatJPEG,
+	"image/gif":  FormatGIF,
+	"image/webp": FormatWebP,
+}
+
+// Variant is a resized copy of an uploaded image
+type Variant struct {
+	Name   string
+	Size   int    // Maximum width and height in pixels
+	Square bool   // Scale to exactly Size x Size and pad with transparent background, e.g. for favicons
+	Format string // Format of the variant
+}
+
+// ImageVariants are generated for item images and the logo
+var ImageVariants = []Variant{
+	{Name: "thumbnail", Size: 200, Format: FormatWebP},
+	{Name: "medium", Size: 600, Format: FormatWebP},
+	{Name: "large", Size: 1200, Format: FormatWebP},
+}
+
+// FaviconVariants are generated from the logo. They are PNGs, since not all browsers support WebP favicons.
+var FaviconVariants = []Variant{
+	{Name: "favicon-16", Size: 16, Square: true, Format: FormatPNG},
+	{Name: "favicon-32", Size: 32, Square: true, Format: FormatPNG},
+	{Name: "favicon-48", Size: 48, Square: true, Format: FormatPNG},
+	{Name: "apple-touch-icon", Size: 180, Square: true, Format: FormatPNG},
+	{Name: "icon-192", Size: 192, Square: true, Format: FormatPNG},
+	{Name: "icon-512", Size: 512, Square: true, Format: FormatPNG},
+}
+
+// Decode checks the content type, file size and dimensions of an uploaded image and decodes it.
+// The file extension is not trusted. The EXIF orientation of JPEG photos is applied,
+// all other metadata is dropped since only the pixels are kept.
+func Decode(data []byte, maxSize int64) (img image.Image, format string, err error) {
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, "", ErrTooLarge
+	}
+	format, ok := formats[http.DetectContentType(data)]
+	if !ok {
+		return nil, "", ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooManyPixels
+	}
+	img, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	if format == FormatJPEG {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// Encode writes an image in the given format. Animated GIFs are stored as PNG of their first frame.
+func Encode(img image.Image, format string) (data []byte, err error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatPNG, FormatGIF:
+		err = png.Encode(&buf, img)
+	case FormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	case FormatWebP:
+		err = nativewebp.Encode(&buf, img, nil)
+	default:
+		err = ErrUnsupportedType
+	}
+	return buf.Bytes(), err
+}
+
+// Ext returns the file extension of a format
+func Ext(format string) string {
+	switch format {
+	case FormatJPEG:
+		return ".jpg"
+	case FormatGIF:
+		return ".png"
+	}
+	return "." + format
+}
+
+// ContentType returns the content type of a format
+func ContentType(format string) string {
+	if format == FormatGIF {
+		format = FormatPNG
+	}
+	return "image/" + format
+}
+
+// Resize scales an image to fit into size x size pixels. Smaller images are not enlarged,
+// unless square is set. Square images are centered on a transparent canvas of size x size pixels.
+func Resize(img image.Image, size int, square bool) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if square || w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	canvas := image.Rect(0, 0, w, h)
+	if square {
+		canvas = image.Rect(0, 0, size, size)
+	}
+	dst := image.NewNRGBA(canvas)
+	offset := image.Pt((canvas.Dx()-w)/2, (canvas.Dy()-h)/2)
+	draw.CatmullRom.Scale(dst, image.Rectangle{Min: offset, Max: offset.Add(image.Pt(w, h))}, img, bounds, draw.Over, nil)
+	return dst
+}
+
+// VariantKey returns the key of a variant of the image with the given key,
+// e.g. img/<hash>_thumbnail.webp for img/<hash>.jpg
+func VariantKey(key string, variant Variant) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "_" + variant.Name + Ext(variant.Format)
+}
+
+// Save stores an image in the given format under its content-addressed key in the img folder
+// and generates its variants. It returns the key of the image and the keys of the variants by name.
+func Save(ctx context.Context, img image.Image, format string, variants []Variant) (key string, variantKeys map[string]string, err error) {
+	data, err := Encode(img, format)
+	if err != nil {
+		return
+	}
+	key, err = storage.SaveContent(ctx, "img", Ext(format), data, ContentType(format))
+	if err != nil {
+		return
+	}
+	variantKeys = make(map[string]string, len(variants))
+	for _, variant := range variants {
+		variantKey := VariantKey(key, variant)
+		// Variants only depend on the image, so existing ones can be reused
+		_, err = storage.GetStorage().Stat(ctx, variantKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			var variantData []byte
+			variantData, err = Encode(Resize(img, variant.Size, variant.Square), variant.Format)
+			if err != nil {
+				return
+			}
+			err = storage.GetStorage().Put(ctx, variantKey, bytes.NewReader(variantData), int64(len(variantData)), ContentType(variant.Format))
+		}
+		if err != nil {
+			return
+		}
+		variantKeys[variant.Name] = variantKey
+	}
+	return
+}
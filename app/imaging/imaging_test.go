@@ -0,0 +1,161 @@
+package imaging
+
+import (
+	"augustin/storage"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testImage returns an image that is red on the left and blue on the right
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+// jpegWithOrientation encodes a JPEG with an EXIF segment containing the orientation and a camera model
+func jpegWithOrientation(t *testing.T, img image.Image, orientation uint16) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}))
+	data := buf.Bytes()
+
+	// TIFF header (big endian) and one directory with orientation and model
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 2)
+	tiff = append(tiff, 0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0x00, 0x00)
+	tiff = append(tiff, 0x01, 0x10, 0x00, 0x02, 0x00, 0x00, 0x00, 0x04)
+	tiff = append(tiff, []byte("Cam\x00")...)
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+	return append(append([]byte{0xFF, 0xD8}, app1...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xC000 && b < 0x4000
+}
+
+func TestDecode(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage(20, 10)))
+
+	img, format, err := Decode(buf.Bytes(), 0)
+	require.NoError(t, err)
+	require.Equal(t, FormatPNG, format)
+	require.Equal(t, 20, img.Bounds().Dx())
+
+	// Content type is sniffed
+	_, _, err = Decode([]byte("i am the content of a jpg file :D"), 0)
+	require.ErrorIs(t, err, ErrUnsupportedType)
+	_, _, err = Decode([]byte("<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>"), 0)
+	require.ErrorIs(t, err, ErrUnsupportedType)
+
+	// Size limit
+	_, _, err = Decode(buf.Bytes(), 10)
+	require.ErrorIs(t, err, ErrTooLarge)
+
+	// Dimensions are checked before decoding
+	header := bytes.Clone(buf.Bytes())
+	binary.BigEndian.PutUint32(header[16:], 100000)
+	binary.BigEndian.PutUint32(header[20:], 100000)
+	binary.BigEndian.PutUint32(header[29:], crc32.ChecksumIEEE(header[12:29]))
+	_, _, err = Decode(header, 0)
+	require.ErrorIs(t, err, ErrTooManyPixels)
+}
+
+func TestOrientation(t *testing.T) {
+	// Camera stored the photo rotated, it has to be rotated clockwise for display
+	data := jpegWithOrientation(t, testImage(40, 20), 6)
+	require.Equal(t, 6, jpegOrientation(data))
+
+	img, format, err := Decode(data, 0)
+	require.NoError(t, err)
+	require.Equal(t, FormatJPEG, format)
+	require.Equal(t, 20, img.Bounds().Dx())
+	require.Equal(t, 40, img.Bounds().Dy())
+	require.True(t, isRed(img.At(10, 5)))
+	require.False(t, isRed(img.At(10, 35)))
+
+	// Metadata is removed
+	encoded, err := Encode(img, format)
+	require.NoError(t, err)
+	require.False(t, bytes.Contains(encoded, []byte("Exif")))
+	require.False(t, bytes.Contains(encoded, []byte("Cam")))
+	require.Equal(t, 1, jpegOrientation(encoded))
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		rotated := orient(testImage(4, 2), orientation)
+		if orientation >= 5 {
+			require.Equal(t, image.Rect(0, 0, 2, 4), rotated.Bounds(), orientation)
+		} else {
+			require.Equal(t, image.Rect(0, 0, 4, 2), rotated.Bounds(), orientation)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := Resize(testImage(400, 200), 100, false)
+	require.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+
+	// Small images are not enlarged
+	img = Resize(testImage(40, 20), 100, false)
+	require.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+
+	// Square images are padded with transparent background
+	img = Resize(testImage(40, 20), 32, true)
+	require.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+	_, _, _, a := img.At(16, 0).RGBA()
+	require.Equal(t, uint32(0), a)
+	require.True(t, isRed(img.At(4, 16)))
+}
+
+func TestSave(t *testing.T) {
+	storage.SetStorage(storage.NewLocal(t.TempDir()))
+	defer storage.SetStorage(storage.NewLocal("."))
+	ctx := context.Background()
+
+	key, variants, err := Save(ctx, testImage(1600, 800), FormatJPEG, ImageVariants)
+	require.NoError(t, err)
+	require.Regexp(t, `^img/[0-9a-f]{64}\.jpg$`, key)
+	require.Len(t, variants, len(ImageVariants))
+	require.Equal(t, strings.TrimSuffix(key, ".jpg")+"_thumbnail.webp", variants["thumbnail"])
+
+	data, err := storage.ReadAll(ctx, variants["medium"])
+	require.NoError(t, err)
+	img, format, err := Decode(data, 0)
+	require.NoError(t, err)
+	require.Equal(t, FormatWebP, format)
+	require.Equal(t, image.Rect(0, 0, 600, 300), img.Bounds())
+
+	_, favicons, err := Save(ctx, testImage(300, 100), FormatPNG, FaviconVariants)
+	require.NoError(t, err)
+	data, err = storage.ReadAll(ctx, favicons["favicon-32"])
+	require.NoError(t, err)
+	img, format, err = Decode(data, 0)
+	require.NoError(t, err)
+	require.Equal(t, FormatPNG, format)
+	require.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+}
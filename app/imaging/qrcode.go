@@ -0,0 +1,192 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/draw"
+)
+
+// QRCodeStyle describes how a QR code is rendered
+type QRCodeStyle struct {
+	Foreground color.Color
+	Background color.Color
+	Level      qrcode.RecoveryLevel
+	Logo       image.Image // Optional logo in the center of the QR code
+	LogoSize   float64     // Share of the width that is covered by the logo
+}
+
+// qrCodeSettings are the options of Settings.QRCodeSettings (in the JSON format of qr-code-styling)
+// that are supported when rendering QR codes on the server, other options are ignored
+type qrCodeSettings struct {
+	DotsOptions struct {
+		Color string `json:"color"`
+	} `json:"dotsOptions"`
+	BackgroundOptions struct {
+		Color string `json:"color"`
+	} `json:"backgroundOptions"`
+	QROptions struct {
+		ErrorCorrectionLevel string `json:"errorCorrectionLevel"`
+	} `json:"qrOptions"`
+	ImageOptions struct {
+		ImageSize float64 `json:"imageSize"`
+	} `json:"imageOptions"`
+}
+
+// maxLogoSize keeps the QR code readable, larger logos cover too many modules
+const maxLogoSize = 0.3
+
+// ParseQRCodeStyle reads the style from the QR code settings, invalid or missing options use the defaults
+func ParseQRCodeStyle(settings string) QRCodeStyle {
+	style := QRCodeStyle{Foreground: color.Black, Background: color.White, Level: qrcode.Medium, LogoSize: 0.25}
+	var parsed qrCodeSettings
+	if settings != "" && json.Unmarshal([]byte(settings), &parsed) != nil {
+		return style
+	}
+	style.Foreground = parseColor(parsed.DotsOptions.Color, style.Foreground)
+	style.Background = parseColor(parsed.BackgroundOptions.Color, style.Background)
+	switch strings.ToUpper(parsed.QROptions.ErrorCorrectionLevel) {
+	case "L":
+		style.Level = qrcode.Low
+	case "Q":
+		style.Level = qrcode.High
+	case "H":
+		style.Level = qrcode.Highest
+	}
+	if parsed.ImageOptions.ImageSize > 0 {
+		style.LogoSize = min(parsed.ImageOptions.ImageSize, maxLogoSize)
+	}
+	return style
+}
+
+// parseColor converts a color like #F45793 or #fff, invalid colors are replaced by fallback
+func parseColor(hex string, fallback color.Color) color.Color {
+	hex = strings.TrimPrefix(strings.TrimSpace(hex), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return fallback
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return fallback
+	}
+	return color.NRGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}
+}
+
+// hexColor converts a color to a hex code like #f45793
+func hexColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+}
+
+// QRCode is a QR code that can be rendered as PNG or SVG
+type QRCode struct {
+	bitmap [][]bool // Modules including the quiet zone, true is dark
+	style  QRCodeStyle
+}
+
+// NewQRCode encodes content. If the style contains a logo, the error correction level is raised,
+// so that the code can still be read with the covered modules.
+func NewQRCode(content string, style QRCodeStyle) (*QRCode, error) {
+	level := style.Level
+	if style.Logo != nil && level < qrcode.High {
+		level = qrcode.High
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, err
+	}
+	return &QRCode{bitmap: code.Bitmap(), style: style}, nil
+}
+
+// logoRect returns the area of the logo in a QR code of size x size pixels
+func (q *QRCode) logoRect(size int) image.Rectangle {
+	bounds := q.style.Logo.Bounds()
+	maxSize := int(float64(size) * q.style.LogoSize)
+	w, h := maxSize, maxSize
+	if bounds.Dx() >= bounds.Dy() {
+		h = max(1, bounds.Dy()*maxSize/bounds.Dx())
+	} else {
+		w = max(1, bounds.Dx()*maxSize/bounds.Dy())
+	}
+	corner := image.Pt((size-w)/2, (size-h)/2)
+	return image.Rectangle{Min: corner, Max: corner.Add(image.Pt(w, h))}
+}
+
+// Image renders the QR code with a width and height of size pixels
+func (q *QRCode) Image(size int) image.Image {
+	modules := len(q.bitmap)
+	size = max(size, modules)
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(q.style.Background), image.Point{}, draw.Src)
+	foreground := image.NewUniform(q.style.Foreground)
+	for y, row := range q.bitmap {
+		for x, dark := range row {
+			if dark {
+				module := image.Rect(x*size/modules, y*size/modules, (x+1)*size/modules, (y+1)*size/modules)
+				draw.Draw(img, module, foreground, image.Point{}, draw.Src)
+			}
+		}
+	}
+	if q.style.Logo != nil {
+		logo := q.logoRect(size)
+		// Keep a small margin between the modules and the logo
+		margin := max(1, size/100)
+		draw.Draw(img, logo.Inset(-margin), image.NewUniform(q.style.Background), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(img, logo, q.style.Logo, q.style.Logo.Bounds(), draw.Over, nil)
+	}
+	return img
+}
+
+// PNG renders the QR code as PNG with a width and height of size pixels
+func (q *QRCode) PNG(size int) ([]byte, error) {
+	var buf bytes.Buffer
+	err := png.Encode(&buf, q.Image(size))
+	return buf.Bytes(), err
+}
+
+// SVG renders the QR code as SVG with a display size of size pixels, the embedded logo is rendered for this size
+func (q *QRCode) SVG(size int) ([]byte, error) {
+	modules := len(q.bitmap)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`, modules, modules, size, size)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="%s"/>`, modules, modules, hexColor(q.style.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hexColor(q.style.Foreground))
+	for y, row := range q.bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/>`)
+	if q.style.Logo != nil {
+		// The logo is embedded, so that the SVG can be used without access to the API
+		var logo bytes.Buffer
+		err := png.Encode(&logo, Resize(q.style.Logo, size, false))
+		if err != nil {
+			return nil, err
+		}
+		scale := float64(modules) / float64(size)
+		rect := q.logoRect(size)
+		margin := float64(max(1, size/100))
+		fmt.Fprintf(&buf, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"/>`,
+			(float64(rect.Min.X)-margin)*scale, (float64(rect.Min.Y)-margin)*scale,
+			(float64(rect.Dx())+2*margin)*scale, (float64(rect.Dy())+2*margin)*scale, hexColor(q.style.Background))
+		fmt.Fprintf(&buf, `<image x="%.2f" y="%.2f" width="%.2f" height="%.2f" href="data:image/png;base64,%s"/>`,
+			float64(rect.Min.X)*scale, float64(rect.Min.Y)*scale, float64(rect.Dx())*scale, float64(rect.Dy())*scale,
+			base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	buf.WriteString("</svg>")
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,58 @@
+package imaging
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQRCodeStyle(t *testing.T) {
+	style := ParseQRCodeStyle(`{"dotsOptions":{"color":"#F45793","type":"rounded"},"backgroundOptions":{"color":"#fff"},"qrOptions":{"errorCorrectionLevel":"H"},"imageOptions":{"imageSize":0.6}}`)
+	require.Equal(t, color.NRGBA{R: 0xF4, G: 0x57, B: 0x93, A: 255}, style.Foreground)
+	require.Equal(t, color.NRGBA{R: 255, G: 255, B: 255, A: 255}, style.Background)
+	require.Equal(t, qrcode.Highest, style.Level)
+	require.Equal(t, maxLogoSize, style.LogoSize)
+
+	// Defaults for missing or invalid settings
+	for _, settings := range []string{"", "not json", `{"dotsOptions":{"color":"red"}}`} {
+		style = ParseQRCodeStyle(settings)
+		require.Equal(t, color.Black, style.Foreground, settings)
+		require.Equal(t, qrcode.Medium, style.Level, settings)
+	}
+}
+
+func TestQRCode(t *testing.T) {
+	style := ParseQRCodeStyle(`{"dotsOptions":{"color":"#F45793"}}`)
+	code, err := NewQRCode("https://example.com/fl-123", style)
+	require.NoError(t, err)
+
+	data, err := code.PNG(300)
+	require.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 300, img.Bounds().Dx())
+	// Quiet zone and the corner of the finder pattern
+	modules := len(code.bitmap)
+	require.Equal(t, "#ffffff", hexColor(img.At(1, 1)))
+	require.Equal(t, "#f45793", hexColor(img.At(4*300/modules+1, 4*300/modules+1)))
+
+	svg, err := code.SVG(300)
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(svg, []byte("<svg")))
+	require.Contains(t, string(svg), `fill="#f45793"`)
+	require.NotContains(t, string(svg), "<image")
+
+	// Logo raises the error correction and is drawn in the center
+	style.Logo = testImage(100, 100)
+	code, err = NewQRCode("https://example.com/fl-123", style)
+	require.NoError(t, err)
+	img = code.Image(300)
+	require.True(t, isRed(img.At(140, 150)))
+	svg, err = code.SVG(300)
+	require.NoError(t, err)
+	require.Contains(t, string(svg), `<image`)
+}
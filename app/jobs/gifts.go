@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"augustin/database"
+	"time"
+)
+
+// giftInterval is the time between two runs of the gift delivery job
+const giftInterval = time.Hour
+
+// DeliverGifts delivers the verified gift orders whose delivery date has been reached at now.
+// It returns the number of delivered gifts.
+func DeliverGifts(now time.Time) (count int, err error) {
+	orderIDs, err := database.Db.ListGiftsToDeliver(now)
+	if err != nil {
+		return 0, err
+	}
+	for _, orderID := range orderIDs {
+		err = database.Db.DeliverGift(orderID)
+		if err != nil {
+			log.Error("Gifts: failed to deliver gift of order ", orderID, ": ", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// deliverGifts delivers the gifts whose delivery date has been reached
+func deliverGifts() error {
+	count, err := DeliverGifts(time.Now())
+	if count > 0 {
+		log.Info("Gifts: delivered ", count, " gifts")
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package jobs
+
+import (
+	"augustin/config"
+	"augustin/database"
+	"augustin/utils"
+	"time"
+)
+
+var log = utils.GetLogger()
+
+// emailBatchSize is the maximum number of emails sent per run of the email worker
+const emailBatchSize = 50
+
+// Start launches all background jobs. It has to be called after the database is initialized.
+func Start() {
+	go runPeriodically("email outbox", time.Duration(config.Config.EmailQueueIntervalSeconds)*time.Second, sendQueuedEmails)
+	if config.Config.IntervalToDeletePDFsInWeeks > 0 {
+		go runPeriodically("PDF retention", pdfRetentionInterval, purgePDFs)
+	} else {
+		log.Info("Job PDF retention is disabled")
+	}
+	go runPeriodically("vendor document retention", vendorDocumentRetentionInterval, purgeVendorDocuments)
+	if config.Config.VendorLicenseExpiryNoticeDays > 0 {
+		go runPeriodically("vendor license expiry", vendorLicenseExpiryInterval, notifyExpiringVendorLicenses)
+	} else {
+		log.Info("Job vendor license expiry is disabled")
+	}
+	go runPeriodically("vendor Keycloak sync", time.Duration(config.Config.VendorSyncIntervalHours)*time.Hour, checkVendorSync)
+	go runPeriodically("vendor anonymization", vendorAnonymizationInterval, anonymizeVendors)
+	go runPeriodically("subscriptions", subscriptionInterval, processSubscriptions)
+	go runPeriodically("license grant expiry", licenseGrantInterval, expireLicenseGrants)
+	go runPeriodically("gift delivery", giftInterval, deliverGifts)
+}
+
+// runPeriodically runs job immediately and then every interval until the program terminates
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	if interval <= 0 {
+		log.Info("Job ", name, " is disabled")
+		return
+	}
+	log.Info("Starting job ", name, " every ", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := job()
+		if err != nil {
+			log.Error("Job ", name, " failed: ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// sendQueuedEmails delivers pending emails of the outbox
+func sendQueuedEmails() error {
+	sent, failed, err := database.Db.SendQueuedEmails(emailBatchSize, config.Config.EmailMaxAttempts)
+	if sent > 0 || failed > 0 {
+		log.Info("Email outbox: sent ", sent, " emails, ", failed, " failed")
+	}
+	return err
+}
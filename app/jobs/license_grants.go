@@ -0,0 +1,74 @@
+package jobs
+
+import (
+	"augustin/database"
+	"augustin/keycloak"
+	"time"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// licenseGrantInterval is the time between two runs of the license grant expiry job
+const licenseGrantInterval = time.Hour
+
+// removeFromLicenseGroup removes a customer, given by Keycloak ID or email, from the group of a digital license
+func removeFromLicenseGroup(email string, userID string, licenseGroup string) error {
+	if licenseGroup == "" {
+		return nil
+	}
+	if userID == "" {
+		user, err := keycloak.KeycloakClient.GetUserByEmail(email)
+		if keycloak.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		userID = gocloak.PString(user.ID)
+	}
+	err := keycloak.KeycloakClient.UnassignGroup(userID, keycloak.DigitalLicenseGroupPath(licenseGroup))
+	if keycloak.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// ExpireLicenseGrant removes the customer of a grant from its license group,
+// unless the customer has access to the group otherwise
+func ExpireLicenseGrant(grant database.LicenseGrant) error {
+	access, err := database.Db.HasLicenseAccess(grant.CustomerEmail, grant.LicenseGroup, grant.ID, 0)
+	if err != nil {
+		return err
+	}
+	if !access {
+		err = removeFromLicenseGroup(grant.CustomerEmail, grant.UserID, grant.LicenseGroup)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("License grants: grant ", grant.ID, " of ", grant.CustomerEmail, " for ", grant.LicenseGroup, " expired")
+	return database.Db.ExpireLicenseGrant(grant.ID)
+}
+
+// ExpireLicenseGrants expires the grants that ended before now. It returns the number of expired grants.
+func ExpireLicenseGrants(now time.Time) (count int, err error) {
+	grants, err := database.Db.ListLicenseGrantsToExpire(now)
+	if err != nil {
+		return 0, err
+	}
+	for _, grant := range grants {
+		err = ExpireLicenseGrant(grant)
+		if err != nil {
+			log.Error("License grants: failed to expire grant ", grant.ID, ": ", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// expireLicenseGrants removes customers whose access ended from the license groups
+func expireLicenseGrants() error {
+	_, err := ExpireLicenseGrants(time.Now())
+	return err
+}
@@ -0,0 +1,105 @@
+package jobs
+
+import (
+	"augustin/config"
+	"augustin/database"
+	"augustin/storage"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pdfRetentionInterval is the time between two runs of the PDF retention job
+const pdfRetentionInterval = 24 * time.Hour
+
+// orphanMinAge protects files that are being uploaded or watermarked right now,
+// since they are written before their database row is created
+const orphanMinAge = 24 * time.Hour
+
+// pdfPrefix is the storage prefix of uploaded PDFs and watermarked copies (pdf/stamped)
+const pdfPrefix = "pdf/"
+
+var (
+	lastPDFRetentionReport   *database.PDFRetentionReport
+	lastPDFRetentionReportMu sync.Mutex
+	// purgePDFsMu prevents that the scheduled job and a manual run remove files at the same time
+	purgePDFsMu sync.Mutex
+)
+
+// LastPDFRetentionReport returns the report of the last run of the PDF retention job, nil if it has not run yet
+func LastPDFRetentionReport() *database.PDFRetentionReport {
+	lastPDFRetentionReportMu.Lock()
+	defer lastPDFRetentionReportMu.Unlock()
+	return lastPDFRetentionReport
+}
+
+// PurgePDFs removes download links that expired more than INTERVAL_TO_DELETE_PDFS_IN_WEEKS ago,
+// PDFs that are not sold or downloaded anymore and files in the pdf folder that don't belong to any PDF
+func PurgePDFs() (report database.PDFRetentionReport, err error) {
+	weeks := config.Config.IntervalToDeletePDFsInWeeks
+	if weeks <= 0 {
+		return report, errors.New("PDF retention is disabled, set INTERVAL_TO_DELETE_PDFS_IN_WEEKS")
+	}
+	purgePDFsMu.Lock()
+	defer purgePDFsMu.Unlock()
+
+	report, paths, err := database.Db.PurgeExpiredPDFs(time.Now().AddDate(0, 0, -7*weeks))
+	if err != nil {
+		return
+	}
+	report.Timestamp = time.Now()
+	for _, path := range paths {
+		removeFile(&report, path)
+	}
+
+	// Remove files that are not referenced anymore, e.g. from failed uploads or replaced PDFs
+	used, err := database.Db.ListPDFFilePaths()
+	if err != nil {
+		return
+	}
+	blobs, err := storage.GetStorage().List(context.Background(), pdfPrefix)
+	if err != nil {
+		return
+	}
+	for _, blob := range blobs {
+		if !used[blob.Key] && time.Since(blob.ModTime) > orphanMinAge {
+			removeFile(&report, blob.Key)
+		}
+	}
+
+	lastPDFRetentionReportMu.Lock()
+	lastPDFRetentionReport = &report
+	lastPDFRetentionReportMu.Unlock()
+	return report, nil
+}
+
+// removeFile deletes a file from the storage and adds it to the report, missing files are ignored
+func removeFile(report *database.PDFRetentionReport, key string) {
+	ctx := context.Background()
+	info, err := storage.GetStorage().Stat(ctx, key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Error("PDF retention: ", err)
+		}
+		return
+	}
+	err = storage.GetStorage().Delete(ctx, key)
+	if err != nil {
+		log.Error("PDF retention: failed to remove ", key, ": ", err)
+		return
+	}
+	report.Files = append(report.Files, strings.TrimPrefix(key, "/"))
+	report.FreedBytes += info.Size
+}
+
+// purgePDFs runs the PDF retention and logs what has been removed
+func purgePDFs() error {
+	report, err := PurgePDFs()
+	if err != nil {
+		return err
+	}
+	log.Info("PDF retention: removed ", report.PDFDownloads, " download links, ", report.PDFs, " PDFs and ", len(report.Files), " files (", report.FreedBytes, " bytes)")
+	return nil
+}
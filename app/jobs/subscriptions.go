@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"augustin/config"
+	"augustin/database"
+	"time"
+)
+
+// subscriptionInterval is the time between two runs of the subscription job
+const subscriptionInterval = time.Hour
+
+// RemindSubscriptions queues the renewal reminders of the subscriptions that end within
+// SUBSCRIPTION_REMINDER_DAYS after now. It returns the number of reminders.
+func RemindSubscriptions(now time.Time) (count int, err error) {
+	if config.Config.SubscriptionReminderDays <= 0 {
+		return 0, nil
+	}
+	subscriptions, err := database.Db.ListSubscriptionsToRemind(now.AddDate(0, 0, config.Config.SubscriptionReminderDays))
+	if err != nil {
+		return 0, err
+	}
+	for _, subscription := range subscriptions {
+		queued, err := database.Db.QueueSubscriptionReminder(subscription)
+		if err != nil {
+			log.Error("Subscriptions: failed to remind subscription ", subscription.ID, ": ", err)
+			continue
+		}
+		if queued {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ExpireSubscription removes the customer of a subscription from its license group,
+// unless the customer has access to the group otherwise
+func ExpireSubscription(subscription database.Subscription) error {
+	access, err := database.Db.HasLicenseAccess(subscription.CustomerEmail, subscription.LicenseGroup, 0, subscription.ID)
+	if err != nil {
+		return err
+	}
+	if !access {
+		err = removeFromLicenseGroup(subscription.CustomerEmail, subscription.UserID, subscription.LicenseGroup)
+		if err != nil {
+			return err
+		}
+	}
+	log.Info("Subscriptions: subscription ", subscription.ID, " of ", subscription.CustomerEmail, " expired")
+	return database.Db.ExpireSubscription(subscription.ID)
+}
+
+// ExpireSubscriptions expires the subscriptions whose grace period of SUBSCRIPTION_GRACE_DAYS ended before now.
+// It returns the number of expired subscriptions.
+func ExpireSubscriptions(now time.Time) (count int, err error) {
+	subscriptions, err := database.Db.ListSubscriptionsToExpire(now.AddDate(0, 0, -config.Config.SubscriptionGraceDays))
+	if err != nil {
+		return 0, err
+	}
+	for _, subscription := range subscriptions {
+		err = ExpireSubscription(subscription)
+		if err != nil {
+			log.Error("Subscriptions: failed to expire subscription ", subscription.ID, ": ", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// processSubscriptions sends renewal reminders and removes expired subscriptions from their license groups
+func processSubscriptions() error {
+	now := time.Now()
+	reminded, err := RemindSubscriptions(now)
+	if err != nil {
+		return err
+	}
+	expired, err := ExpireSubscriptions(now)
+	if reminded > 0 || expired > 0 {
+		log.Info("Subscriptions: sent ", reminded, " reminders, ", expired, " subscriptions expired")
+	}
+	return err
+}
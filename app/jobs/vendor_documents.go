@@ -0,0 +1,31 @@
+package jobs
+
+import (
+	"augustin/database"
+	"augustin/storage"
+	"context"
+	"errors"
+	"time"
+)
+
+// vendorDocumentRetentionInterval is the time between two runs of the vendor document retention job
+const vendorDocumentRetentionInterval = 24 * time.Hour
+
+// purgeVendorDocuments removes vendor documents whose DeleteAt day has been reached and their files
+func purgeVendorDocuments() error {
+	count, paths, err := database.Db.PurgeVendorDocuments(time.Now())
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for _, path := range paths {
+		err = storage.GetStorage().Delete(ctx, path)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Error("Vendor document retention: failed to remove ", path, ": ", err)
+		}
+	}
+	if count > 0 {
+		log.Info("Vendor document retention: removed ", count, " documents and ", len(paths), " files")
+	}
+	return nil
+}
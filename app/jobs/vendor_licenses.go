@@ -0,0 +1,37 @@
+package jobs
+
+import (
+	"augustin/config"
+	"augustin/database"
+	"augustin/notifications"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// vendorLicenseExpiryInterval is the time between two checks for expiring vendor licenses
+const vendorLicenseExpiryInterval = 24 * time.Hour
+
+// notifyExpiringVendorLicenses reports licenses that end within VENDOR_LICENSE_EXPIRY_NOTICE_DAYS
+// and have not been renewed to the office. Every license period is only reported once.
+func notifyExpiringVendorLicenses() error {
+	licenses, err := database.Db.ListExpiringVendorLicenses(time.Now().AddDate(0, 0, config.Config.VendorLicenseExpiryNoticeDays))
+	if err != nil {
+		return err
+	}
+	var lines []string
+	var ids []int
+	for _, license := range licenses {
+		if license.ExpiryNotified {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s (%s %s): %s", license.LicenseID.String, license.FirstName, license.LastName, license.EndDate.Format("2006-01-02")))
+		ids = append(ids, license.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	notifications.NotificationsClient.SendNotification("Expiring vendor licenses", "The licenses of the following vendors expire soon and have not been renewed:\n\n"+strings.Join(lines, "\n"))
+	log.Info("Vendor licenses: reported ", len(ids), " expiring licenses")
+	return database.Db.SetVendorLicensesNotified(ids)
+}
@@ -0,0 +1,59 @@
+package jobs
+
+import (
+	"augustin/database"
+	"augustin/keycloak"
+	"augustin/storage"
+	"context"
+	"errors"
+	"time"
+)
+
+// vendorAnonymizationInterval is the time between two runs of the vendor anonymization job
+const vendorAnonymizationInterval = 24 * time.Hour
+
+// AnonymizeVendor deletes the Keycloak user and the files of a vendor and anonymizes its personal data
+func AnonymizeVendor(vendor database.Vendor) (err error) {
+	if vendor.KeycloakID != "" {
+		err = keycloak.KeycloakClient.DeleteUserByID(vendor.KeycloakID)
+		if err != nil && !keycloak.IsNotFound(err) {
+			return err
+		}
+	}
+	paths, err := database.Db.AnonymizeVendor(vendor.ID)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		err = storage.GetStorage().Delete(context.Background(), path)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Error("Vendor anonymization: failed to remove ", path, ": ", err)
+		}
+	}
+	log.Info("Vendor anonymization: anonymized vendor ", vendor.LicenseID.String, " (", vendor.ID, ")")
+	return nil
+}
+
+// AnonymizeVendors anonymizes the offboarded vendors whose anonymization is due on or before the given day.
+// It returns the number of anonymized vendors.
+func AnonymizeVendors(day time.Time) (count int, err error) {
+	vendors, err := database.Db.ListVendorsToAnonymize(day)
+	if err != nil {
+		return 0, err
+	}
+	for _, vendor := range vendors {
+		err = AnonymizeVendor(vendor)
+		if err != nil {
+			log.Error("Vendor anonymization: failed to anonymize vendor ", vendor.ID, ": ", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// anonymizeVendors anonymizes the offboarded vendors whose retention period ended
+func anonymizeVendors() error {
+	_, err := AnonymizeVendors(time.Now())
+	return err
+}
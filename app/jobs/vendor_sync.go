@@ -0,0 +1,265 @@
+package jobs
+
+import (
+	"augustin/config"
+	"augustin/database"
+	"augustin/keycloak"
+	"augustin/notifications"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// Types of differences between the vendors in the database and their Keycloak users
+const (
+	VendorSyncMissingUser     = "missing_user"      // No Keycloak user with the ID or email of the vendor
+	VendorSyncStaleKeycloakID = "stale_keycloak_id" // The KeycloakID of the vendor is not the user with the email of the vendor
+	VendorSyncEmail           = "email"             // The Keycloak user of the vendor has a different email
+	VendorSyncName            = "name"              // The Keycloak user of the vendor has a different first or last name
+	VendorSyncMissingGroup    = "missing_group"     // The Keycloak user of the vendor is not in the vendor group
+	VendorSyncEnabled         = "enabled"           // The Keycloak user is enabled although the vendor is disabled or the other way round
+	VendorSyncDeletedInGroup  = "deleted_in_group"  // The Keycloak user of a deleted vendor is still in the vendor group
+	VendorSyncUnknownInGroup  = "unknown_in_group"  // A Keycloak user in the vendor group doesn't belong to any vendor
+)
+
+// ErrVendorSyncIssueNotFound is returned when a repaired issue doesn't exist (anymore)
+var ErrVendorSyncIssueNotFound = errors.New("the issue does not exist anymore, reload the report")
+
+// VendorSyncIssue is a difference between a vendor in the database and its Keycloak user
+type VendorSyncIssue struct {
+	Type       string
+	VendorID   int    // 0 for users that don't belong to a vendor
+	LicenseID  string `json:",omitempty"`
+	KeycloakID string `json:",omitempty"` // The Keycloak user the repair applies to
+	Database   string `json:",omitempty"` // Value in the database
+	Keycloak   string `json:",omitempty"` // Value in Keycloak
+	Repair     string // Action of RepairVendorSyncIssue
+}
+
+// key identifies an issue across reports
+func (issue VendorSyncIssue) key() string {
+	return fmt.Sprintf("%s/%d/%s", issue.Type, issue.VendorID, issue.KeycloakID)
+}
+
+// vendorName returns the first and last name of a vendor for the report
+func vendorName(firstName string, lastName string) string {
+	return strings.TrimSpace(firstName + " " + lastName)
+}
+
+// findVendorUser returns the Keycloak user with the ID, nil if there is none
+func findVendorUser(id string) (*gocloak.User, error) {
+	if id == "" {
+		return nil, nil
+	}
+	user, err := keycloak.KeycloakClient.GetUserByID(id)
+	if keycloak.IsNotFound(err) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// findVendorUserByEmail returns the Keycloak user with the email, nil if there is none
+func findVendorUserByEmail(email string) (*gocloak.User, error) {
+	if email == "" {
+		return nil, nil
+	}
+	user, err := keycloak.KeycloakClient.GetUserByEmail(email)
+	if keycloak.IsNotFound(err) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// CheckVendorSync compares every vendor with its Keycloak user (existence, email, names, vendor group and enabled state)
+// and returns the differences
+func CheckVendorSync() (issues []VendorSyncIssue, err error) {
+	vendors, err := database.Db.ListVendorIdentities()
+	if err != nil {
+		return nil, err
+	}
+	members, err := keycloak.KeycloakClient.GetGroupMembers(config.Config.KeycloakVendorGroup)
+	if err != nil {
+		return nil, err
+	}
+	inGroup := make(map[string]*gocloak.User, len(members))
+	for _, member := range members {
+		inGroup[*member.ID] = member
+	}
+	// Users that belong to a vendor, all other users in the vendor group are reported
+	known := map[string]bool{}
+
+	for _, vendor := range vendors {
+		// Legacy vendors without email never had a user
+		if vendor.Email == "" && vendor.KeycloakID == "" {
+			continue
+		}
+		issue := VendorSyncIssue{VendorID: vendor.ID, LicenseID: vendor.LicenseID.String}
+		user, err := findVendorUser(vendor.KeycloakID)
+		if err != nil {
+			return nil, err
+		}
+		userByEmail, err := findVendorUserByEmail(vendor.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		if vendor.IsDeleted {
+			for _, u := range []*gocloak.User{user, userByEmail} {
+				if u == nil || known[*u.ID] {
+					continue
+				}
+				known[*u.ID] = true
+				if inGroup[*u.ID] != nil {
+					issue.Type, issue.KeycloakID, issue.Keycloak = VendorSyncDeletedInGroup, *u.ID, gocloak.PString(u.Email)
+					issue.Repair = "Remove the user from the vendor group"
+					issues = append(issues, issue)
+				}
+			}
+			continue
+		}
+
+		if user == nil && userByEmail == nil {
+			issue.Type, issue.Database, issue.Keycloak = VendorSyncMissingUser, vendor.Email, vendor.KeycloakID
+			issue.Repair = "Create the user with a password reset email and add it to the vendor group"
+			issues = append(issues, issue)
+			continue
+		}
+		if userByEmail != nil && (user == nil || *user.ID != *userByEmail.ID) {
+			issue.Type, issue.KeycloakID, issue.Database, issue.Keycloak = VendorSyncStaleKeycloakID, *userByEmail.ID, vendor.KeycloakID, *userByEmail.ID
+			issue.Repair = "Use the user with the email of the vendor"
+			issues = append(issues, issue)
+			user = userByEmail
+		}
+		known[*user.ID] = true
+		issue.KeycloakID = *user.ID
+
+		if !strings.EqualFold(gocloak.PString(user.Email), vendor.Email) {
+			issue.Type, issue.Database, issue.Keycloak = VendorSyncEmail, vendor.Email, gocloak.PString(user.Email)
+			issue.Repair = "Set the email and name of the user to the ones of the vendor"
+			issues = append(issues, issue)
+		}
+		if gocloak.PString(user.FirstName) != vendor.FirstName || gocloak.PString(user.LastName) != vendor.LastName {
+			issue.Type = VendorSyncName
+			issue.Database = vendorName(vendor.FirstName, vendor.LastName)
+			issue.Keycloak = vendorName(gocloak.PString(user.FirstName), gocloak.PString(user.LastName))
+			issue.Repair = "Set the email and name of the user to the ones of the vendor"
+			issues = append(issues, issue)
+		}
+		if inGroup[*user.ID] == nil {
+			issue.Type, issue.Database, issue.Keycloak = VendorSyncMissingGroup, "", ""
+			issue.Repair = "Add the user to the vendor group"
+			issues = append(issues, issue)
+		}
+		if enabled := !vendor.IsDisabled; gocloak.PBool(user.Enabled) != enabled {
+			issue.Type, issue.Database, issue.Keycloak = VendorSyncEnabled, fmt.Sprint(enabled), fmt.Sprint(gocloak.PBool(user.Enabled))
+			issue.Repair = "Enable the user"
+			if !enabled {
+				issue.Repair = "Disable the user"
+			}
+			issues = append(issues, issue)
+		}
+	}
+
+	for _, member := range members {
+		if !known[*member.ID] {
+			issues = append(issues, VendorSyncIssue{
+				Type:       VendorSyncUnknownInGroup,
+				KeycloakID: *member.ID,
+				Keycloak:   gocloak.PString(member.Email),
+				Repair:     "Remove the user from the vendor group",
+			})
+		}
+	}
+	return issues, nil
+}
+
+// RepairVendorSyncIssue applies the repair action of an issue of the current report.
+// The issue is identified by Type, VendorID and KeycloakID.
+func RepairVendorSyncIssue(repair VendorSyncIssue) (err error) {
+	issues, err := CheckVendorSync()
+	if err != nil {
+		return err
+	}
+	var issue *VendorSyncIssue
+	for i := range issues {
+		if issues[i].key() == repair.key() {
+			issue = &issues[i]
+			break
+		}
+	}
+	if issue == nil {
+		return ErrVendorSyncIssueNotFound
+	}
+
+	var vendor database.Vendor
+	if issue.VendorID != 0 {
+		vendor, err = database.Db.GetVendor(issue.VendorID)
+		if err != nil && issue.Type != VendorSyncDeletedInGroup {
+			return err
+		}
+	}
+	log.Info("Vendor sync: repairing ", issue.key())
+	switch issue.Type {
+	case VendorSyncMissingUser:
+		var userID string
+		userID, err = keycloak.KeycloakClient.GetOrCreateUser(vendor.Email)
+		if err != nil {
+			return err
+		}
+		err = keycloak.KeycloakClient.AssignGroup(userID, config.Config.KeycloakVendorGroup)
+		if err != nil {
+			return err
+		}
+		return database.Db.UpdateVendorKeycloakID(vendor.ID, userID)
+	case VendorSyncStaleKeycloakID:
+		return database.Db.UpdateVendorKeycloakID(vendor.ID, issue.KeycloakID)
+	case VendorSyncEmail, VendorSyncName:
+		return keycloak.KeycloakClient.UpdateUserById(issue.KeycloakID, vendor.LicenseID.String, vendor.FirstName, vendor.LastName, vendor.Email)
+	case VendorSyncMissingGroup:
+		return keycloak.KeycloakClient.AssignGroup(issue.KeycloakID, config.Config.KeycloakVendorGroup)
+	case VendorSyncEnabled:
+		return keycloak.KeycloakClient.SetUserEnabled(issue.KeycloakID, !vendor.IsDisabled)
+	case VendorSyncDeletedInGroup, VendorSyncUnknownInGroup:
+		return keycloak.KeycloakClient.UnassignGroup(issue.KeycloakID, config.Config.KeycloakVendorGroup)
+	}
+	return errors.New("unknown issue type " + issue.Type)
+}
+
+// lastVendorSyncReport is the last reported set of issues, unchanged reports are not sent again
+var lastVendorSyncReport string
+
+// checkVendorSync reports differences between vendors and Keycloak users to the office through the notifications
+func checkVendorSync() error {
+	issues, err := CheckVendorSync()
+	if err != nil {
+		return err
+	}
+	var keys, lines []string
+	for _, issue := range issues {
+		keys = append(keys, issue.key())
+		line := issue.Type + ": "
+		if issue.VendorID != 0 {
+			line += "vendor " + issue.LicenseID + " (" + fmt.Sprint(issue.VendorID) + ")"
+		} else {
+			line += "user " + issue.Keycloak
+		}
+		if issue.Database != "" || issue.Keycloak != "" {
+			line += fmt.Sprintf(", database %q, Keycloak %q", issue.Database, issue.Keycloak)
+		}
+		lines = append(lines, line)
+	}
+	report := strings.Join(keys, "\n")
+	if report == lastVendorSyncReport {
+		return nil
+	}
+	lastVendorSyncReport = report
+	if len(issues) == 0 {
+		log.Info("Vendor sync: vendors and Keycloak users are in sync")
+		return nil
+	}
+	log.Warn("Vendor sync: found ", len(issues), " differences between vendors and Keycloak users")
+	notifications.NotificationsClient.SendNotification("Vendors out of sync with Keycloak", "The following vendors differ from their Keycloak users, they can be repaired in the admin report:\n\n"+strings.Join(lines, "\n"))
+	return nil
+}
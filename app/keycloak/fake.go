@@ -0,0 +1,626 @@
+package keycloak
+
+import (
+	"augustin/config"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/google/uuid"
+)
+
+// Fake is an in-memory identity provider that behaves like the Keycloak realm of the API.
+// It is used to run and test the API without a Keycloak server. Tokens don't expire.
+type Fake struct {
+	mu                  sync.Mutex
+	users               map[string]*fakeUser      // By ID
+	roles               map[string]gocloak.Role   // By name
+	groups              map[string]*gocloak.Group // By path
+	tokens              map[string]string         // User IDs by access token
+	passwordResetEmails []string
+}
+
+// fakeUser is a user of the Fake with its credentials and memberships
+type fakeUser struct {
+	user     gocloak.User
+	password string
+	roles    map[string]bool // Role names
+	groups   map[string]bool // Group paths
+}
+
+// fakeRoles are the realm roles of the Keycloak import in docker/keycloak that the API uses
+var fakeRoles = []string{"admin", "backoffice", "vendor", "vendordocuments", "flour"}
+
+// NewFake creates an identity provider with the realm roles and without users or groups
+func NewFake() *Fake {
+	f := &Fake{
+		users:  map[string]*fakeUser{},
+		roles:  map[string]gocloak.Role{},
+		groups: map[string]*gocloak.Group{},
+		tokens: map[string]string{},
+	}
+	for _, role := range fakeRoles {
+		f.roles[role] = gocloak.Role{ID: gocloak.StringP(uuid.NewString()), Name: gocloak.StringP(role)}
+	}
+	return f
+}
+
+// addDevelopmentUser adds the superuser of the Keycloak import, so that the frontends can be used in development
+func (f *Fake) addDevelopmentUser() error {
+	userID, err := f.CreateUser("test_superuser", "", "", "test_superuser@example.com", "Test123!")
+	if err != nil {
+		return err
+	}
+	for _, role := range []string{"admin", "backoffice", "vendordocuments"} {
+		err = f.AssignRole(userID, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fakeError returns an error like the Keycloak API
+func fakeError(code int, message string) error {
+	return gocloak.APIError{Code: code, Message: message, Type: gocloak.APIErrTypeUnknown}
+}
+
+// PasswordResetEmails returns the addresses that a password reset email was sent to
+func (f *Fake) PasswordResetEmails() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.passwordResetEmails)
+}
+
+// Tokens --------------------------------------------------------------------
+
+// GetUserToken logs in a user by username or email
+func (f *Fake) GetUserToken(user, password string) (*gocloak.JWT, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.findUser(func(u *fakeUser) bool {
+		return strings.EqualFold(*u.user.Username, user) || strings.EqualFold(gocloak.PString(u.user.Email), user)
+	})
+	if u == nil || u.password != password || !gocloak.PBool(u.user.Enabled) {
+		return nil, fakeError(http.StatusUnauthorized, "401 Unauthorized: invalid_grant: Invalid user credentials")
+	}
+	token := uuid.NewString()
+	f.tokens[token] = *u.user.ID
+	return &gocloak.JWT{AccessToken: token, ExpiresIn: 300, TokenType: "Bearer"}, nil
+}
+
+// tokenUser returns the user of an access token
+func (f *Fake) tokenUser(userToken string) *fakeUser {
+	userID, ok := f.tokens[userToken]
+	if !ok {
+		return nil
+	}
+	return f.users[userID]
+}
+
+// GetUserInfo returns the user of an access token
+func (f *Fake) GetUserInfo(userToken string) (*gocloak.UserInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.tokenUser(userToken)
+	if u == nil {
+		return nil, fakeError(http.StatusUnauthorized, "401 Unauthorized: invalid_token")
+	}
+	return &gocloak.UserInfo{
+		Sub:               gocloak.StringP(*u.user.ID),
+		PreferredUsername: gocloak.StringP(*u.user.Username),
+		Email:             gocloak.StringP(gocloak.PString(u.user.Email)),
+		GivenName:         gocloak.StringP(gocloak.PString(u.user.FirstName)),
+		FamilyName:        gocloak.StringP(gocloak.PString(u.user.LastName)),
+		EmailVerified:     gocloak.BoolP(gocloak.PBool(u.user.EmailVerified)),
+	}, nil
+}
+
+// IntrospectToken returns whether an access token is active
+func (f *Fake) IntrospectToken(userToken string) (*gocloak.IntroSpectTokenResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &gocloak.IntroSpectTokenResult{Active: gocloak.BoolP(f.tokenUser(userToken) != nil)}, nil
+}
+
+// Users ---------------------------------------------------------------------
+
+// findUser returns the first user that matches
+func (f *Fake) findUser(match func(u *fakeUser) bool) *fakeUser {
+	for _, u := range f.users {
+		if match(u) {
+			return u
+		}
+	}
+	return nil
+}
+
+// userByUsername returns the user with the username, which is the email for users with an email
+func (f *Fake) userByUsername(username string) (*fakeUser, error) {
+	u := f.findUser(func(u *fakeUser) bool { return strings.EqualFold(*u.user.Username, username) })
+	if u == nil {
+		return nil, fakeError(http.StatusNotFound, "Keycloak GetUser: User does not exist "+username)
+	}
+	return u, nil
+}
+
+// copyUser returns a copy of a user, so that callers can't change the stored user
+func copyUser(u *fakeUser) *gocloak.User {
+	return &gocloak.User{
+		ID:            gocloak.StringP(*u.user.ID),
+		Username:      gocloak.StringP(*u.user.Username),
+		FirstName:     gocloak.StringP(gocloak.PString(u.user.FirstName)),
+		LastName:      gocloak.StringP(gocloak.PString(u.user.LastName)),
+		Email:         gocloak.StringP(gocloak.PString(u.user.Email)),
+		EmailVerified: gocloak.BoolP(gocloak.PBool(u.user.EmailVerified)),
+		Enabled:       gocloak.BoolP(gocloak.PBool(u.user.Enabled)),
+	}
+}
+
+// GetUser returns the user by username
+func (f *Fake) GetUser(username string) (*gocloak.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, err := f.userByUsername(username)
+	if err != nil {
+		return nil, err
+	}
+	return copyUser(u), nil
+}
+
+// GetUserByID returns the user by ID
+func (f *Fake) GetUserByID(id string) (*gocloak.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[id]
+	if !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	return copyUser(u), nil
+}
+
+// GetUserByEmail returns the user by email
+func (f *Fake) GetUserByEmail(email string) (*gocloak.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.findUser(func(u *fakeUser) bool { return strings.EqualFold(gocloak.PString(u.user.Email), email) })
+	if u == nil {
+		return nil, fakeError(http.StatusNotFound, "Keycloak GetUser: User does not exist "+email)
+	}
+	return copyUser(u), nil
+}
+
+// CreateUser creates a user and returns its ID. Like in the realm, the email is used as username.
+func (f *Fake) CreateUser(userid string, firstName string, lastName string, email string, password string) (userID string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	username := strings.ToLower(userid)
+	if email != "" {
+		username = strings.ToLower(email)
+	}
+	existing := f.findUser(func(u *fakeUser) bool {
+		return *u.user.Username == username || (email != "" && strings.EqualFold(gocloak.PString(u.user.Email), email))
+	})
+	if existing != nil {
+		return "", fakeError(http.StatusConflict, "409 Conflict: User exists with same username or email")
+	}
+	userID = uuid.NewString()
+	f.users[userID] = &fakeUser{
+		user: gocloak.User{
+			ID:            gocloak.StringP(userID),
+			Username:      gocloak.StringP(username),
+			FirstName:     gocloak.StringP(firstName),
+			LastName:      gocloak.StringP(lastName),
+			Email:         gocloak.StringP(strings.ToLower(email)),
+			EmailVerified: gocloak.BoolP(true),
+			Enabled:       gocloak.BoolP(true),
+		},
+		password: password,
+		roles:    map[string]bool{},
+		groups:   map[string]bool{},
+	}
+	return userID, nil
+}
+
+// GetOrCreateUser returns the ID of the user with the email and creates the user if it doesn't exist
+func (f *Fake) GetOrCreateUser(email string) (userID string, err error) {
+	return getOrCreateUser(f, email)
+}
+
+// UpdateUser updates the name and email of the user with the username
+func (f *Fake) UpdateUser(username string, firstName string, lastName string, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, err := f.userByUsername(username)
+	if err != nil {
+		return err
+	}
+	u.user.FirstName = gocloak.StringP(firstName)
+	u.user.LastName = gocloak.StringP(lastName)
+	u.user.Email = gocloak.StringP(strings.ToLower(email))
+	u.user.EmailVerified = gocloak.BoolP(true)
+	u.user.Enabled = gocloak.BoolP(true)
+	u.user.Username = gocloak.StringP(strings.ToLower(username))
+	return nil
+}
+
+// UpdateUserById updates the user with the ID, the email becomes the username
+func (f *Fake) UpdateUserById(userID, username, firstName, lastName, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	if !strings.EqualFold(gocloak.PString(u.user.Email), email) {
+		u.user.EmailVerified = gocloak.BoolP(false)
+	} else {
+		u.user.EmailVerified = gocloak.BoolP(true)
+	}
+	u.user.FirstName = gocloak.StringP(firstName)
+	u.user.LastName = gocloak.StringP(lastName)
+	u.user.Email = gocloak.StringP(strings.ToLower(email))
+	u.user.Enabled = gocloak.BoolP(true)
+	u.user.Username = gocloak.StringP(strings.ToLower(email))
+	return nil
+}
+
+// UpdateUserPassword sets the password of the user with the username
+func (f *Fake) UpdateUserPassword(username string, password string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, err := f.userByUsername(username)
+	if err != nil {
+		return err
+	}
+	u.password = password
+	return nil
+}
+
+// UpdateVendor updates the user of a vendor or creates it, if there is no user with the old email
+func (f *Fake) UpdateVendor(oldEmail, newEmail, licenseID, firstName, lastName string) (string, error) {
+	return updateVendor(f, oldEmail, newEmail, licenseID, firstName, lastName)
+}
+
+// SetUserEnabled enables or disables the login of a user, disabled users lose their tokens
+func (f *Fake) SetUserEnabled(userID string, enabled bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	u.user.Enabled = gocloak.BoolP(enabled)
+	if !enabled {
+		f.revokeTokens(userID)
+	}
+	return nil
+}
+
+// revokeTokens removes all tokens of a user
+func (f *Fake) revokeTokens(userID string) {
+	for token, tokenUserID := range f.tokens {
+		if tokenUserID == userID {
+			delete(f.tokens, token)
+		}
+	}
+}
+
+// DeleteUser deletes the user with the username and revokes its tokens
+func (f *Fake) DeleteUser(username string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, err := f.userByUsername(username)
+	if err != nil {
+		return err
+	}
+	delete(f.users, *u.user.ID)
+	f.revokeTokens(*u.user.ID)
+	return nil
+}
+
+// DeleteUserByID removes the user with the ID
+func (f *Fake) DeleteUserByID(userID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.users[userID]; !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	delete(f.users, userID)
+	f.revokeTokens(userID)
+	return nil
+}
+
+// SendPasswordResetEmail records the password reset email, see PasswordResetEmails
+func (f *Fake) SendPasswordResetEmail(email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u := f.findUser(func(u *fakeUser) bool { return strings.EqualFold(gocloak.PString(u.user.Email), email) })
+	if u == nil {
+		return fakeError(http.StatusNotFound, "Keycloak GetUser: User does not exist "+email)
+	}
+	log.Info("SendPasswordResetEmail: Fake: password reset email for ", email)
+	f.passwordResetEmails = append(f.passwordResetEmails, *u.user.Email)
+	return nil
+}
+
+// Roles ---------------------------------------------------------------------
+
+// GetRoles returns the realm roles sorted by name
+func (f *Fake) GetRoles() ([]*gocloak.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	roles := make([]*gocloak.Role, 0, len(f.roles))
+	for _, role := range f.roles {
+		role := role
+		roles = append(roles, &role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return *roles[i].Name < *roles[j].Name })
+	return roles, nil
+}
+
+// GetRole returns the role of the given name
+func (f *Fake) GetRole(name string) (*gocloak.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	role, ok := f.roles[name]
+	if !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: Could not find role")
+	}
+	return &role, nil
+}
+
+// CreateRole creates a realm role
+func (f *Fake) CreateRole(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.roles[name]; ok {
+		return fakeError(http.StatusConflict, "409 Conflict: Role with name "+name+" already exists")
+	}
+	f.roles[name] = gocloak.Role{ID: gocloak.StringP(uuid.NewString()), Name: gocloak.StringP(name)}
+	return nil
+}
+
+// DeleteRole deletes a realm role and removes it from all users
+func (f *Fake) DeleteRole(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.roles[name]; !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: Could not find role")
+	}
+	delete(f.roles, name)
+	for _, u := range f.users {
+		delete(u.roles, name)
+	}
+	return nil
+}
+
+// GetUserRoles returns the roles of a user sorted by name
+func (f *Fake) GetUserRoles(userID string) ([]*gocloak.Role, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	var roles []*gocloak.Role
+	for name := range u.roles {
+		role := f.roles[name]
+		roles = append(roles, &role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return *roles[i].Name < *roles[j].Name })
+	return roles, nil
+}
+
+// setUserRole assigns or unassigns a role
+func (f *Fake) setUserRole(userID string, roleName string, assigned bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	if _, ok := f.roles[roleName]; !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: Could not find role")
+	}
+	if assigned {
+		u.roles[roleName] = true
+	} else {
+		delete(u.roles, roleName)
+	}
+	return nil
+}
+
+// AssignRole assigns a role to a user by userID
+func (f *Fake) AssignRole(userID string, roleName string) error {
+	return f.setUserRole(userID, roleName, true)
+}
+
+// UnassignRole unassigns a role from a user by userID
+func (f *Fake) UnassignRole(userID string, roleName string) error {
+	return f.setUserRole(userID, roleName, false)
+}
+
+// Groups --------------------------------------------------------------------
+
+// groupByPath returns a copy of the group with the path
+func (f *Fake) groupByPath(path string) (*gocloak.Group, error) {
+	group, ok := f.groups[path]
+	if !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: Group path does not exist")
+	}
+	copied := *group
+	return &copied, nil
+}
+
+// GetGroup returns the top level group of the given name
+func (f *Fake) GetGroup(name string) (*gocloak.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.groupByPath("/" + name)
+}
+
+// GetGroupByPath returns the group with the path like /customer/newspapers
+func (f *Fake) GetGroupByPath(path string) (*gocloak.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.groupByPath(path)
+}
+
+// addGroup adds a group below the parent path ("" for top level groups)
+func (f *Fake) addGroup(groupName string, parentPath string) error {
+	path := parentPath + "/" + groupName
+	if _, ok := f.groups[path]; ok {
+		return fakeError(http.StatusConflict, "409 Conflict: Top level group named '"+groupName+"' already exists.")
+	}
+	f.groups[path] = &gocloak.Group{ID: gocloak.StringP(uuid.NewString()), Name: gocloak.StringP(groupName), Path: gocloak.StringP(path)}
+	return nil
+}
+
+// CreateGroup creates a top level group
+func (f *Fake) CreateGroup(groupName string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addGroup(groupName, "")
+}
+
+// CreateSubGroup creates a group below the group with the ID
+func (f *Fake) CreateSubGroup(groupName string, parentGroupID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for path, group := range f.groups {
+		if *group.ID == parentGroupID {
+			return f.addGroup(groupName, path)
+		}
+	}
+	return fakeError(http.StatusNotFound, "404 Not Found: Could not find parent group")
+}
+
+// removeGroup deletes the group with the path, its subgroups and their memberships
+func (f *Fake) removeGroup(path string) error {
+	if _, ok := f.groups[path]; !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: Group path does not exist")
+	}
+	for groupPath := range f.groups {
+		if groupPath == path || strings.HasPrefix(groupPath, path+"/") {
+			delete(f.groups, groupPath)
+			for _, u := range f.users {
+				delete(u.groups, groupPath)
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteGroup deletes a top level group given by name
+func (f *Fake) DeleteGroup(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removeGroup("/" + name)
+}
+
+// DeleteSubGroupByPath deletes the group with the path
+func (f *Fake) DeleteSubGroupByPath(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removeGroup(path)
+}
+
+// GetUserGroups returns the groups of a user sorted by path
+func (f *Fake) GetUserGroups(userID string) ([]*gocloak.Group, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	u, ok := f.users[userID]
+	if !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	var groups []*gocloak.Group
+	for path := range u.groups {
+		group, err := f.groupByPath(path)
+		if err == nil {
+			groups = append(groups, group)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return *groups[i].Path < *groups[j].Path })
+	return groups, nil
+}
+
+// GetGroupMembers returns the users of the group with the name or path sorted by username
+func (f *Fake) GetGroupMembers(groupName string) ([]*gocloak.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if groupName[0] != '/' {
+		groupName = "/" + groupName
+	}
+	if _, ok := f.groups[groupName]; !ok {
+		return nil, fakeError(http.StatusNotFound, "404 Not Found: Group path does not exist")
+	}
+	var members []*gocloak.User
+	for _, u := range f.users {
+		if u.groups[groupName] {
+			members = append(members, copyUser(u))
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return *members[i].Username < *members[j].Username })
+	return members, nil
+}
+
+// setUserGroup adds a user to or removes it from the group with the name or path
+func (f *Fake) setUserGroup(userID string, groupName string, member bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if groupName[0] != '/' {
+		groupName = "/" + groupName
+	}
+	u, ok := f.users[userID]
+	if !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: User not found")
+	}
+	if _, ok := f.groups[groupName]; !ok {
+		return fakeError(http.StatusNotFound, "404 Not Found: Group path does not exist")
+	}
+	if member {
+		u.groups[groupName] = true
+	} else {
+		delete(u.groups, groupName)
+	}
+	return nil
+}
+
+// AssignGroup adds a user to the group with the name or path
+func (f *Fake) AssignGroup(userID string, groupName string) error {
+	return f.setUserGroup(userID, groupName, true)
+}
+
+// UnassignGroup removes a user from the group with the name or path
+func (f *Fake) UnassignGroup(userID string, groupName string) error {
+	return f.setUserGroup(userID, groupName, false)
+}
+
+// AssignDigitalLicenseGroup adds a user to the group of a digital license and creates the group if necessary
+func (f *Fake) AssignDigitalLicenseGroup(userID string, licenseGroup string) error {
+	parentPath := "/" + config.Config.KeycloakCustomerGroup + "/" + newspaperGroup
+	f.mu.Lock()
+	_, parentExists := f.groups[parentPath]
+	_, exists := f.groups[parentPath+"/"+licenseGroup]
+	var err error
+	if !parentExists {
+		err = fakeError(http.StatusNotFound, "404 Not Found: Group path does not exist")
+	} else if !exists {
+		err = f.addGroup(licenseGroup, parentPath)
+	}
+	f.mu.Unlock()
+	if err != nil {
+		log.Errorf("AssignDigitalLicenseGroup: Error creating group %s", licenseGroup)
+		return err
+	}
+	return f.AssignGroup(userID, parentPath+"/"+licenseGroup)
+}
+
+// GetVendorGroup returns the name of the group of vendors
+func (f *Fake) GetVendorGroup() string {
+	return config.Config.KeycloakVendorGroup
+}
@@ -0,0 +1,157 @@
+package keycloak
+
+import (
+	"augustin/config"
+	"testing"
+
+	"github.com/Nerzal/gocloak/v13"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestFake returns a fake with the default groups of the API
+func newTestFake(t *testing.T) *Fake {
+	config.Config.KeycloakVendorGroup = "vendors"
+	config.Config.KeycloakCustomerGroup = "customer"
+	config.Config.KeycloakBackofficeGroup = "backoffice"
+	f := NewFake()
+	require.NoError(t, ensureDefaultGroups(f))
+	return f
+}
+
+func TestFakeUsers(t *testing.T) {
+	f := newTestFake(t)
+
+	userID, err := f.CreateUser("testuser", "Test", "User", "TestUser@example.com", "password")
+	require.NoError(t, err)
+	_, err = f.CreateUser("other", "", "", "testuser@example.com", "password")
+	require.Equal(t, 409, err.(gocloak.APIError).Code)
+
+	// The email is the username
+	user, err := f.GetUser("testuser@example.com")
+	require.NoError(t, err)
+	require.Equal(t, userID, *user.ID)
+	require.Equal(t, "Test", *user.FirstName)
+	_, err = f.GetUser("testuser")
+	require.True(t, IsNotFound(err))
+
+	// Login and tokens
+	_, err = f.GetUserToken("testuser@example.com", "wrong")
+	require.Error(t, err)
+	token, err := f.GetUserToken("testuser@example.com", "password")
+	require.NoError(t, err)
+	userInfo, err := f.GetUserInfo(token.AccessToken)
+	require.NoError(t, err)
+	require.Equal(t, userID, *userInfo.Sub)
+	require.Equal(t, "testuser@example.com", *userInfo.Email)
+	result, err := f.IntrospectToken(token.AccessToken)
+	require.NoError(t, err)
+	require.True(t, *result.Active)
+	_, err = f.GetUserInfo("invalid")
+	require.Error(t, err)
+
+	// Updates
+	require.NoError(t, f.UpdateUserPassword("testuser@example.com", "new"))
+	_, err = f.GetUserToken("testuser@example.com", "new")
+	require.NoError(t, err)
+	require.NoError(t, f.UpdateUserById(userID, "license", "New", "Name", "new@example.com"))
+	user, err = f.GetUserByEmail("new@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "new@example.com", *user.Username)
+	require.False(t, *user.EmailVerified)
+
+	// Changing the returned user doesn't change the stored one
+	*user.FirstName = "Changed"
+	user, err = f.GetUserByID(userID)
+	require.NoError(t, err)
+	require.Equal(t, "New", *user.FirstName)
+
+	// Disabled users can't log in
+	require.NoError(t, f.SetUserEnabled(userID, false))
+	_, err = f.GetUserInfo(token.AccessToken)
+	require.Error(t, err)
+	_, err = f.GetUserToken("new@example.com", "new")
+	require.Error(t, err)
+	require.NoError(t, f.SetUserEnabled(userID, true))
+	token, err = f.GetUserToken("new@example.com", "new")
+	require.NoError(t, err)
+
+	// Deleted users lose their tokens
+	require.NoError(t, f.DeleteUser("new@example.com"))
+	_, err = f.GetUserInfo(token.AccessToken)
+	require.Error(t, err)
+	_, err = f.GetUserByID(userID)
+	require.Error(t, err)
+	require.True(t, IsNotFound(f.DeleteUserByID(userID)))
+}
+
+func TestFakeRolesAndGroups(t *testing.T) {
+	f := newTestFake(t)
+	userID, err := f.CreateUser("", "", "", "vendor@example.com", "password")
+	require.NoError(t, err)
+
+	// Roles
+	require.Error(t, f.AssignRole(userID, "unknown"))
+	require.NoError(t, f.CreateRole("testrole"))
+	require.Error(t, f.CreateRole("testrole"))
+	require.NoError(t, f.AssignRole(userID, "testrole"))
+	require.NoError(t, f.AssignRole(userID, "admin"))
+	roles, err := f.GetUserRoles(userID)
+	require.NoError(t, err)
+	require.Len(t, roles, 2)
+	require.Equal(t, "admin", *roles[0].Name)
+	require.NoError(t, f.UnassignRole(userID, "admin"))
+	require.NoError(t, f.DeleteRole("testrole"))
+	roles, err = f.GetUserRoles(userID)
+	require.NoError(t, err)
+	require.Empty(t, roles)
+
+	// Default groups
+	group, err := f.GetGroupByPath("/customer/newspapers")
+	require.NoError(t, err)
+	require.Equal(t, "newspapers", *group.Name)
+	require.NoError(t, ensureDefaultGroups(f))
+
+	// Groups and digital licenses
+	require.NoError(t, f.AssignGroup(userID, f.GetVendorGroup()))
+	require.Error(t, f.AssignGroup(userID, "unknown"))
+	require.NoError(t, f.AssignDigitalLicenseGroup(userID, "edition"))
+	groups, err := f.GetUserGroups(userID)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	require.Equal(t, "/customer/newspapers/edition", *groups[0].Path)
+	require.Equal(t, "vendors", *groups[1].Name)
+	members, err := f.GetGroupMembers("vendors")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	require.Equal(t, userID, *members[0].ID)
+	require.NoError(t, f.UnassignGroup(userID, "vendors"))
+	members, err = f.GetGroupMembers("vendors")
+	require.NoError(t, err)
+	require.Empty(t, members)
+	require.NoError(t, f.DeleteSubGroupByPath("/customer/newspapers"))
+	groups, err = f.GetUserGroups(userID)
+	require.NoError(t, err)
+	require.Empty(t, groups)
+	_, err = f.GetGroupByPath("/customer/newspapers/edition")
+	require.Error(t, err)
+}
+
+func TestFakeVendors(t *testing.T) {
+	f := newTestFake(t)
+
+	// New users get a password reset email
+	userID, err := f.GetOrCreateUser("vendor@example.com")
+	require.NoError(t, err)
+	require.Equal(t, []string{"vendor@example.com"}, f.PasswordResetEmails())
+	sameID, err := f.GetOrCreateUser("vendor@example.com")
+	require.NoError(t, err)
+	require.Equal(t, userID, sameID)
+
+	// Changing the email of a vendor keeps the user
+	updatedID, err := f.UpdateVendor("vendor@example.com", "renamed@example.com", "license", "First", "Last")
+	require.NoError(t, err)
+	require.Equal(t, userID, updatedID)
+	user, err := f.GetUser("renamed@example.com")
+	require.NoError(t, err)
+	require.Equal(t, "First", *user.FirstName)
+}
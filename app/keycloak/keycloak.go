@@ -4,6 +4,7 @@ import (
 	"augustin/config"
 	"augustin/utils"
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/Nerzal/gocloak/v13"
@@ -11,8 +12,8 @@ import (
 
 var log = utils.GetLogger()
 
-// KeycloakClient global variable
-var KeycloakClient Keycloak
+// KeycloakClient is the identity provider of the API, Keycloak or the in-memory Fake (IDENTITY_PROVIDER=memory)
+var KeycloakClient IdentityProvider
 
 // Keycloak struct
 type Keycloak struct {
@@ -30,13 +31,25 @@ type Keycloak struct {
 	newspaperGroup          string
 }
 
-// InitializeOauthServer initializes the Keycloak client
+// InitializeOauthServer initializes the identity provider
 // and stores it in the global variable KeycloakClient
 func InitializeOauthServer() (err error) {
-	if err != nil {
-		log.Info("Error loading .env file which is okey if we are in production")
+	if config.Config.IdentityProvider == "memory" {
+		// The in-memory provider issues tokens to anyone who knows a password of its users
+		if !config.Config.Development {
+			return errors.New("IDENTITY_PROVIDER=memory is only allowed with DEVELOPMENT=true")
+		}
+		log.Warn("Using the in-memory identity provider, users are lost on restart")
+		fake := NewFake()
+		KeycloakClient = fake
+		err = fake.addDevelopmentUser()
+		if err != nil {
+			return err
+		}
+		return ensureDefaultGroups(fake)
 	}
-	KeycloakClient = Keycloak{
+
+	k := &Keycloak{
 		hostname:        config.Config.KeycloakHostname,
 		ClientID:        config.Config.KeycloakClientID,
 		ClientSecret:    config.Config.KeycloakClientSecret,
@@ -47,59 +60,18 @@ func InitializeOauthServer() (err error) {
 		vendorGroup:     config.Config.KeycloakVendorGroup,
 		customerGroup:   config.Config.KeycloakCustomerGroup,
 		backofficeGroup: config.Config.KeycloakBackofficeGroup,
-		newspaperGroup:  "newspapers",
+		newspaperGroup:  newspaperGroup,
 	}
 	// Initialize Keycloak client
-	client := gocloak.NewClient(KeycloakClient.hostname)
-	KeycloakClient.Client = client
-	KeycloakClient.clientTokenCreationTime = utils.GetUnixTime()
-	KeycloakClient.clientToken, err = KeycloakClient.LoginClient()
-	if err != nil {
-		log.Fatalf("Error logging in Keycloak client. A running keycloak server is necessary! ", err)
-	}
-
-	// Check if groups exists
-	_, err = KeycloakClient.Client.GetGroupByPath(KeycloakClient.Context, KeycloakClient.clientToken.AccessToken, KeycloakClient.Realm, KeycloakClient.vendorGroup)
-	if err != nil {
-		// Create group
-		err = KeycloakClient.CreateGroup(KeycloakClient.vendorGroup)
-		if err != nil {
-			log.Error("Error creating keycloak vendor group ", KeycloakClient.vendorGroup, err)
-		}
-	}
-	_, err = KeycloakClient.Client.GetGroupByPath(KeycloakClient.Context, KeycloakClient.clientToken.AccessToken, KeycloakClient.Realm, KeycloakClient.customerGroup)
-	if err != nil {
-		// Create group
-		err = KeycloakClient.CreateGroup(KeycloakClient.customerGroup)
-		if err != nil {
-			log.Error("Error creating keycloak customer group ", KeycloakClient.customerGroup, err)
-		}
-	}
-	_, err = KeycloakClient.Client.GetGroupByPath(KeycloakClient.Context, KeycloakClient.clientToken.AccessToken, KeycloakClient.Realm, KeycloakClient.backofficeGroup)
-	if err != nil {
-		// Create group
-		err = KeycloakClient.CreateGroup(KeycloakClient.backofficeGroup)
-		if err != nil {
-			log.Error("Error creating keycloak backoffice group ", KeycloakClient.backofficeGroup, err)
-		}
-	}
-	_, err = KeycloakClient.Client.GetGroupByPath(KeycloakClient.Context, KeycloakClient.clientToken.AccessToken, KeycloakClient.Realm, "/"+KeycloakClient.customerGroup+"/"+KeycloakClient.newspaperGroup)
+	k.Client = gocloak.NewClient(k.hostname)
+	k.clientTokenCreationTime = utils.GetUnixTime()
+	k.clientToken, err = k.LoginClient()
 	if err != nil {
-		// Create group
-		var customerGroup *gocloak.Group
-		customerGroup, err = KeycloakClient.Client.GetGroupByPath(KeycloakClient.Context, KeycloakClient.clientToken.AccessToken, KeycloakClient.Realm, "/"+KeycloakClient.customerGroup)
-		if err != nil {
-			log.Error("Error creating keycloak newspaper group: customer group not found ", err)
-		} else {
-			err = KeycloakClient.CreateSubGroup(KeycloakClient.newspaperGroup, *customerGroup.ID)
-			if err != nil {
-				log.Error("Error creating keycloak newspaper group ", err)
-			}
-		}
-
+		return fmt.Errorf("error logging in Keycloak client, a running keycloak server is necessary: %w", err)
 	}
+	KeycloakClient = k
 
-	return err
+	return ensureDefaultGroups(k)
 }
 
 // Login function returns the admin token
@@ -154,14 +126,14 @@ func (k *Keycloak) GetUserGroups(userID string) ([]*gocloak.Group, error) {
 func (k *Keycloak) checkAdminToken() {
 	var err error
 	if k.clientToken == nil {
-		k.clientToken, err = KeycloakClient.LoginClient()
+		k.clientToken, err = k.LoginClient()
 		if err != nil {
 			log.Error("Error logging in Keycloak client ", err)
 		}
 	}
 	// admin  token is expired
 	if utils.GetUnixTime()-(k.clientTokenCreationTime+int64(k.clientToken.ExpiresIn)) > 0 {
-		k.clientToken, err = KeycloakClient.LoginClient()
+		k.clientToken, err = k.LoginClient()
 		if err != nil {
 			log.Error("Error logging in Keycloak admin ", err)
 		}
@@ -244,6 +216,48 @@ func (k *Keycloak) AssignDigitalLicenseGroup(userID string, licenseGroup string)
 	return k.AssignGroup(userID, licenseGroupPath)
 }
 
+// UnassignGroup removes a user from a group
+func (k *Keycloak) UnassignGroup(userID string, groupName string) error {
+	k.checkAdminToken()
+	if groupName[0] != '/' {
+		groupName = "/" + groupName
+	}
+	group, err := k.Client.GetGroupByPath(k.Context, k.clientToken.AccessToken, k.Realm, groupName)
+	if err != nil {
+		return err
+	}
+	log.Infof("Removing user from group %s %s", userID, groupName)
+	return k.Client.DeleteUserFromGroup(k.Context, k.clientToken.AccessToken, k.Realm, userID, *group.ID)
+}
+
+// groupMembersPageSize is the number of group members requested at once
+const groupMembersPageSize = 100
+
+// GetGroupMembers returns all users of a group given by name or path
+func (k *Keycloak) GetGroupMembers(groupName string) (members []*gocloak.User, err error) {
+	k.checkAdminToken()
+	if groupName[0] != '/' {
+		groupName = "/" + groupName
+	}
+	group, err := k.Client.GetGroupByPath(k.Context, k.clientToken.AccessToken, k.Realm, groupName)
+	if err != nil {
+		return nil, err
+	}
+	for first := 0; ; first += groupMembersPageSize {
+		page, err := k.Client.GetGroupMembers(k.Context, k.clientToken.AccessToken, k.Realm, *group.ID, gocloak.GetGroupsParams{
+			First: gocloak.IntP(first),
+			Max:   gocloak.IntP(groupMembersPageSize),
+		})
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if len(page) < groupMembersPageSize {
+			return members, nil
+		}
+	}
+}
+
 func (k *Keycloak) CreateGroup(groupName string) error {
 	k.checkAdminToken()
 	group := gocloak.Group{
@@ -370,30 +384,10 @@ func (k *Keycloak) CreateUser(userid string, firstName string, lastName string,
 	})
 }
 
+// GetOrCreateUser returns the ID of the user with the email and creates the user if it doesn't exist
 func (k *Keycloak) GetOrCreateUser(email string) (userID string, err error) {
 	k.checkAdminToken()
-	user, err := k.GetUser(email)
-	if err != nil {
-		log.Info("GetOrCreateUser: User does not exist we create one", email)
-		// User does not exist
-		password := utils.RandomString(10)
-		user, err := k.CreateUser(email, email, "", email, password)
-		if err != nil {
-			log.Errorf("GetOrCreateUser: Error creating keycloak user %s", email)
-			return "", err
-		}
-		log.Info("GetOrCreateUser: Created user ", user)
-
-		// send welcome email with password reset link
-		err = k.SendPasswordResetEmail(email)
-		if err != nil {
-			// send password reset email only should soft fail
-			log.Error("GetOrCreateUser: Error sending password reset email ", err)
-		}
-		return user, nil
-
-	}
-	return *user.ID, nil
+	return getOrCreateUser(k, email)
 }
 
 // SendPasswordResetEmail function sends a password reset email to the user
@@ -425,6 +419,23 @@ func (k *Keycloak) DeleteUser(username string) error {
 	return k.Client.DeleteUser(k.Context, k.clientToken.AccessToken, k.Realm, *user.ID)
 }
 
+// DeleteUserByID deletes the user with the given ID
+func (k *Keycloak) DeleteUserByID(userID string) error {
+	k.checkAdminToken()
+	return k.Client.DeleteUser(k.Context, k.clientToken.AccessToken, k.Realm, userID)
+}
+
+// SetUserEnabled enables or disables the login of a user
+func (k *Keycloak) SetUserEnabled(userID string, enabled bool) error {
+	k.checkAdminToken()
+	user, err := k.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	user.Enabled = gocloak.BoolP(enabled)
+	return k.Client.UpdateUser(k.Context, k.clientToken.AccessToken, k.Realm, *user)
+}
+
 // UpdateUserPassword function updates a user password given by userID
 func (k *Keycloak) UpdateUserPassword(username string, password string) error {
 	k.checkAdminToken()
@@ -474,38 +485,7 @@ func (k *Keycloak) GetVendorGroup() string {
 	return k.vendorGroup
 }
 
+// UpdateVendor updates the user of a vendor or creates it, if there is no user with the old email
 func (k *Keycloak) UpdateVendor(oldEmail, newEmail, licenseID, firstName, lastName string) (string, error) {
-
-	// Update user in keycloak
-	user, err := k.GetUserByEmail(oldEmail)
-	if err != nil {
-		keycloak_user_id := ""
-		// check if new email already exists in keycloak
-		new_keycloak_user, err := k.GetUserByEmail(newEmail)
-		if err != nil {
-
-			keycloakUser, err2 := k.GetOrCreateUser(newEmail)
-			if err != nil {
-				log.Error("UpdateVendor: create keycloak user for "+newEmail+" failed: %v %v", err2, err)
-				return "", fmt.Errorf("UpdateVendor: create keycloak user for "+newEmail+" failed: %v %v", err2, err)
-			}
-			keycloak_user_id = keycloakUser
-		} else {
-			keycloak_user_id = *new_keycloak_user.ID
-		}
-
-		err = k.AssignGroup(keycloak_user_id, config.Config.KeycloakVendorGroup)
-		if err != nil {
-
-			return "", fmt.Errorf("UpdateVendor: assign keycloak group for "+newEmail+" failed: %v", err)
-		}
-		return keycloak_user_id, nil
-	} else {
-		err = k.UpdateUserById(*user.ID, licenseID, firstName, lastName, newEmail)
-		if err != nil {
-			return "", fmt.Errorf("UpdateVendor: update keycloak user for %s failed: %v", newEmail, fmt.Sprint(err))
-		}
-		return *user.ID, nil
-	}
-
+	return updateVendor(k, oldEmail, newEmail, licenseID, firstName, lastName)
 }
@@ -0,0 +1,166 @@
+package keycloak
+
+import (
+	"augustin/config"
+	"augustin/utils"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Nerzal/gocloak/v13"
+)
+
+// IdentityProvider manages the users, groups and roles of the API and validates their tokens.
+// It is implemented by Keycloak and by the in-memory Fake for running the API offline.
+type IdentityProvider interface {
+	// Tokens
+	GetUserToken(user, password string) (*gocloak.JWT, error)
+	GetUserInfo(userToken string) (*gocloak.UserInfo, error)
+	IntrospectToken(userToken string) (*gocloak.IntroSpectTokenResult, error)
+
+	// Users
+	GetUser(username string) (*gocloak.User, error)
+	GetUserByID(id string) (*gocloak.User, error)
+	GetUserByEmail(email string) (*gocloak.User, error)
+	CreateUser(userid string, firstName string, lastName string, email string, password string) (userID string, err error)
+	GetOrCreateUser(email string) (userID string, err error)
+	UpdateUser(username string, firstName string, lastName string, email string) error
+	UpdateUserById(userID, username, firstName, lastName, email string) error
+	UpdateUserPassword(username string, password string) error
+	UpdateVendor(oldEmail, newEmail, licenseID, firstName, lastName string) (string, error)
+	SetUserEnabled(userID string, enabled bool) error
+	DeleteUser(username string) error
+	DeleteUserByID(userID string) error
+	SendPasswordResetEmail(email string) error
+
+	// Roles
+	GetRoles() ([]*gocloak.Role, error)
+	GetRole(name string) (*gocloak.Role, error)
+	CreateRole(name string) error
+	DeleteRole(name string) error
+	GetUserRoles(userID string) ([]*gocloak.Role, error)
+	AssignRole(userID string, roleName string) error
+	UnassignRole(userID string, roleName string) error
+
+	// Groups
+	GetGroup(name string) (*gocloak.Group, error)
+	GetGroupByPath(path string) (*gocloak.Group, error)
+	CreateGroup(groupName string) error
+	CreateSubGroup(groupName string, parentGroupID string) error
+	DeleteGroup(name string) error
+	DeleteSubGroupByPath(path string) error
+	GetUserGroups(userID string) ([]*gocloak.Group, error)
+	GetGroupMembers(groupName string) ([]*gocloak.User, error)
+	AssignGroup(userID string, groupName string) error
+	UnassignGroup(userID string, groupName string) error
+	AssignDigitalLicenseGroup(userID string, licenseGroup string) error
+	GetVendorGroup() string
+}
+
+// IsNotFound returns whether an error of the identity provider means that the user, group or role doesn't exist
+func IsNotFound(err error) bool {
+	var apiErr gocloak.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusNotFound
+	}
+	var apiErrPtr *gocloak.APIError
+	return errors.As(err, &apiErrPtr) && apiErrPtr.Code == http.StatusNotFound
+}
+
+// newspaperGroup is the subgroup of the customer group that contains the groups of digital licenses
+const newspaperGroup = "newspapers"
+
+// DigitalLicenseGroupPath returns the path of the group of a digital license, "" returns the prefix of all of them
+func DigitalLicenseGroupPath(licenseGroup string) string {
+	return "/" + config.Config.KeycloakCustomerGroup + "/" + newspaperGroup + "/" + licenseGroup
+}
+
+// ensureDefaultGroups creates the vendor, customer, backoffice and newspaper groups if they don't exist
+func ensureDefaultGroups(p IdentityProvider) (err error) {
+	for _, group := range []string{config.Config.KeycloakVendorGroup, config.Config.KeycloakCustomerGroup, config.Config.KeycloakBackofficeGroup} {
+		_, err = p.GetGroup(group)
+		if err != nil {
+			err = p.CreateGroup(group)
+			if err != nil {
+				log.Error("Error creating keycloak group ", group, err)
+			}
+		}
+	}
+	_, err = p.GetGroupByPath("/" + config.Config.KeycloakCustomerGroup + "/" + newspaperGroup)
+	if err != nil {
+		var customerGroup *gocloak.Group
+		customerGroup, err = p.GetGroup(config.Config.KeycloakCustomerGroup)
+		if err != nil {
+			log.Error("Error creating keycloak newspaper group: customer group not found ", err)
+		} else {
+			err = p.CreateSubGroup(newspaperGroup, *customerGroup.ID)
+			if err != nil {
+				log.Error("Error creating keycloak newspaper group ", err)
+			}
+		}
+	}
+	return err
+}
+
+// getOrCreateUser returns the ID of the user with the email. New users get a random password and a password reset email.
+func getOrCreateUser(p IdentityProvider, email string) (userID string, err error) {
+	user, err := p.GetUser(email)
+	if err != nil {
+		log.Info("GetOrCreateUser: User does not exist we create one", email)
+		// User does not exist
+		password := utils.RandomString(10)
+		user, err := p.CreateUser(email, email, "", email, password)
+		if err != nil {
+			log.Errorf("GetOrCreateUser: Error creating keycloak user %s", email)
+			return "", err
+		}
+		log.Info("GetOrCreateUser: Created user ", user)
+
+		// send welcome email with password reset link
+		err = p.SendPasswordResetEmail(email)
+		if err != nil {
+			// send password reset email only should soft fail
+			log.Error("GetOrCreateUser: Error sending password reset email ", err)
+		}
+		return user, nil
+
+	}
+	return *user.ID, nil
+}
+
+// updateVendor updates the user of a vendor or creates it, if there is no user with the old email
+func updateVendor(p IdentityProvider, oldEmail, newEmail, licenseID, firstName, lastName string) (string, error) {
+
+	// Update user in keycloak
+	user, err := p.GetUserByEmail(oldEmail)
+	if err != nil {
+		keycloak_user_id := ""
+		// check if new email already exists in keycloak
+		new_keycloak_user, err := p.GetUserByEmail(newEmail)
+		if err != nil {
+
+			keycloakUser, err2 := p.GetOrCreateUser(newEmail)
+			if err != nil {
+				log.Error("UpdateVendor: create keycloak user for "+newEmail+" failed: %v %v", err2, err)
+				return "", fmt.Errorf("UpdateVendor: create keycloak user for "+newEmail+" failed: %v %v", err2, err)
+			}
+			keycloak_user_id = keycloakUser
+		} else {
+			keycloak_user_id = *new_keycloak_user.ID
+		}
+
+		err = p.AssignGroup(keycloak_user_id, config.Config.KeycloakVendorGroup)
+		if err != nil {
+
+			return "", fmt.Errorf("UpdateVendor: assign keycloak group for "+newEmail+" failed: %v", err)
+		}
+		return keycloak_user_id, nil
+	} else {
+		err = p.UpdateUserById(*user.ID, licenseID, firstName, lastName, newEmail)
+		if err != nil {
+			return "", fmt.Errorf("UpdateVendor: update keycloak user for %s failed: %v", newEmail, fmt.Sprint(err))
+		}
+		return *user.ID, nil
+	}
+
+}
@@ -5,38 +5,63 @@ import (
 	"augustin/utils"
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
-
+	htmltemplate "html/template"
+	"net"
+	"net/mail"
 	"net/smtp"
+	"os"
+	"strings"
 	"text/template"
+	"time"
 )
 
 var log = utils.GetLogger()
 
 var auth smtp.Auth
 
-func Init() {
-	log.Infoln("Initializing mailer")
-	host := config.Config.SMTPServer + ":" + config.Config.SMTPPort
-	if !config.Config.SMTPSsl {
-		host = config.Config.SMTPServer
+// Init selects the mail transport of the configuration, unknown transports are refused
+func Init() error {
+	log.Infoln("Initializing mailer with transport ", config.Config.MailTransport)
+	auth = smtp.PlainAuth("", config.Config.SMTPUsername, config.Config.SMTPPassword, config.Config.SMTPServer)
+	t, err := NewTransport(config.Config.MailTransport, config.Config.MailDir)
+	if err != nil {
+		return err
 	}
-	auth = smtp.PlainAuth("", config.Config.SMTPUsername, config.Config.SMTPPassword, host)
+	SetTransport(t)
+	return nil
 }
 
 // Request struct
 type EmailRequest struct {
-	to      []string
-	subject string
-	body    string
+	to          []string
+	cc          []string
+	bcc         []string
+	replyTo     []string
+	subject     string
+	body        string // HTML body
+	textBody    string // Plain text alternative of the body
+	attachments []Attachment
+}
+
+// Template is a localized email template.
+// Subject and TextBody are rendered with text/template, HTMLBody with html/template
+// so that data supplied by customers is escaped.
+type Template struct {
+	Subject  string
+	HTMLBody string
+	TextBody string
 }
 
-func NewRequestFromTemplate(to []string, subject, templateFileName string, data interface{}) (*EmailRequest, error) {
-	r := NewRequest(to, subject, "")
-	err := r.ParseTemplate(templateFileName, data)
+// NewRequestFromTemplate renders the given template with data and creates a new request
+func NewRequestFromTemplate(to []string, tmpl Template, data interface{}) (*EmailRequest, error) {
+	rendered, err := RenderTemplate(tmpl, data)
 	if err != nil {
 		return nil, err
 	}
+	r := NewRequest(to, rendered.Subject, rendered.HTMLBody)
+	r.textBody = rendered.TextBody
 	return r, nil
 }
 
@@ -48,112 +73,187 @@ func NewRequest(to []string, subject, body string) *EmailRequest {
 	}
 }
 
-func (r *EmailRequest) SendEmail() (bool, error) {
+// AddCc adds carbon copy recipients
+func (r *EmailRequest) AddCc(cc ...string) {
+	r.cc = append(r.cc, cc...)
+}
 
-	from := "From: " + config.Config.SMTPSenderAddress + "\n"
-	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	subject := "Subject: " + r.subject + "!\n"
-	msg := []byte(from + subject + mime + "\n" + r.body)
-
-	if !config.Config.SMTPSsl {
-		log.Info("Sending email to ", r.to, " with subject ", r.subject)
-		addr := config.Config.SMTPServer + ":" + config.Config.SMTPPort
-		if err := smtp.SendMail(addr, auth, config.Config.SMTPUsername, r.to, msg); err != nil {
-			log.Error("SendEmail: noSSL: Error sending email ", err)
-			return false, err
-		}
-		return true, nil
-	} else {
-		log.Info("Sending ssl email to ", r.to, " with subject ", r.subject)
+// AddBcc adds blind carbon copy recipients
+func (r *EmailRequest) AddBcc(bcc ...string) {
+	r.bcc = append(r.bcc, bcc...)
+}
 
-		// TLS config
-		tlsconfig := &tls.Config{
-			InsecureSkipVerify: true,
-			ServerName:         config.Config.SMTPServer,
-		}
-		host := config.Config.SMTPServer + ":" + config.Config.SMTPPort
+// SetReplyTo sets the addresses replies should be sent to
+func (r *EmailRequest) SetReplyTo(replyTo ...string) {
+	r.replyTo = replyTo
+}
 
-		// Here is the key, you need to call tls.Dial instead of smtp.Dial
-		// for smtp servers running on 465 that require an ssl connection
-		// from the very beginning (no starttls)
-		conn, err := tls.Dial("tcp", host, tlsconfig)
-		if err != nil {
-			log.Error("SendEmail: failed tls dial", err)
-			return false, err
-		}
+// Attach adds a file to the email
+func (r *EmailRequest) Attach(filename string, contentType string, data []byte) {
+	r.attachments = append(r.attachments, Attachment{Filename: filename, ContentType: contentType, Data: data})
+}
 
-		c, err := smtp.NewClient(conn, host)
-		if err != nil {
-			log.Error("SendMail: Ssl: create smtp client", err)
-			return false, err
+// Message builds the MIME message of the request
+func (r *EmailRequest) Message() (msg *Message, err error) {
+	msg = &Message{
+		From:        mail.Address{Name: config.Config.SMTPSenderName, Address: config.Config.SMTPSenderAddress},
+		Subject:     r.subject,
+		HTML:        r.body,
+		Text:        r.textBody,
+		Attachments: r.attachments,
+	}
+	if msg.To, err = ParseAddresses(r.to); err != nil {
+		return
+	}
+	if msg.Cc, err = ParseAddresses(r.cc); err != nil {
+		return
+	}
+	if msg.Bcc, err = ParseAddresses(r.bcc); err != nil {
+		return
+	}
+	msg.ReplyTo, err = ParseAddresses(r.replyTo)
+	return
+}
 
-		}
+func (r *EmailRequest) SendEmail() (bool, error) {
+	msg, err := r.Message()
+	if err != nil {
+		log.Error("SendEmail: ", err)
+		return false, err
+	}
+	log.Info("Sending email to ", r.to, " with subject ", r.subject)
+	err = transport.Send(msg)
+	if err != nil {
+		log.Error("SendEmail: Error sending email ", err)
+		return false, err
+	}
+	return true, nil
+}
 
-		// Auth
-		if err = c.Auth(auth); err != nil {
-			log.Error("SendMail: Ssl:", err)
-			return false, err
-		}
+// SMTP connection security modes (SMTP_SECURITY)
+const (
+	SMTPSecurityTLS      = "tls"      // Implicit TLS, usually port 465
+	SMTPSecurityStartTLS = "starttls" // STARTTLS is required, usually port 587
+	SMTPSecurityNone     = "none"     // Never use TLS, e.g. for a local development mail server
+	// If no mode is set, STARTTLS is used if the server supports it
+)
 
-		// To && From
-		if err = c.Mail(config.Config.SMTPSenderAddress); err != nil {
-			log.Error("SendMail: Ssl: create mail: ", err)
-			return false, err
+// tlsConfig returns the TLS configuration for the SMTP server.
+// Certificates are verified unless SMTP_TLS_SKIP_VERIFY is set.
+func tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.Config.SMTPServer,
+		InsecureSkipVerify: config.Config.SMTPTLSSkipVerify,
+	}
+	if config.Config.SMTPTLSCAFile != "" {
+		ca, err := os.ReadFile(config.Config.SMTPTLSCAFile)
+		if err != nil {
+			return nil, err
 		}
-
-		if err = c.Rcpt(r.to[0]); err != nil {
-			log.Error("SendMail: Ssl: set recipient:", err)
-			return false, err
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificates found in " + config.Config.SMTPTLSCAFile)
 		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
 
-		// Data
-		w, err := c.Data()
-		if err != nil {
-			log.Error("SendMail: Ssl: data", err)
-			return false, err
-		}
+// sendSMTP delivers a message to all recipients via the configured SMTP server
+func sendSMTP(from string, recipients []string, msg []byte) error {
+	security := config.Config.SMTPSecurity
+	host := net.JoinHostPort(config.Config.SMTPServer, config.Config.SMTPPort)
+	tlsConfig, err := tlsConfig()
+	if err != nil {
+		return err
+	}
 
-		_, err = w.Write([]byte(msg))
-		if err != nil {
-			log.Error("SendMail: Ssl: send message", err)
-			return false, err
-		}
+	var conn net.Conn
+	if security == SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", host, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", host, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, config.Config.SMTPServer)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
 
-		err = w.Close()
-		if err != nil {
-			log.Error("SendMail: Ssl: close connection", err)
-			return false, err
+	if security != SMTPSecurityTLS && security != SMTPSecurityNone {
+		ok, _ := c.Extension("STARTTLS")
+		if ok {
+			if err = c.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if security == SMTPSecurityStartTLS {
+			return errors.New("smtp server does not support STARTTLS")
 		}
+	}
 
-		err = c.Quit()
-		if err != nil {
-			log.Error("SendMail: Ssl: quit", err)
-			return false, err
+	if ok, _ := c.Extension("AUTH"); ok && config.Config.SMTPUsername != "" {
+		if err = c.Auth(auth); err != nil {
+			return err
 		}
-		return true, nil
 	}
 
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err = c.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
 }
 
-func (r *EmailRequest) ParseTemplate(templateFileName string, data interface{}) error {
-	// Parse the template
-	if templateFileName == "" {
-		return errors.New("template file name is empty")
+// RenderTemplate renders subject, HTML and text body of a template with the given data
+func RenderTemplate(tmpl Template, data interface{}) (rendered Template, err error) {
+	rendered.Subject, err = renderText("subject", tmpl.Subject, data)
+	if err != nil {
+		return
 	}
-	path := "./templates/" + templateFileName
+	// Subjects are sent as a single header line
+	rendered.Subject = strings.Join(strings.Fields(rendered.Subject), " ")
 
-	if !utils.FileExists(path) {
-		return errors.New("template file does not exist")
+	t, err := htmltemplate.New("html").Parse(tmpl.HTMLBody)
+	if err != nil {
+		return
 	}
+	buf := new(bytes.Buffer)
+	if err = t.Execute(buf, data); err != nil {
+		return
+	}
+	rendered.HTMLBody = buf.String()
 
-	t, err := template.ParseFiles("./templates/" + templateFileName)
+	rendered.TextBody, err = renderText("text", tmpl.TextBody, data)
+	return
+}
+
+// renderText renders a text/template
+func renderText(name string, text string, data interface{}) (string, error) {
+	t, err := template.New(name).Parse(text)
 	if err != nil {
-		return err
+		return "", err
 	}
 	buf := new(bytes.Buffer)
 	if err = t.Execute(buf, data); err != nil {
-		return err
+		return "", err
 	}
-	r.body = buf.String()
-	return nil
+	return buf.String(), nil
 }
@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestRenderTemplate tests that customer data is escaped in the HTML body only
+func TestRenderTemplate(t *testing.T) {
+	tmpl := Template{
+		Subject:  "Hallo {{.Name}}\n",
+		HTMLBody: "<p>{{.Name}}</p>",
+		TextBody: "Hallo {{.Name}}",
+	}
+	rendered, err := RenderTemplate(tmpl, map[string]interface{}{"Name": "<b>Jörg</b>"})
+	require.NoError(t, err)
+	require.Equal(t, "Hallo <b>Jörg</b>", rendered.Subject)
+	require.Equal(t, "<p>&lt;b&gt;Jörg&lt;/b&gt;</p>", rendered.HTMLBody)
+	require.Equal(t, "Hallo <b>Jörg</b>", rendered.TextBody)
+
+	_, err = RenderTemplate(Template{HTMLBody: "{{.Name"}, nil)
+	require.Error(t, err)
+}
+
+// TestMessageBytes tests the MIME encoding of a message with text, HTML and attachment
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		From:        mail.Address{Name: "Augustin", Address: "no-reply@example.com"},
+		To:          []mail.Address{{Name: "Jörg Müller", Address: "joerg@example.com"}, {Address: "anna@example.com"}},
+		Cc:          []mail.Address{{Address: "cc@example.com"}},
+		Bcc:         []mail.Address{{Address: "bcc@example.com"}},
+		ReplyTo:     []mail.Address{{Address: "office@example.com"}},
+		Subject:     "Deine Zeitung für Jänner",
+		Text:        "Grüß dich",
+		HTML:        "<p>Grüß dich</p>",
+		Attachments: []Attachment{{Filename: "receipt.pdf", ContentType: "application/pdf", Data: []byte("%PDF-1.4")}},
+	}
+	require.Equal(t, []string{"joerg@example.com", "anna@example.com", "cc@example.com", "bcc@example.com"}, msg.Recipients())
+
+	data, err := msg.Bytes()
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "bcc@example.com")
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	require.Equal(t, "Deine Zeitung für Jänner", subject)
+	require.NotContains(t, parsed.Header.Get("Subject"), "ü")
+
+	to, err := parsed.Header.AddressList("To")
+	require.NoError(t, err)
+	require.Equal(t, "Jörg Müller", to[0].Name)
+	require.Len(t, to, 2)
+	replyTo, err := parsed.Header.AddressList("Reply-To")
+	require.NoError(t, err)
+	require.Equal(t, "office@example.com", replyTo[0].Address)
+	require.NotEmpty(t, parsed.Header.Get("Message-ID"))
+	_, err = parsed.Header.Date()
+	require.NoError(t, err)
+
+	// multipart/mixed with multipart/alternative and attachment
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/mixed", mediaType)
+	mixed := multipart.NewReader(parsed.Body, params["boundary"])
+
+	part, err := mixed.NextPart()
+	require.NoError(t, err)
+	mediaType, params, err = mime.ParseMediaType(part.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+	alternative := multipart.NewReader(part, params["boundary"])
+	for _, expected := range []string{"Grüß dich", "<p>Grüß dich</p>"} {
+		// multipart.Reader decodes quoted-printable automatically
+		body, err := alternative.NextPart()
+		require.NoError(t, err)
+		content, err := io.ReadAll(body)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content))
+	}
+
+	part, err = mixed.NextPart()
+	require.NoError(t, err)
+	require.Equal(t, "receipt.pdf", part.FileName())
+	require.Equal(t, "application/pdf", part.Header.Get("Content-Type"))
+}
+
+// TestMessageBytesWithoutRecipients tests that a message needs at least one recipient
+func TestMessageBytesWithoutRecipients(t *testing.T) {
+	msg := Message{From: mail.Address{Address: "no-reply@example.com"}, Text: "Hallo"}
+	_, err := msg.Bytes()
+	require.Error(t, err)
+}
+
+// TestTransports tests that the memory and the file transport keep the sent emails
+func TestTransports(t *testing.T) {
+	msg := &Message{
+		From:        mail.Address{Address: "no-reply@example.com"},
+		To:          []mail.Address{{Address: "customer@example.com"}},
+		Subject:     "Test",
+		Text:        "Hallo",
+		Attachments: []Attachment{{Filename: "receipt.pdf", Data: []byte("%PDF-1.4")}},
+	}
+
+	memory := NewMemoryTransport()
+	require.NoError(t, memory.Send(msg))
+	emails := memory.Emails()
+	require.Len(t, emails, 1)
+	require.Equal(t, []string{"customer@example.com"}, emails[0].To)
+	require.Equal(t, "Test", emails[0].Subject)
+	require.Equal(t, []string{"receipt.pdf"}, emails[0].Attachments)
+	memory.Reset()
+	require.Empty(t, memory.Emails())
+
+	dir := t.TempDir()
+	file, err := NewTransport(TransportFile, dir)
+	require.NoError(t, err)
+	require.NoError(t, file.Send(msg))
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	content, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(content), "Subject: Test")
+
+	_, err = NewTransport("pigeon", "")
+	require.Error(t, err)
+}
@@ -0,0 +1,250 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Attachment is a file that is attached to an email
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is an email that can be encoded as MIME message (RFC 5322 / RFC 2045)
+type Message struct {
+	From        mail.Address
+	To          []mail.Address
+	Cc          []mail.Address
+	Bcc         []mail.Address // Only used for the envelope, never written to the headers
+	ReplyTo     []mail.Address
+	Subject     string
+	Text        string // Plain text body
+	HTML        string // HTML body
+	Attachments []Attachment
+	Date        time.Time
+	MessageID   string // Generated if empty
+}
+
+// ParseAddresses parses a list of email addresses like "Jörg <joerg@example.com>"
+func ParseAddresses(addresses []string) ([]mail.Address, error) {
+	parsed := make([]mail.Address, 0, len(addresses))
+	for _, address := range addresses {
+		a, err := mail.ParseAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email address %q: %w", address, err)
+		}
+		parsed = append(parsed, *a)
+	}
+	return parsed, nil
+}
+
+// Recipients returns the addresses of all recipients (To, Cc and Bcc) for the SMTP envelope
+func (m *Message) Recipients() []string {
+	var recipients []string
+	for _, list := range [][]mail.Address{m.To, m.Cc, m.Bcc} {
+		for _, a := range list {
+			recipients = append(recipients, a.Address)
+		}
+	}
+	return recipients
+}
+
+// Bytes encodes the message. Text and HTML bodies are sent as multipart/alternative,
+// attachments are added in a multipart/mixed container.
+func (m *Message) Bytes() ([]byte, error) {
+	if m.From.Address == "" {
+		return nil, errors.New("sender address is empty")
+	}
+	if len(m.Recipients()) == 0 {
+		return nil, errors.New("no recipients")
+	}
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	if m.MessageID == "" {
+		m.MessageID = generateMessageID(m.From.Address)
+	}
+
+	buf := new(bytes.Buffer)
+	writeHeader(buf, "From", m.From.String())
+	if len(m.To) > 0 {
+		writeHeader(buf, "To", joinAddresses(m.To))
+	}
+	if len(m.Cc) > 0 {
+		writeHeader(buf, "Cc", joinAddresses(m.Cc))
+	}
+	if len(m.ReplyTo) > 0 {
+		writeHeader(buf, "Reply-To", joinAddresses(m.ReplyTo))
+	}
+	writeHeader(buf, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	writeHeader(buf, "Date", m.Date.Format(time.RFC1123Z))
+	writeHeader(buf, "Message-ID", m.MessageID)
+	writeHeader(buf, "MIME-Version", "1.0")
+
+	body, contentType, err := m.body()
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Attachments) == 0 {
+		for key, values := range contentType {
+			writeHeader(buf, key, values[0])
+		}
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+
+	// Wrap body and attachments in multipart/mixed
+	mixed := new(bytes.Buffer)
+	w := multipart.NewWriter(mixed)
+	part, err := w.CreatePart(contentType)
+	if err != nil {
+		return nil, err
+	}
+	_, err = part.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range m.Attachments {
+		err = writeAttachment(w, attachment)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	writeHeader(buf, "Content-Type", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": w.Boundary()}))
+	buf.WriteString("\r\n")
+	buf.Write(mixed.Bytes())
+	return buf.Bytes(), nil
+}
+
+// body returns the encoded body and its headers. If both text and HTML are set,
+// they are combined as multipart/alternative with the preferred HTML part last.
+func (m *Message) body() ([]byte, textproto.MIMEHeader, error) {
+	if m.HTML == "" || m.Text == "" {
+		if m.HTML != "" {
+			return encodeTextPart("text/html", m.HTML)
+		}
+		return encodeTextPart("text/plain", m.Text)
+	}
+	buf := new(bytes.Buffer)
+	w := multipart.NewWriter(buf)
+	for _, p := range []struct{ contentType, content string }{{"text/plain", m.Text}, {"text/html", m.HTML}} {
+		body, header, err := encodeTextPart(p.contentType, p.content)
+		if err != nil {
+			return nil, nil, err
+		}
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = part.Write(body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": w.Boundary()}))
+	return buf.Bytes(), header, nil
+}
+
+// encodeTextPart encodes text as quoted-printable UTF-8
+func encodeTextPart(contentType string, content string) ([]byte, textproto.MIMEHeader, error) {
+	buf := new(bytes.Buffer)
+	w := quotedprintable.NewWriter(buf)
+	_, err := w.Write([]byte(content))
+	if err != nil {
+		return nil, nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType+"; charset=UTF-8")
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	return buf.Bytes(), header, nil
+}
+
+// writeAttachment adds a base64 encoded attachment to a multipart message
+func writeAttachment(w *multipart.Writer, attachment Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "base64")
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+	// Lines must not be longer than 76 characters
+	for len(encoded) > 76 {
+		_, err = part.Write([]byte(encoded[:76] + "\r\n"))
+		if err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = part.Write([]byte(encoded + "\r\n"))
+	return err
+}
+
+// writeHeader writes a header line, values have to be encoded already
+func writeHeader(buf *bytes.Buffer, key string, value string) {
+	// Prevent header injection
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+// joinAddresses formats addresses for a header, names are encoded according to RFC 2047
+func joinAddresses(addresses []mail.Address) string {
+	formatted := make([]string, len(addresses))
+	for i, a := range addresses {
+		formatted[i] = a.String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// addressStrings returns the plain email addresses
+func addressStrings(addresses []mail.Address) []string {
+	plain := make([]string, len(addresses))
+	for i, a := range addresses {
+		plain[i] = a.Address
+	}
+	return plain
+}
+
+// generateMessageID creates a unique message id in the domain of the sender
+func generateMessageID(from string) string {
+	domain := "localhost"
+	if idx := strings.LastIndex(from, "@"); idx != -1 {
+		domain = from[idx+1:]
+	}
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(random), domain)
+}
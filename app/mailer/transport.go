@@ -0,0 +1,166 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Transport delivers email messages
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// Transports that can be selected with MAIL_TRANSPORT
+const (
+	TransportSMTP   = "smtp"
+	TransportFile   = "file"
+	TransportMemory = "memory"
+)
+
+// transport is used by SendEmail, it is set in Init or with SetTransport
+var transport Transport = SMTPTransport{}
+
+// SetTransport replaces the transport that is used to send emails, e.g. to capture emails in tests
+func SetTransport(t Transport) {
+	transport = t
+}
+
+// GetTransport returns the transport that is used to send emails
+func GetTransport() Transport {
+	return transport
+}
+
+// NewTransport creates the transport with the given name
+func NewTransport(name string, dir string) (Transport, error) {
+	switch name {
+	case TransportSMTP, "":
+		return SMTPTransport{}, nil
+	case TransportFile:
+		return NewFileTransport(dir)
+	case TransportMemory:
+		return NewMemoryTransport(), nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", name)
+}
+
+// SMTPTransport sends emails via the SMTP server of the configuration
+type SMTPTransport struct{}
+
+// Send delivers the message to all recipients
+func (SMTPTransport) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	return sendSMTP(msg.From.Address, msg.Recipients(), data)
+}
+
+// FileTransport writes emails to a maildir (https://cr.yp.to/proto/maildir.html),
+// which can be opened with most mail clients
+type FileTransport struct {
+	Dir string
+}
+
+// NewFileTransport creates the maildir folders if they don't exist
+func NewFileTransport(dir string) (*FileTransport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &FileTransport{Dir: dir}, nil
+}
+
+// Send writes the message to the new folder of the maildir
+func (t *FileTransport) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(random), hostname)
+
+	// Write to tmp first, so that readers never see incomplete files
+	tmpPath := filepath.Join(t.Dir, "tmp", name)
+	err = os.WriteFile(tmpPath, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(t.Dir, "new", name))
+}
+
+// CapturedEmail is an email that has been captured by the MemoryTransport
+type CapturedEmail struct {
+	From        string
+	To          []string
+	Cc          []string
+	Bcc         []string
+	Subject     string
+	Text        string
+	HTML        string
+	Attachments []string // File names of the attachments
+	Timestamp   time.Time
+	Raw         string // Encoded MIME message
+}
+
+// MemoryTransport keeps all emails in memory instead of sending them.
+// It is used in development and tests.
+type MemoryTransport struct {
+	mu     sync.Mutex
+	emails []CapturedEmail
+}
+
+// NewMemoryTransport creates an empty MemoryTransport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send captures the message
+func (t *MemoryTransport) Send(msg *Message) error {
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	captured := CapturedEmail{
+		From:      msg.From.String(),
+		To:        addressStrings(msg.To),
+		Cc:        addressStrings(msg.Cc),
+		Bcc:       addressStrings(msg.Bcc),
+		Subject:   msg.Subject,
+		Text:      msg.Text,
+		HTML:      msg.HTML,
+		Timestamp: msg.Date,
+		Raw:       string(data),
+	}
+	for _, attachment := range msg.Attachments {
+		captured.Attachments = append(captured.Attachments, attachment.Filename)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emails = append(t.emails, captured)
+	return nil
+}
+
+// Emails returns all captured emails
+func (t *MemoryTransport) Emails() []CapturedEmail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	emails := make([]CapturedEmail, len(t.emails))
+	copy(emails, t.emails)
+	return emails
+}
+
+// Reset removes all captured emails
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emails = nil
+}
@@ -4,9 +4,11 @@ import (
 	"augustin/config"
 	"augustin/database"
 	"augustin/handlers"
+	"augustin/jobs"
 	"augustin/keycloak"
 	"augustin/mailer"
 	"augustin/notifications"
+	"augustin/storage"
 	"augustin/utils"
 	"net/http"
 	"time"
@@ -31,12 +33,20 @@ func main() {
 		log.Fatal("Keycloak: ", err)
 	}
 
+	// Initialize storage for uploads
+	err = storage.Init()
+	if err != nil {
+		log.Fatal("Storage: ", err)
+	}
+
 	// Initialize database
 	go func() {
 		err = database.Db.InitDb()
 		if err != nil {
 			log.Fatal("Db init:", err)
 		}
+		// Start background jobs (e.g. email outbox)
+		jobs.Start()
 	}()
 	if conf.SentryDSN != "" {
 		err = sentry.Init(sentry.ClientOptions{
@@ -53,7 +63,11 @@ func main() {
 	// Set the timeout to the maximum duration the program can afford to wait.
 	defer sentry.Flush(2 * time.Second)
 
-	mailer.Init()
+	err = mailer.Init()
+	if err != nil {
+		log.Fatal("Mailer: ", err)
+	}
+
 	// Initialize server
 	log.Info("Listening on port ", conf.Port)
 	err = http.ListenAndServe(":"+conf.Port, handlers.GetRouter())
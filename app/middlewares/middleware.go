@@ -10,6 +10,9 @@ import (
 
 var log = utils.GetLogger()
 
+// VendorDocumentsRole is the Keycloak role that admins need to access vendor documents
+const VendorDocumentsRole = "vendordocuments"
+
 // AuthMiddleware is a middleware to check if the request is authorized
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -34,9 +37,11 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		r.Header.Set("X-Auth-User-Validated", "false")
 		r.Header.Del("X-Auth-User")
 		r.Header.Del("X-Auth-User-Email")
+		r.Header.Del("X-Auth-User-Email-Verified")
 		r.Header.Del("X-Auth-Roles-vendor")
 		r.Header.Del("X-Auth-Roles-admin")
 		r.Header.Del("X-Auth-Roles-flour")
+		r.Header.Del("X-Auth-Roles-" + VendorDocumentsRole)
 		r.Header.Del("X-Auth-Groups-Vendors")
 		r.Header.Del("X-Auth-Groups-Admins")
 
@@ -51,6 +56,10 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		r.Header.Set("X-Auth-User", *userinfo.Sub)
 		r.Header.Set("X-Auth-User-Name", *userinfo.PreferredUsername)
 		r.Header.Set("X-Auth-User-Email", *userinfo.Email)
+		// Anyone can register with any email, only verified emails identify the customer of orders
+		if userinfo.EmailVerified != nil && *userinfo.EmailVerified {
+			r.Header.Set("X-Auth-User-Email-Verified", "true")
+		}
 		r.Header.Set("X-Auth-User-Validated", "true")
 
 		// set user roles headers
@@ -151,3 +160,33 @@ func FlourAuthMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// HasVendorDocumentsRole returns true if the user of an authorized request may access vendor documents
+func HasVendorDocumentsRole(r *http.Request) bool {
+	return r.Header.Get("X-Auth-User-Validated") == "true" && r.Header.Get("X-Auth-Roles-admin") != "" && r.Header.Get("X-Auth-Roles-"+VendorDocumentsRole) != ""
+}
+
+// VendorDocumentsAuthMiddleware is a middleware to check if the request is authorized as admin with access to vendor documents
+func VendorDocumentsAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// ignore for options request
+		if r.Method == "OPTIONS" {
+			next.ServeHTTP(w, r)
+			return // skip
+		}
+
+		if r.Header.Get("X-Auth-User-Validated") != "true" {
+			log.Info("VendorDocumentsAuthMiddleware: No validated user", r.Header.Get("X-Auth-User"))
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !HasVendorDocumentsRole(r) {
+			log.Infof("VendorDocumentsAuthMiddleware: User %v has no %v role", r.Header.Get("X-Auth-User"), VendorDocumentsRole)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,22 @@
+-- Write your migrate up statements here
+
+CREATE TABLE EmailQueue (
+    ID serial PRIMARY KEY,
+    Recipient text NOT NULL,
+    Template text NOT NULL,
+    Data jsonb NOT NULL DEFAULT '{}',
+    Attempts integer NOT NULL DEFAULT 0,
+    LastError text,
+    NextAttempt timestamp NOT NULL DEFAULT NOW(),
+    SentAt timestamp,
+    Timestamp timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX EmailQueue_Pending ON EmailQueue (NextAttempt) WHERE SentAt IS NULL;
+
+---- create above / drop below ----
+
+DROP TABLE EmailQueue;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
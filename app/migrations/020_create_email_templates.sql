@@ -0,0 +1,28 @@
+-- Write your migrate up statements here
+
+CREATE TABLE EmailTemplate (
+    ID serial PRIMARY KEY,
+    Name text NOT NULL,
+    Language text NOT NULL,
+    Subject text NOT NULL DEFAULT '',
+    HTMLBody text NOT NULL DEFAULT '',
+    TextBody text NOT NULL DEFAULT '',
+    Timestamp timestamp NOT NULL DEFAULT NOW(),
+    UNIQUE (Name, Language)
+);
+
+-- Emails are now rendered from the localized templates in the database
+ALTER TABLE EmailQueue ADD COLUMN Language text NOT NULL DEFAULT '';
+UPDATE EmailQueue SET Template = regexp_replace(Template, 'Template\.html$', '');
+
+ALTER TABLE PaymentOrder ADD COLUMN Language text NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE PaymentOrder DROP COLUMN Language;
+UPDATE EmailQueue SET Template = Template || 'Template.html';
+ALTER TABLE EmailQueue DROP COLUMN Language;
+DROP TABLE EmailTemplate;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,18 @@
+-- Write your migrate up statements here
+
+CREATE TABLE EmailAttachment (
+    ID serial PRIMARY KEY,
+    Email integer NOT NULL REFERENCES EmailQueue ON DELETE CASCADE,
+    Filename text NOT NULL,
+    ContentType text NOT NULL,
+    Data bytea NOT NULL
+);
+
+CREATE INDEX EmailAttachment_Email ON EmailAttachment (Email);
+
+---- create above / drop below ----
+
+DROP TABLE EmailAttachment;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
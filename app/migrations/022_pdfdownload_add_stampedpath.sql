@@ -0,0 +1,11 @@
+-- Write your migrate up statements here
+
+-- Path of the watermarked copy of the PDF, created on the first download
+ALTER TABLE PDFDownload ADD COLUMN StampedPath text NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE PDFDownload DROP COLUMN StampedPath;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,38 @@
+-- Write your migrate up statements here
+
+ALTER TABLE Item ADD COLUMN PDFValidityDays integer NOT NULL DEFAULT 0;
+ALTER TABLE Item ADD COLUMN PDFMaxDownloads integer NOT NULL DEFAULT 0;
+ALTER TABLE Item ADD COLUMN PDFMaxIPs integer NOT NULL DEFAULT 0;
+
+ALTER TABLE PDFDownload ADD COLUMN ValidUntil timestamp DEFAULT NULL;
+ALTER TABLE PDFDownload ADD COLUMN MaxDownloads integer NOT NULL DEFAULT 0;
+ALTER TABLE PDFDownload ADD COLUMN MaxIPs integer NOT NULL DEFAULT 0;
+ALTER TABLE PDFDownload ADD COLUMN Revoked boolean NOT NULL DEFAULT false;
+
+-- Existing links keep the previous expiry of 6 weeks
+UPDATE PDFDownload SET ValidUntil = Timestamp + INTERVAL '6 weeks';
+
+CREATE TABLE PDFDownloadAccess (
+    ID serial PRIMARY KEY,
+    PDFDownload integer NOT NULL REFERENCES PDFDownload(ID) ON DELETE CASCADE,
+    IP varchar(255) NOT NULL,
+    Timestamp timestamp DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX PDFDownloadAccess_PDFDownload_idx ON PDFDownloadAccess (PDFDownload);
+
+---- create above / drop below ----
+
+DROP TABLE IF EXISTS PDFDownloadAccess;
+
+ALTER TABLE PDFDownload DROP COLUMN ValidUntil;
+ALTER TABLE PDFDownload DROP COLUMN MaxDownloads;
+ALTER TABLE PDFDownload DROP COLUMN MaxIPs;
+ALTER TABLE PDFDownload DROP COLUMN Revoked;
+
+ALTER TABLE Item DROP COLUMN PDFValidityDays;
+ALTER TABLE Item DROP COLUMN PDFMaxDownloads;
+ALTER TABLE Item DROP COLUMN PDFMaxIPs;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
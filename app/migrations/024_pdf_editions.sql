@@ -0,0 +1,32 @@
+-- Write your migrate up statements here
+
+-- Every PDF is an edition of an item
+ALTER TABLE PDF ADD COLUMN Item integer REFERENCES Item(ID) ON DELETE SET NULL;
+ALTER TABLE PDF ADD COLUMN IssueNumber varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE PDF ADD COLUMN PublicationDate date;
+ALTER TABLE PDF ADD COLUMN CoverImage varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE PDF ADD COLUMN AvailableFrom timestamp DEFAULT NULL;
+ALTER TABLE PDF ADD COLUMN AvailableUntil timestamp DEFAULT NULL;
+
+CREATE INDEX PDF_Item_idx ON PDF (Item);
+
+-- The current PDFs of the items become their first edition
+UPDATE PDF SET Item = Item.ID, PublicationDate = PDF.Timestamp::date FROM Item WHERE Item.PDF = PDF.ID;
+
+-- Edition that has been bought
+ALTER TABLE OrderEntry ADD COLUMN PDF integer REFERENCES PDF(ID) ON DELETE SET NULL;
+
+---- create above / drop below ----
+
+ALTER TABLE OrderEntry DROP COLUMN PDF;
+
+DROP INDEX IF EXISTS PDF_Item_idx;
+ALTER TABLE PDF DROP COLUMN Item;
+ALTER TABLE PDF DROP COLUMN IssueNumber;
+ALTER TABLE PDF DROP COLUMN PublicationDate;
+ALTER TABLE PDF DROP COLUMN CoverImage;
+ALTER TABLE PDF DROP COLUMN AvailableFrom;
+ALTER TABLE PDF DROP COLUMN AvailableUntil;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,51 @@
+-- Write your migrate up statements here
+
+-- Deletes on PDF and PDFDownload stay forbidden, except for transactions that explicitly
+-- allow them with SET LOCAL augustin.allow_delete = 'on' (used by the retention job)
+
+CREATE OR REPLACE FUNCTION prevent_delete_pdf()
+RETURNS trigger AS $$
+BEGIN
+    IF current_setting('augustin.allow_delete', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'Cannot delete from table PDF';
+    -- This will prevent the delete operation
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION prevent_delete_pdfdownloads()
+RETURNS trigger AS $$
+BEGIN
+    IF current_setting('augustin.allow_delete', true) = 'on' THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'Cannot delete from table PDFDownloads';
+    -- This will prevent the delete operation
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+---- create above / drop below ----
+
+CREATE OR REPLACE FUNCTION prevent_delete_pdf()
+RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'Cannot delete from table PDF';
+    -- This will prevent the delete operation
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION prevent_delete_pdfdownloads()
+RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'Cannot delete from table PDFDownloads';
+    -- This will prevent the delete operation
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,15 @@
+-- Write your migrate up statements here
+
+-- Keys of the resized copies of uploaded images by variant name, e.g. {"thumbnail": "img/<hash>_thumbnail.webp"}
+ALTER TABLE Item ADD COLUMN ImageVariants jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE Settings ADD COLUMN LogoVariants jsonb NOT NULL DEFAULT '{}';
+ALTER TABLE Settings ADD COLUMN FaviconVariants jsonb NOT NULL DEFAULT '{}';
+
+---- create above / drop below ----
+
+ALTER TABLE Item DROP COLUMN ImageVariants;
+ALTER TABLE Settings DROP COLUMN LogoVariants;
+ALTER TABLE Settings DROP COLUMN FaviconVariants;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,11 @@
+-- Write your migrate up statements here
+
+-- Storage key of the photo on the vendor ID badge
+ALTER TABLE Vendor ADD COLUMN Photo varchar(255) NOT NULL DEFAULT '';
+
+---- create above / drop below ----
+
+ALTER TABLE Vendor DROP COLUMN Photo;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,26 @@
+-- Write your migrate up statements here
+
+-- Photos, ID scans, registration forms and signed agreements of vendors.
+-- Files are kept in the blob storage under vendor/documents, DeleteAt is set by the retention policy.
+CREATE TABLE VendorDocument (
+    ID serial PRIMARY KEY,
+    Vendor integer NOT NULL REFERENCES Vendor(ID) ON DELETE CASCADE,
+    Type varchar(50) NOT NULL,
+    Filename varchar(255) NOT NULL DEFAULT '',
+    Path varchar(255) NOT NULL,
+    ContentType varchar(255) NOT NULL,
+    Size bigint NOT NULL DEFAULT 0,
+    ExpiresAt date,
+    DeleteAt date,
+    UploadedBy varchar(255) NOT NULL DEFAULT '',
+    Timestamp timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX VendorDocument_Vendor_idx ON VendorDocument (Vendor);
+
+---- create above / drop below ----
+
+DROP TABLE VendorDocument;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,24 @@
+-- Write your migrate up statements here
+
+-- Validity periods of vendor licenses. A renewal adds a new period, so the table is the renewal history.
+-- Vendors without any period are not restricted.
+CREATE TABLE VendorLicense (
+    ID serial PRIMARY KEY,
+    Vendor integer NOT NULL REFERENCES Vendor(ID) ON DELETE CASCADE,
+    StartDate date NOT NULL,
+    EndDate date NOT NULL,
+    Comment text NOT NULL DEFAULT '',
+    CreatedBy varchar(255) NOT NULL DEFAULT '',
+    ExpiryNotified boolean NOT NULL DEFAULT false,
+    Timestamp timestamp NOT NULL DEFAULT NOW(),
+    CHECK (EndDate >= StartDate)
+);
+
+CREATE INDEX VendorLicense_Vendor_idx ON VendorLicense (Vendor, EndDate);
+
+---- create above / drop below ----
+
+DROP TABLE VendorLicense;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,35 @@
+-- Write your migrate up statements here
+
+-- Selling locations of vendors with their weekly time slots
+CREATE TABLE VendorLocation (
+    ID serial PRIMARY KEY,
+    Vendor integer NOT NULL REFERENCES Vendor(ID) ON DELETE CASCADE,
+    Name varchar(255) NOT NULL DEFAULT '',
+    Address varchar(255) NOT NULL DEFAULT '',
+    PLZ varchar(255) NOT NULL DEFAULT '',
+    Longitude double precision NOT NULL DEFAULT 0,
+    Latitude double precision NOT NULL DEFAULT 0,
+    Comment text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX VendorLocation_Vendor_idx ON VendorLocation (Vendor);
+
+-- Weekday is the ISO weekday (1 is Monday, 7 is Sunday), times are in the local time zone
+CREATE TABLE VendorLocationTimeSlot (
+    ID serial PRIMARY KEY,
+    Location integer NOT NULL REFERENCES VendorLocation(ID) ON DELETE CASCADE,
+    Weekday smallint NOT NULL CHECK (Weekday BETWEEN 1 AND 7),
+    StartTime time NOT NULL,
+    EndTime time NOT NULL,
+    CHECK (EndTime > StartTime)
+);
+
+CREATE INDEX VendorLocationTimeSlot_Location_idx ON VendorLocationTimeSlot (Location);
+
+---- create above / drop below ----
+
+DROP TABLE VendorLocationTimeSlot;
+DROP TABLE VendorLocation;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
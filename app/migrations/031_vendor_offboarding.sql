@@ -0,0 +1,18 @@
+-- Write your migrate up statements here
+
+-- Offboarded vendors are anonymized on AnonymizeAt, the ledger (Account, Payment) is kept
+ALTER TABLE Vendor ADD COLUMN OffboardedAt timestamp;
+ALTER TABLE Vendor ADD COLUMN AnonymizeAt date;
+ALTER TABLE Vendor ADD COLUMN AnonymizedAt timestamp;
+
+CREATE INDEX Vendor_AnonymizeAt_idx ON Vendor (AnonymizeAt) WHERE AnonymizedAt IS NULL;
+
+---- create above / drop below ----
+
+DROP INDEX Vendor_AnonymizeAt_idx;
+ALTER TABLE Vendor DROP COLUMN AnonymizedAt;
+ALTER TABLE Vendor DROP COLUMN AnonymizeAt;
+ALTER TABLE Vendor DROP COLUMN OffboardedAt;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,16 @@
+-- Write your migrate up statements here
+
+-- Email preferences of customers, identified by their lower case email
+CREATE TABLE CustomerPreference (
+    Email varchar(255) PRIMARY KEY,
+    Language text NOT NULL DEFAULT '',  -- Overrides the language of the orders for emails if set
+    OrderConfirmations bool NOT NULL DEFAULT true,
+    Timestamp timestamp NOT NULL DEFAULT NOW()
+);
+
+---- create above / drop below ----
+
+DROP TABLE CustomerPreference;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
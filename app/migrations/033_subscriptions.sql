@@ -0,0 +1,32 @@
+-- Write your migrate up statements here
+
+-- Digital license items with a period are sold as subscriptions, empty means a one-off purchase
+ALTER TABLE Item ADD COLUMN SubscriptionPeriod text NOT NULL DEFAULT '' CHECK (SubscriptionPeriod IN ('', 'month', 'year'));
+
+-- Subscription of a customer (lower case email) to a subscription item, renewals extend ValidUntil
+CREATE TABLE Subscription (
+    ID serial PRIMARY KEY,
+    Item integer NOT NULL REFERENCES Item(ID),
+    CustomerEmail varchar(255) NOT NULL,
+    UserID text NOT NULL DEFAULT '',  -- Keycloak ID of the customer
+    Vendor integer NOT NULL REFERENCES Vendor(ID),  -- Renewals are sold by the same vendor by default
+    Language text NOT NULL DEFAULT '',
+    LastOrder integer REFERENCES PaymentOrder(ID),
+    ValidUntil timestamp NOT NULL,
+    RenewalToken text NOT NULL UNIQUE,  -- Secret of the renewal link
+    ReminderSentAt timestamp,  -- Reset by every renewal
+    CancelledAt timestamp,  -- No more reminders, the subscription ends with ValidUntil
+    ExpiredAt timestamp,  -- The customer has been removed from the license group
+    Timestamp timestamp NOT NULL DEFAULT NOW(),
+    UNIQUE (CustomerEmail, Item)
+);
+
+CREATE INDEX Subscription_ValidUntil_idx ON Subscription (ValidUntil) WHERE ExpiredAt IS NULL;
+
+---- create above / drop below ----
+
+DROP TABLE Subscription;
+ALTER TABLE Item DROP COLUMN SubscriptionPeriod;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
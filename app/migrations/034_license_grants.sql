@@ -0,0 +1,40 @@
+-- Write your migrate up statements here
+
+-- Days of access to the license group bought with a digital license item, 0 uses the default of the configuration
+ALTER TABLE Item ADD COLUMN LicenseValidityDays integer NOT NULL DEFAULT 0;
+
+-- Access of a customer (lower case email) to a license group bought with an order
+CREATE TABLE LicenseGrant (
+    ID serial PRIMARY KEY,
+    PaymentOrder integer REFERENCES PaymentOrder(ID),
+    Item integer REFERENCES Item(ID),
+    LicenseGroup text NOT NULL,
+    CustomerEmail varchar(255) NOT NULL,
+    UserID text NOT NULL DEFAULT '',  -- Keycloak ID of the customer
+    StartDate timestamp NOT NULL DEFAULT NOW(),
+    EndDate timestamp,  -- NULL for unlimited access
+    ExpiredAt timestamp,  -- The customer has been removed from the license group
+    Timestamp timestamp NOT NULL DEFAULT NOW(),
+    UNIQUE (PaymentOrder, Item)
+);
+
+CREATE INDEX LicenseGrant_EndDate_idx ON LicenseGrant (EndDate) WHERE ExpiredAt IS NULL;
+
+-- Purchases before time-limited access keep their unlimited access.
+-- PaymentOrder.UserID is the buyer, so the UserID stays empty and the holder is resolved by the customer email.
+-- Gift orders with a separate recipient do not exist before 035_gift_orders.
+INSERT INTO LicenseGrant (PaymentOrder, Item, LicenseGroup, CustomerEmail, UserID, StartDate)
+SELECT DISTINCT PaymentOrder.ID, Item.ID, Item.LicenseGroup, lower(PaymentOrder.CustomerEmail), '', PaymentOrder.Timestamp
+FROM PaymentOrder
+JOIN OrderEntry ON OrderEntry.PaymentOrder = PaymentOrder.ID
+JOIN Item ON Item.ID = OrderEntry.Item
+WHERE PaymentOrder.Verified AND Item.LicenseItem IS NOT NULL AND NOT Item.IsPDFItem AND Item.SubscriptionPeriod = ''
+AND COALESCE(Item.LicenseGroup, '') <> '' AND COALESCE(PaymentOrder.CustomerEmail, '') <> '';
+
+---- create above / drop below ----
+
+DROP TABLE LicenseGrant;
+ALTER TABLE Item DROP COLUMN LicenseValidityDays;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
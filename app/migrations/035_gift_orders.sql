@@ -0,0 +1,17 @@
+-- Write your migrate up statements here
+
+-- Gift orders deliver the digital license items and PDFs to a recipient, the buyer (CustomerEmail) gets the receipt
+ALTER TABLE PaymentOrder ADD COLUMN RecipientEmail varchar(255);
+ALTER TABLE PaymentOrder ADD COLUMN RecipientName text NOT NULL DEFAULT '';
+ALTER TABLE PaymentOrder ADD COLUMN GiftMessage text NOT NULL DEFAULT '';
+ALTER TABLE PaymentOrder ADD COLUMN DeliveryDate timestamp;  -- NULL delivers the gift with the verification of the order
+ALTER TABLE PaymentOrder ADD COLUMN DeliveredAt timestamp;  -- The gift has been delivered to the recipient
+
+CREATE INDEX PaymentOrder_DeliveryDate_idx ON PaymentOrder (DeliveryDate) WHERE RecipientEmail IS NOT NULL AND DeliveredAt IS NULL;
+
+---- create above / drop below ----
+
+ALTER TABLE PaymentOrder DROP COLUMN RecipientEmail, DROP COLUMN RecipientName, DROP COLUMN GiftMessage, DROP COLUMN DeliveryDate, DROP COLUMN DeliveredAt;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
@@ -0,0 +1,42 @@
+-- Write your migrate up statements here
+
+-- Discount campaigns of the webshop, the organization bears the discount
+CREATE TABLE Voucher (
+    ID serial PRIMARY KEY,
+    Name text NOT NULL,
+    Type text NOT NULL CHECK (Type IN ('fixed', 'percent', 'free_item')),
+    Amount integer NOT NULL DEFAULT 0 CHECK (Amount >= 0),  -- Discount in cents of fixed vouchers
+    Percent integer NOT NULL DEFAULT 0 CHECK (Percent BETWEEN 0 AND 100),
+    FreeItem integer REFERENCES Item(ID),  -- One unit of the item is free
+    Items integer[] NOT NULL DEFAULT '{}',  -- Items that are discounted, empty for all items
+    ValidFrom timestamp,
+    ValidUntil timestamp,
+    MaxUses integer NOT NULL DEFAULT 0,  -- Uses per code, 0 for unlimited
+    Disabled boolean NOT NULL DEFAULT false,
+    CreatedBy text NOT NULL DEFAULT '',
+    Timestamp timestamp NOT NULL DEFAULT NOW()
+);
+
+-- Codes of a voucher, one promo code or many generated single-use codes
+CREATE TABLE VoucherCode (
+    ID serial PRIMARY KEY,
+    Voucher integer NOT NULL REFERENCES Voucher(ID),
+    Code text NOT NULL UNIQUE,  -- Upper case
+    Timestamp timestamp NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX VoucherCode_Voucher_idx ON VoucherCode (Voucher);
+
+-- Discount entries are subtracted from the total of the order
+ALTER TABLE OrderEntry ADD COLUMN VoucherCode integer REFERENCES VoucherCode(ID);
+
+CREATE INDEX OrderEntry_VoucherCode_idx ON OrderEntry (VoucherCode) WHERE VoucherCode IS NOT NULL;
+
+---- create above / drop below ----
+
+ALTER TABLE OrderEntry DROP COLUMN VoucherCode;
+DROP TABLE VoucherCode;
+DROP TABLE Voucher;
+
+-- Write your migrate down statements here. If this migration is irreversible
+-- Then delete the separator line above.
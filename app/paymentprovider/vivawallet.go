@@ -265,6 +265,10 @@ func HandlePaymentSuccessfulResponse(paymentSuccessful TransactionSuccessRequest
 		if entry.Item == transactionCostItem.ID {
 			continue // Skip transaction costs
 		}
+		if entry.VoucherCode.Valid {
+			sum -= float64(entry.Price * entry.Quantity) // Discounts are not paid
+			continue
+		}
 		item, err := database.Db.GetItem(entry.Item) // Get item by ID
 		if err != nil {
 			log.Error("Item could not be found", zap.Error(err))
@@ -0,0 +1,154 @@
+package pdfgen
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg" // Register decoder for the photo size
+	_ "image/png"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Badge contains everything that is printed on the ID badge of a vendor
+type Badge struct {
+	Name      string
+	LicenseID string
+	Photo     []byte // Optional PNG or JPEG photo
+	QRCode    []byte // PNG of the vendor's QR code
+}
+
+// BadgeSheet contains the badges of one or many vendors with the branding of the newspaper
+type BadgeSheet struct {
+	NewspaperName string
+	Color         string // Branding color as hex code, e.g. #F45793
+	Language      string
+	Badges        []Badge
+}
+
+// badgeLabels are the translations of the badge, German is the default
+var badgeLabels = map[string]map[string]string{
+	"de": {
+		"title":   "Ausweise",
+		"vendor":  "Verkäufer*in",
+		"license": "Ausweis-Nr.",
+	},
+	"en": {
+		"title":   "Badges",
+		"vendor":  "Vendor",
+		"license": "License ID",
+	},
+}
+
+// Badges have the size of a credit card (ID-1) and are arranged in a grid on A4 pages to be cut out
+const (
+	badgeWidth   = 85.6
+	badgeHeight  = 54.0
+	badgeColumns = 2
+	badgeRows    = 5
+	badgeMarginX = (210 - badgeColumns*badgeWidth) / 2
+	badgeMarginY = (297 - badgeRows*badgeHeight) / 2
+)
+
+// GenerateBadges creates a printable PDF with the ID badges of vendors, 10 per A4 page
+func GenerateBadges(sheet BadgeSheet) ([]byte, error) {
+	labels, ok := badgeLabels[strings.SplitN(strings.ToLower(sheet.Language), "-", 2)[0]]
+	if !ok {
+		labels = badgeLabels["de"]
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(labels["title"]+" "+sheet.NewspaperName, true)
+	r, g, b := parseHexColor(sheet.Color)
+
+	for i, badge := range sheet.Badges {
+		position := i % (badgeColumns * badgeRows)
+		if position == 0 {
+			pdf.AddPage()
+		}
+		x := badgeMarginX + float64(position%badgeColumns)*badgeWidth
+		y := badgeMarginY + float64(position/badgeColumns)*badgeHeight
+
+		// Cut line
+		pdf.SetDrawColor(200, 200, 200)
+		pdf.SetLineWidth(0.2)
+		pdf.Rect(x, y, badgeWidth, badgeHeight, "D")
+
+		// Header with the name of the newspaper
+		pdf.SetFillColor(r, g, b)
+		pdf.Rect(x, y, badgeWidth, 10, "F")
+		pdf.SetTextColor(255, 255, 255)
+		pdf.SetFont("Helvetica", "B", 12)
+		pdf.SetXY(x+4, y)
+		pdf.CellFormat(badgeWidth-8, 10, tr(sheet.NewspaperName), "", 0, "L", false, 0, "")
+
+		// Photo or placeholder
+		photoX, photoY, photoW, photoH := x+4, y+14, 24.0, 32.0
+		if !addBadgeImage(pdf, "photo"+strconv.Itoa(i), badge.Photo, photoX, photoY, photoW, photoH) {
+			pdf.SetFillColor(230, 230, 230)
+			pdf.Rect(photoX, photoY, photoW, photoH, "F")
+		}
+
+		// QR code
+		qrSize := 28.0
+		addBadgeImage(pdf, "qrcode"+strconv.Itoa(i), badge.QRCode, x+badgeWidth-4-qrSize, y+15, qrSize, qrSize)
+
+		// Name and license ID between photo and QR code
+		textX, textW := x+31, badgeWidth-31-4-qrSize-1
+		pdf.SetTextColor(110, 110, 110)
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.SetXY(textX, y+14)
+		pdf.CellFormat(textW, 4, tr(labels["vendor"]), "", 2, "L", false, 0, "")
+		pdf.SetTextColor(0, 0, 0)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.MultiCell(textW, 4.5, tr(badge.Name), "", "L", false)
+		pdf.SetXY(textX, y+36)
+		pdf.SetTextColor(110, 110, 110)
+		pdf.SetFont("Helvetica", "", 7)
+		pdf.CellFormat(textW, 4, tr(labels["license"]), "", 2, "L", false, 0, "")
+		pdf.SetTextColor(r, g, b)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(textW, 6, tr(badge.LicenseID), "", 2, "L", false, 0, "")
+	}
+	if len(sheet.Badges) == 0 {
+		pdf.AddPage()
+	}
+
+	buf := new(bytes.Buffer)
+	err := pdf.Output(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// addBadgeImage draws a PNG or JPEG centered into the given box, keeping its aspect ratio.
+// It returns false if there is no image or it can't be read.
+func addBadgeImage(pdf *fpdf.Fpdf, name string, data []byte, x, y, w, h float64) bool {
+	if len(data) == 0 {
+		return false
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		log.Warn("GenerateBadges: invalid image ", name, ": ", err)
+		return false
+	}
+	imgW, imgH := w, w*float64(config.Height)/float64(config.Width)
+	if imgH > h {
+		imgW, imgH = h*float64(config.Width)/float64(config.Height), h
+	}
+	options := fpdf.ImageOptions{ImageType: strings.TrimPrefix(http.DetectContentType(data), "image/")}
+	pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(data))
+	pdf.ImageOptions(name, x+(w-imgW)/2, y+(h-imgH)/2, imgW, imgH, false, options, 0, "")
+	if pdf.Err() {
+		// A broken image must not prevent the other badges
+		log.Warn("GenerateBadges: failed to add image ", name, ": ", pdf.Error())
+		pdf.ClearError()
+		return false
+	}
+	return true
+}
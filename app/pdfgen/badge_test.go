@@ -0,0 +1,36 @@
+package pdfgen
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestGenerateBadges tests that badges are split on multiple pages and broken images are skipped
+func TestGenerateBadges(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	img.Set(0, 0, color.Black)
+	var photo, qrcode bytes.Buffer
+	require.NoError(t, jpeg.Encode(&photo, img, nil))
+	require.NoError(t, png.Encode(&qrcode, img))
+
+	badges := make([]Badge, 11)
+	for i := range badges {
+		badges[i] = Badge{Name: "Jürgen Müller-Lüdenscheidt", LicenseID: "fl-123", Photo: photo.Bytes(), QRCode: qrcode.Bytes()}
+	}
+	badges[1].Photo = []byte("broken")
+	badges[2].Photo = nil
+
+	pdf, err := GenerateBadges(BadgeSheet{NewspaperName: "Augustin", Color: "#F45793", Badges: badges})
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+	require.Equal(t, 2, bytes.Count(pdf, []byte("/Type /Page\n")))
+}
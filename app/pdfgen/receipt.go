@@ -0,0 +1,178 @@
+package pdfgen
+
+import (
+	"augustin/utils"
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+var log = utils.GetLogger()
+
+// ReceiptLine is a purchased item on a receipt
+type ReceiptLine struct {
+	Name     string
+	Quantity int
+	Price    int // Price per unit in cents
+}
+
+// Receipt contains everything that is printed on the receipt of an order
+type Receipt struct {
+	NewspaperName   string
+	Color           string // Branding color as hex code, e.g. #F45793
+	Logo            []byte // Optional PNG or JPEG logo
+	OrderCode       string
+	Date            time.Time
+	VendorFirstName string
+	Lines           []ReceiptLine
+	Donation        int // Donation in cents
+	Discount        int // Discount of vouchers in cents
+	Total           int // Total in cents
+	Language        string
+}
+
+// receiptLabels are the translations of the receipt, German is the default
+var receiptLabels = map[string]map[string]string{
+	"de": {
+		"title":    "Kaufbestätigung",
+		"order":    "Bestellnummer",
+		"date":     "Datum",
+		"vendor":   "Verkäufer*in",
+		"item":     "Artikel",
+		"quantity": "Menge",
+		"price":    "Preis",
+		"sum":      "Summe",
+		"donation": "Spende",
+		"discount": "Gutschein",
+		"total":    "Gesamt",
+		"thanks":   "Vielen Dank für deinen Einkauf!",
+	},
+	"en": {
+		"title":    "Receipt",
+		"order":    "Order code",
+		"date":     "Date",
+		"vendor":   "Vendor",
+		"item":     "Item",
+		"quantity": "Quantity",
+		"price":    "Price",
+		"sum":      "Sum",
+		"donation": "Donation",
+		"discount": "Voucher",
+		"total":    "Total",
+		"thanks":   "Thank you for your purchase!",
+	},
+}
+
+// FormatEuro formats an amount in cents, e.g. 350 as "3,50 €"
+func FormatEuro(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d,%02d €", sign, cents/100, cents%100)
+}
+
+// parseHexColor converts a color like #F45793 to RGB, invalid colors are black
+func parseHexColor(color string) (r, g, b int) {
+	color = strings.TrimPrefix(color, "#")
+	if len(color) != 6 {
+		return 0, 0, 0
+	}
+	value, err := strconv.ParseUint(color, 16, 32)
+	if err != nil {
+		return 0, 0, 0
+	}
+	return int(value >> 16 & 0xFF), int(value >> 8 & 0xFF), int(value & 0xFF)
+}
+
+// GenerateReceipt creates a PDF receipt for an order
+func GenerateReceipt(receipt Receipt) ([]byte, error) {
+	labels, ok := receiptLabels[strings.SplitN(strings.ToLower(receipt.Language), "-", 2)[0]]
+	if !ok {
+		labels = receiptLabels["de"]
+	}
+
+	pdf := fpdf.New("P", "mm", "A4", "")
+	// The core fonts only support cp1252, which covers German umlauts and the euro sign
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetTitle(labels["title"]+" "+receipt.OrderCode, true)
+	pdf.AddPage()
+
+	if len(receipt.Logo) > 0 {
+		options := fpdf.ImageOptions{ImageType: strings.TrimPrefix(http.DetectContentType(receipt.Logo), "image/"), ReadDpi: true}
+		pdf.RegisterImageOptionsReader("logo", options, bytes.NewReader(receipt.Logo))
+		pdf.ImageOptions("logo", 150, 10, 40, 0, false, options, 0, "")
+		if pdf.Err() {
+			// A broken logo must not prevent the receipt
+			log.Warn("GenerateReceipt: failed to add logo: ", pdf.Error())
+			pdf.ClearError()
+		}
+	}
+
+	r, g, b := parseHexColor(receipt.Color)
+	pdf.SetTextColor(r, g, b)
+	pdf.SetFont("Helvetica", "B", 20)
+	pdf.CellFormat(0, 10, tr(receipt.NewspaperName), "", 1, "L", false, 0, "")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 10, tr(labels["title"]), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+
+	pdf.SetFont("Helvetica", "", 11)
+	for _, row := range [][2]string{
+		{labels["order"], receipt.OrderCode},
+		{labels["date"], receipt.Date.Format("02.01.2006 15:04")},
+		{labels["vendor"], receipt.VendorFirstName},
+	} {
+		pdf.CellFormat(40, 6, tr(row[0]+":"), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, tr(row[1]), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// Table of purchased items
+	widths := []float64{90, 25, 35, 40}
+	pdf.SetFont("Helvetica", "B", 11)
+	for i, header := range []string{labels["item"], labels["quantity"], labels["price"], labels["sum"]} {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		pdf.CellFormat(widths[i], 8, tr(header), "B", 0, align, false, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("Helvetica", "", 11)
+	for _, line := range receipt.Lines {
+		pdf.CellFormat(widths[0], 7, tr(line.Name), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[1], 7, strconv.Itoa(line.Quantity), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[2], 7, tr(FormatEuro(line.Price)), "", 0, "R", false, 0, "")
+		pdf.CellFormat(widths[3], 7, tr(FormatEuro(line.Price*line.Quantity)), "", 1, "R", false, 0, "")
+	}
+	if receipt.Donation > 0 {
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 7, tr(labels["donation"]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[3], 7, tr(FormatEuro(receipt.Donation)), "", 1, "R", false, 0, "")
+	}
+	if receipt.Discount > 0 {
+		pdf.CellFormat(widths[0]+widths[1]+widths[2], 7, tr(labels["discount"]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(widths[3], 7, tr(FormatEuro(-receipt.Discount)), "", 1, "R", false, 0, "")
+	}
+	pdf.SetFont("Helvetica", "B", 11)
+	pdf.CellFormat(widths[0]+widths[1]+widths[2], 8, tr(labels["total"]), "T", 0, "L", false, 0, "")
+	pdf.CellFormat(widths[3], 8, tr(FormatEuro(receipt.Total)), "T", 1, "R", false, 0, "")
+	pdf.Ln(10)
+
+	pdf.SetFont("Helvetica", "", 11)
+	pdf.CellFormat(0, 6, tr(labels["thanks"]), "", 1, "L", false, 0, "")
+
+	buf := new(bytes.Buffer)
+	err := pdf.Output(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,33 @@
+package pdfgen
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatEuro(t *testing.T) {
+	require.Equal(t, "3,50 €", FormatEuro(350))
+	require.Equal(t, "0,05 €", FormatEuro(5))
+	require.Equal(t, "-12,00 €", FormatEuro(-1200))
+}
+
+// TestGenerateReceipt tests that a receipt with umlauts can be generated
+func TestGenerateReceipt(t *testing.T) {
+	pdf, err := GenerateReceipt(Receipt{
+		NewspaperName:   "Augustin",
+		Color:           "#F45793",
+		OrderCode:       "1234567890",
+		Date:            time.Now(),
+		VendorFirstName: "Jürgen",
+		Lines:           []ReceiptLine{{Name: "Zeitung", Quantity: 2, Price: 300}},
+		Donation:        100,
+		Discount:        50,
+		Total:           650,
+		Language:        "de-AT",
+	})
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(pdf, []byte("%PDF-")))
+}
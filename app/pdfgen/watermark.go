@@ -0,0 +1,43 @@
+package pdfgen
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+func init() {
+	// pdfcpu would otherwise create a configuration folder in the home directory
+	api.DisableConfigDir()
+}
+
+// watermarkDescription places a small gray line of text at the bottom of every page
+const watermarkDescription = "font:Helvetica, points:8, position:bc, offset:0 12, rotation:0, scale:1 abs, opacity:0.7, fillcolor:#555555"
+
+// Watermark stamps text on every page of a PDF, e.g. to mark who downloaded a copy
+func Watermark(in io.ReadSeeker, out io.Writer, text string) error {
+	// Commas separate the options of pdfcpu descriptions and are not allowed in the text
+	text = strings.ReplaceAll(text, ",", " ")
+	wm, err := api.TextWatermark(text, watermarkDescription, true, false, types.POINTS)
+	if err != nil {
+		return err
+	}
+	conf := model.NewDefaultConfiguration()
+	// Many newspaper PDFs are not fully spec compliant
+	conf.ValidationMode = model.ValidationRelaxed
+	return api.AddWatermarks(in, out, nil, wm, conf)
+}
+
+// WatermarkBytes stamps text on every page of a PDF in memory
+func WatermarkBytes(pdf []byte, text string) ([]byte, error) {
+	out := new(bytes.Buffer)
+	err := Watermark(bytes.NewReader(pdf), out, text)
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,19 @@
+package pdfgen
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestWatermark tests that a generated PDF can be stamped
+func TestWatermark(t *testing.T) {
+	pdf, err := GenerateReceipt(Receipt{NewspaperName: "Augustin", Date: time.Now()})
+	require.NoError(t, err)
+	stamped, err := WatermarkBytes(pdf, "kunde@example.com, 01.01.2025 10:00")
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(stamped, []byte("%PDF-")))
+	require.NotEqual(t, pdf, stamped)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores blobs in a folder of the local file system
+type Local struct {
+	Root string
+}
+
+// NewLocal creates a storage in the given folder
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+// path returns the file path of a key
+func (l *Local) path(key string) (string, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes a blob. The file is written to a temporary file first, so that readers never see incomplete files.
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Open returns the content of a blob
+func (l *Local) Open(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, Info{}, localError(err)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, Info{}, ErrNotFound
+	}
+	return f, localInfo(key, stat), nil
+}
+
+// Stat returns information about a blob
+func (l *Local) Stat(ctx context.Context, key string) (Info, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(p)
+	if err != nil {
+		return Info{}, localError(err)
+	}
+	if stat.IsDir() {
+		return Info{}, ErrNotFound
+	}
+	return localInfo(key, stat), nil
+}
+
+// Delete removes a blob
+func (l *Local) Delete(ctx context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	return localError(os.Remove(p))
+}
+
+// List returns all blobs with keys starting with prefix
+func (l *Local) List(ctx context.Context, prefix string) (blobs []Info, err error) {
+	// Only walk the folder that contains the prefix
+	dir := path.Dir(strings.TrimPrefix(prefix, "/") + "x")
+	if dir == "." {
+		dir = ""
+	}
+	root := filepath.Join(l.Root, filepath.FromSlash(dir))
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, strings.TrimPrefix(prefix, "/")) {
+			return nil
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, localInfo(key, stat))
+		return nil
+	})
+	return blobs, err
+}
+
+// URL returns an empty string, since local blobs are served by the API
+func (l *Local) URL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return "", nil
+}
+
+// localInfo converts file information
+func localInfo(key string, stat fs.FileInfo) Info {
+	return Info{
+		Key:         strings.TrimPrefix(key, "/"),
+		Size:        stat.Size(),
+		ModTime:     stat.ModTime(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+	}
+}
+
+// localError converts errors of missing files to ErrNotFound
+func localError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
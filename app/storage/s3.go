@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options configure the connection to an S3 compatible object storage (e.g. AWS S3, MinIO, Garage)
+type S3Options struct {
+	Endpoint  string // Host and port, e.g. s3.eu-central-1.amazonaws.com or minio:9000
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+	Redirect  bool // Redirect downloads to presigned URLs instead of serving them through the API
+}
+
+// S3 stores blobs in a bucket of an S3 compatible object storage
+type S3 struct {
+	client   *minio.Client
+	bucket   string
+	redirect bool
+}
+
+// NewS3 connects to the object storage. The bucket has to exist.
+func NewS3(options S3Options) (*S3, error) {
+	if options.Endpoint == "" || options.Bucket == "" {
+		return nil, errors.New("S3 endpoint and bucket are required")
+	}
+	client, err := minio.New(options.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(options.AccessKey, options.SecretKey, ""),
+		Secure: options.UseSSL,
+		Region: options.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: client, bucket: options.Bucket, redirect: options.Redirect}, nil
+}
+
+// Put uploads a blob
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	return err
+}
+
+// Open returns the content of a blob
+func (s *S3) Open(ctx context.Context, key string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, info.Key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, Info{}, s3Error(err)
+	}
+	return obj, info, nil
+}
+
+// Stat returns information about a blob
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	key, err := CleanKey(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s3Error(err)
+	}
+	return s3Info(stat), nil
+}
+
+// Delete removes a blob
+func (s *S3) Delete(ctx context.Context, key string) error {
+	key, err := CleanKey(key)
+	if err != nil {
+		return err
+	}
+	return s3Error(s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}))
+}
+
+// List returns all blobs with keys starting with prefix
+func (s *S3) List(ctx context.Context, prefix string) (blobs []Info, err error) {
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		blobs = append(blobs, s3Info(obj))
+	}
+	return blobs, nil
+}
+
+// URL returns a presigned URL if redirects are enabled
+func (s *S3) URL(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	if !s.redirect {
+		return "", nil
+	}
+	key, err := CleanKey(key)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// s3Info converts object information
+func s3Info(obj minio.ObjectInfo) Info {
+	return Info{
+		Key:         obj.Key,
+		Size:        obj.Size,
+		ModTime:     obj.LastModified,
+		ContentType: obj.ContentType,
+	}
+}
+
+// s3Error converts errors of missing objects to ErrNotFound
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
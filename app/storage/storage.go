@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"augustin/config"
+	"augustin/utils"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var log = utils.GetLogger()
+
+// ErrNotFound is returned if there is no blob with the given key
+var ErrNotFound = errors.New("blob not found")
+
+// Info describes a stored blob
+type Info struct {
+	Key         string
+	Size        int64
+	ModTime     time.Time
+	ContentType string
+}
+
+// Storage stores blobs like uploaded images and PDFs. Keys are slash separated paths like "img/<hash>.png".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, Info, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]Info, error)
+	// URL returns a temporary URL to download a blob directly from the backend,
+	// or an empty string if blobs have to be served by the API
+	URL(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// Backends that can be selected with STORAGE_BACKEND
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// storage is used by the handlers, it is set in Init or with SetStorage
+var storage Storage = NewLocal(".")
+
+// SetStorage replaces the storage, e.g. to use a temporary folder in tests
+func SetStorage(s Storage) {
+	storage = s
+}
+
+// GetStorage returns the storage that is used for uploads
+func GetStorage() Storage {
+	return storage
+}
+
+// Init selects the storage backend of the configuration
+func Init() error {
+	s, err := New(config.Config.StorageBackend)
+	if err != nil {
+		return err
+	}
+	log.Info("Using storage backend ", config.Config.StorageBackend)
+	storage = s
+	return nil
+}
+
+// New creates the storage backend with the given name from the configuration
+func New(backend string) (Storage, error) {
+	switch backend {
+	case BackendLocal, "":
+		return NewLocal(config.Config.StorageDir), nil
+	case BackendS3:
+		return NewS3(S3Options{
+			Endpoint:  config.Config.S3Endpoint,
+			Bucket:    config.Config.S3Bucket,
+			AccessKey: config.Config.S3AccessKey,
+			SecretKey: config.Config.S3SecretKey,
+			Region:    config.Config.S3Region,
+			UseSSL:    config.Config.S3UseSSL,
+			Redirect:  config.Config.StorageRedirect,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// CleanKey normalizes a key. Leading slashes are removed, so that URL paths like "/img/logo.png"
+// can be used as keys, and keys that point outside of the storage are rejected.
+func CleanKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || cleaned != "/"+strings.TrimPrefix(key, "/") {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
+
+// ContentKey returns the content-addressed key of data, e.g. "img/<sha256>.png"
+func ContentKey(prefix string, ext string, data []byte) string {
+	sum := sha256.Sum256(data)
+	return path.Join(prefix, hex.EncodeToString(sum[:])+strings.ToLower(ext))
+}
+
+// SaveContent stores data under its content-addressed key and returns the key.
+// Identical files are only stored once.
+func SaveContent(ctx context.Context, prefix string, ext string, data []byte, contentType string) (key string, err error) {
+	key = ContentKey(prefix, ext, data)
+	_, err = storage.Stat(ctx, key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return "", err
+	}
+	err = storage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// ReadAll returns the content of a blob
+func ReadAll(ctx context.Context, key string) ([]byte, error) {
+	obj, _, err := storage.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
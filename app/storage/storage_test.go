@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is a minimal S3 compatible server (path-style requests, no signature checks) for tests
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+	times   map[string]time.Time
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}, types: map[string]string{}, times: map[string]time.Time{}}
+}
+
+type fakeS3ListResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	IsTruncated bool
+	Contents    []fakeS3Object
+}
+
+type fakeS3Object struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		result := fakeS3ListResult{Name: bucket, Prefix: r.URL.Query().Get("prefix")}
+		for k, data := range f.objects {
+			if strings.HasPrefix(k, result.Prefix) {
+				result.Contents = append(result.Contents, fakeS3Object{Key: k, LastModified: f.times[k].UTC().Format(time.RFC3339), ETag: `"etag"`, Size: len(data)})
+			}
+		}
+		sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+		result.KeyCount = len(result.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		_ = xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodPut:
+		body, err := readS3Body(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = body
+		f.types[key] = r.Header.Get("Content-Type")
+		f.times[key] = time.Now()
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code><Message>not found</Message></Error>")
+			}
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Content-Type", f.types[key])
+		http.ServeContent(w, r, key, f.times[key], bytes.NewReader(data))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readS3Body reads a request body, which may use the aws-chunked encoding of streaming signatures
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	reader := bufio.NewReader(r.Body)
+	var body []byte
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body, nil
+		}
+		chunk := make([]byte, size+2) // Data is followed by \r\n
+		_, err = io.ReadFull(reader, chunk)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+// testStorage runs the same checks for every backend
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	SetStorage(s)
+
+	key, err := SaveContent(ctx, "img", ".PNG", []byte("image"), "image/png")
+	require.NoError(t, err)
+	require.Equal(t, ContentKey("img", ".png", []byte("image")), key)
+	require.True(t, strings.HasPrefix(key, "img/"))
+
+	// Identical content gets the same key
+	again, err := SaveContent(ctx, "img", ".png", []byte("image"), "image/png")
+	require.NoError(t, err)
+	require.Equal(t, key, again)
+
+	data, err := ReadAll(ctx, "/"+key)
+	require.NoError(t, err)
+	require.Equal(t, "image", string(data))
+
+	obj, info, err := s.Open(ctx, key)
+	require.NoError(t, err)
+	_, err = obj.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(obj)
+	require.NoError(t, err)
+	require.Equal(t, "age", string(rest))
+	require.NoError(t, obj.Close())
+	require.Equal(t, int64(5), info.Size)
+
+	require.NoError(t, s.Put(ctx, "pdf/stamped/a.pdf", strings.NewReader("pdf"), 3, "application/pdf"))
+	blobs, err := s.List(ctx, "pdf/")
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	require.Equal(t, "pdf/stamped/a.pdf", blobs[0].Key)
+
+	require.NoError(t, s.Delete(ctx, "pdf/stamped/a.pdf"))
+	_, err = s.Stat(ctx, "pdf/stamped/a.pdf")
+	require.ErrorIs(t, err, ErrNotFound)
+	_, _, err = s.Open(ctx, "pdf/missing.pdf")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = s.Stat(ctx, "img/../../etc/passwd")
+	require.Error(t, err)
+}
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+func TestS3(t *testing.T) {
+	server := httptest.NewServer(newFakeS3())
+	defer server.Close()
+	s, err := NewS3(S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		Bucket:    "augustin",
+		AccessKey: "access",
+		SecretKey: "secret",
+		Region:    "us-east-1",
+		Redirect:  true,
+	})
+	require.NoError(t, err)
+	testStorage(t, s)
+
+	url, err := s.URL(context.Background(), "img/a.png", time.Minute)
+	require.NoError(t, err)
+	require.Contains(t, url, "/augustin/img/a.png")
+	require.Contains(t, url, "X-Amz-Signature")
+}
+
+func TestCleanKey(t *testing.T) {
+	key, err := CleanKey("/img/logo.png")
+	require.NoError(t, err)
+	require.Equal(t, "img/logo.png", key)
+	for _, invalid := range []string{"", "/", "../secret", "img/../../secret", "img//a.png"} {
+		_, err = CleanKey(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -4,8 +4,11 @@ import (
 	"augustin/config"
 	"augustin/notifications"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -68,18 +71,52 @@ func GetUnixTime() int64 {
 	return time.Now().Unix()
 }
 
-// ReadUserIP returns the user's IP address
+// ReadUserIP returns the user's IP address.
+// The headers X-Real-Ip and X-Forwarded-For can be set by any client, so they are only used
+// if the request comes from one of the TRUSTED_PROXIES.
 func ReadUserIP(r *http.Request) string {
-	IPAddress := r.Header.Get("X-Real-Ip")
-	if IPAddress == "" {
-		IPAddress = r.Header.Get("X-Forwarded-For")
+	IPAddress := r.RemoteAddr
+	// Remove the port, which changes with every connection
+	if host, _, err := net.SplitHostPort(IPAddress); err == nil {
+		IPAddress = host
 	}
-	if IPAddress == "" {
-		IPAddress = r.RemoteAddr
+	if !isTrustedProxy(IPAddress) {
+		return IPAddress
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-Ip")); realIP != "" {
+		return realIP
+	}
+	// Proxies append the address they received the request from, so the client is
+	// the last address that is not a trusted proxy
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+		IPAddress = address
+		if !isTrustedProxy(address) {
+			break
+		}
 	}
 	return IPAddress
 }
 
+// isTrustedProxy returns true if the address belongs to one of the TRUSTED_PROXIES
+func isTrustedProxy(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range config.Config.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 func FileExists(path string) bool {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false